@@ -0,0 +1,199 @@
+package api
+
+import (
+	"fmt"
+	"react-golang/src/backend/model"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func currentUserID(c echo.Context) string {
+	userID, _ := c.Get("user_id").(string)
+	return userID
+}
+
+func hasRole(c echo.Context, role string) bool {
+	roles, _ := c.Get("roles").([]interface{})
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isAdmin(c echo.Context) bool {
+	return hasRole(c, "admin")
+}
+
+// callerHasRole tells whether the authenticated user was assigned one of
+// roles. Roles are read from the database so revocations apply immediately
+func callerHasRole(db *gorm.DB, c echo.Context, roles []string) (bool, error) {
+	userID := currentUserID(c)
+	tableName, _ := c.Get("user_table").(string)
+	if userID == "" || tableName == "" {
+		return false, nil
+	}
+
+	userRoles, err := fetchUserRoles(db, tableName, userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, userRole := range userRoles {
+		for _, role := range roles {
+			if userRole == role {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// authSecretColumns are the columns of auth tables that must never be
+// returned to clients
+var authSecretColumns = map[string]bool{
+	"password":       true,
+	"salt":           true,
+	"totp_secret":    true,
+	"recovery_codes": true,
+}
+
+func fetchColumnMeta(db *gorm.DB, tableName string) (map[string]model.ColumnMeta, error) {
+	var metas []model.ColumnMeta
+	err := db.Where("`table` = ?", tableName).Find(&metas).Error
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]model.ColumnMeta{}
+	for _, meta := range metas {
+		result[meta.Column] = meta
+	}
+
+	return result, nil
+}
+
+func ownerColumn(table model.Tables) string {
+	if table.OwnerColumn == "" && table.IsAuth {
+		return "id"
+	}
+
+	return table.OwnerColumn
+}
+
+// canAccessColumn tells whether the caller may read a column of row.
+// Admins can read everything, owner-only columns need the row to be owned
+// by the caller
+func canAccessColumn(c echo.Context, table model.Tables, meta model.ColumnMeta, row map[string]interface{}) bool {
+	if isAdmin(c) {
+		return true
+	}
+
+	switch meta.Access {
+	case model.ACCESS_ADMIN:
+		return false
+	case model.ACCESS_OWNER:
+		owner := ownerColumn(table)
+		userID := currentUserID(c)
+		return owner != "" && userID != "" && fmt.Sprint(row[owner]) == userID
+	default:
+		return true
+	}
+}
+
+// stripRestrictedColumns removes the columns the caller isn't allowed to
+// read from each row, along with the credentials of auth tables
+func stripRestrictedColumns(db *gorm.DB, c echo.Context, table model.Tables, rows []map[string]interface{}) error {
+	metas, err := fetchColumnMeta(db, table.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if table.IsAuth {
+			for column := range authSecretColumns {
+				delete(row, column)
+			}
+		}
+
+		for column, meta := range metas {
+			if !canAccessColumn(c, table, meta, row) {
+				delete(row, column)
+			}
+		}
+	}
+
+	return nil
+}
+
+// rowFilterOperators are the operators of the filters of the reads
+var rowFilterOperators = map[string]bool{
+	"=":        true,
+	"==":       true,
+	"!=":       true,
+	"<>":       true,
+	"<":        true,
+	"<=":       true,
+	">":        true,
+	">=":       true,
+	"like":     true,
+	"not like": true,
+	"within":   true,
+}
+
+// validateFilters rejects the filters on anything but a column of the table
+// and those with an unknown operator. The filters are written into the SQL,
+// an expression such as lower(cost) would get around checkFilterAccess
+func validateFilters(db *gorm.DB, table model.Tables, filters []Filter) error {
+	if len(filters) == 0 {
+		return nil
+	}
+
+	columns, err := fetchColumns(db, table.Name)
+	if err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, column := range columns {
+		if !(table.IsAuth && authSecretColumns[column.Name]) {
+			names[column.Name] = true
+		}
+	}
+
+	for _, filter := range filters {
+		if !names[filter.Column] {
+			return fmt.Errorf("column %s not found", filter.Column)
+		}
+		if !rowFilterOperators[strings.ToLower(strings.TrimSpace(filter.Operator))] {
+			return fmt.Errorf("unsupported filter operator %s", filter.Operator)
+		}
+	}
+
+	return nil
+}
+
+// checkFilterAccess prevents non admins from filtering on restricted columns,
+// which would leak their values even though they are stripped from the result
+func checkFilterAccess(db *gorm.DB, c echo.Context, tableName string, filters []Filter) error {
+	if isAdmin(c) {
+		return nil
+	}
+
+	metas, err := fetchColumnMeta(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	for _, filter := range filters {
+		if meta, ok := metas[filter.Column]; ok && meta.Access != model.ACCESS_PUBLIC {
+			return fmt.Errorf("cannot filter on restricted column %s", filter.Column)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// recordActivity keeps track of a change made by the admin behind the
+// request, requests made by application users aren't recorded
+func recordActivity(db *gorm.DB, c echo.Context, action string, target string, detail string) {
+	if !isAdmin(c) {
+		return
+	}
+
+	activity := model.AdminActivity{
+		AdminID: currentUserID(c),
+		Action:  action,
+		Target:  target,
+		Detail:  detail,
+	}
+	go db.Create(&activity)
+}
+
+type activityReq struct {
+	AdminID  string `query:"admin_id"`
+	Action   string `query:"action"`
+	Page     int    `query:"page"`
+	PageSize int    `query:"page_size"`
+}
+
+type activityEntry struct {
+	model.AdminActivity
+	Email    string `json:"email"`
+	Username string `json:"username"`
+}
+
+func fetchActivity(db *gorm.DB, params activityReq) ([]activityEntry, error) {
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.PageSize < 1 || params.PageSize > 100 {
+		params.PageSize = 50
+	}
+
+	query := db.Table("admin_activity").
+		Select("admin_activity.*, admin.email, admin.username").
+		Joins("LEFT JOIN admin ON admin.id = admin_activity.admin_id")
+	if params.AdminID != "" {
+		query = query.Where("admin_activity.admin_id = ?", params.AdminID)
+	}
+	if params.Action != "" {
+		query = query.Where("admin_activity.action = ?", params.Action)
+	}
+
+	entries := []activityEntry{}
+	err := query.
+		Order("admin_activity.id DESC").
+		Offset((params.Page - 1) * params.PageSize).
+		Limit(params.PageSize).
+		Scan(&entries).Error
+
+	return entries, err
+}
+
+// FetchActivity lists the most recent changes made by every admin
+func (h *AdminAPIImpl) FetchActivity(c echo.Context) error {
+	var params *activityReq = new(activityReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	entries, err := fetchActivity(h.db, *params)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
+
+// FetchAdminActivity lists the most recent changes made by a single admin
+func (h *AdminAPIImpl) FetchAdminActivity(c echo.Context) error {
+	var params *activityReq = new(activityReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	params.AdminID = c.Param("id")
+
+	entries, err := fetchActivity(h.db, *params)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, entries)
+}
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"react-golang/src/backend/config"
 	"react-golang/src/backend/constants"
 	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/middleware"
 	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
 	"react-golang/src/backend/utils"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sarulabs/di"
@@ -17,6 +22,13 @@ type AdminAPI interface {
 	Register(c echo.Context) error
 	Login(c echo.Context) error
 	FetchAdminList(c echo.Context) error
+	UpdateRole(c echo.Context) error
+	EnrollTOTP(c echo.Context) error
+	ConfirmTOTP(c echo.Context) error
+	DisableTOTP(c echo.Context) error
+	FetchActivity(c echo.Context) error
+	FetchAdminActivity(c echo.Context) error
+	Impersonate(c echo.Context) error
 }
 
 type AdminAPIImpl struct {
@@ -29,60 +41,91 @@ func NewAdminAPI(ioc di.Container) AdminAPI {
 	}
 }
 
-type adminRegisterReq struct {
-	Email        string `json:"email"`
-	Username     string `json:"username"`
-	Password     string `json:"password"`
-	ReturnsToken bool   `json:"returns_token"`
-}
-
-func (h *AdminAPIImpl) Register(c echo.Context) error {
-	var body *adminRegisterReq = new(adminRegisterReq)
-	if err := c.Bind(body); err != nil {
-		return c.String(http.StatusBadRequest, "Bad Request")
-	}
+var ErrAdminExists = errors.New("email already exists")
 
+// CreateAdmin adds an admin of the role, the role is not checked
+func CreateAdmin(db *gorm.DB, email string, username string, password string, role string) (model.Admin, error) {
 	var exist int64
-	err := h.db.Model(&model.Admin{}).
-		Where("email = ?", body.Email).
+	err := db.Model(&model.Admin{}).
+		Where("email = ?", email).
 		Count(&exist).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return model.Admin{}, err
 	}
 
 	if exist > 0 {
-		return c.String(http.StatusBadRequest, "Email already exists")
+		return model.Admin{}, ErrAdminExists
 	}
 
-	hashedPassword, salt, err := auth_libraries.EncryptPassword(body.Password)
+	hashedPassword, salt, err := auth_libraries.EncryptPassword(password)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return model.Admin{}, err
 	}
 
 	id, _ := utils.GenerateRandomString(16)
-	newAdmin := model.Admin{
+	admin := model.Admin{
 		ID:       id,
-		Email:    body.Email,
-		Username: body.Username,
+		Email:    email,
+		Username: username,
 		Password: hashedPassword,
 		Salt:     salt,
+		Role:     role,
+	}
+
+	return admin, db.Create(&admin).Error
+}
+
+type adminRegisterReq struct {
+	Email        string `json:"email"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Role         string `json:"role"`
+	ReturnsToken bool   `json:"returns_token"`
+}
+
+func IsValidAdminRole(role string) bool {
+	return role == model.ADMIN_ROLE_OWNER || role == model.ADMIN_ROLE_EDITOR || role == model.ADMIN_ROLE_READ_ONLY
+}
+
+// Register creates the first admin as owner without authentication, any
+// further admin can only be added by an owner
+func (h *AdminAPIImpl) Register(c echo.Context) error {
+	var body *adminRegisterReq = new(adminRegisterReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
-	err = h.db.Create(&newAdmin).Error
+	var adminCount int64
+	if err := h.db.Model(&model.Admin{}).Count(&adminCount).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if adminCount == 0 {
+		body.Role = model.ADMIN_ROLE_OWNER
+	} else {
+		if !middleware.HasAdminRole(c, model.ADMIN_ROLE_OWNER) {
+			return pkg_apierror.Message(c, http.StatusForbidden, "only owners can register admins")
+		}
+		if body.Role == "" {
+			body.Role = model.ADMIN_ROLE_READ_ONLY
+		}
+		if !IsValidAdminRole(body.Role) {
+			return pkg_apierror.Message(c, http.StatusBadRequest, "invalid admin role")
+		}
+	}
+
+	newAdmin, err := CreateAdmin(h.db, body.Email, body.Username, body.Password, body.Role)
+	if errors.Is(err, ErrAdminExists) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "email already exists")
+	}
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	if body.ReturnsToken {
-		token, err := auth_libraries.GenerateJWT(map[string]interface{}{
-			"sub":   id,
-			"email": newAdmin.Email,
-			"roles": []string{"user", "admin"},
-		})
+		token, err := generateAdminToken(h.db, c, newAdmin)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 		}
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"message": "success",
@@ -98,41 +141,95 @@ func (h *AdminAPIImpl) Register(c echo.Context) error {
 type adminLoginReq struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+const adminTOTPEnrollScope = "totp_enroll"
+
+func generateAdminToken(db *gorm.DB, c echo.Context, admin model.Admin) (string, error) {
+	return issueToken(db, c, "admin", admin.ID, adminTokenLifetime(), map[string]interface{}{
+		"sub":        admin.ID,
+		"email":      admin.Email,
+		"roles":      []string{"user", "admin"},
+		"admin_role": admin.Role,
+	})
 }
 
 func (h *AdminAPIImpl) Login(c echo.Context) error {
 	var body *adminLoginReq = new(adminLoginReq)
 	if err := c.Bind(body); err != nil {
-		return c.String(http.StatusBadRequest, "Bad Request")
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	attemptKeys := loginAttemptKeys(c, "admin", body.Email)
+	lockedUntil, err := loginLockedUntil(attemptKeys)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !lockedUntil.IsZero() {
+		return lockedResponse(c, lockedUntil)
 	}
 
 	var admin model.Admin
-	err := h.db.Model(&model.Admin{}).
+	err = h.db.Model(&model.Admin{}).
 		Where("email = ?", body.Email).
 		First(&admin).Error
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-			"error": "Invalid email or password",
-		})
+		return loginFailed(c, attemptKeys, "Invalid email or password")
 	}
 
 	if !auth_libraries.VerifyPassword(body.Password, admin.Salt, admin.Password) {
-		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-			"error": "Invalid email or password",
+		return loginFailed(c, attemptKeys, "Invalid email or password")
+	}
+
+	if admin.TOTPEnabled {
+		if body.Code == "" {
+			return pkg_apierror.Write(c, http.StatusUnauthorized, pkg_apierror.Response{
+				Message: "two factor code required",
+				Details: map[string]interface{}{"totp_required": true},
+			})
+		}
+
+		ok, remaining := verifySecondFactor(admin.TOTPSecret, admin.RecoveryCodes, body.Code)
+		if !ok {
+			return loginFailed(c, attemptKeys, "invalid two factor code")
+		}
+		if remaining != admin.RecoveryCodes {
+			err := h.db.Model(&model.Admin{}).
+				Where("id = ?", admin.ID).
+				Update("recovery_codes", remaining).Error
+			if err != nil {
+				return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+			}
+		}
+	} else if config.GetInstance().RequireAdminTOTP {
+		clearLoginAttempts(attemptKeys)
+
+		// the token can only be used to enroll, a regular one is returned
+		// once the enrollment is confirmed
+		token, err := issueToken(h.db, c, "admin", admin.ID, adminTokenLifetime(), map[string]interface{}{
+			"sub":   admin.ID,
+			"email": admin.Email,
+			"roles": []string{"user", "admin"},
+			"scope": adminTOTPEnrollScope,
+		})
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"token":                    token,
+			"totp_enrollment_required": true,
 		})
 	}
 
-	token, err := auth_libraries.GenerateJWT(map[string]interface{}{
-		"sub":   admin.ID,
-		"email": admin.Email,
-		"roles": []string{"user", "admin"},
-	})
+	token, err := generateAdminToken(h.db, c, admin)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
+	clearLoginAttempts(attemptKeys)
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"token": token,
 	})
@@ -143,7 +240,7 @@ func (h *AdminAPIImpl) FetchAdminList(c echo.Context) error {
 
 	err := h.db.Find(&admins).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	columns := []model.Column{}
@@ -151,12 +248,12 @@ func (h *AdminAPIImpl) FetchAdminList(c echo.Context) error {
 		Scan(&columns).
 		Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	cleanedColumns := []model.Column{}
 	for _, column := range columns {
-		if column.Name != "password" && column.Name != "salt" {
+		if column.Name != "password" && column.Name != "salt" && column.Name != "totp_secret" && column.Name != "recovery_codes" {
 			cleanedColumns = append(cleanedColumns, column)
 		}
 	}
@@ -166,3 +263,259 @@ func (h *AdminAPIImpl) FetchAdminList(c echo.Context) error {
 		"columns": cleanedColumns,
 	})
 }
+
+type updateAdminRoleReq struct {
+	Role string `json:"role"`
+}
+
+func (h *AdminAPIImpl) UpdateRole(c echo.Context) error {
+	adminID := c.Param("id")
+
+	var body *updateAdminRoleReq = new(updateAdminRoleReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if !IsValidAdminRole(body.Role) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid admin role")
+	}
+
+	// keep at least one owner so the instance can still be administered
+	if body.Role != model.ADMIN_ROLE_OWNER {
+		var owners int64
+		err := h.db.Model(&model.Admin{}).
+			Where("role = ?", model.ADMIN_ROLE_OWNER).
+			Where("id != ?", adminID).
+			Count(&owners).Error
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		if owners == 0 {
+			return pkg_apierror.Message(c, http.StatusBadRequest, "at least one owner is required")
+		}
+	}
+
+	// the role is a claim of the tokens, the sessions of the admin are
+	// revoked so they log in again with the new one
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Admin{}).
+			Where("id = ?", adminID).
+			Update("role", body.Role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Where("`table` = ?", "admin").
+			Where("user_id = ?", adminID).
+			Delete(&model.Session{}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "admin not found")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
+
+func (h *AdminAPIImpl) currentAdmin(c echo.Context) (model.Admin, error) {
+	var admin model.Admin
+	err := h.db.Where("id = ?", currentUserID(c)).First(&admin).Error
+
+	return admin, err
+}
+
+// EnrollTOTP generates a new secret for the current admin, 2FA is only
+// enabled once a code is confirmed with ConfirmTOTP
+func (h *AdminAPIImpl) EnrollTOTP(c echo.Context) error {
+	if !isAdmin(c) {
+		return pkg_apierror.Message(c, http.StatusForbidden, "admin access required")
+	}
+
+	admin, err := h.currentAdmin(c)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusUnauthorized, err)
+	}
+	if admin.TOTPEnabled {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is already enabled")
+	}
+
+	secret, encrypted, url, err := enrollTOTP(config.GetInstance().AppName, admin.Email)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	err = h.db.Model(&model.Admin{}).
+		Where("id = ?", admin.ID).
+		Update("totp_secret", encrypted).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"secret": secret,
+		"url":    url,
+	})
+}
+
+type totpCodeReq struct {
+	Code string `json:"code"`
+}
+
+// ConfirmTOTP enables 2FA once the admin proves the secret was enrolled,
+// returning the recovery codes and a regular token
+func (h *AdminAPIImpl) ConfirmTOTP(c echo.Context) error {
+	if !isAdmin(c) {
+		return pkg_apierror.Message(c, http.StatusForbidden, "admin access required")
+	}
+
+	var body *totpCodeReq = new(totpCodeReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	admin, err := h.currentAdmin(c)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusUnauthorized, err)
+	}
+	if admin.TOTPEnabled {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is already enabled")
+	}
+	if !verifyTOTPCode(admin.TOTPSecret, body.Code) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid two factor code")
+	}
+
+	codes, stored, err := generateRecoveryCodes()
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	err = h.db.Model(&model.Admin{}).
+		Where("id = ?", admin.ID).
+		Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"recovery_codes": stored,
+		}).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	token, err := generateAdminToken(h.db, c, admin)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recovery_codes": codes,
+		"token":          token,
+	})
+}
+
+func (h *AdminAPIImpl) DisableTOTP(c echo.Context) error {
+	if config.GetInstance().RequireAdminTOTP {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is required for admins")
+	}
+
+	var body *totpCodeReq = new(totpCodeReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	admin, err := h.currentAdmin(c)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusUnauthorized, err)
+	}
+	if !admin.TOTPEnabled {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is not enabled")
+	}
+	if ok, _ := verifySecondFactor(admin.TOTPSecret, admin.RecoveryCodes, body.Code); !ok {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid two factor code")
+	}
+
+	err = h.db.Model(&model.Admin{}).
+		Where("id = ?", admin.ID).
+		Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    "",
+			"recovery_codes": "",
+		}).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
+
+const (
+	defaultImpersonationLifetime = 15 * time.Minute
+	maxImpersonationLifetime     = time.Hour
+)
+
+type impersonateReq struct {
+	Minutes int `json:"minutes"`
+}
+
+// Impersonate issues a short lived token acting as a user of an auth table,
+// the session keeps track of the admin who requested it
+func (h *AdminAPIImpl) Impersonate(c echo.Context) error {
+	tableName := c.Param("table_name")
+	userID := c.Param("user_id")
+
+	var body *impersonateReq = new(impersonateReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	lifetime := defaultImpersonationLifetime
+	if body.Minutes > 0 {
+		lifetime = time.Duration(body.Minutes) * time.Minute
+	}
+	if lifetime > maxImpersonationLifetime {
+		lifetime = maxImpersonationLifetime
+	}
+
+	table, err := getTableInfo(h.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !table.IsAuth || table.IsSystem {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+	}
+
+	var user map[string]interface{}
+	err = h.db.Table(tableName).
+		Where("id = ?", userID).
+		Take(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg_apierror.Message(c, http.StatusNotFound, "user does not exist")
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	claims, err := userTokenClaims(h.db, tableName, user)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	claims["impersonated_by"] = currentUserID(c)
+
+	token, err := issueToken(h.db, c, tableName, userID, lifetime, claims)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(h.db, c, model.ACTIVITY_IMPERSONATE, tableName, userID)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_at": time.Now().Add(lifetime),
+	})
+}
@@ -1,10 +1,20 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"react-golang/src/backend/config"
 	"react-golang/src/backend/constants"
+	"react-golang/src/backend/events"
 	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	pkg_mailer "react-golang/src/backend/pkg/mailer"
 	"react-golang/src/backend/utils"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sarulabs/di"
@@ -14,15 +24,22 @@ import (
 type AuthAPI interface {
 	Register(c echo.Context) error
 	Login(c echo.Context) error
+	EnrollTOTP(c echo.Context) error
+	ConfirmTOTP(c echo.Context) error
+	DisableTOTP(c echo.Context) error
+	RequestMagicLink(c echo.Context) error
+	VerifyMagicLink(c echo.Context) error
 }
 
 type AuthAPIImpl struct {
-	db *gorm.DB
+	db     *gorm.DB
+	mailer pkg_mailer.Mailer
 }
 
 func NewAuthAPI(ioc di.Container) AuthAPI {
 	return &AuthAPIImpl{
-		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		db:     ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		mailer: ioc.Get(constants.CONTAINER_MAILER_NAME).(pkg_mailer.Mailer),
 	}
 }
 
@@ -36,20 +53,20 @@ func (h *AuthAPIImpl) Register(c echo.Context) error {
 
 	var body *registerReq = new(registerReq)
 	if err := c.Bind(body); err != nil {
-		return c.String(http.StatusBadRequest, "Bad Request")
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	if body.Data["email"] == nil || body.Data["password"] == nil {
-		return c.String(http.StatusBadRequest, "Bad Request")
+		return pkg_apierror.Message(c, http.StatusBadRequest, "email and password are required")
 	}
 
 	table, err := getTableInfo(h.db, tableName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	if !table.IsAuth {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "table is not user type"})
+		return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
 	}
 
 	var exist int64
@@ -57,21 +74,23 @@ func (h *AuthAPIImpl) Register(c echo.Context) error {
 		Where("email = ?", body.Data["email"]).
 		Count(&exist).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	if exist > 0 {
-		return c.String(http.StatusBadRequest, "Email already exists")
+		return pkg_apierror.Message(c, http.StatusBadRequest, "email already exists")
 	}
 
 	hashedPassword, salt, err := auth_libraries.EncryptPassword(body.Data["password"].(string))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
-	id, _ := utils.GenerateRandomString(16)
+	generated, err := generateRowID(table)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	id := generated.(string)
 	newUser := map[string]interface{}{
 		"id":       id,
 		"email":    body.Data["email"],
@@ -81,17 +100,25 @@ func (h *AuthAPIImpl) Register(c echo.Context) error {
 
 	err = h.db.Table(tableName).Create(&newUser).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
+	invalidateRowCounts(tableName)
+	events.Publish(events.Event{
+		Name:   events.USER_REGISTERED,
+		Table:  tableName,
+		Record: map[string]interface{}{"id": id, "email": newUser["email"]},
+	})
 
 	if body.ReturnsToken {
-		token, err := auth_libraries.GenerateJWT(map[string]interface{}{
-			"sub":   newUser["id"].(string),
-			"email": newUser["email"].(string),
-			"roles": []string{"user", "admin"},
+		token, err := issueToken(h.db, c, tableName, id, userTokenLifetime(), map[string]interface{}{
+			"sub":        newUser["id"].(string),
+			"email":      newUser["email"].(string),
+			"roles":      []string{"user", tableName},
+			"table":      tableName,
+			"user_roles": []string{},
 		})
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 		}
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"message": "success",
@@ -106,6 +133,7 @@ func (h *AuthAPIImpl) Register(c echo.Context) error {
 
 type loginReq struct {
 	Data map[string]interface{} `json:"data"`
+	Code string                 `json:"code"`
 }
 
 func (h *AuthAPIImpl) Login(c echo.Context) error {
@@ -113,50 +141,372 @@ func (h *AuthAPIImpl) Login(c echo.Context) error {
 
 	var body *loginReq = new(loginReq)
 	if err := c.Bind(body); err != nil {
-		return c.String(http.StatusBadRequest, "Bad Request")
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	if body.Data["email"] == nil || body.Data["password"] == nil {
-		return c.String(http.StatusBadRequest, "Bad Request")
+		return pkg_apierror.Message(c, http.StatusBadRequest, "email and password are required")
 	}
 
 	table, err := getTableInfo(h.db, tableName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	if !table.IsAuth {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "table is not user type"})
+		return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+	}
+
+	email, _ := body.Data["email"].(string)
+	attemptKeys := loginAttemptKeys(c, tableName, email)
+	lockedUntil, err := loginLockedUntil(attemptKeys)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !lockedUntil.IsZero() {
+		return lockedResponse(c, lockedUntil)
 	}
 
 	var user map[string]interface{}
 	err = h.db.Table(tableName).
 		Where("email = ?", body.Data["email"]).
-		First(&user).Error
+		Take(&user).Error
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-			"error": "Invalid email or password",
-		})
+		return loginFailed(c, attemptKeys, "Invalid email or password")
 	}
 
 	if !auth_libraries.VerifyPassword(body.Data["password"].(string), user["salt"].(string), user["password"].(string)) {
-		return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-			"error": "Invalid email or password",
-		})
+		return loginFailed(c, attemptKeys, "Invalid email or password")
 	}
 
-	token, err := auth_libraries.GenerateJWT(map[string]interface{}{
-		"sub":   user["id"].(string),
-		"email": user["email"].(string),
-		"roles": []string{"user", tableName},
-	})
+	return h.completeLogin(c, table, user, body.Code)
+}
+
+func userTokenClaims(db *gorm.DB, tableName string, user map[string]interface{}) (map[string]interface{}, error) {
+	userRoles, err := fetchUserRoles(db, tableName, user["id"].(string))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return nil, err
 	}
 
+	email, _ := user["email"].(string)
+	return map[string]interface{}{
+		"sub":        user["id"].(string),
+		"email":      email,
+		"roles":      []string{"user", tableName},
+		"table":      tableName,
+		"user_roles": userRoles,
+	}, nil
+}
+
+// completeLogin checks the second factor of a user whose first factor was
+// verified and returns their token
+func (h *AuthAPIImpl) completeLogin(c echo.Context, table model.Tables, user map[string]interface{}, code string) error {
+	email, _ := user["email"].(string)
+	attemptKeys := loginAttemptKeys(c, table.Name, email)
+
+	if table.AllowTOTP && isTruthy(user["totp_enabled"]) {
+		if code == "" {
+			return pkg_apierror.Write(c, http.StatusUnauthorized, pkg_apierror.Response{
+				Message: "two factor code required",
+				Details: map[string]interface{}{"totp_required": true},
+			})
+		}
+
+		secret, _ := user["totp_secret"].(string)
+		recoveryCodes, _ := user["recovery_codes"].(string)
+		ok, remaining := verifySecondFactor(secret, recoveryCodes, code)
+		if !ok {
+			return loginFailed(c, attemptKeys, "invalid two factor code")
+		}
+		if remaining != recoveryCodes {
+			err := h.db.Table(table.Name).
+				Where("id = ?", user["id"]).
+				Update("recovery_codes", remaining).Error
+			if err != nil {
+				return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+			}
+		}
+	}
+
+	claims, err := userTokenClaims(h.db, table.Name, user)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	token, err := issueToken(h.db, c, table.Name, user["id"].(string), userTokenLifetime(), claims)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	clearLoginAttempts(attemptKeys)
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"token": token,
 	})
 }
+
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	default:
+		return false
+	}
+}
+
+// currentTOTPUser loads the authenticated user of an auth table allowing 2FA
+func (h *AuthAPIImpl) currentTOTPUser(c echo.Context, tableName string) (map[string]interface{}, error) {
+	if userTable, _ := c.Get("user_table").(string); userTable != tableName {
+		return nil, errors.New("token does not belong to this table")
+	}
+
+	table, err := getTableInfo(h.db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if !table.IsAuth || !table.AllowTOTP {
+		return nil, errors.New("two factor authentication is not enabled for this table")
+	}
+
+	var user map[string]interface{}
+	err = h.db.Table(tableName).
+		Where("id = ?", currentUserID(c)).
+		Take(&user).Error
+
+	return user, err
+}
+
+// EnrollTOTP generates a new secret for the current user and returns the
+// otpauth url for authenticator apps
+func (h *AuthAPIImpl) EnrollTOTP(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	user, err := h.currentTOTPUser(c, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if isTruthy(user["totp_enabled"]) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is already enabled")
+	}
+
+	email, _ := user["email"].(string)
+	secret, encrypted, url, err := enrollTOTP(config.GetInstance().AppName, email)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	err = h.db.Table(tableName).
+		Where("id = ?", user["id"]).
+		Update("totp_secret", encrypted).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"secret": secret,
+		"url":    url,
+	})
+}
+
+func (h *AuthAPIImpl) ConfirmTOTP(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var body *totpCodeReq = new(totpCodeReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	user, err := h.currentTOTPUser(c, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if isTruthy(user["totp_enabled"]) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is already enabled")
+	}
+
+	secret, _ := user["totp_secret"].(string)
+	if !verifyTOTPCode(secret, body.Code) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid two factor code")
+	}
+
+	codes, stored, err := generateRecoveryCodes()
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	err = h.db.Table(tableName).
+		Where("id = ?", user["id"]).
+		Updates(map[string]interface{}{
+			"totp_enabled":   true,
+			"recovery_codes": stored,
+		}).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recovery_codes": codes,
+	})
+}
+
+func (h *AuthAPIImpl) DisableTOTP(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var body *totpCodeReq = new(totpCodeReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	user, err := h.currentTOTPUser(c, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if !isTruthy(user["totp_enabled"]) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "two factor authentication is not enabled")
+	}
+
+	secret, _ := user["totp_secret"].(string)
+	recoveryCodes, _ := user["recovery_codes"].(string)
+	if ok, _ := verifySecondFactor(secret, recoveryCodes, body.Code); !ok {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid two factor code")
+	}
+
+	err = h.db.Table(tableName).
+		Where("id = ?", user["id"]).
+		Updates(map[string]interface{}{
+			"totp_enabled":   false,
+			"totp_secret":    nil,
+			"recovery_codes": nil,
+		}).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
+
+const defaultMagicLinkLifetime = 15 * time.Minute
+
+func magicLinkLifetime() time.Duration {
+	return lifetimeSetting(config.GetInstance().MagicLinkTTLMinutes, defaultMagicLinkLifetime)
+}
+
+type magicLinkReq struct {
+	Email string `json:"email"`
+}
+
+// RequestMagicLink emails a single use login link to the user. The response
+// is the same whether the email exists or not
+func (h *AuthAPIImpl) RequestMagicLink(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var body *magicLinkReq = new(magicLinkReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if body.Email == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "email is required")
+	}
+
+	table, err := getTableInfo(h.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !table.IsAuth || !table.AllowMagicLink {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "magic link is not enabled for this table")
+	}
+
+	var user map[string]interface{}
+	err = h.db.Table(tableName).
+		Where("email = ?", body.Email).
+		Take(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusOK, map[string]interface{}{"message": "success"})
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	token, err := utils.GenerateRandomString(48)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// expired links are never used again
+	h.db.Where("expires_at < ?", time.Now()).Delete(&model.MagicLinkToken{})
+
+	err = h.db.Create(&model.MagicLinkToken{
+		TokenHash: auth_libraries.HashRecoveryCode(token),
+		Table:     tableName,
+		UserID:    user["id"].(string),
+		ExpiresAt: time.Now().Add(magicLinkLifetime()),
+	}).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	appConfig := config.GetInstance()
+	link := fmt.Sprintf("%s/magic-link?table=%s&token=%s", strings.TrimRight(appConfig.AppURL, "/"), url.QueryEscape(tableName), token)
+	message := fmt.Sprintf("Use the link below to log in to %s. It expires in %d minutes.\n\n%s\n\nIf you didn't ask for it, you can ignore this email.",
+		appConfig.AppName, int(magicLinkLifetime().Minutes()), link)
+
+	if err := h.mailer.Send(body.Email, fmt.Sprintf("Log in to %s", appConfig.AppName), message); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"message": "success"})
+}
+
+type verifyMagicLinkReq struct {
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+// VerifyMagicLink exchanges a magic link token for a JWT, the token can't be
+// used again even when the second factor is missing
+func (h *AuthAPIImpl) VerifyMagicLink(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var body *verifyMagicLinkReq = new(verifyMagicLinkReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(h.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !table.IsAuth || !table.AllowMagicLink {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "magic link is not enabled for this table")
+	}
+
+	var magicLink model.MagicLinkToken
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("token_hash = ?", auth_libraries.HashRecoveryCode(body.Token)).
+			Where("`table` = ?", tableName).
+			Take(&magicLink).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("token_hash = ?", magicLink.TokenHash).Delete(&model.MagicLinkToken{}).Error
+	})
+	if err != nil || time.Now().After(magicLink.ExpiresAt) {
+		return pkg_apierror.Message(c, http.StatusUnauthorized, "invalid or expired link")
+	}
+
+	var user map[string]interface{}
+	err = h.db.Table(tableName).
+		Where("id = ?", magicLink.UserID).
+		Take(&user).Error
+	if err != nil {
+		return pkg_apierror.Message(c, http.StatusUnauthorized, "invalid or expired link")
+	}
+
+	return h.completeLogin(c, table, user, body.Code)
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"react-golang/src/backend/service"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type BackupAPI interface {
+	FetchBackups(c echo.Context) error
+	CreateBackup(c echo.Context) error
+	DownloadBackup(c echo.Context) error
+	RestoreBackup(c echo.Context) error
+	DeleteBackup(c echo.Context) error
+	FetchRestorePoints(c echo.Context) error
+	CreateIncrementalBackup(c echo.Context) error
+	RestoreToPoint(c echo.Context) error
+}
+
+type BackupAPIImpl struct {
+	db     *gorm.DB
+	backup service.BackupService
+}
+
+func NewBackupAPI(ioc di.Container) BackupAPI {
+	return &BackupAPIImpl{
+		db:     ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		backup: ioc.Get(constants.CONTAINER_BACKUP_NAME).(service.BackupService),
+	}
+}
+
+func backupErrorStatus(err error) int {
+	if errors.Is(err, service.ErrBackupNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, service.ErrChecksumMismatch) {
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// integrityError answers with the findings of the integrity check err failed,
+// it reports whether err is such a failure
+func integrityError(c echo.Context, err error) (bool, error) {
+	var integrity *pkg_sqlite.IntegrityError
+	if !errors.As(err, &integrity) {
+		return false, nil
+	}
+
+	return true, pkg_apierror.Write(c, http.StatusConflict, pkg_apierror.Response{
+		Code:    pkg_apierror.CODE_INTEGRITY_CHECK_FAILED,
+		Message: err.Error(),
+		Details: map[string]interface{}{"integrity": integrity.Report},
+	})
+}
+
+func (b *BackupAPIImpl) FetchBackups(c echo.Context) error {
+	backups, err := b.backup.FetchBackups(c.Request().Context())
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, backups)
+}
+
+func (b *BackupAPIImpl) CreateBackup(c echo.Context) error {
+	backup, err := b.backup.Backup(c.Request().Context())
+	if failed, err := integrityError(c, err); failed {
+		return err
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(b.db, c, model.ACTIVITY_BACKUP, backup.Name, "")
+
+	return c.JSON(http.StatusOK, backup)
+}
+
+func (b *BackupAPIImpl) DownloadBackup(c echo.Context) error {
+	name := c.Param("name")
+
+	path, err := b.backup.Path(c.Request().Context(), name)
+	if err != nil {
+		return pkg_apierror.Error(c, backupErrorStatus(err), err)
+	}
+
+	return c.Attachment(path, name)
+}
+
+// RestoreBackup replaces the database with a backup, the signing keys, the
+// cron jobs, the triggers and the cached schemas are reloaded since they come
+// from the database too
+func (b *BackupAPIImpl) RestoreBackup(c echo.Context) error {
+	name := c.Param("name")
+
+	// a restored database failing the integrity check is in place already,
+	// what comes from it is reloaded all the same
+	err := b.backup.Restore(c.Request().Context(), name)
+	var integrity *pkg_sqlite.IntegrityError
+	if err != nil && !errors.As(err, &integrity) {
+		return pkg_apierror.Error(c, backupErrorStatus(err), err)
+	}
+
+	if err := LoadSigningKeys(b.db); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadCronJobs()
+	reloadTriggers(b.db)
+	flushTableCache()
+
+	recordActivity(b.db, c, model.ACTIVITY_RESTORE_BACKUP, name, "")
+	if failed, err := integrityError(c, err); failed {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
+
+func (b *BackupAPIImpl) DeleteBackup(c echo.Context) error {
+	name := c.Param("name")
+
+	if err := b.backup.Delete(c.Request().Context(), name); err != nil {
+		return pkg_apierror.Error(c, backupErrorStatus(err), err)
+	}
+
+	recordActivity(b.db, c, model.ACTIVITY_DELETE_BACKUP, name, "")
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (b *BackupAPIImpl) FetchRestorePoints(c echo.Context) error {
+	points, err := b.backup.FetchRestorePoints(c.Request().Context())
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, points)
+}
+
+func (b *BackupAPIImpl) CreateIncrementalBackup(c echo.Context) error {
+	point, err := b.backup.IncrementalBackup(c.Request().Context())
+	if failed, err := integrityError(c, err); failed {
+		return err
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(b.db, c, model.ACTIVITY_BACKUP, point.Chain, "incremental")
+
+	return c.JSON(http.StatusOK, point)
+}
+
+type restoreToPointReq struct {
+	// Time is the point in time to restore to, the latest restore point is
+	// used when empty
+	Time time.Time `json:"time"`
+}
+
+func (b *BackupAPIImpl) RestoreToPoint(c echo.Context) error {
+	var body *restoreToPointReq = new(restoreToPointReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	point, err := b.backup.RestoreToPoint(c.Request().Context(), body.Time)
+	var integrity *pkg_sqlite.IntegrityError
+	if err != nil && !errors.As(err, &integrity) {
+		return pkg_apierror.Error(c, backupErrorStatus(err), err)
+	}
+
+	if err := LoadSigningKeys(b.db); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadCronJobs()
+	reloadTriggers(b.db)
+	flushTableCache()
+
+	recordActivity(b.db, c, model.ACTIVITY_RESTORE_BACKUP, point.Chain, point.Time.Format(time.RFC3339Nano))
+	if failed, err := integrityError(c, err); failed {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, point)
+}
@@ -10,13 +10,27 @@ import (
 )
 
 type API struct {
-	app      *echo.Echo
-	router   *echo.Group
-	Admin    AdminAPI
-	Auth     AuthAPI
-	Database DatabaseAPI
-	Function FunctionAPI
-	Setting  SettingAPI
+	app         *echo.Echo
+	router      *echo.Group
+	Admin       AdminAPI
+	Auth        AuthAPI
+	Backup      BackupAPI
+	Cron        CronAPI
+	Database    DatabaseAPI
+	Function    FunctionAPI
+	Maintenance MaintenanceAPI
+	Privacy     PrivacyAPI
+	Realtime    RealtimeAPI
+	Role        RoleAPI
+	SAML        SAMLAPI
+	Schema      SchemaAPI
+	Session     SessionAPI
+	Setting     SettingAPI
+	Stats       StatsAPI
+	Storage     StorageAPI
+	Token       TokenAPI
+	Trigger     TriggerAPI
+	Webhook     WebhookAPI
 }
 
 type Search struct {
@@ -25,51 +39,120 @@ type Search struct {
 
 func NewAPI(app *echo.Echo, ioc di.Container) *API {
 	return &API{
-		app:      app,
-		router:   app.Group("/api", middleware.ValidateAPIKey),
-		Admin:    NewAdminAPI(ioc),
-		Auth:     NewAuthAPI(ioc),
-		Database: NewDatabaseAPI(ioc),
-		Function: NewFunctionAPI(ioc),
-		Setting:  NewSettingAPI(ioc),
+		app:         app,
+		router:      app.Group("/api", middleware.ValidateAPIKey),
+		Admin:       NewAdminAPI(ioc),
+		Auth:        NewAuthAPI(ioc),
+		Backup:      NewBackupAPI(ioc),
+		Cron:        NewCronAPI(ioc),
+		Database:    NewDatabaseAPI(ioc),
+		Function:    NewFunctionAPI(ioc),
+		Maintenance: NewMaintenanceAPI(ioc),
+		Privacy:     NewPrivacyAPI(ioc),
+		Realtime:    NewRealtimeAPI(ioc),
+		Role:        NewRoleAPI(ioc),
+		SAML:        NewSAMLAPI(ioc),
+		Schema:      NewSchemaAPI(ioc),
+		Session:     NewSessionAPI(ioc),
+		Setting:     NewSettingAPI(ioc),
+		Stats:       NewStatsAPI(ioc),
+		Storage:     NewStorageAPI(ioc),
+		Token:       NewTokenAPI(ioc),
+		Trigger:     NewTriggerAPI(ioc),
+		Webhook:     NewWebhookAPI(ioc),
 	}
 }
 
+// Router returns the /api group, the routes of the plugins are added to it
+func (api *API) Router() *echo.Group {
+	return api.router
+}
+
 func (api *API) Serve() {
 	api.MainAPI()
 	api.AdminAPI()
 	api.AuthAPI()
+	api.BackupAPI()
+	api.CronAPI()
+	api.MaintenanceAPI()
+	api.PrivacyAPI()
+	api.RealtimeAPI()
+	api.RoleAPI()
+	api.SAMLAPI()
+	api.SchemaAPI()
+	api.SessionAPI()
 	api.SettingAPI()
+	api.StatsAPI()
+	api.StorageAPI()
+	api.TokenAPI()
+	api.WebhookAPI()
+
+	var (
+		readOnly = middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY)
+		editor   = middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR)
+	)
 
 	api.router.POST("/:func_name", api.Function.RunFunction, middleware.RequireAuth(false))
-	api.router.GET("/function", api.Function.FetchFunctionList)
-	api.router.GET("/function/:func_name", api.Function.FetchFunctionDetail)
-	api.router.DELETE("/function/:func_name", api.Function.DeleteFunction)
-	api.router.POST("/function/create", api.Function.CreateFunction)
+	api.router.GET("/function", api.Function.FetchFunctionList, middleware.RequireAuth(true), readOnly)
+	api.router.GET("/function/:func_name", api.Function.FetchFunctionDetail, middleware.RequireAuth(true), readOnly)
+	api.router.DELETE("/function/:func_name", api.Function.DeleteFunction, middleware.RequireAuth(true), editor)
+	api.router.POST("/function/create", api.Function.CreateFunction, middleware.RequireAuth(true), editor)
+
+	api.router.GET("/function/triggers", api.Trigger.FetchTriggers, middleware.RequireAuth(true), readOnly)
+	api.router.POST("/function/triggers", api.Trigger.CreateTrigger, middleware.RequireAuth(true), editor)
+	api.router.PUT("/function/triggers/:name", api.Trigger.UpdateTrigger, middleware.RequireAuth(true), editor)
+	api.router.DELETE("/function/triggers/:name", api.Trigger.DeleteTrigger, middleware.RequireAuth(true), editor)
 }
 
 func (api *API) MainAPI() {
 	mainRouter := api.router.Group("/main", middleware.RequireAuth(true))
 
+	var (
+		readOnly    = middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY)
+		editor      = middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR)
+		owner       = middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER)
+		writeAccess = middleware.RestrictAdminRole(model.ADMIN_ROLE_EDITOR)
+	)
+
 	mainRouter.GET("/tables", api.Database.FetchAllTables)
-	mainRouter.POST("/query", api.Database.RunQuery)
-	mainRouter.GET("/query", api.Database.FetchQueryHistory)
+	mainRouter.POST("/query", api.Database.RunQuery, owner)
+	mainRouter.GET("/query", api.Database.FetchQueryHistory, readOnly)
+	mainRouter.POST("/cache/flush", api.Database.FlushCache, owner)
 	mainRouter.GET("/:table_name/columns", api.Database.FetchTableColumns)
+	mainRouter.PUT("/:table_name/columns/:column_name", api.Database.UpdateColumnMeta, editor)
+	mainRouter.PUT("/table/:table_name/columns/:column_name/collation", api.Database.UpdateColumnCollation, editor)
+	mainRouter.PUT("/table/:table_name/settings", api.Database.UpdateTableSettings, editor)
+	mainRouter.GET("/table/:table_name/export", api.Database.ExportRows, readOnly)
+	mainRouter.GET("/table/:table_name/stats", api.Database.FetchTableStats, readOnly)
 	mainRouter.POST("/:table_name/rows", api.Database.FetchRows)
 	mainRouter.GET("/:table_name/:id", api.Database.FetchDataByID)
-	mainRouter.POST("/table/create", api.Database.CreateTable)
-	mainRouter.POST("/:table_name/insert", api.Database.InsertData)
-	mainRouter.PUT("/:table_name/update", api.Database.UpdateData)
-	mainRouter.DELETE("/:table_name/rows", api.Database.DeleteData)
-	mainRouter.DELETE("/:table_name", api.Database.DeleteTable)
+	mainRouter.GET("/:table_name/distinct/:column", api.Database.FetchDistinctValues)
+	mainRouter.GET("/:table_name/:id/descendants", api.Database.FetchDescendants)
+	mainRouter.GET("/:table_name/:id/ancestors", api.Database.FetchAncestors)
+	mainRouter.POST("/table/create", api.Database.CreateTable, editor)
+	mainRouter.POST("/view/create", api.Database.CreateView, editor)
+	mainRouter.POST("/table/:table_name/duplicate", api.Database.DuplicateTable, editor)
+	mainRouter.PUT("/table/:table_name/rename", api.Database.RenameTable, owner)
+	mainRouter.POST("/:table_name/insert", api.Database.InsertData, writeAccess)
+	mainRouter.POST("/:table_name/insert/nested", api.Database.InsertNested, writeAccess)
+	mainRouter.PUT("/:table_name/update", api.Database.UpdateData, writeAccess)
+	mainRouter.DELETE("/:table_name/rows", api.Database.DeleteData, writeAccess)
+	mainRouter.DELETE("/:table_name", api.Database.DeleteTable, owner)
 }
 
 func (api *API) AdminAPI() {
 	adminRouter := api.router.Group("/admin")
 
-	adminRouter.POST("/register", api.Admin.Register)
+	adminRouter.POST("/register", api.Admin.Register, middleware.RequireAuth(false))
 	adminRouter.POST("/login", api.Admin.Login)
 	adminRouter.GET("", api.Admin.FetchAdminList)
+	adminRouter.PUT("/:id/role", api.Admin.UpdateRole, middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	adminRouter.POST("/totp/enroll", api.Admin.EnrollTOTP, middleware.RequireScopedAuth(adminTOTPEnrollScope))
+	adminRouter.POST("/totp/confirm", api.Admin.ConfirmTOTP, middleware.RequireScopedAuth(adminTOTPEnrollScope))
+	adminRouter.POST("/totp/disable", api.Admin.DisableTOTP, middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	adminRouter.POST("/impersonate/:table_name/:user_id", api.Admin.Impersonate, middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	adminRouter.GET("/activity", api.Admin.FetchActivity, middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	adminRouter.GET("/:id/activity", api.Admin.FetchAdminActivity, middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
 }
 
 func (api *API) AuthAPI() {
@@ -77,17 +160,179 @@ func (api *API) AuthAPI() {
 
 	authRouter.POST("/register/:table_name", api.Auth.Register)
 	authRouter.POST("/login/:table_name", api.Auth.Login)
+	authRouter.POST("/magic-link/:table_name", api.Auth.RequestMagicLink)
+	authRouter.POST("/magic-link/:table_name/verify", api.Auth.VerifyMagicLink)
+	authRouter.POST("/totp/enroll/:table_name", api.Auth.EnrollTOTP, middleware.RequireAuth(true))
+	authRouter.POST("/totp/confirm/:table_name", api.Auth.ConfirmTOTP, middleware.RequireAuth(true))
+	authRouter.POST("/totp/disable/:table_name", api.Auth.DisableTOTP, middleware.RequireAuth(true))
+}
+
+func (api *API) BackupAPI() {
+	backupRouter := api.router.Group("/backups", middleware.RequireAuth(true))
+
+	backupRouter.GET("", api.Backup.FetchBackups, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	backupRouter.POST("", api.Backup.CreateBackup, middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR))
+	backupRouter.GET("/restore-points", api.Backup.FetchRestorePoints, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	backupRouter.POST("/restore-points/restore", api.Backup.RestoreToPoint, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	backupRouter.POST("/incremental", api.Backup.CreateIncrementalBackup, middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR))
+	backupRouter.GET("/:name", api.Backup.DownloadBackup, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	backupRouter.POST("/:name/restore", api.Backup.RestoreBackup, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	backupRouter.DELETE("/:name", api.Backup.DeleteBackup, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+}
+
+func (api *API) CronAPI() {
+	cronRouter := api.router.Group("/cron", middleware.RequireAuth(true))
+
+	cronRouter.GET("", api.Cron.FetchCronJobs, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	cronRouter.POST("", api.Cron.CreateCronJob, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	cronRouter.GET("/runs", api.Cron.FetchCronRuns, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	cronRouter.POST("/preview", api.Cron.PreviewSchedule, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	cronRouter.GET("/:name/next-runs", api.Cron.FetchNextRuns, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	cronRouter.PUT("/:name", api.Cron.UpdateCronJob, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	cronRouter.DELETE("/:name", api.Cron.DeleteCronJob, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	cronRouter.POST("/:name/run", api.Cron.RunCronJob, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+}
+
+func (api *API) MaintenanceAPI() {
+	maintenanceRouter := api.router.Group("/maintenance", middleware.RequireAuth(true))
+
+	maintenanceRouter.POST("/integrity-check", api.Maintenance.CheckIntegrity, middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR))
+	// VACUUM blocks the writes while it rebuilds the database
+	maintenanceRouter.POST("/:operation", api.Maintenance.RunMaintenance, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+}
+
+func (api *API) PrivacyAPI() {
+	privacyRouter := api.router.Group("/main/privacy", middleware.RequireAuth(true))
+
+	privacyRouter.GET("/:table_name/:user_id/export", api.Privacy.ExportUserData, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	privacyRouter.POST("/:table_name/:user_id/forget", api.Privacy.ForgetUser, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+}
+
+func (api *API) RoleAPI() {
+	roleRouter := api.router.Group("/main/roles", middleware.RequireAuth(true))
+
+	var (
+		readOnly = middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY)
+		owner    = middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER)
+	)
+
+	roleRouter.GET("", api.Role.FetchRoles, readOnly)
+	roleRouter.POST("", api.Role.CreateRole, owner)
+	roleRouter.DELETE("/:role", api.Role.DeleteRole, owner)
+	roleRouter.GET("/users/:table_name/:user_id", api.Role.FetchUserRoles, readOnly)
+	roleRouter.POST("/users/:table_name/:user_id", api.Role.AssignRole, owner)
+	roleRouter.DELETE("/users/:table_name/:user_id/:role", api.Role.UnassignRole, owner)
+}
+
+// SAMLAPI is served outside of /api since the IdP can't send the API key
+func (api *API) SAMLAPI() {
+	samlRouter := api.app.Group("/saml")
+
+	samlRouter.GET("/metadata", api.SAML.Metadata)
+	samlRouter.GET("/login", api.SAML.Login)
+	samlRouter.POST("/acs", api.SAML.AssertionConsumer)
+}
+
+func (api *API) SchemaAPI() {
+	schemaRouter := api.router.Group("/main/schema", middleware.RequireAuth(true))
+
+	schemaRouter.GET("/export", api.Schema.ExportSchema, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	// the statements of a schema document are run as they are, like the
+	// queries only owners run
+	schemaRouter.POST("/import", api.Schema.ImportSchema, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	schemaRouter.POST("/import/dump", api.Schema.ImportDump, middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR))
+	schemaRouter.POST("/diff", api.Schema.DiffSchema, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	schemaRouter.GET("/sdk", api.Schema.GenerateSDK, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+}
+
+func (api *API) SessionAPI() {
+	sessionRouter := api.router.Group("/sessions", middleware.RequireAuth(true))
+
+	sessionRouter.GET("", api.Session.FetchMySessions)
+	sessionRouter.DELETE("", api.Session.RevokeMySessions)
+	sessionRouter.DELETE("/:id", api.Session.RevokeMySession)
+
+	userSessionRouter := api.router.Group("/main/sessions", middleware.RequireAuth(true))
+
+	userSessionRouter.GET("/:table_name/:user_id", api.Session.FetchUserSessions, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	userSessionRouter.DELETE("/:table_name/:user_id", api.Session.RevokeUserSessions, middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR))
+	userSessionRouter.DELETE("/:id", api.Session.RevokeSession, middleware.RequireAdminRole(model.ADMIN_ROLE_EDITOR))
 }
 
 func (api *API) SettingAPI() {
-	settingRouter := api.router.Group("/settings")
+	settingRouter := api.router.Group("/settings", middleware.RequireAuth(true))
 
-	settingRouter.GET("", api.Setting.Get)
-	settingRouter.PUT("", api.Setting.Update)
+	settingRouter.GET("", api.Setting.Get, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	settingRouter.PUT("", api.Setting.Update, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	settingRouter.POST("/reload", api.Setting.Reload, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	settingRouter.GET("/signing-keys", api.Setting.FetchSigningKeys, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	settingRouter.POST("/signing-keys", api.Setting.AddSigningKey, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	settingRouter.DELETE("/signing-keys/:kid", api.Setting.RetireSigningKey, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+}
+
+func (api *API) StatsAPI() {
+	statsRouter := api.router.Group("/stats", middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+
+	statsRouter.GET("/storage", api.Stats.FetchStorageStats)
+	statsRouter.GET("/stream", api.Stats.StreamStats)
+	statsRouter.GET("/traffic", api.Stats.FetchTraffic)
+	statsRouter.GET("/latency", api.Stats.FetchLatency)
+	statsRouter.DELETE("/latency", api.Stats.ResetLatency, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	statsRouter.GET("/slow-queries", api.Stats.FetchSlowQueries)
+	statsRouter.DELETE("/slow-queries", api.Stats.ClearSlowQueries, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	statsRouter.GET("/index-suggestions", api.Stats.FetchIndexSuggestions)
+	statsRouter.DELETE("/index-suggestions", api.Stats.ClearIndexSuggestions, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+}
+
+func (api *API) RealtimeAPI() {
+	// the connections authenticate once opened, browsers can't send headers
+	api.router.GET("/realtime", api.Realtime.Connect)
+}
+
+func (api *API) StorageAPI() {
+	fileRouter := api.router.Group("/files")
+
+	readOnly := middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY)
+
+	fileRouter.GET("", api.Storage.FetchFiles, middleware.RequireAuth(true), readOnly)
+	fileRouter.GET("/usage", api.Storage.FetchUsage, middleware.RequireAuth(true), readOnly)
+	fileRouter.POST("/migrate", api.Storage.MigrateFiles, middleware.RequireAuth(true), middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	fileRouter.POST("", api.Storage.UploadFile, middleware.RequireAuth(true))
+	fileRouter.GET("/:key", api.Storage.DownloadFile)
+	fileRouter.HEAD("/:key", api.Storage.DownloadFile)
+	fileRouter.GET("/:key/info", api.Storage.FetchFile, middleware.RequireAuth(true), readOnly)
+	fileRouter.POST("/:key/sign", api.Storage.SignFile, middleware.RequireAuth(true))
+
+	fileRouter.POST("/uploads", api.Storage.CreateUpload, middleware.RequireAuth(true))
+	fileRouter.GET("/uploads/:id", api.Storage.FetchUpload, middleware.RequireAuth(true))
+	fileRouter.HEAD("/uploads/:id", api.Storage.FetchUpload, middleware.RequireAuth(true))
+	fileRouter.PATCH("/uploads/:id", api.Storage.AppendUpload, middleware.RequireAuth(true))
+	fileRouter.POST("/uploads/:id/complete", api.Storage.CompleteUpload, middleware.RequireAuth(true))
+	fileRouter.DELETE("/uploads/:id", api.Storage.AbortUpload, middleware.RequireAuth(true))
+}
+
+func (api *API) TokenAPI() {
+	tokenRouter := api.router.Group("/tokens", middleware.RequireAuth(true))
+
+	tokenRouter.GET("", api.Token.FetchTokens)
+	tokenRouter.POST("", api.Token.CreateToken)
+	tokenRouter.DELETE("/:id", api.Token.DeleteToken)
+}
+
+func (api *API) WebhookAPI() {
+	webhookRouter := api.router.Group("/webhooks", middleware.RequireAuth(true))
+
+	webhookRouter.GET("/deliveries", api.Webhook.FetchDeliveries, middleware.RequireAdminRole(model.ADMIN_ROLE_READ_ONLY))
+	webhookRouter.POST("/deliveries/:id/redeliver", api.Webhook.RedeliverDelivery, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
+	webhookRouter.DELETE("/deliveries/:id", api.Webhook.DeleteDelivery, middleware.RequireAdminRole(model.ADMIN_ROLE_OWNER))
 }
 
 func getTableInfo(db *gorm.DB, tableName string) (model.Tables, error) {
 	var table model.Tables
+	if cacheGet(tableInfoKey(tableName), &table) {
+		return table, nil
+	}
+
 	err := db.Model(&model.Tables{}).
 		Where("is_system = ?", false).
 		Where("name = ?", tableName).
@@ -95,6 +340,79 @@ func getTableInfo(db *gorm.DB, tableName string) (model.Tables, error) {
 	if err != nil {
 		return table, err
 	}
+	cacheSet(tableInfoKey(tableName), table, tableCacheTTL)
 
 	return table, nil
 }
+
+// fetchColumns returns the visible columns of a table, including generated
+// columns, with relation references resolved
+func fetchColumns(db *gorm.DB, tableName string) ([]model.Column, error) {
+	var columns []model.Column
+	if cacheGet(columnsKey(tableName), &columns) {
+		return columns, nil
+	}
+
+	err := db.Raw(`
+		SELECT 
+			info.cid,
+			info.name,
+			info.'type',
+			info.pk,
+			info.'notnull',
+			info.dflt_value,
+			info.hidden IN (2, 3) AS 'generated',
+			fk.'table' AS reference
+		FROM pragma_table_xinfo(?) AS info
+		LEFT JOIN pragma_foreign_key_list(?) AS fk ON
+		info.name = fk.'from'
+		WHERE info.hidden != 1
+	`, tableName, tableName).
+		Scan(&columns).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	// the collations are only part of the statement creating the table
+	var statement string
+	err = db.Table("sqlite_master").
+		Select("sql").
+		Where("type = ?", "table").
+		Where("name = ?", tableName).
+		Scan(&statement).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	collations := columnCollations(statement)
+	for i, column := range columns {
+		if collation := collations[column.Name]; collation != COLLATION_BINARY {
+			columns[i].Collation = collation
+		}
+	}
+
+	// tables that don't exist have no columns, they aren't cached
+	if len(columns) > 0 {
+		cacheSet(columnsKey(tableName), columns, tableCacheTTL)
+	}
+
+	return columns, nil
+}
+
+// removeGeneratedColumns drops values targeting generated columns since
+// those are computed by SQLite and cannot be written
+func removeGeneratedColumns(db *gorm.DB, tableName string, data map[string]interface{}) error {
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if column.Generated {
+			delete(data, column.Name)
+		}
+	}
+
+	return nil
+}
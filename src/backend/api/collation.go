@@ -0,0 +1,259 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// the collations of the text columns. = and != compare the values of a
+// binary column as is and those of a nocase column regardless of the case
+// of ASCII letters, LIKE ignores it on both
+const (
+	COLLATION_BINARY = "binary"
+	COLLATION_NOCASE = "nocase"
+)
+
+var collatePattern = regexp.MustCompile(`(?i)\s+COLLATE\s+["'` + "`" + `]?(\w+)["'` + "`" + `]?`)
+
+// tableConstraints start the definitions of a CREATE TABLE which aren't
+// columns
+var tableConstraints = map[string]bool{
+	"CONSTRAINT": true,
+	"PRIMARY":    true,
+	"UNIQUE":     true,
+	"CHECK":      true,
+	"FOREIGN":    true,
+}
+
+// collationClause returns the clause giving a column the collation
+func collationClause(collation string) (string, error) {
+	switch strings.ToLower(collation) {
+	case "", COLLATION_BINARY:
+		return "", nil
+	case COLLATION_NOCASE:
+		return " COLLATE NOCASE", nil
+	default:
+		return "", fmt.Errorf("collation must be %s or %s", COLLATION_BINARY, COLLATION_NOCASE)
+	}
+}
+
+// splitDefinitions splits the body of a CREATE TABLE into its column and
+// constraint definitions, on the commas outside parentheses and quotes
+func splitDefinitions(body string) []string {
+	definitions := []string{}
+	depth := 0
+	var quote rune
+	start := 0
+	for i, ch := range body {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '[':
+			quote = ']'
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == ',' && depth == 0:
+			definitions = append(definitions, strings.TrimSpace(body[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(definitions, strings.TrimSpace(body[start:]))
+}
+
+// definedColumn returns the column of a definition, nothing for the table
+// constraints
+func definedColumn(definition string) string {
+	name := strings.Fields(definition)
+	if len(name) == 0 || tableConstraints[strings.ToUpper(name[0])] {
+		return ""
+	}
+
+	return strings.Trim(name[0], "\"'`[]")
+}
+
+// tableBody returns the definitions of a CREATE TABLE statement
+func tableBody(statement string) (string, string, string, bool) {
+	open := strings.Index(statement, "(")
+	end := strings.LastIndex(statement, ")")
+	if open < 0 || end < open {
+		return "", "", "", false
+	}
+
+	return statement[:open+1], statement[open+1 : end], statement[end:], true
+}
+
+// columnCollations returns the collation of the columns of a CREATE TABLE
+// statement declaring one
+func columnCollations(statement string) map[string]string {
+	collations := map[string]string{}
+	_, body, _, ok := tableBody(statement)
+	if !ok {
+		return collations
+	}
+
+	for _, definition := range splitDefinitions(body) {
+		column := definedColumn(definition)
+		if match := collatePattern.FindStringSubmatch(definition); column != "" && match != nil {
+			collations[column] = strings.ToLower(match[1])
+		}
+	}
+
+	return collations
+}
+
+// withCollation rewrites a CREATE TABLE statement so the column has the
+// collation, it reports whether the table has the column
+func withCollation(statement string, column string, clause string) (string, bool) {
+	head, body, tail, ok := tableBody(statement)
+	if !ok {
+		return "", false
+	}
+
+	found := false
+	definitions := splitDefinitions(body)
+	for i, definition := range definitions {
+		if !strings.EqualFold(definedColumn(definition), column) {
+			continue
+		}
+		definitions[i] = collatePattern.ReplaceAllString(definition, "") + clause
+		found = true
+	}
+
+	return head + "\n\t\t\t" + strings.Join(definitions, ",\n\t\t\t") + "\n\t\t" + tail, found
+}
+
+type columnCollationReq struct {
+	Collation string `json:"collation"`
+}
+
+// UpdateColumnCollation gives a text column the binary or the nocase
+// collation. SQLite can't alter a column, the table is rebuilt with its rows,
+// indexes and triggers. A unique column whose values only differ by case
+// can't become nocase
+func (d *DatabaseAPIImpl) UpdateColumnCollation(c echo.Context) error {
+	tableName := c.Param("table_name")
+	columnName := c.Param("column_name")
+
+	var params *columnCollationReq = new(columnCollationReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	defer invalidateTables(tableName)
+
+	clause, err := collationClause(params.Collation)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view columns have no collation")
+	}
+
+	columns, err := fetchColumns(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	var column *model.Column
+	names := []string{}
+	for i := range columns {
+		if columns[i].Name == columnName {
+			column = &columns[i]
+		}
+		if !columns[i].Generated {
+			names = append(names, columns[i].Name)
+		}
+	}
+	if column == nil {
+		return pkg_apierror.Message(c, http.StatusNotFound, "column does not exist")
+	}
+	if column.Reference != "" || !isTextType(column.Type) {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "only text columns have a collation")
+	}
+
+	objects, err := fetchSchemaObjects(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	rebuilt := "_rebuilt_" + tableName
+	statement, _ := withCollation(objects[0].SQL, columnName, clause)
+	head, _, _, _ := tableBody(statement)
+	statement = fmt.Sprintf("CREATE TABLE %s (%s", rebuilt, statement[len(head):])
+
+	// the foreign keys are switched off on the connection of the rebuild, the
+	// rows referencing the table would go along with it otherwise
+	err = d.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+		// the views reading the table don't stop it from being renamed
+		if err := conn.Exec("PRAGMA legacy_alter_table = ON").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA legacy_alter_table = OFF")
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			steps := []string{
+				statement,
+				fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", rebuilt, strings.Join(names, ", "), strings.Join(names, ", "), tableName),
+				fmt.Sprintf("DROP TABLE %s", tableName),
+				fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rebuilt, tableName),
+			}
+			for _, object := range objects[1:] {
+				steps = append(steps, object.SQL)
+			}
+			for _, step := range steps {
+				if err := tx.Exec(step).Error; err != nil {
+					return err
+				}
+			}
+
+			var violations []map[string]interface{}
+			if err := tx.Raw("PRAGMA foreign_key_check").Scan(&violations).Error; err != nil {
+				return err
+			}
+			if len(violations) > 0 {
+				return fmt.Errorf("the rebuilt %s breaks %d foreign keys", tableName, len(violations))
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return pkg_apierror.Message(c, http.StatusConflict, fmt.Sprintf("values of %s only differ by case", columnName))
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	collation := strings.ToLower(params.Collation)
+	if collation == "" {
+		collation = COLLATION_BINARY
+	}
+	recordActivity(d.db, c, model.ACTIVITY_UPDATE_COLUMN, tableName, fmt.Sprintf("set the collation of %s to %s", columnName, collation))
+
+	return c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,426 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/utils"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+// ReloadCronJobs applies the changes made to the cron jobs and StartCronJob
+// runs a job right away, they are set by the module once the scheduler runs
+var (
+	ReloadCronJobs func()
+	StartCronJob   func(name string) (model.CronRun, error)
+)
+
+var (
+	ErrCronJobNotFound = errors.New("job does not exist")
+	ErrCronJobRunning  = errors.New("job is already running")
+)
+
+type CronAPI interface {
+	FetchCronJobs(c echo.Context) error
+	CreateCronJob(c echo.Context) error
+	UpdateCronJob(c echo.Context) error
+	DeleteCronJob(c echo.Context) error
+	FetchCronRuns(c echo.Context) error
+	RunCronJob(c echo.Context) error
+	FetchNextRuns(c echo.Context) error
+	PreviewSchedule(c echo.Context) error
+}
+
+type CronAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewCronAPI(ioc di.Container) CronAPI {
+	return &CronAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+type cronJobReq struct {
+	Name          string                 `json:"name"`
+	Schedule      string                 `json:"schedule"`
+	Timezone      string                 `json:"timezone"`
+	Action        string                 `json:"action"`
+	Target        string                 `json:"target"`
+	Input         map[string]interface{} `json:"input"`
+	RetentionDays int                    `json:"retention_days"`
+	Enabled       *bool                  `json:"enabled"`
+
+	AllowOverlap      bool `json:"allow_overlap"`
+	JitterSeconds     int  `json:"jitter_seconds"`
+	MaxRuntimeSeconds int  `json:"max_runtime_seconds"`
+}
+
+// cronJobRes is a job along with its last run and the time it runs next,
+// NextRun is empty when the job is disabled
+type cronJobRes struct {
+	model.CronJob
+	Input   map[string]interface{} `json:"input"`
+	LastRun *model.CronRun         `json:"last_run"`
+	NextRun *time.Time             `json:"next_run"`
+}
+
+func newCronJobRes(job model.CronJob) cronJobRes {
+	res := cronJobRes{CronJob: job}
+	if job.Input != "" {
+		json.Unmarshal([]byte(job.Input), &res.Input)
+	}
+	if schedule, err := utils.ParseCronSchedule(job.Schedule, job.Timezone); err == nil && job.Enabled {
+		next := schedule.Next(time.Now())
+		res.NextRun = &next
+	}
+
+	return res
+}
+
+func reloadCronJobs() {
+	if ReloadCronJobs != nil {
+		ReloadCronJobs()
+	}
+}
+
+// validateCronJob checks the job can be scheduled and its target exists
+func validateCronJob(db *gorm.DB, job model.CronJob) error {
+	if _, err := utils.ParseCronSchedule(job.Schedule, job.Timezone); err != nil {
+		return fmt.Errorf("invalid schedule: %v", err)
+	}
+	if job.JitterSeconds < 0 || job.MaxRuntimeSeconds < 0 {
+		return errors.New("jitter_seconds and max_runtime_seconds must not be negative")
+	}
+
+	switch job.Action {
+	case model.CRON_ACTION_BACKUP, model.CRON_ACTION_VACUUM, model.CRON_ACTION_ANALYZE, model.CRON_ACTION_CHECKPOINT:
+	case model.CRON_ACTION_FUNCTION:
+		var count int64
+		if err := db.Model(&model.FunctionStored{}).Where("name = ?", job.Target).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("function %s does not exist", job.Target)
+		}
+	case model.CRON_ACTION_PURGE:
+		var count int64
+		if err := db.Model(&model.Tables{}).Where("name = ?", job.Target).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("table %s does not exist", job.Target)
+		}
+		if job.RetentionDays <= 0 {
+			return errors.New("retention_days must be positive")
+		}
+	default:
+		return errors.New("action must be backup, function, vacuum, analyze, checkpoint or purge")
+	}
+
+	return nil
+}
+
+// PurgeRows deletes the rows of the table created more than retentionDays
+// ago, as the purge jobs do, and drops the cached reads of the table
+func PurgeRows(db *gorm.DB, tableName string, retentionDays int) (int64, error) {
+	cutoff := time.Now().UTC().AddDate(0, 0, -retentionDays).Format("2006-01-02 15:04:05")
+	result := db.Table(tableName).Where("created_at < ?", cutoff).Delete(nil)
+	if result.RowsAffected > 0 {
+		invalidateRowCounts(tableName)
+	}
+
+	return result.RowsAffected, result.Error
+}
+
+func (cr *CronAPIImpl) FetchCronJobs(c echo.Context) error {
+	jobs := []model.CronJob{}
+	if err := cr.db.Order("name").Find(&jobs).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// the latest run of every job
+	runs := []model.CronRun{}
+	err := cr.db.Where("id IN (?)", cr.db.Model(&model.CronRun{}).Select("MAX(id)").Group("job")).Find(&runs).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	lastRuns := map[string]model.CronRun{}
+	for _, run := range runs {
+		lastRuns[run.Job] = run
+	}
+
+	result := []cronJobRes{}
+	for _, job := range jobs {
+		res := newCronJobRes(job)
+		if run, ok := lastRuns[job.Name]; ok {
+			res.LastRun = &run
+		}
+		result = append(result, res)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+func (cr *CronAPIImpl) bindCronJob(c echo.Context, job *model.CronJob) error {
+	var body *cronJobReq = new(cronJobReq)
+	if err := c.Bind(body); err != nil {
+		return err
+	}
+
+	job.Schedule = strings.TrimSpace(body.Schedule)
+	job.Timezone = body.Timezone
+	job.Action = body.Action
+	job.Target = body.Target
+	job.RetentionDays = body.RetentionDays
+	job.AllowOverlap = body.AllowOverlap
+	job.JitterSeconds = body.JitterSeconds
+	job.MaxRuntimeSeconds = body.MaxRuntimeSeconds
+	job.Input = ""
+	if body.Input != nil {
+		input, err := json.Marshal(body.Input)
+		if err != nil {
+			return err
+		}
+		job.Input = string(input)
+	}
+	if body.Enabled != nil {
+		job.Enabled = *body.Enabled
+	}
+	if job.Name == "" {
+		job.Name = body.Name
+	}
+
+	return validateCronJob(cr.db, *job)
+}
+
+func (cr *CronAPIImpl) CreateCronJob(c echo.Context) error {
+	job := model.CronJob{Enabled: true}
+	if err := cr.bindCronJob(c, &job); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if job.Name == "" || strings.ContainsAny(job.Name, "/ ") {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid job name")
+	}
+	if job.Name == model.CRON_JOB_BACKUP_SCHEDULE {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "job name is reserved for the backup_schedule setting")
+	}
+
+	var count int64
+	if err := cr.db.Model(&model.CronJob{}).Where("name = ?", job.Name).Count(&count).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if count > 0 {
+		return pkg_apierror.Message(c, http.StatusConflict, "job already exists")
+	}
+
+	if err := cr.db.Create(&job).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadCronJobs()
+
+	recordActivity(cr.db, c, model.ACTIVITY_CREATE_CRON_JOB, job.Name, job.Schedule)
+
+	return c.JSON(http.StatusOK, newCronJobRes(job))
+}
+
+func (cr *CronAPIImpl) UpdateCronJob(c echo.Context) error {
+	var job model.CronJob
+	if err := cr.db.Where("name = ?", c.Param("name")).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg_apierror.Error(c, http.StatusNotFound, ErrCronJobNotFound)
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if err := cr.bindCronJob(c, &job); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if err := cr.db.Save(&job).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadCronJobs()
+
+	recordActivity(cr.db, c, model.ACTIVITY_UPDATE_CRON_JOB, job.Name, job.Schedule)
+
+	return c.JSON(http.StatusOK, newCronJobRes(job))
+}
+
+func (cr *CronAPIImpl) DeleteCronJob(c echo.Context) error {
+	name := c.Param("name")
+
+	result := cr.db.Where("name = ?", name).Delete(&model.CronJob{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return pkg_apierror.Error(c, http.StatusNotFound, ErrCronJobNotFound)
+	}
+	reloadCronJobs()
+
+	recordActivity(cr.db, c, model.ACTIVITY_DELETE_CRON_JOB, name, "")
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+type cronRunReq struct {
+	Job      string `query:"job"`
+	Status   string `query:"status"`
+	Page     int    `query:"page"`
+	PageSize int    `query:"page_size"`
+}
+
+// FetchCronRuns lists the most recent runs of the cron jobs
+func (cr *CronAPIImpl) FetchCronRuns(c echo.Context) error {
+	var params *cronRunReq = new(cronRunReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.PageSize < 1 || params.PageSize > 100 {
+		params.PageSize = 50
+	}
+
+	query := cr.db.Model(&model.CronRun{})
+	if params.Job != "" {
+		query = query.Where("job = ?", params.Job)
+	}
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+
+	runs := []model.CronRun{}
+	err := query.
+		Order("id DESC").
+		Offset((params.Page - 1) * params.PageSize).
+		Limit(params.PageSize).
+		Find(&runs).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, runs)
+}
+
+// RunCronJob starts a job without waiting for its schedule, the outcome is
+// found in the history of the runs
+func (cr *CronAPIImpl) RunCronJob(c echo.Context) error {
+	name := c.Param("name")
+
+	if StartCronJob == nil {
+		return pkg_apierror.Message(c, http.StatusServiceUnavailable, "the scheduler is not running")
+	}
+
+	run, err := StartCronJob(name)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCronJobNotFound):
+			return pkg_apierror.Error(c, http.StatusNotFound, err)
+		case errors.Is(err, ErrCronJobRunning):
+			return pkg_apierror.Error(c, http.StatusConflict, err)
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(cr.db, c, model.ACTIVITY_RUN_CRON_JOB, name, "")
+
+	return c.JSON(http.StatusAccepted, run)
+}
+
+type nextRunsReq struct {
+	Schedule string `json:"schedule"`
+	Timezone string `json:"timezone"`
+	Count    int    `json:"count" query:"count"`
+}
+
+type nextRunsRes struct {
+	Schedule string      `json:"schedule"`
+	Timezone string      `json:"timezone"`
+	NextRuns []time.Time `json:"next_runs"`
+}
+
+func nextRuns(params nextRunsReq) (nextRunsRes, error) {
+	if params.Count < 1 || params.Count > 100 {
+		params.Count = 5
+	}
+
+	schedule, err := utils.ParseCronSchedule(params.Schedule, params.Timezone)
+	if err != nil {
+		return nextRunsRes{}, fmt.Errorf("invalid schedule: %v", err)
+	}
+
+	// the runs are shown in the timezone of the schedule
+	now := time.Now()
+	if location, err := time.LoadLocation(params.Timezone); err == nil && params.Timezone != "" {
+		now = now.In(location)
+	}
+
+	return nextRunsRes{
+		Schedule: params.Schedule,
+		Timezone: params.Timezone,
+		NextRuns: utils.NextCronRuns(schedule, now, params.Count),
+	}, nil
+}
+
+// FetchNextRuns lists the next times a job runs, count of them
+func (cr *CronAPIImpl) FetchNextRuns(c echo.Context) error {
+	name := c.Param("name")
+
+	var params *nextRunsReq = new(nextRunsReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	settings := config.GetInstance()
+	if name == model.CRON_JOB_BACKUP_SCHEDULE && settings.BackupSchedule != "" {
+		params.Schedule = settings.BackupSchedule
+		params.Timezone = settings.BackupScheduleTimezone
+	} else {
+		var job model.CronJob
+		if err := cr.db.Where("name = ?", name).First(&job).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return pkg_apierror.Error(c, http.StatusNotFound, ErrCronJobNotFound)
+			}
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		params.Schedule = job.Schedule
+		params.Timezone = job.Timezone
+	}
+
+	res, err := nextRuns(*params)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+// PreviewSchedule validates a schedule before it is saved and lists the
+// next times it would run
+func (cr *CronAPIImpl) PreviewSchedule(c echo.Context) error {
+	var body *nextRunsReq = new(nextRunsReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	res, err := nextRuns(*body)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
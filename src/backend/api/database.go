@@ -1,12 +1,18 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"react-golang/src/backend/config"
 	"react-golang/src/backend/constants"
 	"react-golang/src/backend/model"
-	"react-golang/src/backend/utils"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"react-golang/src/backend/service"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sarulabs/di"
@@ -17,25 +23,50 @@ type DatabaseAPI interface {
 	FetchAllTables(c echo.Context) error
 	FetchTableColumns(c echo.Context) error
 	FetchRows(c echo.Context) error
+	ExportRows(c echo.Context) error
+	UpdateColumnMeta(c echo.Context) error
+	UpdateColumnCollation(c echo.Context) error
+	UpdateTableSettings(c echo.Context) error
 
 	CreateTable(c echo.Context) error
+	CreateView(c echo.Context) error
+	DuplicateTable(c echo.Context) error
+	RenameTable(c echo.Context) error
 	FetchDataByID(c echo.Context) error
+	FetchDistinctValues(c echo.Context) error
+	FetchTableStats(c echo.Context) error
+	FetchDescendants(c echo.Context) error
+	FetchAncestors(c echo.Context) error
 	InsertData(c echo.Context) error
+	InsertNested(c echo.Context) error
 	UpdateData(c echo.Context) error
 	DeleteData(c echo.Context) error
 	DeleteTable(c echo.Context) error
 
 	RunQuery(c echo.Context) error
 	FetchQueryHistory(c echo.Context) error
+	FlushCache(c echo.Context) error
 }
 
 type DatabaseAPIImpl struct {
 	db *gorm.DB
+	// read is the pool of read-only connections, the writer itself when
+	// there is none
+	read    *gorm.DB
+	storage service.StorageService
 }
 
 func NewDatabaseAPI(ioc di.Container) DatabaseAPI {
+	db := ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB)
+	read := ioc.Get(constants.CONTAINER_READ_DB_NAME).(*gorm.DB)
+	if read != db {
+		readPool = read
+	}
+
 	return &DatabaseAPIImpl{
-		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		db:      db,
+		read:    read,
+		storage: ioc.Get(constants.CONTAINER_STORAGE_NAME).(service.StorageService),
 	}
 }
 
@@ -46,13 +77,11 @@ func (d *DatabaseAPIImpl) FetchAllTables(c echo.Context) error {
 
 	var params *Search = new(Search)
 	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	query := d.db.Model(&model.Tables{}).
-		Select("name, is_auth").
+		Select("name, is_auth, is_view").
 		Where("is_system = ?", false).
 		Order("name ASC")
 
@@ -62,9 +91,7 @@ func (d *DatabaseAPIImpl) FetchAllTables(c echo.Context) error {
 
 	err := query.Find(&result).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, result)
@@ -79,43 +106,30 @@ func (d *DatabaseAPIImpl) FetchTableColumns(c echo.Context) error {
 
 	var params *fetchColumn = new(fetchColumn)
 	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	table, err := getTableInfo(d.db, tableName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
-	var result []model.Column
-	if err := d.db.Raw(fmt.Sprintf(`
-		SELECT 
-			info.cid,
-			info.name,
-			info.'type',
-			info.pk,
-			info.'notnull',
-			info.dflt_value,
-			fk.'table' AS reference
-		FROM pragma_table_info('%s') AS info
-		LEFT JOIN pragma_foreign_key_list('%s') AS fk ON
-		info.name = fk.'from'
-	`, tableName, tableName)).
-		Scan(&result).
-		Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+	result, err := fetchColumns(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	metas, err := fetchColumnMeta(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	for i, col := range result {
 		if col.Reference != "" {
 			result[i].Type = "RELATION"
 		}
+		result[i].Access = metas[col.Name].Access
+		result[i].Anonymize = metas[col.Name].Anonymize
 	}
 
 	// If table is user type, prevent displaying authentication fields
@@ -123,7 +137,7 @@ func (d *DatabaseAPIImpl) FetchTableColumns(c echo.Context) error {
 		var cleanedResult []model.Column
 		if params.FetchAuthColumn {
 			for _, row := range result {
-				if row.Name != "salt" {
+				if row.Name == "password" || !authSecretColumns[row.Name] {
 					cleanedResult = append(cleanedResult, row)
 				}
 			}
@@ -131,7 +145,7 @@ func (d *DatabaseAPIImpl) FetchTableColumns(c echo.Context) error {
 			return c.JSON(http.StatusOK, cleanedResult)
 		}
 		for _, row := range result {
-			if row.Name != "password" && row.Name != "salt" {
+			if !authSecretColumns[row.Name] {
 				cleanedResult = append(cleanedResult, row)
 			}
 		}
@@ -150,7 +164,30 @@ type Filter struct {
 
 type fetchRowsParam struct {
 	Filter []Filter `json:"filters,omitempty"`
-	Limit  int      `json:"limit,omitempty"`
+
+	// Page and PageSize select the rows read, from 1. PageSize is
+	// default_page_size when unset and capped at max_page_size. Limit is
+	// the page size of the clients sent before pages, PageSize wins over it
+	Page     int `json:"page,omitempty"`
+	PageSize int `json:"page_size,omitempty"`
+	Limit    int `json:"limit,omitempty"`
+
+	// Expression filters the rows with the PocketBase syntax, such as
+	// status = "active" && title ~ "go". It is combined with the filters
+	Expression string `json:"filter,omitempty"`
+
+	// Q searches the text columns of the table, or its searchable columns
+	// when some are set, for the rows holding it regardless of case
+	Q string `json:"q,omitempty"`
+
+	// Count is none, exact or estimate. The total is sent in the
+	// X-Total-Count header, nothing is counted when none
+	Count string `json:"count,omitempty"`
+
+	// Expand lists the relation columns whose referenced rows are added to
+	// the expand field of every row, and the back-relations such as
+	// comments(post_id) whose rows pointing at every row are added with them
+	Expand []string `json:"expand,omitempty"`
 }
 
 func (d *DatabaseAPIImpl) FetchRows(c echo.Context) error {
@@ -158,35 +195,33 @@ func (d *DatabaseAPIImpl) FetchRows(c echo.Context) error {
 
 	table, err := getTableInfo(d.db, tableName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	var result []map[string]interface{} = make([]map[string]interface{}, 0)
 
 	var params *fetchRowsParam = new(fetchRowsParam)
 	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	switch params.Count {
+	case "", countNone, countExact, countEstimate:
+	default:
+		return pkg_apierror.Message(c, http.StatusBadRequest, "count must be one of none, exact or estimate")
 	}
 
 	columns := "*"
 	if table.IsAuth {
-		allColumn := []model.Column{}
-		err = d.db.Raw(fmt.Sprintf("PRAGMA table_info(%s)", tableName)).
-			Scan(&allColumn).
-			Error
-
+		allColumn, err := fetchColumns(d.db, tableName)
 		if err != nil {
-			return err
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 		}
 
 		columns = ""
 
 		for _, column := range allColumn {
-			if column.Name != "password" && column.Name != "salt" {
+			if !authSecretColumns[column.Name] {
 				if columns != "" {
 					columns = fmt.Sprintf("%s, %s", columns, column.Name)
 				} else {
@@ -195,24 +230,329 @@ func (d *DatabaseAPIImpl) FetchRows(c echo.Context) error {
 			}
 		}
 	}
-	query := d.db.Table(tableName)
+	query := preparedDB(d.read).WithContext(c.Request().Context()).Table(tableName)
+
+	if params.PageSize <= 0 {
+		params.PageSize = params.Limit
+	}
+	page, pageSize := pagination(params.Page, params.PageSize)
+	query = query.Limit(pageSize).Offset((page - 1) * pageSize)
+	c.Response().Header().Set("X-Page", fmt.Sprint(page))
+	c.Response().Header().Set("X-Page-Size", fmt.Sprint(pageSize))
+
+	expr, err := rowFilterExpr(d.db, c, table, params.Expression)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if expr, err = withSearch(d.db, table, isAdmin(c), expr, params.Q); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if err := validateFilters(d.db, table, params.Filter); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	filters := params.Filter
+	if expr != nil {
+		filters = append(append([]Filter{}, params.Filter...), expr.conditions()...)
+	}
+	if err := checkFilterAccess(d.db, c, tableName, filters); err != nil {
+		return pkg_apierror.Error(c, http.StatusForbidden, err)
+	}
 
-	if params.Limit > 0 {
-		query = query.Limit(params.Limit)
+	expansion, err := parseExpand(d.db, c, tableName, params.Expand)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	// the expanded rows come from other tables whose writes don't drop the
+	// cached reads of this one
+	cacheKey := ""
+	if table.CacheTTLSeconds > 0 && len(params.Expand) == 0 {
+		cacheKey = resultCacheKey(c, tableName, params)
+		if cached, err := respondCached(c, cacheKey); cached {
+			return err
+		}
 	}
 
 	query = query.Select(columns)
 	for _, filter := range params.Filter {
-		query = query.Where(fmt.Sprintf("%s %s ?", filter.Column, filter.Operator), filter.Value)
+		query, err = applyRowFilter(query, filter)
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
 	}
+	query = applyFilterExpr(query, expr)
 
+	start := time.Now()
 	if err := query.
 		Find(&result).
 		Error; err != nil {
-		return err
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
+	suggestIndex(d.read, d.db, tableName, filters, query, time.Since(start))
 
-	return c.JSON(http.StatusOK, result)
+	if err := stripRestrictedColumns(d.db, c, table, result); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if err := expansion.apply(d.read.WithContext(c.Request().Context()), c, result); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if params.Count != "" && params.Count != countNone {
+		total, err := countRows(d.read.WithContext(c.Request().Context()), tableName, params.Filter, expr, params.Count)
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		c.Response().Header().Set("X-Total-Count", fmt.Sprint(total))
+	}
+
+	if cacheKey != "" {
+		cacheResult(c, cacheKey, result, table.CacheTTLSeconds)
+	}
+
+	return respondWithETag(c, result)
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pagination returns the page and the page size applied to a read, the page
+// size defaults to default_page_size and is capped at max_page_size
+func pagination(page int, pageSize int) (int, int) {
+	settings := config.GetInstance()
+	limit := settings.MaxPageSize
+	if limit <= 0 {
+		limit = maxPageSize
+	}
+	if pageSize <= 0 {
+		pageSize = settings.DefaultPageSize
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	return max(page, 1), min(pageSize, limit)
+}
+
+func applyRowFilter(query *gorm.DB, filter Filter) (*gorm.DB, error) {
+	switch strings.ToLower(filter.Operator) {
+	case "within":
+		return applyWithinFilter(query, filter)
+	default:
+		return query.Where(fmt.Sprintf("`%s` %s ?", filter.Column, filter.Operator), filter.Value), nil
+	}
+}
+
+type columnMetaReq struct {
+	Access           string   `json:"access"`
+	Protected        bool     `json:"protected"`
+	AllowedMimeTypes []string `json:"allowed_mime_types"`
+	MaxFileSize      int64    `json:"max_file_size"`
+	MaxFiles         int      `json:"max_files"`
+	Anonymize        string   `json:"anonymize"`
+	Required         bool     `json:"required"`
+	Format           string   `json:"format"`
+	Pattern          string   `json:"pattern"`
+	Min              *float64 `json:"min"`
+	Max              *float64 `json:"max"`
+	MaxLength        int      `json:"max_length"`
+	Searchable       bool     `json:"searchable"`
+}
+
+// UpdateColumnMeta replaces the metadata of a column
+func (d *DatabaseAPIImpl) UpdateColumnMeta(c echo.Context) error {
+	tableName := c.Param("table_name")
+	columnName := c.Param("column_name")
+
+	var params *columnMetaReq = new(columnMetaReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if params.Access == "public" {
+		params.Access = model.ACCESS_PUBLIC
+	}
+	if params.Access != model.ACCESS_PUBLIC && params.Access != model.ACCESS_ADMIN && params.Access != model.ACCESS_OWNER {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "access must be one of public, admin or owner")
+	}
+
+	columns, err := fetchColumns(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	var found *model.Column
+	for i, column := range columns {
+		if column.Name == columnName {
+			found = &columns[i]
+			break
+		}
+	}
+	if found == nil {
+		return pkg_apierror.Message(c, http.StatusNotFound, "column not found")
+	}
+	if (params.Protected || len(params.AllowedMimeTypes) > 0 || params.MaxFileSize != 0 || params.MaxFiles != 0) && !strings.EqualFold(found.Type, "FILE") {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "only file columns can be protected or restrict their files")
+	}
+	switch params.Anonymize {
+	case "", model.ANONYMIZE_HASH, model.ANONYMIZE_MASK, model.ANONYMIZE_DROP:
+	default:
+		return pkg_apierror.Message(c, http.StatusBadRequest, "anonymize must be one of hash, mask or drop")
+	}
+	if params.MaxFileSize < 0 || params.MaxFiles < 0 {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "max_file_size and max_files must not be negative")
+	}
+	for _, mimeType := range params.AllowedMimeTypes {
+		if !validMimePattern(mimeType) {
+			return pkg_apierror.Message(c, http.StatusBadRequest, fmt.Sprintf("%s is not a MIME type", mimeType))
+		}
+	}
+
+	meta := model.ColumnMeta{
+		Table:            tableName,
+		Column:           columnName,
+		Access:           params.Access,
+		Protected:        params.Protected,
+		AllowedMimeTypes: strings.Join(params.AllowedMimeTypes, ","),
+		MaxFileSize:      params.MaxFileSize,
+		MaxFiles:         params.MaxFiles,
+		Anonymize:        params.Anonymize,
+		Required:         params.Required,
+		Format:           params.Format,
+		Pattern:          params.Pattern,
+		Min:              params.Min,
+		Max:              params.Max,
+		MaxLength:        params.MaxLength,
+		Searchable:       params.Searchable,
+	}
+	if err := checkValidators(meta); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if err := d.db.Save(&meta).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// the access of the column decides who reads it
+	invalidateResults(tableName)
+	recordActivity(d.db, c, model.ACTIVITY_UPDATE_COLUMN, tableName, columnName)
+
+	return c.JSON(http.StatusOK, meta)
+}
+
+type tableSettingsReq struct {
+	OwnerColumn     *string `json:"owner_column"`
+	AllowTOTP       *bool   `json:"allow_totp"`
+	AllowMagicLink  *bool   `json:"allow_magic_link"`
+	ProtectFiles    *bool   `json:"protect_files"`
+	StorageQuotaMB  *int    `json:"storage_quota_mb"`
+	CacheTTLSeconds *int    `json:"cache_ttl_seconds"`
+	IDPrefix        *string `json:"id_prefix"`
+}
+
+// UpdateTableSettings updates the provided settings of a table, leaving the
+// omitted ones untouched
+func (d *DatabaseAPIImpl) UpdateTableSettings(c echo.Context) error {
+	tableName := c.Param("table_name")
+	defer invalidateTables(tableName)
+
+	var params *tableSettingsReq = new(tableSettingsReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	updates := map[string]interface{}{}
+	if params.OwnerColumn != nil {
+		if *params.OwnerColumn != "" {
+			columns, err := fetchColumns(d.db, tableName)
+			if err != nil {
+				return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+			}
+
+			found := false
+			for _, column := range columns {
+				if column.Name == *params.OwnerColumn {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return pkg_apierror.Message(c, http.StatusBadRequest, "owner column not found")
+			}
+		}
+		updates["owner_column"] = *params.OwnerColumn
+	}
+
+	if params.AllowTOTP != nil {
+		if *params.AllowTOTP {
+			if !table.IsAuth {
+				return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+			}
+			if err := ensureTOTPColumns(d.db, tableName); err != nil {
+				return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+			}
+		}
+		updates["allow_totp"] = *params.AllowTOTP
+	}
+
+	if params.AllowMagicLink != nil {
+		if *params.AllowMagicLink && !table.IsAuth {
+			return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+		}
+		updates["allow_magic_link"] = *params.AllowMagicLink
+	}
+
+	if params.ProtectFiles != nil {
+		updates["protect_files"] = *params.ProtectFiles
+	}
+
+	if params.StorageQuotaMB != nil {
+		if *params.StorageQuotaMB < 0 {
+			return pkg_apierror.Message(c, http.StatusBadRequest, "storage quota must not be negative")
+		}
+		updates["storage_quota_mb"] = *params.StorageQuotaMB
+	}
+
+	if params.CacheTTLSeconds != nil {
+		if *params.CacheTTLSeconds < 0 || *params.CacheTTLSeconds > int(maxResultCacheTTL.Seconds()) {
+			return pkg_apierror.Message(c, http.StatusBadRequest, fmt.Sprintf("cache ttl must be between 0 and %d seconds", int(maxResultCacheTTL.Seconds())))
+		}
+		updates["cache_ttl_seconds"] = *params.CacheTTLSeconds
+	}
+
+	// the rows written before keep their ids
+	if params.IDPrefix != nil {
+		if err := validateIDPrefix(table.IDType, *params.IDPrefix); err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		updates["id_prefix"] = *params.IDPrefix
+	}
+
+	if len(updates) > 0 {
+		err = d.db.Model(&model.Tables{}).
+			Where("name = ?", table.Name).
+			Updates(updates).
+			Error
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+	}
+
+	table, err = getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(d.db, c, model.ACTIVITY_UPDATE_TABLE, tableName, "updated table settings")
+
+	return c.JSON(http.StatusOK, table)
 }
 
 type fields struct {
@@ -222,6 +562,15 @@ type fields struct {
 	RelatedTable string `json:"related_table,omitempty"`
 	Indexed      bool   `json:"indexed"`
 	Unique       bool   `json:"unique"`
+
+	// Expression makes the field a generated column computed from other
+	// columns, stored on disk when Stored is set or computed on read otherwise
+	Expression string `json:"expression,omitempty"`
+	Stored     bool   `json:"stored,omitempty"`
+
+	// Collation of a text field is binary, the default, or nocase for the
+	// values compared regardless of case
+	Collation string `json:"collation,omitempty"`
 }
 
 func (f *fields) convertTypeToSQLiteType() string {
@@ -230,43 +579,73 @@ func (f *fields) convertTypeToSQLiteType() string {
 		return "TEXT"
 	case "number":
 		return "REAL"
+	case "integer":
+		return "INTEGER"
 	case "boolean":
 		return "BOOLEAN"
 	case "datetime":
 		return "DATETIME"
+	case "geopoint":
+		return "LATLNG"
 	case "file":
-		return ""
+		return "FILE"
 	case "relation":
 		return "RELATION"
 	default:
+		if precision, scale, ok := decimalType(f.FieldType); ok {
+			return fmt.Sprintf("DECIMAL(%d,%d)", precision, scale)
+		}
 		return ""
 	}
 }
 
+// defaultOwnerColumn is the owner column of the tables created with a field
+// of that name and no owner column
+const defaultOwnerColumn = "owner_id"
+
 type createTableReq struct {
 	TableName string   `json:"table_name"`
 	IDType    string   `json:"id_type"`
 	Fields    []fields `json:"fields"`
 	Type      string   `json:"table_type"`
+
+	// OwnerColumn holds the id of the user inserting a row, it is added to
+	// the fields when missing
+	OwnerColumn string `json:"owner_column"`
+
+	// IDPrefix starts the ids made for the rows, such as ord_
+	IDPrefix string `json:"id_prefix"`
 }
 
 func (d *DatabaseAPIImpl) CreateTable(c echo.Context) error {
 	var params *createTableReq = new(createTableReq)
 	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
+	defer invalidateTables(params.TableName)
 
-	id := "id %s"
+	if err := validateIdentifier("table_name", params.TableName); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if params.OwnerColumn != "" {
+		if err := validateIdentifier("owner_column", params.OwnerColumn); err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+	}
 
-	switch params.IDType {
-	case "string":
-		id = fmt.Sprintf(id, "TEXT PRIMARY KEY DEFAULT (hex(randomblob(8)))")
-	case "manual":
-		id = fmt.Sprintf(id, "TEXT PRIMARY KEY")
-	default:
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid id type")
+	if params.IDType == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "Invalid id type")
+	}
+	id, err := idColumn(params.IDType)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	// the users are referenced by their id in the tokens, as a text
+	if params.Type == "users" && params.IDType == model.ID_TYPE_AUTOINCREMENT {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "user type tables can't have autoincrement ids")
+	}
+	if err := validateIDPrefix(params.IDType, params.IDPrefix); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	fields := []string{
@@ -281,6 +660,7 @@ func (d *DatabaseAPIImpl) CreateTable(c echo.Context) error {
 			"password TEXT NOT NULL",
 			"salt TEXT NOT NULL",
 		}
+		authFields = append(authFields, totpColumns...)
 		isAuth = true
 
 		fields = append(fields, authFields...)
@@ -291,6 +671,15 @@ func (d *DatabaseAPIImpl) CreateTable(c echo.Context) error {
 	indexes := []string{}
 
 	for i := 0; i < len(params.Fields); i++ {
+		if err := params.Fields[i].validateType(); err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		if err := params.Fields[i].validateExpression(); err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		if err := validateIdentifier("field_name", params.Fields[i].FieldName); err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
 		dtype := params.Fields[i].convertTypeToSQLiteType()
 		// IGNORE UNSUPPORTED DATATYPES FOR NOW
 		if dtype == "" {
@@ -299,18 +688,40 @@ func (d *DatabaseAPIImpl) CreateTable(c echo.Context) error {
 
 		var field string
 		if dtype == "RELATION" {
+			if err := validateIdentifier("related_table", params.Fields[i].RelatedTable); err != nil {
+				return pkg_apierror.Error(c, http.StatusBadRequest, err)
+			}
 			field = fmt.Sprintf("%s %s", params.Fields[i].FieldName, "TEXT")
 			foreignKeys = append(foreignKeys, fmt.Sprintf("FOREIGN KEY(%s) REFERENCES %s(id) ON UPDATE CASCADE", params.Fields[i].FieldName, params.Fields[i].RelatedTable))
 		} else {
 			field = fmt.Sprintf("%s %s", params.Fields[i].FieldName, dtype)
 		}
 
+		if params.Fields[i].Collation != "" {
+			if dtype != "TEXT" {
+				return pkg_apierror.Message(c, http.StatusBadRequest, fmt.Sprintf("%s is not a text field, it has no collation", params.Fields[i].FieldName))
+			}
+			collation, err := collationClause(params.Fields[i].Collation)
+			if err != nil {
+				return pkg_apierror.Error(c, http.StatusBadRequest, err)
+			}
+			field += collation
+		}
+
+		if params.Fields[i].Expression != "" {
+			storage := "VIRTUAL"
+			if params.Fields[i].Stored {
+				storage = "STORED"
+			}
+			field += fmt.Sprintf(" GENERATED ALWAYS AS (%s) %s", params.Fields[i].Expression, storage)
+		}
+
 		if !params.Fields[i].Nullable {
 			field += " NOT NULL"
 		}
 
 		if params.Fields[i].Indexed {
-			indexes = append(indexes, fmt.Sprintf("CREATE INDEX idx_%s ON %s (%s)", params.Fields[i].FieldName, params.TableName, params.Fields[i].FieldName))
+			indexes = append(indexes, fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s (%s)", params.TableName, params.Fields[i].FieldName, params.TableName, params.Fields[i].FieldName))
 		}
 
 		if params.Fields[i].Unique {
@@ -320,6 +731,22 @@ func (d *DatabaseAPIImpl) CreateTable(c echo.Context) error {
 		fields = append(fields, field)
 	}
 
+	owner := params.OwnerColumn
+	if isAuth && owner != "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "user type tables are owned through their id")
+	}
+	declared := false
+	for _, field := range params.Fields {
+		if field.FieldName == owner || owner == "" && field.FieldName == defaultOwnerColumn && !isAuth {
+			owner = field.FieldName
+			declared = true
+		}
+	}
+	if owner != "" && !declared {
+		fields = append(fields, fmt.Sprintf("%s TEXT", owner))
+		indexes = append(indexes, fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s (%s)", params.TableName, owner, params.TableName, owner))
+	}
+
 	fields = append(fields, []string{
 		"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
 		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
@@ -335,64 +762,429 @@ func (d *DatabaseAPIImpl) CreateTable(c echo.Context) error {
 
 	query = fmt.Sprintf(query, params.TableName, strings.Join(fields, ","))
 
-	err := d.db.Transaction(func(tx *gorm.DB) error {
-		err := d.db.Exec(query).Error
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(query).Error
 		if err != nil {
 			return err
 		}
 
 		// add index
 		for _, index := range indexes {
-			err = d.db.Exec(index).Error
+			err = tx.Exec(index).Error
 			if err != nil {
 				return err
 			}
 		}
 
-		// check if trigger already exist
-		var triggerHolder int64
-		err = d.db.Table("sqlite_master").
-			Select("*").
-			Where("type = ?", "trigger").
-			Where("name = ?", fmt.Sprintf("updated_timestamp_%s", params.TableName)).
-			Count(&triggerHolder).Error
+		err = createUpdatedTimestampTrigger(tx, params.TableName)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(
+			&model.Tables{
+				Name:        params.TableName,
+				IsAuth:      isAuth,
+				IsSystem:    false,
+				OwnerColumn: owner,
+				IDType:      params.IDType,
+				IDPrefix:    params.IDPrefix,
+			}).
+			Error
 		if err != nil {
 			return err
 		}
 
-		// add trigger to update updated_at value on update
-		if triggerHolder == 0 {
-			err = d.db.Exec(fmt.Sprintf(`
-			CREATE TRIGGER updated_timestamp_%s
-			AFTER UPDATE ON %s
-			FOR EACH ROW
-			BEGIN
-				UPDATE %s SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
-			END
-			`, params.TableName, params.TableName, params.TableName)).Error
+		return nil
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(d.db, c, model.ACTIVITY_CREATE_TABLE, params.TableName, "")
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// createUpdatedTimestampTrigger adds the trigger keeping updated_at in sync
+// on every update, if the table doesn't have one yet
+func createUpdatedTimestampTrigger(db *gorm.DB, tableName string) error {
+	// check if trigger already exist
+	var triggerHolder int64
+	err := db.Table("sqlite_master").
+		Select("*").
+		Where("type = ?", "trigger").
+		Where("name = ?", fmt.Sprintf("updated_timestamp_%s", tableName)).
+		Count(&triggerHolder).Error
+	if err != nil {
+		return err
+	}
+
+	if triggerHolder > 0 {
+		return nil
+	}
+
+	return db.Exec(fmt.Sprintf(`
+		CREATE TRIGGER updated_timestamp_%s
+		AFTER UPDATE ON %s
+		FOR EACH ROW
+		BEGIN
+			UPDATE %s SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+		END
+		`, tableName, tableName, tableName)).Error
+}
+
+type schemaObject struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// fetchSchemaObjects returns the DDL of a table and of its indexes and triggers
+func fetchSchemaObjects(db *gorm.DB, tableName string) ([]schemaObject, error) {
+	var objects []schemaObject
+	err := db.Table("sqlite_master").
+		Select("type, name, sql").
+		Where("tbl_name = ?", tableName).
+		Where("sql IS NOT NULL").
+		Order("CASE type WHEN 'table' THEN 0 WHEN 'view' THEN 0 WHEN 'index' THEN 1 ELSE 2 END").
+		Scan(&objects).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// renameIndex maps an index of source onto target, following the
+// idx_<table>_<column> naming used by CreateTable
+func renameIndex(name, source, target string) string {
+	prefix := fmt.Sprintf("idx_%s_", source)
+	if strings.HasPrefix(name, prefix) {
+		return fmt.Sprintf("idx_%s_%s", target, strings.TrimPrefix(name, prefix))
+	}
+
+	return fmt.Sprintf("%s_%s", target, name)
+}
+
+// retargetIndexSQL rewrites a CREATE INDEX statement to create index name
+// on table target instead
+func retargetIndexSQL(sql, name, target string) string {
+	head := "CREATE INDEX"
+	if strings.HasPrefix(strings.ToUpper(sql), "CREATE UNIQUE INDEX") {
+		head = "CREATE UNIQUE INDEX"
+	}
+
+	return fmt.Sprintf("%s %s ON %s %s", head, name, target, sql[strings.Index(sql, "("):])
+}
+
+type duplicateTableReq struct {
+	NewName  string `json:"new_name"`
+	WithData bool   `json:"with_data"`
+}
+
+func (d *DatabaseAPIImpl) DuplicateTable(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var params *duplicateTableReq = new(duplicateTableReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	defer invalidateTables(params.NewName)
+
+	if params.NewName == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "new_name is required")
+	}
+	if err := validateIdentifier("new_name", params.NewName); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view cannot be duplicated")
+	}
+
+	objects, err := fetchSchemaObjects(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	columns, err := fetchColumns(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	tableRef := regexp.MustCompile(fmt.Sprintf(`\b%s\b`, regexp.QuoteMeta(tableName)))
+
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		for _, object := range objects {
+			var err error
+			switch object.Type {
+			case "table":
+				err = tx.Exec(fmt.Sprintf("CREATE TABLE %s %s", params.NewName, object.SQL[strings.Index(object.SQL, "("):])).Error
+			case "index":
+				name := renameIndex(object.Name, tableName, params.NewName)
+				err = tx.Exec(retargetIndexSQL(object.SQL, name, params.NewName)).Error
+			case "trigger":
+				if object.Name == fmt.Sprintf("updated_timestamp_%s", tableName) {
+					err = createUpdatedTimestampTrigger(tx, params.NewName)
+				} else {
+					err = tx.Exec(tableRef.ReplaceAllString(object.SQL, params.NewName)).Error
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if params.WithData {
+			// the files are copied once the rows are, the copies never hold
+			// the stored files of the table
+			names := []string{}
+			values := []string{}
+			for _, column := range columns {
+				if column.Generated {
+					continue
+				}
+				names = append(names, column.Name)
+				switch {
+				case !strings.EqualFold(column.Type, "FILE"):
+					values = append(values, column.Name)
+				case column.NotNull:
+					values = append(values, "''")
+				default:
+					values = append(values, "NULL")
+				}
+			}
+
+			err := tx.Exec(fmt.Sprintf(
+				"INSERT INTO %s (%s) SELECT %s FROM %s",
+				params.NewName, strings.Join(names, ", "), strings.Join(values, ", "), tableName,
+			)).Error
 			if err != nil {
 				return err
 			}
 		}
-		err = d.db.Create(
-			&model.Tables{
-				Name:     params.TableName,
-				IsAuth:   isAuth,
-				IsSystem: false,
-			}).
+
+		// the copy keeps the access, protection, file limits and validations
+		// of the columns along with the settings and triggers of the table
+		var metas []model.ColumnMeta
+		if err := tx.Where("`table` = ?", tableName).Find(&metas).Error; err != nil {
+			return err
+		}
+		for i := range metas {
+			metas[i].Table = params.NewName
+		}
+		if len(metas) > 0 {
+			if err := tx.Create(&metas).Error; err != nil {
+				return err
+			}
+		}
+
+		var triggers []model.FunctionTrigger
+		if err := tx.Where("`table` = ?", tableName).Find(&triggers).Error; err != nil {
+			return err
+		}
+		for i := range triggers {
+			triggers[i].Name = fmt.Sprintf("%s_%s", params.NewName, triggers[i].Name)
+			triggers[i].Table = params.NewName
+			triggers[i].CreatedAt = time.Time{}
+			triggers[i].UpdatedAt = time.Time{}
+		}
+		if len(triggers) > 0 {
+			if err := tx.Create(&triggers).Error; err != nil {
+				return err
+			}
+		}
+
+		duplicate := table
+		duplicate.Name = params.NewName
+		duplicate.IsSystem = false
+
+		return tx.Create(&duplicate).Error
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadTriggers(d.db)
+	if params.WithData {
+		copyTableFiles(c.Request().Context(), d.db, d.storage, tableName, params.NewName)
+	}
+
+	recordActivity(d.db, c, model.ACTIVITY_DUPLICATE_TABLE, tableName, "duplicated to "+params.NewName)
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+type renameTableReq struct {
+	NewName string `json:"new_name"`
+}
+
+func (d *DatabaseAPIImpl) RenameTable(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var params *renameTableReq = new(renameTableReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	// the references of the other tables are renamed too
+	defer flushTableCache()
+
+	if params.NewName == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "new_name is required")
+	}
+	if err := validateIdentifier("new_name", params.NewName); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view cannot be renamed")
+	}
+
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", tableName, params.NewName)).Error
+		if err != nil {
+			return err
+		}
+
+		// SQLite rewrites the references inside indexes and triggers, but
+		// their names still carry the old table name
+		objects, err := fetchSchemaObjects(tx, params.NewName)
+		if err != nil {
+			return err
+		}
+
+		for _, object := range objects {
+			switch {
+			case object.Type == "index" && strings.HasPrefix(object.Name, fmt.Sprintf("idx_%s_", tableName)):
+				name := renameIndex(object.Name, tableName, params.NewName)
+				if err := tx.Exec(fmt.Sprintf("DROP INDEX %s", object.Name)).Error; err != nil {
+					return err
+				}
+				if err := tx.Exec(retargetIndexSQL(object.SQL, name, params.NewName)).Error; err != nil {
+					return err
+				}
+			case object.Type == "trigger" && object.Name == fmt.Sprintf("updated_timestamp_%s", tableName):
+				if err := tx.Exec(fmt.Sprintf("DROP TRIGGER %s", object.Name)).Error; err != nil {
+					return err
+				}
+				if err := createUpdatedTimestampTrigger(tx, params.NewName); err != nil {
+					return err
+				}
+			}
+		}
+
+		// the column settings, triggers, files and purge jobs follow the table
+		err = tx.Model(&model.ColumnMeta{}).
+			Where("`table` = ?", tableName).
+			Update("table", params.NewName).
 			Error
 		if err != nil {
 			return err
 		}
 
-		return nil
+		err = tx.Model(&model.FunctionTrigger{}).
+			Where("`table` = ?", tableName).
+			Update("table", params.NewName).
+			Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&model.File{}).
+			Where("`table` = ?", tableName).
+			Update("table", params.NewName).
+			Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&model.CronJob{}).
+			Where("action = ? AND target = ?", model.CRON_ACTION_PURGE, tableName).
+			Update("target", params.NewName).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&model.Tables{}).
+			Where("name = ?", tableName).
+			Update("name", params.NewName).
+			Error
 	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
+	reloadTriggers(d.db)
+	reloadCronJobs()
+
+	recordActivity(d.db, c, model.ACTIVITY_RENAME_TABLE, tableName, "renamed to "+params.NewName)
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+type createViewReq struct {
+	ViewName string `json:"view_name"`
+	Query    string `json:"query"`
+}
+
+func (d *DatabaseAPIImpl) CreateView(c echo.Context) error {
+	var params *createViewReq = new(createViewReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	defer invalidateTables(params.ViewName)
+
+	if err := validateIdentifier("view_name", params.ViewName); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	query, err := singleStatement(params.Query)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	keyword := strings.ToUpper(strings.SplitN(query, " ", 2)[0])
+	if keyword != "SELECT" && keyword != "WITH" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view query must be a SELECT statement")
+	}
+	// a WITH may end in a statement writing rows
+	readOnly, err := pkg_sqlite.ReadOnly(d.db, query)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if !readOnly {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view query must be a SELECT statement")
+	}
+
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf("CREATE VIEW %s AS %s", params.ViewName, query)).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(
+			&model.Tables{
+				Name:     params.ViewName,
+				IsAuth:   false,
+				IsSystem: false,
+				IsView:   true,
+			}).
+			Error
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(d.db, c, model.ACTIVITY_CREATE_VIEW, params.ViewName, query)
+
 	return c.JSON(http.StatusOK, nil)
 }
 
@@ -401,16 +1193,72 @@ func (d *DatabaseAPIImpl) FetchDataByID(c echo.Context) error {
 	id := c.Param("id")
 	var result map[string]interface{} = make(map[string]interface{}, 0)
 
-	if err := d.db.Table(tableName).
-		Select("*").
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// expand is a comma separated list of relation columns and of
+	// back-relations such as comments(post_id)
+	var expand []string
+	if c.QueryParam("expand") != "" {
+		expand = strings.Split(c.QueryParam("expand"), ",")
+	}
+	expansion, err := parseExpand(d.db, c, tableName, expand)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	// fields is a comma separated list of the columns to read, the BLOB and
+	// file columns are only read when listed
+	var fields []string
+	if c.QueryParam("fields") != "" {
+		fields = strings.Split(c.QueryParam("fields"), ",")
+	}
+	selected, hidden, err := rowFields(d.db, table, fields, expansion)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	cacheKey := ""
+	if table.CacheTTLSeconds > 0 && len(expand) == 0 {
+		cacheKey = resultCacheKey(c, tableName, []interface{}{id, selected})
+		if cached, err := respondCached(c, cacheKey); cached {
+			return err
+		}
+	}
+
+	quoted := make([]string, 0, len(selected))
+	for _, column := range selected {
+		quoted = append(quoted, fmt.Sprintf("`%s`", column))
+	}
+	if err := preparedDB(d.read).WithContext(c.Request().Context()).Table(tableName).
+		Select(strings.Join(quoted, ", ")).
 		Where("id = ?", id).
-		Find(&result).
 		Limit(1).
+		Find(&result).
 		Error; err != nil {
-		return err
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
-	return c.JSON(http.StatusOK, result)
+	if err := stripRestrictedColumns(d.db, c, table, []map[string]interface{}{result}); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if len(result) > 0 {
+		if err := expansion.apply(d.read.WithContext(c.Request().Context()), c, []map[string]interface{}{result}); err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+	}
+	for _, column := range hidden {
+		delete(result, column)
+	}
+
+	if cacheKey != "" {
+		cacheResult(c, cacheKey, result, table.CacheTTLSeconds)
+	}
+
+	return respondWithETag(c, result)
 }
 
 type insertDataReq struct {
@@ -419,24 +1267,39 @@ type insertDataReq struct {
 
 func (d *DatabaseAPIImpl) InsertData(c echo.Context) error {
 	tableName := c.Param("table_name")
+	defer invalidateRowCounts(tableName)
 
 	var params *insertDataReq = new(insertDataReq)
-	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+	var stored []string
+	written := false
+	if isMultipart(c) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		// the files sent along are deleted when the row isn't written
+		defer func() {
+			if !written {
+				deleteFiles(d.storage, stored)
+			}
+		}()
+		params.Data, stored, err = multipartRowData(c, d.storage, form)
+		if err != nil {
+			return pkg_apierror.Error(c, storageErrorStatus(err), err)
+		}
+	} else if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	table, err := getTableInfo(d.db, tableName)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 	if table.IsAuth {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": "Insertion to user type table can only be done through auth API",
-		})
+		return pkg_apierror.Message(c, http.StatusBadRequest, "Insertion to user type table can only be done through auth API")
+	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view is read-only")
 	}
 
 	filteredData := make(map[string]interface{})
@@ -445,21 +1308,60 @@ func (d *DatabaseAPIImpl) InsertData(c echo.Context) error {
 			continue
 		}
 		if v != nil && v != "" {
-			filteredData[k] = v
+			value, err := encodeGeoPoint(v)
+			if err != nil {
+				return pkg_apierror.Error(c, http.StatusBadRequest, err)
+			}
+			filteredData[k] = value
 		}
 	}
 
-	filteredData["id"], _ = utils.GenerateRandomString(16)
+	if err := removeGeneratedColumns(d.db, tableName, filteredData); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
 
-	result := d.db.Table(tableName).
-		Create(&filteredData)
-	if result.Error != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": result.Error.Error(),
+	if errs, err := validateRow(d.db, tableName, filteredData, false); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	} else if len(errs) > 0 {
+		return pkg_apierror.Write(c, http.StatusUnprocessableEntity, pkg_apierror.Response{
+			Code:    pkg_apierror.CODE_VALIDATION_FAILED,
+			Message: "invalid values",
+			Fields:  errs,
 		})
 	}
 
-	return c.JSON(http.StatusOK, params.Data)
+	if err := prepareFileColumns(d.db, tableName, "", filteredData); err != nil {
+		return pkg_apierror.Error(c, fileErrorStatus(err), err)
+	}
+	if err := validateFiles(c.Request().Context(), d.db, d.storage, tableName, "", currentUserID(c), filteredData); err != nil {
+		return pkg_apierror.Error(c, fileErrorStatus(err), err)
+	}
+
+	// the rows are owned by the users inserting them, admins may set the
+	// owner of a row
+	if table.OwnerColumn != "" && !isAdmin(c) {
+		filteredData[table.OwnerColumn] = currentUserID(c)
+	}
+
+	if err := assignRowID(table, filteredData); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	err = createRow(d.db.WithContext(c.Request().Context()), tableName, filteredData)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	written = true
+	id := fmt.Sprint(filteredData["id"])
+	attachFiles(c.Request().Context(), d.db, d.storage, tableName, id, filteredData)
+	publishChange(d.db, tableName, CHANGE_CREATE, changedRows(d.db, tableName, CHANGE_CREATE, []string{id}))
+
+	// the row is returned as written, with its id, the rowid gorm sets on
+	// the maps it creates isn't a column
+	delete(filteredData, "@id")
+
+	return c.JSON(http.StatusOK, filteredData)
 }
 
 type updateDataReq struct {
@@ -469,23 +1371,94 @@ type updateDataReq struct {
 
 func (d *DatabaseAPIImpl) UpdateData(c echo.Context) error {
 	tableName := c.Param("table_name")
+	defer invalidateRowCounts(tableName)
 
 	var params *updateDataReq = new(updateDataReq)
-	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
+	var stored []string
+	written := false
+	if isMultipart(c) {
+		form, err := c.MultipartForm()
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		// the files sent along are deleted when the row isn't written
+		defer func() {
+			if !written {
+				deleteFiles(d.storage, stored)
+			}
+		}()
+		params.Data, stored, err = multipartRowData(c, d.storage, form)
+		if err != nil {
+			return pkg_apierror.Error(c, storageErrorStatus(err), err)
+		}
+		params.ID, _ = params.Data["id"].(string)
+		delete(params.Data, "id")
+	} else if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view is read-only")
+	}
+	// only admins can give a row to another user
+	if table.OwnerColumn != "" && !isAdmin(c) {
+		delete(params.Data, table.OwnerColumn)
+	}
+
+	for k, v := range params.Data {
+		value, err := encodeGeoPoint(v)
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		params.Data[k] = value
+	}
+
+	if err := removeGeneratedColumns(d.db, tableName, params.Data); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if errs, err := validateRow(d.db, tableName, params.Data, true); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	} else if len(errs) > 0 {
+		return pkg_apierror.Write(c, http.StatusUnprocessableEntity, pkg_apierror.Response{
+			Code:    pkg_apierror.CODE_VALIDATION_FAILED,
+			Message: "invalid values",
+			Fields:  errs,
 		})
 	}
 
-	result := d.db.Table(tableName).
+	if err := prepareFileColumns(d.db, tableName, params.ID, params.Data); err != nil {
+		return pkg_apierror.Error(c, fileErrorStatus(err), err)
+	}
+	if err := validateFiles(c.Request().Context(), d.db, d.storage, tableName, params.ID, currentUserID(c), params.Data); err != nil {
+		return pkg_apierror.Error(c, fileErrorStatus(err), err)
+	}
+	previousFiles, err := rowFiles(d.db, tableName, params.ID, params.Data)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	result := d.db.WithContext(c.Request().Context()).Table(tableName).
 		Where("id = ?", params.ID).
 		Updates(&params.Data)
 	if result.Error != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": result.Error.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
 	}
 
+	written = true
+	attachFiles(c.Request().Context(), d.db, d.storage, tableName, params.ID, params.Data)
+	// the files the update replaced are no longer held by any row
+	if changes := replaceFiles(c.Request().Context(), d.storage, tableName, params.ID, previousFiles, params.Data); len(changes) > 0 {
+		params.Data["_files"] = changes
+	}
+	publishChange(d.db, tableName, CHANGE_UPDATE, changedRows(d.db, tableName, CHANGE_UPDATE, []string{params.ID}))
+
+	params.Data["id"] = params.ID
+
 	return c.JSON(http.StatusOK, params.Data)
 }
 
@@ -495,23 +1468,40 @@ type deleteDataReq struct {
 
 func (d *DatabaseAPIImpl) DeleteData(c echo.Context) error {
 	tableName := c.Param("table_name")
+	defer invalidateRowCounts(tableName)
 
 	var params *deleteDataReq = new(deleteDataReq)
 	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if len(params.ID) == 0 {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "id is required")
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view is read-only")
+	}
+
+	fileKeys, err := rowFileKeys(d.db, tableName, params.ID)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	deleted := changedRows(d.db, tableName, CHANGE_DELETE, params.ID)
 
-	result := d.db.Table(tableName).
+	result := d.db.WithContext(c.Request().Context()).Table(tableName).
 		Where("id IN ?", params.ID).
 		Delete(nil)
 	if result.Error != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": result.Error.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
 	}
 
+	deleteFiles(d.storage, fileKeys)
+	publishChange(d.db, tableName, CHANGE_DELETE, deleted)
+
 	return c.JSON(http.StatusOK, nil)
 }
 
@@ -522,27 +1512,28 @@ type queryReq struct {
 func (d *DatabaseAPIImpl) RunQuery(c echo.Context) error {
 	var params *queryReq = new(queryReq)
 	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	// the SELECT queries are read through the read pool, any other query
+	// may change the schema of any table
+	db := d.read
+	if !isSelectQuery(params.Query) {
+		db = d.db
+		defer flushTableCache()
 	}
 
 	var result []map[string]interface{} = make([]map[string]interface{}, 0)
 
-	rows, err := d.db.Raw(params.Query).Rows()
+	rows, err := db.WithContext(c.Request().Context()).Raw(params.Query).Rows()
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var row map[string]interface{}
-		if err := d.db.ScanRows(rows, &row); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-				"error": err.Error(),
-			})
+		if err := db.ScanRows(rows, &row); err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 		}
 		result = append(result, row)
 	}
@@ -566,17 +1557,73 @@ func (d *DatabaseAPIImpl) RunQuery(c echo.Context) error {
 		`)
 	}(params.Query)
 
+	recordActivity(d.db, c, model.ACTIVITY_QUERY, "", params.Query)
+
 	return c.JSON(http.StatusOK, result)
 }
 
+// isSelectQuery tells whether query is a single SELECT statement. The read
+// pool can't write, so a SELECT calling a writing function still fails
+func isSelectQuery(query string) bool {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	fields := strings.Fields(query)
+
+	return len(fields) > 0 && strings.EqualFold(fields[0], "SELECT") && !strings.Contains(query, ";")
+}
+
+// singleStatement returns query without its trailing semicolons, it fails
+// when a statement follows the first one. SQLite runs every statement of a
+// query given to Exec
+func singleStatement(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	for strings.HasSuffix(query, ";") {
+		query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+	}
+
+	var quote string
+	for i := 0; i < len(query); i++ {
+		if quote != "" {
+			if strings.HasPrefix(query[i:], quote) {
+				i += len(quote) - 1
+				quote = ""
+			}
+			continue
+		}
+
+		switch {
+		case query[i] == '\'' || query[i] == '"' || query[i] == '`':
+			quote = query[i : i+1]
+		case query[i] == '[':
+			quote = "]"
+		case strings.HasPrefix(query[i:], "--"):
+			quote = "\n"
+		case strings.HasPrefix(query[i:], "/*"):
+			quote = "*/"
+			i++
+		case query[i] == ';':
+			return "", errors.New("query must be a single statement")
+		}
+	}
+
+	return query, nil
+}
+
+// FlushCache drops the cached schema of every table, for changes made to
+// the database file outside of the API
+func (d *DatabaseAPIImpl) FlushCache(c echo.Context) error {
+	flushTableCache()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
+
 func (d *DatabaseAPIImpl) FetchQueryHistory(c echo.Context) error {
 	var queryHistories []model.QueryHistory
 
 	result := d.db.Limit(10).Order("id DESC").Find(&queryHistories)
 	if result.Error != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": result.Error.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
 	}
 
 	return c.JSON(http.StatusOK, queryHistories)
@@ -584,14 +1631,33 @@ func (d *DatabaseAPIImpl) FetchQueryHistory(c echo.Context) error {
 
 func (d *DatabaseAPIImpl) DeleteTable(c echo.Context) error {
 	tableName := c.Param("table_name")
+	defer invalidateTables(tableName)
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	drop := "DROP TABLE %s"
+	if table.IsView {
+		drop = "DROP VIEW %s"
+	}
+
+	var fileKeys []string
+	if !table.IsView {
+		fileKeys, err = tableFileKeys(d.db, tableName)
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+	}
 
-	err := d.db.Transaction(func(tx *gorm.DB) error {
-		err := d.db.Exec(fmt.Sprintf("DROP TABLE %s", tableName)).Error
+	err = d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf(drop, tableName)).Error
 		if err != nil {
 			return err
 		}
 
-		err = d.db.
+		err = tx.
 			Where("lower(name) = ?", strings.ToLower(tableName)).
 			Delete(&model.Tables{}).
 			Error
@@ -599,12 +1665,33 @@ func (d *DatabaseAPIImpl) DeleteTable(c echo.Context) error {
 			return err
 		}
 
+		err = tx.
+			Where("`table` = ?", tableName).
+			Delete(&model.ColumnMeta{}).
+			Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.
+			Where("`table` = ?", tableName).
+			Delete(&model.FunctionTrigger{}).
+			Error
+		if err != nil {
+			return err
+		}
+
 		return nil
 	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
+
+	reloadTriggers(d.db)
+
+	deleteFiles(d.storage, fileKeys)
+
+	recordActivity(d.db, c, model.ACTIVITY_DELETE_TABLE, tableName, "")
+
 	return c.JSON(http.StatusOK, nil)
 }
@@ -0,0 +1,516 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"react-golang/src/backend/model"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"react-golang/src/backend/service"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := pkg_sqlite.NewSQLiteClient(filepath.Join(t.TempDir(), "test.db"), pkg_sqlite.SQLiteOption{Migrate: true})
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return db
+}
+
+func TestDuplicateTableKeepsColumnSettings(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+
+	steps := []error{
+		db.Exec("CREATE TABLE invoices (id TEXT PRIMARY KEY, cost REAL, scan TEXT)").Error,
+		db.Create(&model.Tables{Name: "invoices", ProtectFiles: true, StorageQuotaMB: 10, CacheTTLSeconds: 30}).Error,
+		db.Create(&model.ColumnMeta{Table: "invoices", Column: "cost", Access: model.ACCESS_ADMIN, Required: true}).Error,
+		db.Create(&model.ColumnMeta{Table: "invoices", Column: "scan", Protected: true, AllowedMimeTypes: "application/pdf", MaxFileSize: 1024}).Error,
+		db.Create(&model.FunctionTrigger{Name: "notify", Table: "invoices", Event: "insert", Function: "notify", Enabled: true}).Error,
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"new_name":"invoices_copy"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("table_name")
+	c.SetParamValues("invoices")
+
+	if err := d.DuplicateTable(c); err != nil {
+		t.Fatalf("DuplicateTable: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DuplicateTable returned %d: %s", rec.Code, rec.Body.String())
+	}
+
+	metas, err := fetchColumnMeta(db, "invoices_copy")
+	if err != nil {
+		t.Fatalf("failed to read the column settings: %v", err)
+	}
+	if cost := metas["cost"]; cost.Access != model.ACCESS_ADMIN || !cost.Required {
+		t.Errorf("cost of the copy is %+v, want admin only and required", cost)
+	}
+	if scan := metas["scan"]; !scan.Protected || scan.AllowedMimeTypes != "application/pdf" || scan.MaxFileSize != 1024 {
+		t.Errorf("scan of the copy is %+v, want protected pdfs up to 1024 bytes", scan)
+	}
+
+	var table model.Tables
+	if err := db.Where("name = ?", "invoices_copy").First(&table).Error; err != nil {
+		t.Fatalf("failed to read the copy: %v", err)
+	}
+	if !table.ProtectFiles || table.StorageQuotaMB != 10 || table.CacheTTLSeconds != 30 {
+		t.Errorf("settings of the copy are %+v, want the settings of invoices", table)
+	}
+
+	var triggers []model.FunctionTrigger
+	if err := db.Where("`table` = ?", "invoices_copy").Find(&triggers).Error; err != nil {
+		t.Fatalf("failed to read the triggers: %v", err)
+	}
+	if len(triggers) != 1 || triggers[0].Event != "insert" || triggers[0].Function != "notify" {
+		t.Errorf("triggers of the copy are %+v, want the notify trigger of invoices", triggers)
+	}
+}
+
+func TestCreateTableRejectsInjectedExpressions(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+
+	createOrders := func(expression string) int {
+		body, _ := json.Marshal(map[string]interface{}{
+			"table_name": "orders",
+			"id_type":    "string",
+			"fields": []map[string]interface{}{
+				{"field_name": "price", "field_type": "number", "nullable": true},
+				{"field_name": "doubled", "field_type": "number", "nullable": true, "expression": expression},
+			},
+		})
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := d.CreateTable(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("CreateTable: %v", err)
+		}
+
+		return rec.Code
+	}
+
+	expressions := []string{
+		"price * 2) VIRTUAL); CREATE TABLE pwned (x TEXT); CREATE TABLE zz (y TEXT GENERATED ALWAYS AS (1",
+		"price * 2) VIRTUAL, extra TEXT GENERATED ALWAYS AS (1",
+		"(SELECT count(*) FROM admin)",
+		"price -- comment",
+		"price, quantity",
+		"'unterminated",
+	}
+	for _, expression := range expressions {
+		if code := createOrders(expression); code != http.StatusBadRequest {
+			t.Errorf("CreateTable with %q returned %d, want 400", expression, code)
+		}
+	}
+
+	var tables []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name IN ('orders', 'pwned', 'zz')").Scan(&tables).Error; err != nil {
+		t.Fatalf("failed to list the tables: %v", err)
+	}
+	if len(tables) > 0 {
+		t.Errorf("tables %v were created", tables)
+	}
+
+	if code := createOrders("round(price * 2, 2) || ')'"); code != http.StatusOK {
+		t.Errorf("CreateTable with a valid expression returned %d, want 200", code)
+	}
+}
+
+func TestCreateViewRunsASingleSelect(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+
+	createView := func(name string, query string) int {
+		body, _ := json.Marshal(map[string]string{"view_name": name, "query": query})
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(body)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := d.CreateView(e.NewContext(req, rec)); err != nil {
+			t.Fatalf("CreateView: %v", err)
+		}
+
+		return rec.Code
+	}
+
+	rejected := [][2]string{
+		{"numbers", "SELECT 1 AS a; CREATE TABLE pwned(x TEXT)"},
+		{"numbers", "SELECT 1 AS a /* ; */; CREATE TABLE pwned(x TEXT)"},
+		{"numbers", "WITH x AS (SELECT 1) DELETE FROM admin"},
+		{"numbers AS SELECT 1; CREATE TABLE pwned(x TEXT); CREATE VIEW v2", "SELECT 1 AS a"},
+		{"1numbers", "SELECT 1 AS a"},
+	}
+	for _, view := range rejected {
+		if code := createView(view[0], view[1]); code != http.StatusBadRequest {
+			t.Errorf("CreateView of %q as %q returned %d, want 400", view[0], view[1], code)
+		}
+	}
+
+	var count int64
+	if err := db.Table("sqlite_master").Where("name IN ?", []string{"pwned", "numbers", "v2"}).Count(&count).Error; err != nil {
+		t.Fatalf("failed to list the tables: %v", err)
+	}
+	if count > 0 {
+		t.Errorf("%d tables or views were created", count)
+	}
+
+	if code := createView("numbers", "SELECT 1 AS a, ';' AS b;"); code != http.StatusOK {
+		t.Errorf("CreateView of a single SELECT returned %d, want 200", code)
+	}
+}
+
+func TestDuplicateTableCopiesFiles(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	db := newTestDB(t)
+	storage := service.NewStorageService(db)
+	d := &DatabaseAPIImpl{db: db, read: db, storage: storage}
+
+	file, err := storage.Save(context.Background(), "scan.txt", "user", strings.NewReader("invoice"))
+	if err != nil {
+		t.Fatalf("failed to store the file: %v", err)
+	}
+	steps := []error{
+		db.Exec("CREATE TABLE receipts (id TEXT PRIMARY KEY, scan FILE NOT NULL)").Error,
+		db.Create(&model.Tables{Name: "receipts"}).Error,
+		db.Exec("INSERT INTO receipts (id, scan) VALUES ('a', ?)", file.Key).Error,
+		storage.Attach(context.Background(), file.Key, "receipts", "a", "scan"),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"new_name":"receipts_copy","with_data":true}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("table_name")
+	c.SetParamValues("receipts")
+	if err := d.DuplicateTable(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("DuplicateTable returned %d %v: %s", rec.Code, err, rec.Body.String())
+	}
+
+	var copied string
+	if err := db.Raw("SELECT scan FROM receipts_copy WHERE id = 'a'").Scan(&copied).Error; err != nil {
+		t.Fatalf("failed to read the copy: %v", err)
+	}
+	if copied == "" || copied == file.Key {
+		t.Fatalf("scan of the copy is %q, want a copy of %s", copied, file.Key)
+	}
+
+	copy, err := storage.Fetch(context.Background(), copied)
+	if err != nil {
+		t.Fatalf("failed to read the copied file: %v", err)
+	}
+	if copy.Table != "receipts_copy" || copy.RowID != "a" || copy.Column != "scan" || copy.Hash != file.Hash {
+		t.Errorf("copied file is %+v, want the content of %s held by receipts_copy", copy, file.Key)
+	}
+	if original, err := storage.Fetch(context.Background(), file.Key); err != nil || original.Table != "receipts" {
+		t.Errorf("original file is %+v %v, want it held by receipts", original, err)
+	}
+}
+
+func TestRenameTableMovesItsReferences(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+
+	steps := []error{
+		db.Exec("CREATE TABLE tickets (id TEXT PRIMARY KEY, scan FILE)").Error,
+		db.Create(&model.Tables{Name: "tickets"}).Error,
+		db.Create(&model.FunctionTrigger{Name: "notify", Table: "tickets", Event: "insert", Function: "notify", Enabled: true}).Error,
+		db.Create(&model.File{Key: "key", Table: "tickets", RowID: "a", Column: "scan"}).Error,
+		db.Create(&model.CronJob{Name: "purge", Action: model.CRON_ACTION_PURGE, Target: "tickets", RetentionDays: 30}).Error,
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"new_name":"issues"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("table_name")
+	c.SetParamValues("tickets")
+	if err := d.RenameTable(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("RenameTable returned %d %v: %s", rec.Code, err, rec.Body.String())
+	}
+
+	var trigger model.FunctionTrigger
+	if err := db.First(&trigger, "name = ?", "notify").Error; err != nil || trigger.Table != "issues" {
+		t.Errorf("trigger is on %q %v, want issues", trigger.Table, err)
+	}
+	var file model.File
+	if err := db.First(&file, "key = ?", "key").Error; err != nil || file.Table != "issues" {
+		t.Errorf("file is held by %q %v, want issues", file.Table, err)
+	}
+	var job model.CronJob
+	if err := db.First(&job, "name = ?", "purge").Error; err != nil || job.Target != "issues" {
+		t.Errorf("purge job targets %q %v, want issues", job.Target, err)
+	}
+}
+
+func TestDeleteTableRemovesItsTriggers(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+
+	steps := []error{
+		db.Exec("CREATE TABLE shipments (id TEXT PRIMARY KEY)").Error,
+		db.Create(&model.Tables{Name: "shipments"}).Error,
+		db.Create(&model.FunctionTrigger{Name: "track", Table: "shipments", Event: "insert", Function: "track", Enabled: true}).Error,
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("table_name")
+	c.SetParamValues("shipments")
+	if err := d.DeleteTable(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("DeleteTable returned %d %v: %s", rec.Code, err, rec.Body.String())
+	}
+
+	var triggers int64
+	if err := db.Model(&model.FunctionTrigger{}).Where("`table` = ?", "shipments").Count(&triggers).Error; err != nil || triggers != 0 {
+		t.Errorf("%d triggers %v are left on the deleted table", triggers, err)
+	}
+}
+
+func TestSchemaRoutesRejectInvalidNames(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+	e := echo.New()
+
+	call := func(handler echo.HandlerFunc, table string, body interface{}) int {
+		encoded, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(encoded)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("table_name")
+		c.SetParamValues(table)
+		if err := handler(c); err != nil {
+			t.Fatalf("handler: %v", err)
+		}
+
+		return rec.Code
+	}
+	table := func(name string, field map[string]interface{}, owner string) map[string]interface{} {
+		return map[string]interface{}{"table_name": name, "id_type": "string", "owner_column": owner, "fields": []map[string]interface{}{field}}
+	}
+	text := map[string]interface{}{"field_name": "body", "field_type": "text", "nullable": true}
+
+	rejected := []map[string]interface{}{
+		table("posts (x TEXT); CREATE TABLE pwned", text, ""),
+		table("posts", map[string]interface{}{"field_name": "body TEXT); CREATE TABLE pwned (x", "field_type": "text", "nullable": true}, ""),
+		table("posts", map[string]interface{}{"field_name": "author", "field_type": "relation", "related_table": "users(id)); CREATE TABLE pwned (x", "nullable": true}, ""),
+		table("posts", text, "owner TEXT); CREATE TABLE pwned (x"),
+	}
+	for _, body := range rejected {
+		if code := call(d.CreateTable, "", body); code != http.StatusBadRequest {
+			t.Errorf("CreateTable of %v returned %d, want 400", body, code)
+		}
+	}
+
+	if code := call(d.CreateTable, "", table("posts", text, "")); code != http.StatusOK {
+		t.Fatalf("CreateTable of posts returned %d, want 200", code)
+	}
+	if code := call(d.DuplicateTable, "posts", map[string]interface{}{"new_name": "copy (x TEXT); CREATE TABLE pwned"}); code != http.StatusBadRequest {
+		t.Errorf("DuplicateTable returned %d, want 400", code)
+	}
+	if code := call(d.RenameTable, "posts", map[string]interface{}{"new_name": "renamed; DROP TABLE admin"}); code != http.StatusBadRequest {
+		t.Errorf("RenameTable returned %d, want 400", code)
+	}
+
+	var count int64
+	if err := db.Table("sqlite_master").Where("name = ?", "pwned").Count(&count).Error; err != nil || count > 0 {
+		t.Errorf("pwned was created: %d %v", count, err)
+	}
+}
+
+func TestDeleteDataKeepsFilesOfOtherRows(t *testing.T) {
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "test.db"))
+	db := newTestDB(t)
+	storage := service.NewStorageService(db)
+	d := &DatabaseAPIImpl{db: db, read: db, storage: storage}
+	ctx := context.Background()
+
+	own, err := storage.Save(ctx, "own.txt", "user", strings.NewReader("own"))
+	if err != nil {
+		t.Fatalf("failed to store the file: %v", err)
+	}
+	other, err := storage.Save(ctx, "other.txt", "user", strings.NewReader("other"))
+	if err != nil {
+		t.Fatalf("failed to store the file: %v", err)
+	}
+	steps := []error{
+		db.Exec("CREATE TABLE letters (id TEXT PRIMARY KEY, scan FILE, copy FILE)").Error,
+		db.Create(&model.Tables{Name: "letters"}).Error,
+		// the key of copy was written to the row without being attached to it
+		db.Exec("INSERT INTO letters (id, scan, copy) VALUES ('a', ?, ?)", own.Key, other.Key).Error,
+		storage.Attach(ctx, own.Key, "letters", "a", "scan"),
+		storage.Attach(ctx, other.Key, "parcels", "b", "scan"),
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/", strings.NewReader(`{"id":["a"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("table_name")
+	c.SetParamValues("letters")
+	if err := d.DeleteData(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("DeleteData returned %d %v: %s", rec.Code, err, rec.Body.String())
+	}
+
+	if _, err := storage.Fetch(ctx, own.Key); !errors.Is(err, service.ErrFileNotFound) {
+		t.Errorf("file of the row wasn't deleted: %v", err)
+	}
+	if _, err := storage.Fetch(ctx, other.Key); err != nil {
+		t.Errorf("file of another row was deleted: %v", err)
+	}
+}
+
+func TestBackgroundWritesDropCachedCounts(t *testing.T) {
+	db := newTestDB(t)
+
+	steps := []error{
+		db.Exec("CREATE TABLE readings (id TEXT PRIMARY KEY, value INTEGER, created_at DATETIME)").Error,
+		db.Create(&model.Tables{Name: "readings", IDType: model.ID_TYPE_MANUAL}).Error,
+		db.Exec("INSERT INTO readings (id, value, created_at) VALUES ('old', 1, '2000-01-01 00:00:00')").Error,
+		db.Create(&model.FunctionStored{
+			Name:     "record",
+			Function: `[{"name":"reading","action":"insert","table":"readings","values":{"id":"","value":""}}]`,
+		}).Error,
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	count := func() int64 {
+		count, err := countRows(db, "readings", nil, nil, countExact)
+		if err != nil {
+			t.Fatalf("countRows: %v", err)
+		}
+		return count
+	}
+	if got := count(); got != 1 {
+		t.Fatalf("counted %d rows, want 1", got)
+	}
+
+	if _, err := ExecuteFunction(db, "record", map[string]interface{}{"reading": map[string]interface{}{"id": "new", "value": 2}}); err != nil {
+		t.Fatalf("ExecuteFunction: %v", err)
+	}
+	if got := count(); got != 2 {
+		t.Errorf("counted %d rows after the function inserted one, want 2", got)
+	}
+
+	if _, err := PurgeRows(db, "readings", 30); err != nil {
+		t.Fatalf("PurgeRows: %v", err)
+	}
+	if got := count(); got != 1 {
+		t.Errorf("counted %d rows after the purge, want 1", got)
+	}
+}
+
+func TestInsertDataReturnsTheID(t *testing.T) {
+	db := newTestDB(t)
+	d := &DatabaseAPIImpl{db: db, read: db}
+
+	steps := []error{
+		db.Exec("CREATE TABLE purchases (id TEXT PRIMARY KEY, item TEXT)").Error,
+		db.Create(&model.Tables{Name: "purchases", IDType: model.ID_TYPE_STRING, IDPrefix: "ord_"}).Error,
+		db.Exec("CREATE TABLE counters (id INTEGER PRIMARY KEY AUTOINCREMENT, item TEXT)").Error,
+		db.Create(&model.Tables{Name: "counters", IDType: model.ID_TYPE_AUTOINCREMENT}).Error,
+	}
+	for _, err := range steps {
+		if err != nil {
+			t.Fatalf("failed to create the table: %v", err)
+		}
+	}
+
+	insert := func(table string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"data":{"item":"book"}}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("table_name")
+		c.SetParamValues(table)
+		if err := d.InsertData(c); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("InsertData into %s returned %d %v: %s", table, rec.Code, err, rec.Body.String())
+		}
+
+		row := map[string]interface{}{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &row); err != nil {
+			t.Fatalf("failed to decode the row inserted into %s: %v", table, err)
+		}
+		if _, ok := row["@id"]; ok {
+			t.Errorf("row inserted into %s holds the rowid of gorm", table)
+		}
+		if row["item"] != "book" {
+			t.Errorf("row inserted into %s has the item %v, want book", table, row["item"])
+		}
+
+		return row
+	}
+
+	purchase := insert("purchases")
+	id, _ := purchase["id"].(string)
+	if !strings.HasPrefix(id, "ord_") {
+		t.Errorf("purchase has the id %v, want an id prefixed with ord_", purchase["id"])
+	}
+	var stored int64
+	if err := db.Table("purchases").Where("id = ?", id).Count(&stored).Error; err != nil || stored != 1 {
+		t.Errorf("found %d purchases with the returned id %v", stored, err)
+	}
+
+	if counter := insert("counters"); counter["id"] != float64(1) {
+		t.Errorf("counter has the id %v, want 1", counter["id"])
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDistinctLimit = 100
+	maxDistinctLimit     = 1000
+)
+
+type distinctValuesReq struct {
+	Limit int `query:"limit"`
+	// Counts returns every value along with the number of rows holding it,
+	// the most common first
+	Counts bool `query:"counts"`
+	// Filter only counts the rows matching the expression, such as
+	// status = "active"
+	Filter string `query:"filter"`
+}
+
+type distinctValue struct {
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+// FetchDistinctValues returns the values of a column without duplicates,
+// sorted, to fill the filters of the clients. With counts, the values come
+// along with the number of rows holding them, the most common first
+func (d *DatabaseAPIImpl) FetchDistinctValues(c echo.Context) error {
+	tableName := c.Param("table_name")
+	column := c.Param("column")
+
+	var params *distinctValuesReq = new(distinctValuesReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if params.Limit <= 0 {
+		params.Limit = defaultDistinctLimit
+	}
+	params.Limit = min(params.Limit, maxDistinctLimit)
+
+	table, err := getTableInfo(d.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	columns, err := fetchColumns(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	found := false
+	for _, col := range columns {
+		if col.Name == column && !(table.IsAuth && authSecretColumns[column]) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return pkg_apierror.Message(c, http.StatusNotFound, "column not found")
+	}
+
+	expr, err := rowFilterExpr(d.db, c, table, params.Filter)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	filters := []Filter{{Column: column}}
+	if expr != nil {
+		filters = append(filters, expr.conditions()...)
+	}
+	// the values of a restricted column would leak through its distinct
+	// values just as through a filter
+	if err := checkFilterAccess(d.db, c, tableName, filters); err != nil {
+		return pkg_apierror.Error(c, http.StatusForbidden, err)
+	}
+
+	query := applyFilterExpr(d.read.WithContext(c.Request().Context()).Table(tableName), expr).Limit(params.Limit)
+
+	if params.Counts {
+		rows, err := query.
+			Select(fmt.Sprintf("`%s` AS value, COUNT(*) AS count", column)).
+			Group(fmt.Sprintf("`%s`", column)).
+			Order("count DESC, value").
+			Rows()
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		defer rows.Close()
+
+		values := []distinctValue{}
+		for rows.Next() {
+			var value distinctValue
+			if err := rows.Scan(&value.Value, &value.Count); err != nil {
+				return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+			}
+			values = append(values, value)
+		}
+		if err := rows.Err(); err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, values)
+	}
+
+	values := []interface{}{}
+	err = query.
+		Distinct(fmt.Sprintf("`%s`", column)).
+		Order(fmt.Sprintf("`%s`", column)).
+		Pluck(column, &values).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, values)
+}
@@ -0,0 +1,1000 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"react-golang/src/backend/model"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// the dialects of the dumps ImportDump reads
+const (
+	DUMP_POSTGRES = "postgres"
+	DUMP_MYSQL    = "mysql"
+)
+
+// the rows of a dump are inserted this many at a time
+const dumpInsertBatch = 500
+
+var ErrInvalidDump = errors.New("invalid dump")
+
+// ImportedTable is a table created from a dump, with the field type each of
+// its columns was mapped to
+type ImportedTable struct {
+	Name    string            `json:"name"`
+	Columns map[string]string `json:"columns"`
+	Rows    int64             `json:"rows"`
+}
+
+type DumpImport struct {
+	Dialect  string          `json:"dialect"`
+	Tables   []ImportedTable `json:"tables"`
+	Skipped  []string        `json:"skipped"`
+	Warnings []string        `json:"warnings"`
+}
+
+// DetectDumpDialect guesses the dialect of a dump from its beginning
+func DetectDumpDialect(head []byte) string {
+	text := string(head)
+	if strings.Contains(text, "MySQL dump") || strings.Contains(text, "MariaDB dump") || strings.Contains(text, "`") {
+		return DUMP_MYSQL
+	}
+
+	return DUMP_POSTGRES
+}
+
+// ImportDump creates the tables of a plain SQL dump of Postgres (pg_dump
+// with COPY or INSERT statements) or MySQL (mysqldump) and inserts their
+// rows, all in one transaction. The column types are mapped to the field
+// types, every imported column is nullable and the constraints, indexes and
+// the other statements are left out. A column named id becomes the id of
+// the table, which is generated otherwise. The tables which already exist
+// are skipped along with their rows
+func ImportDump(db *gorm.DB, r io.Reader, dialect string) (DumpImport, error) {
+	if dialect != DUMP_POSTGRES && dialect != DUMP_MYSQL {
+		return DumpImport{}, fmt.Errorf("%w: dialect must be postgres or mysql", ErrInvalidDump)
+	}
+
+	importer := &dumpImporter{
+		scanner: &dumpScanner{r: bufio.NewReaderSize(r, 64<<10), dialect: dialect},
+		tables:  map[string]*dumpTable{},
+		result:  DumpImport{Dialect: dialect, Tables: []ImportedTable{}, Skipped: []string{}, Warnings: []string{}},
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		importer.tx = tx
+		return importer.run()
+	})
+	if err != nil {
+		return DumpImport{}, err
+	}
+
+	for _, name := range importer.order {
+		table := importer.tables[name]
+		if table.skipped {
+			continue
+		}
+		columns := map[string]string{}
+		for _, column := range table.columns {
+			columns[column.name] = column.fieldType
+		}
+		importer.result.Tables = append(importer.result.Tables, ImportedTable{Name: name, Columns: columns, Rows: table.rows})
+	}
+
+	return importer.result, nil
+}
+
+type dumpColumn struct {
+	name      string
+	fieldType string
+}
+
+type dumpTable struct {
+	name    string
+	columns []dumpColumn
+	skipped bool
+	rows    int64
+}
+
+func (t *dumpTable) column(name string) (dumpColumn, bool) {
+	for _, column := range t.columns {
+		if column.name == name {
+			return column, true
+		}
+	}
+
+	return dumpColumn{}, false
+}
+
+type dumpImporter struct {
+	tx      *gorm.DB
+	scanner *dumpScanner
+	tables  map[string]*dumpTable
+	order   []string
+	result  DumpImport
+	// the tables whose rows were found without a CREATE TABLE, reported once
+	unknown map[string]bool
+}
+
+func (d *dumpImporter) warn(format string, args ...interface{}) {
+	d.result.Warnings = append(d.result.Warnings, fmt.Sprintf(format, args...))
+}
+
+var (
+	createTablePattern = regexp.MustCompile(`(?is)^CREATE\s+(?:(?:GLOBAL\s+|LOCAL\s+)?(?:TEMPORARY|TEMP)\s+|UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?([^\s(]+)\s*\((.*)\)[^)]*$`)
+	insertPattern      = regexp.MustCompile(`(?is)^(?:INSERT|REPLACE)\s+(?:IGNORE\s+)?INTO\s+([^\s(]+)\s*(?:\(([^)]*)\))?\s*VALUES\s*(.*)$`)
+	copyPattern        = regexp.MustCompile(`(?is)^COPY\s+([^\s(]+)\s*(?:\(([^)]*)\))?\s+FROM\s+stdin`)
+)
+
+func (d *dumpImporter) run() error {
+	for {
+		statement, err := d.scanner.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if match := createTablePattern.FindStringSubmatch(statement); match != nil {
+			if err := d.createTable(match[1], match[2]); err != nil {
+				return err
+			}
+			continue
+		}
+		if match := insertPattern.FindStringSubmatch(statement); match != nil {
+			if err := d.insert(match[1], match[2], match[3]); err != nil {
+				return err
+			}
+			continue
+		}
+		if match := copyPattern.FindStringSubmatch(statement); match != nil {
+			if err := d.copy(match[1], match[2]); err != nil {
+				return err
+			}
+			continue
+		}
+	}
+
+	return nil
+}
+
+// dumpIdentifier returns the name of a possibly quoted and schema qualified
+// identifier, turned into a plain identifier
+func dumpIdentifier(value string) string {
+	parts := splitTopLevel(value, '.')
+	name := strings.TrimSpace(parts[len(parts)-1])
+	if len(name) >= 2 && (name[0] == '"' || name[0] == '`') && name[len(name)-1] == name[0] {
+		quote := string(name[0])
+		name = strings.ReplaceAll(name[1:len(name)-1], quote+quote, quote)
+	}
+
+	return name
+}
+
+// plainIdentifier replaces the characters the queries of the API can't take
+// unquoted
+func plainIdentifier(name string) string {
+	if identifierPattern.MatchString(name) {
+		return name
+	}
+
+	plain := []rune{}
+	for _, r := range name {
+		if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			plain = append(plain, r)
+		} else {
+			plain = append(plain, '_')
+		}
+	}
+	if len(plain) == 0 || unicode.IsDigit(plain[0]) {
+		plain = append([]rune{'_'}, plain...)
+	}
+
+	return string(plain)
+}
+
+var dumpNumberTypes = map[string]bool{
+	"numeric": true, "decimal": true, "dec": true, "fixed": true, "real": true, "float": true,
+	"float4": true, "float8": true, "double": true, "money": true,
+}
+
+var dumpIntegerTypes = map[string]bool{
+	"int": true, "integer": true, "smallint": true, "bigint": true, "tinyint": true, "mediumint": true,
+	"int2": true, "int4": true, "int8": true, "serial": true, "smallserial": true, "bigserial": true,
+	"serial2": true, "serial4": true, "serial8": true, "year": true,
+}
+
+// dumpFieldType maps the type of a column to a field type, anything not a
+// number, a boolean or a date is text
+func dumpFieldType(sourceType string) string {
+	sourceType = strings.ToLower(strings.TrimSpace(sourceType))
+	base := sourceType
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+
+	switch {
+	case sourceType == "tinyint(1)" || sourceType == "bit(1)" || base == "bool" || base == "boolean":
+		return "boolean"
+	case strings.HasSuffix(sourceType, "[]"):
+		return "text"
+	case dumpIntegerTypes[base]:
+		return "integer"
+	case base == "decimal" || base == "numeric":
+		// the decimals too precise for SQLite are imported as numbers
+		field := fields{FieldType: strings.Replace(sourceType, base, "decimal", 1)}
+		if _, _, ok := decimalType(field.FieldType); ok && field.validateType() == nil {
+			return field.FieldType
+		}
+		return "number"
+	case dumpNumberTypes[base]:
+		return "number"
+	case base == "date" || base == "datetime" || base == "timestamp" || base == "timestamptz" ||
+		base == "time" || base == "timetz":
+		return "datetime"
+	}
+
+	return "text"
+}
+
+// the words ending the type of a column definition
+var columnConstraintWords = map[string]bool{
+	"not": true, "null": true, "default": true, "primary": true, "references": true, "unique": true,
+	"check": true, "collate": true, "constraint": true, "generated": true, "auto_increment": true,
+	"comment": true, "on": true, "character": true, "charset": true, "unsigned": true, "zerofill": true,
+	"identity": true, "key": true, "invisible": true, "visible": true,
+}
+
+// the definitions of a CREATE TABLE which aren't columns
+var tableConstraintWords = map[string]bool{
+	"constraint": true, "primary": true, "unique": true, "key": true, "index": true, "foreign": true,
+	"check": true, "fulltext": true, "spatial": true, "exclude": true, "like": true,
+}
+
+func (d *dumpImporter) createTable(rawName string, body string) error {
+	source := dumpIdentifier(rawName)
+	name := plainIdentifier(source)
+	if name != source {
+		d.warn("table %s imported as %s", source, name)
+	}
+	if _, ok := d.tables[name]; ok {
+		return fmt.Errorf("%w: table %s is created twice", ErrInvalidDump, name)
+	}
+
+	table := &dumpTable{name: name}
+	d.tables[name] = table
+	d.order = append(d.order, name)
+
+	exist, err := tableExists(d.tx, name)
+	if err != nil {
+		return err
+	}
+	if exist {
+		table.skipped = true
+		d.result.Skipped = append(d.result.Skipped, name)
+		return nil
+	}
+
+	definitions := []string{"id TEXT PRIMARY KEY DEFAULT (hex(randomblob(8)))"}
+	for _, item := range splitTopLevel(body, ',') {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		words := strings.Fields(item)
+		if tableConstraintWords[strings.ToLower(words[0])] {
+			continue
+		}
+
+		columnSource, rest := splitColumnName(item)
+		columnName := plainIdentifier(columnSource)
+		if columnName != columnSource {
+			d.warn("column %s of %s imported as %s", columnSource, name, columnName)
+		}
+
+		typeWords := []string{}
+		for _, word := range strings.Fields(rest) {
+			if columnConstraintWords[strings.ToLower(word)] {
+				break
+			}
+			typeWords = append(typeWords, word)
+		}
+		column := dumpColumn{name: columnName, fieldType: dumpFieldType(strings.Join(typeWords, " "))}
+
+		// the columns of every table take the source columns of their name
+		switch lower := strings.ToLower(columnName); lower {
+		case "id":
+			column = dumpColumn{name: lower, fieldType: "text"}
+		case "created_at", "updated_at":
+			column = dumpColumn{name: lower, fieldType: "datetime"}
+		default:
+			field := fields{FieldType: column.fieldType, FieldName: columnName, Nullable: true}
+			definitions = append(definitions, fmt.Sprintf("`%s` %s", columnName, field.convertTypeToSQLiteType()))
+		}
+		table.columns = append(table.columns, column)
+	}
+	definitions = append(definitions,
+		"created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+		"updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP",
+	)
+
+	if err := d.tx.Exec(fmt.Sprintf("CREATE TABLE `%s` (%s)", name, strings.Join(definitions, ", "))).Error; err != nil {
+		return fmt.Errorf("table %s: %w", name, err)
+	}
+	if err := createUpdatedTimestampTrigger(d.tx, name); err != nil {
+		return err
+	}
+
+	return d.tx.Create(&model.Tables{Name: name}).Error
+}
+
+// splitColumnName splits a column definition into its name and the rest
+func splitColumnName(item string) (string, string) {
+	if item[0] == '"' || item[0] == '`' {
+		for i := 1; i < len(item); i++ {
+			if item[i] != item[0] {
+				continue
+			}
+			if i+1 < len(item) && item[i+1] == item[0] {
+				i++
+				continue
+			}
+			return dumpIdentifier(item[:i+1]), item[i+1:]
+		}
+	}
+
+	name, rest, _ := strings.Cut(item, " ")
+	return name, rest
+}
+
+// target returns the table the rows of a statement go to, nil when they are
+// left out
+func (d *dumpImporter) target(rawName string) *dumpTable {
+	name := plainIdentifier(dumpIdentifier(rawName))
+	table, ok := d.tables[name]
+	if !ok {
+		if d.unknown == nil {
+			d.unknown = map[string]bool{}
+		}
+		if !d.unknown[name] {
+			d.unknown[name] = true
+			d.warn("rows of %s skipped, the dump doesn't create it", name)
+		}
+		return nil
+	}
+	if table.skipped {
+		return nil
+	}
+
+	return table
+}
+
+// columnsOf returns the columns listed by a statement, every column of the
+// table in order when there is no list
+func (d *dumpImporter) columnsOf(table *dumpTable, list string) ([]dumpColumn, error) {
+	if strings.TrimSpace(list) == "" {
+		return table.columns, nil
+	}
+
+	columns := []dumpColumn{}
+	for _, item := range splitTopLevel(list, ',') {
+		name := plainIdentifier(dumpIdentifier(strings.TrimSpace(item)))
+		switch lower := strings.ToLower(name); lower {
+		case "id", "created_at", "updated_at":
+			name = lower
+		}
+		column, ok := table.column(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: table %s has no column %s", ErrInvalidDump, table.name, name)
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+func (d *dumpImporter) insert(rawName string, list string, values string) error {
+	table := d.target(rawName)
+	if table == nil {
+		return nil
+	}
+	columns, err := d.columnsOf(table, list)
+	if err != nil {
+		return err
+	}
+
+	tuples, err := parseValueTuples(values, d.scanner.dialect)
+	if err != nil {
+		return fmt.Errorf("rows of %s: %w", table.name, err)
+	}
+
+	rows := []map[string]interface{}{}
+	for _, tuple := range tuples {
+		if len(tuple) != len(columns) {
+			return fmt.Errorf("%w: a row of %s has %d values for %d columns", ErrInvalidDump, table.name, len(tuple), len(columns))
+		}
+		rows = append(rows, dumpRow(columns, tuple))
+	}
+
+	return d.write(table, rows)
+}
+
+func (d *dumpImporter) copy(rawName string, list string) error {
+	table := d.target(rawName)
+	var columns []dumpColumn
+	if table != nil {
+		var err error
+		if columns, err = d.columnsOf(table, list); err != nil {
+			return err
+		}
+	}
+
+	rows := []map[string]interface{}{}
+	err := d.scanner.copyLines(func(line string) error {
+		if table == nil {
+			return nil
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != len(columns) {
+			return fmt.Errorf("%w: a row of %s has %d values for %d columns", ErrInvalidDump, table.name, len(fields), len(columns))
+		}
+		values := make([]interface{}, len(fields))
+		for i, field := range fields {
+			if field == `\N` {
+				continue
+			}
+			values[i] = unescapeCopy(field)
+		}
+
+		rows = append(rows, dumpRow(columns, values))
+		if len(rows) < dumpInsertBatch {
+			return nil
+		}
+		err := d.write(table, rows)
+		rows = rows[:0]
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if table == nil {
+		return nil
+	}
+	return d.write(table, rows)
+}
+
+func (d *dumpImporter) write(table *dumpTable, rows []map[string]interface{}) error {
+	for start := 0; start < len(rows); start += dumpInsertBatch {
+		batch := rows[start:min(start+dumpInsertBatch, len(rows))]
+		if err := d.tx.Table(table.name).Create(&batch).Error; err != nil {
+			return fmt.Errorf("rows of %s: %w", table.name, err)
+		}
+		table.rows += int64(len(batch))
+	}
+
+	return nil
+}
+
+// dumpRow converts the values of a row to the field types of their columns
+func dumpRow(columns []dumpColumn, values []interface{}) map[string]interface{} {
+	row := map[string]interface{}{}
+	for i, column := range columns {
+		value := values[i]
+		if value == nil {
+			row[column.name] = nil
+			// the rows are inserted in batches, so a missing timestamp is set
+			// rather than left to its default
+			if column.name == "created_at" || column.name == "updated_at" {
+				row[column.name] = gorm.Expr("CURRENT_TIMESTAMP")
+			}
+			continue
+		}
+
+		text := fmt.Sprint(value)
+		switch {
+		case column.name == "id":
+			row[column.name] = text
+		case column.fieldType == "boolean":
+			switch strings.ToLower(text) {
+			case "t", "true", "1", "y", "yes", "on", "\x01":
+				row[column.name] = true
+			default:
+				row[column.name] = false
+			}
+		case column.fieldType == "datetime":
+			row[column.name] = dumpTime(text)
+		case column.fieldType == "number":
+			if number, err := strconv.ParseFloat(text, 64); err == nil {
+				row[column.name] = number
+			} else {
+				row[column.name] = text
+			}
+		default:
+			row[column.name] = text
+		}
+	}
+
+	return row
+}
+
+// the layouts of the dates and times of the dumps, with the time zone
+// offsets written by Postgres
+var dumpTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02T15:04:05.999999999Z07:00",
+}
+
+// dumpTime writes the times having an offset in UTC, the driver doesn't read
+// them otherwise. The others are kept as they are
+func dumpTime(value string) string {
+	for _, layout := range dumpTimeLayouts {
+		if at, err := time.Parse(layout, value); err == nil {
+			return at.UTC().Format("2006-01-02 15:04:05.999999999")
+		}
+	}
+
+	return value
+}
+
+// unescapeCopy decodes the backslash escapes of a value of a COPY row
+func unescapeCopy(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+
+	return b.String()
+}
+
+// splitTopLevel splits s on sep outside of parentheses and quotes
+func splitTopLevel(s string, sep byte) []string {
+	parts := []string{}
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// parseValueTuples reads the (...), (...) list of an INSERT statement. The
+// values are literals, a cast following one is ignored
+func parseValueTuples(s string, dialect string) ([][]interface{}, error) {
+	p := &valueParser{s: s, dialect: dialect}
+	tuples := [][]interface{}{}
+
+	for {
+		p.skipSpaces()
+		if !p.consume('(') {
+			break
+		}
+
+		tuple := []interface{}{}
+		for {
+			p.skipSpaces()
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			tuple = append(tuple, value)
+			p.skipCast()
+
+			p.skipSpaces()
+			if p.consume(',') {
+				continue
+			}
+			if p.consume(')') {
+				break
+			}
+			return nil, fmt.Errorf("%w: unexpected %q in the values", ErrInvalidDump, p.rest())
+		}
+		tuples = append(tuples, tuple)
+
+		p.skipSpaces()
+		if !p.consume(',') {
+			break
+		}
+	}
+
+	if len(tuples) == 0 {
+		return nil, fmt.Errorf("%w: INSERT without values", ErrInvalidDump)
+	}
+
+	return tuples, nil
+}
+
+type valueParser struct {
+	s       string
+	i       int
+	dialect string
+}
+
+func (p *valueParser) rest() string {
+	rest := p.s[p.i:]
+	if len(rest) > 20 {
+		rest = rest[:20]
+	}
+
+	return rest
+}
+
+func (p *valueParser) skipSpaces() {
+	for p.i < len(p.s) && unicode.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+func (p *valueParser) consume(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+
+	return false
+}
+
+// skipCast skips what follows a value up to the next comma or closing
+// parenthesis, such as a ::timestamp cast
+func (p *valueParser) skipCast() {
+	depth := 0
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				return
+			}
+		case '\'':
+			p.i++
+			for p.i < len(p.s) && p.s[p.i] != '\'' {
+				p.i++
+			}
+		}
+		p.i++
+	}
+}
+
+func (p *valueParser) value() (interface{}, error) {
+	if p.i >= len(p.s) {
+		return nil, fmt.Errorf("%w: the values end too early", ErrInvalidDump)
+	}
+
+	c := p.s[p.i]
+	switch {
+	case c == '\'':
+		return p.quoted(p.dialect == DUMP_MYSQL)
+	case (c == 'E' || c == 'e') && p.i+1 < len(p.s) && p.s[p.i+1] == '\'':
+		p.i++
+		return p.quoted(true)
+	case (c == 'X' || c == 'x') && p.i+1 < len(p.s) && p.s[p.i+1] == '\'':
+		p.i++
+		return p.quoted(false)
+	case c == '_':
+		// a character set introducer such as _binary 'abc' or _utf8mb4'abc'
+		for p.i < len(p.s) && p.s[p.i] != '\'' && p.s[p.i] != ',' && p.s[p.i] != ')' {
+			p.i++
+		}
+		return p.value()
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		start := p.i
+		p.i++
+		for p.i < len(p.s) && strings.IndexByte("0123456789.eE+-xXabcdefABCDEF", p.s[p.i]) >= 0 {
+			p.i++
+		}
+		return p.s[start:p.i], nil
+	}
+
+	start := p.i
+	for p.i < len(p.s) && (unicode.IsLetter(rune(p.s[p.i])) || p.s[p.i] == '_') {
+		p.i++
+	}
+	switch word := strings.ToUpper(p.s[start:p.i]); word {
+	case "NULL":
+		return nil, nil
+	case "TRUE":
+		return "true", nil
+	case "FALSE":
+		return "false", nil
+	case "":
+		return nil, fmt.Errorf("%w: unexpected %q in the values", ErrInvalidDump, p.rest())
+	default:
+		return nil, fmt.Errorf("%w: unsupported value %s, only literals can be imported", ErrInvalidDump, word)
+	}
+}
+
+// quoted reads a quoted string, a doubled quote stands for a quote and a
+// backslash escapes the next character when escapes is set
+func (p *valueParser) quoted(escapes bool) (interface{}, error) {
+	p.i++
+	var b strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\' && escapes && p.i < len(p.s):
+			e := p.s[p.i]
+			p.i++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case 'Z':
+				b.WriteByte(26)
+			case 'b':
+				b.WriteByte('\b')
+			default:
+				b.WriteByte(e)
+			}
+		case c == '\'':
+			if p.i < len(p.s) && p.s[p.i] == '\'' {
+				b.WriteByte('\'')
+				p.i++
+				continue
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: unterminated string", ErrInvalidDump)
+}
+
+// dumpScanner splits a dump into its statements, skipping the comments
+type dumpScanner struct {
+	r       *bufio.Reader
+	dialect string
+}
+
+// next returns the next statement without its semicolon, io.EOF once the
+// dump ends
+func (s *dumpScanner) next() (string, error) {
+	var b strings.Builder
+	var previous rune
+
+	for {
+		r, _, err := s.r.ReadRune()
+		if errors.Is(err, io.EOF) {
+			if statement := strings.TrimSpace(b.String()); statement != "" {
+				return statement, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case r == ';':
+			if statement := strings.TrimSpace(b.String()); statement != "" {
+				return statement, nil
+			}
+			b.Reset()
+			previous = 0
+			continue
+		case r == '\'':
+			// a backslash escapes in the strings of MySQL and in the E'...'
+			// strings of Postgres
+			escapes := s.dialect == DUMP_MYSQL || ((previous == 'E' || previous == 'e') && !isIdentifierRune(lastButOne(b.String())))
+			b.WriteRune(r)
+			if err := s.quoted(&b, '\'', escapes); err != nil {
+				return "", err
+			}
+		case r == '"' || r == '`':
+			b.WriteRune(r)
+			if err := s.quoted(&b, r, false); err != nil {
+				return "", err
+			}
+		case r == '-' && s.peek() == '-', r == '#' && s.dialect == DUMP_MYSQL:
+			if _, err := s.r.ReadString('\n'); err != nil && !errors.Is(err, io.EOF) {
+				return "", err
+			}
+			b.WriteRune('\n')
+		case r == '/' && s.peek() == '*':
+			if err := s.blockComment(); err != nil {
+				return "", err
+			}
+			b.WriteRune(' ')
+		case r == '$' && s.dialect == DUMP_POSTGRES && !isIdentifierRune(previous):
+			b.WriteRune(r)
+			if err := s.dollarQuoted(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteRune(r)
+		}
+		previous = r
+	}
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// lastButOne returns the rune before the last one of s
+func lastButOne(s string) rune {
+	runes := []rune(s)
+	if len(runes) < 2 {
+		return 0
+	}
+
+	return runes[len(runes)-2]
+}
+
+func (s *dumpScanner) peek() rune {
+	r, _, err := s.r.ReadRune()
+	if err != nil {
+		return 0
+	}
+	s.r.UnreadRune()
+
+	return r
+}
+
+func (s *dumpScanner) quoted(b *strings.Builder, quote rune, escapes bool) error {
+	for {
+		r, _, err := s.r.ReadRune()
+		if err != nil {
+			return fmt.Errorf("%w: unterminated string", ErrInvalidDump)
+		}
+		b.WriteRune(r)
+
+		if r == '\\' && escapes {
+			next, _, err := s.r.ReadRune()
+			if err != nil {
+				return fmt.Errorf("%w: unterminated string", ErrInvalidDump)
+			}
+			b.WriteRune(next)
+			continue
+		}
+		if r == quote {
+			// a doubled quote stays in the string
+			if s.peek() != quote {
+				return nil
+			}
+			next, _, _ := s.r.ReadRune()
+			b.WriteRune(next)
+		}
+	}
+}
+
+func (s *dumpScanner) blockComment() error {
+	s.r.ReadRune()
+	var previous rune
+	for {
+		r, _, err := s.r.ReadRune()
+		if err != nil {
+			return fmt.Errorf("%w: unterminated comment", ErrInvalidDump)
+		}
+		if previous == '*' && r == '/' {
+			return nil
+		}
+		previous = r
+	}
+}
+
+// dollarQuoted reads a $tag$...$tag$ string of Postgres, such as the body of
+// a function. A $ not starting a tag, like a $1 parameter, is left as is
+func (s *dumpScanner) dollarQuoted(b *strings.Builder) error {
+	tag := "$"
+	for {
+		r, _, err := s.r.ReadRune()
+		if err != nil {
+			return nil
+		}
+		if r == '$' {
+			tag += "$"
+			break
+		}
+		if !isIdentifierRune(r) || (tag == "$" && unicode.IsDigit(r)) {
+			s.r.UnreadRune()
+			b.WriteString(tag[1:])
+			return nil
+		}
+		tag += string(r)
+	}
+	b.WriteString(tag[1:])
+
+	var body strings.Builder
+	for {
+		r, _, err := s.r.ReadRune()
+		if err != nil {
+			return fmt.Errorf("%w: unterminated %s string", ErrInvalidDump, tag)
+		}
+		body.WriteRune(r)
+		if r == '$' && strings.HasSuffix(body.String(), tag) {
+			b.WriteString(body.String())
+			return nil
+		}
+	}
+}
+
+// copyLines passes the data lines following a COPY ... FROM stdin statement
+// to fn, up to the \. line ending them
+func (s *dumpScanner) copyLines(fn func(line string) error) error {
+	// the rest of the line of the statement
+	if _, err := s.r.ReadString('\n'); err != nil {
+		return fmt.Errorf("%w: COPY without data", ErrInvalidDump)
+	}
+
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == `\.` {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: COPY data without its \\. ending", ErrInvalidDump)
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// etagMatches tells whether the If-None-Match header holds etag, compared
+// weakly as the tags only tell the content apart
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// respondWithETag answers with result along with its weak ETag, or with 304
+// Not Modified when the client already holds it
+func respondWithETag(c echo.Context, result interface{}) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	return respondBlobWithETag(c, body)
+}
+
+// respondBlobWithETag answers with the encoded body along with its weak
+// ETag. The tag covers the X-Total-Count already set, so polling clients
+// see the count change too
+func respondBlobWithETag(c echo.Context, body []byte) error {
+	hash := sha1.New()
+	hash.Write(body)
+	hash.Write([]byte(c.Response().Header().Get("X-Total-Count")))
+	etag := `W/"` + hex.EncodeToString(hash.Sum(nil)) + `"`
+
+	c.Response().Header().Set("ETag", etag)
+	if ifNoneMatch := c.Request().Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return c.JSONBlob(http.StatusOK, body)
+}
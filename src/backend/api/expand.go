@@ -0,0 +1,283 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// the ids of a related table are looked up in chunks, SQLite limits the
+// number of bound parameters of a query
+const expandChunkSize = 500
+
+const (
+	// the rows of a back-relation added to every row when it sets no limit
+	defaultBackRelationLimit = 20
+	maxBackRelationLimit     = 100
+)
+
+// backRelation is a table whose column references the rows read, its rows
+// pointing at every row are added to its expand field under Key
+type backRelation struct {
+	Key    string
+	Table  string
+	Column string
+	Limit  int
+}
+
+// expansion is what expand adds to the rows read
+type expansion struct {
+	relations map[string]string
+	back      []backRelation
+}
+
+// parseExpand reads the expand of a read of a table. Its items are relation
+// columns of the table, or back-relations written as table(column), such as
+// comments(post_id), with an optional limit as in comments(post_id):5
+func parseExpand(db *gorm.DB, c echo.Context, tableName string, expand []string) (expansion, error) {
+	columns := []string{}
+	back := []backRelation{}
+	for _, item := range expand {
+		item = strings.TrimSpace(item)
+		open := strings.Index(item, "(")
+		if open < 0 {
+			columns = append(columns, item)
+			continue
+		}
+
+		relation, err := parseBackRelation(db, c, tableName, item, open)
+		if err != nil {
+			return expansion{}, err
+		}
+		back = append(back, relation)
+	}
+
+	relations, err := relationColumns(db, tableName, columns)
+	if err != nil {
+		return expansion{}, err
+	}
+
+	return expansion{relations: relations, back: back}, nil
+}
+
+// parseBackRelation reads a back-relation of expand, its column must
+// reference tableName and be readable by the caller, like the columns of
+// filters
+func parseBackRelation(db *gorm.DB, c echo.Context, tableName string, item string, open int) (backRelation, error) {
+	relation := backRelation{Key: item, Table: item[:open], Limit: defaultBackRelationLimit}
+	spec, limit, hasLimit := strings.Cut(item[open+1:], ")")
+	relation.Column = strings.TrimSpace(spec)
+	if hasLimit && limit != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(limit, ":"))
+		if !strings.HasPrefix(limit, ":") || err != nil || n < 1 {
+			return relation, fmt.Errorf("the limit of %s must be a positive number", item)
+		}
+		relation.Limit = min(n, maxBackRelationLimit)
+	}
+	relation.Key = fmt.Sprintf("%s(%s)", relation.Table, relation.Column)
+
+	if _, err := getTableInfo(db, relation.Table); errors.Is(err, gorm.ErrRecordNotFound) {
+		return relation, fmt.Errorf("%s does not exist", relation.Table)
+	} else if err != nil {
+		return relation, err
+	}
+
+	columns, err := fetchColumns(db, relation.Table)
+	if err != nil {
+		return relation, err
+	}
+	for _, column := range columns {
+		if column.Name == relation.Column && column.Reference == tableName {
+			return relation, checkFilterAccess(db, c, relation.Table, []Filter{{Column: relation.Column}})
+		}
+	}
+
+	return relation, fmt.Errorf("%s is not a relation of %s to %s", relation.Column, relation.Table, tableName)
+}
+
+// apply adds the expansions to the expand field of the rows
+func (e expansion) apply(db *gorm.DB, c echo.Context, rows []map[string]interface{}) error {
+	if err := expandRelations(db, c, rows, e.relations); err != nil {
+		return err
+	}
+
+	return expandBackRelations(db, c, rows, e.back)
+}
+
+// relationColumns maps the columns to expand to the table they reference,
+// every column must be a relation of the table
+func relationColumns(db *gorm.DB, tableName string, expand []string) (map[string]string, error) {
+	relations := map[string]string{}
+	if len(expand) == 0 {
+		return relations, nil
+	}
+
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	references := map[string]string{}
+	for _, column := range columns {
+		if column.Reference != "" {
+			references[column.Name] = column.Reference
+		}
+	}
+
+	for _, name := range expand {
+		reference, ok := references[name]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a relation of %s", name, tableName)
+		}
+		relations[name] = reference
+	}
+
+	return relations, nil
+}
+
+// expandRelations adds the rows referenced by the relation columns to the
+// expand field of every row, keyed by column. The referenced rows are read
+// with one query per related table however many rows there are, and their
+// restricted columns are stripped like any other read
+func expandRelations(db *gorm.DB, c echo.Context, rows []map[string]interface{}, relations map[string]string) error {
+	if len(relations) == 0 || len(rows) == 0 {
+		return nil
+	}
+
+	// the ids referenced by the rows, grouped by related table
+	ids := map[string][]interface{}{}
+	seen := map[string]map[string]bool{}
+	for column, reference := range relations {
+		if seen[reference] == nil {
+			seen[reference] = map[string]bool{}
+		}
+		for _, row := range rows {
+			value, ok := row[column]
+			if !ok || value == nil || seen[reference][fmt.Sprint(value)] {
+				continue
+			}
+			seen[reference][fmt.Sprint(value)] = true
+			ids[reference] = append(ids[reference], value)
+		}
+	}
+
+	related := map[string]map[string]map[string]interface{}{}
+	for reference, values := range ids {
+		table, err := getTableInfo(db, reference)
+		if err != nil {
+			return err
+		}
+
+		var referenced []map[string]interface{}
+		for start := 0; start < len(values); start += expandChunkSize {
+			end := min(start+expandChunkSize, len(values))
+
+			var chunk []map[string]interface{}
+			// not prepared, every number of ids would be a statement of its own
+			err := db.Table(reference).
+				Where("id IN ?", values[start:end]).
+				Find(&chunk).Error
+			if err != nil {
+				return err
+			}
+			referenced = append(referenced, chunk...)
+		}
+
+		if err := stripRestrictedColumns(db, c, table, referenced); err != nil {
+			return err
+		}
+
+		related[reference] = map[string]map[string]interface{}{}
+		for _, row := range referenced {
+			related[reference][fmt.Sprint(row["id"])] = row
+		}
+	}
+
+	for _, row := range rows {
+		expanded := map[string]interface{}{}
+		for column, reference := range relations {
+			value, ok := row[column]
+			if !ok || value == nil {
+				continue
+			}
+			if referenced, found := related[reference][fmt.Sprint(value)]; found {
+				expanded[column] = referenced
+			}
+		}
+		row["expand"] = expanded
+	}
+
+	return nil
+}
+
+// expandBackRelations adds the rows of other tables pointing at the rows to
+// their expand field, up to the limit of every back-relation for every row.
+// The rows pointing at a chunk of rows are read with one query, and their
+// restricted columns are stripped like any other read
+func expandBackRelations(db *gorm.DB, c echo.Context, rows []map[string]interface{}, relations []backRelation) error {
+	if len(relations) == 0 || len(rows) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		if id, ok := row["id"]; ok && id != nil {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, relation := range relations {
+		table, err := getTableInfo(db, relation.Table)
+		if err != nil {
+			return err
+		}
+
+		pointing := map[string][]map[string]interface{}{}
+		for start := 0; start < len(ids); start += expandChunkSize {
+			end := min(start+expandChunkSize, len(ids))
+
+			var chunk []map[string]interface{}
+			err := db.Raw(fmt.Sprintf(`
+			SELECT * FROM (
+				SELECT *, "%[2]s" AS "@parent", ROW_NUMBER() OVER (PARTITION BY "%[2]s" ORDER BY id) AS "@rank"
+				FROM "%[1]s" WHERE "%[2]s" IN ?
+			) WHERE "@rank" <= ?`, relation.Table, relation.Column), ids[start:end], relation.Limit).
+				Scan(&chunk).Error
+			if err != nil {
+				return err
+			}
+			for _, row := range chunk {
+				delete(row, "@rank")
+			}
+			if err := stripRestrictedColumns(db, c, table, chunk); err != nil {
+				return err
+			}
+
+			for _, row := range chunk {
+				// read before the strip, the column may be restricted
+				parent := fmt.Sprint(row["@parent"])
+				delete(row, "@parent")
+				pointing[parent] = append(pointing[parent], row)
+			}
+		}
+
+		for _, row := range rows {
+			expanded, ok := row["expand"].(map[string]interface{})
+			if !ok {
+				expanded = map[string]interface{}{}
+				row["expand"] = expanded
+			}
+			children := pointing[fmt.Sprint(row["id"])]
+			if children == nil {
+				children = []map[string]interface{}{}
+			}
+			expanded[relation.Key] = children
+		}
+	}
+
+	return nil
+}
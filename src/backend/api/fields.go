@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"react-golang/src/backend/model"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// bulkyTypes are the column types left out of the reads of a row unless they
+// are requested, their values are large or only useful to some clients
+var bulkyTypes = map[string]bool{"BLOB": true, "FILE": true}
+
+// rowFields returns the columns selected by a read of a row of the table:
+// fields, or every column but the BLOB and file ones when fields is empty.
+// The columns the access rules and expand rely on are selected along, hidden
+// holds those to remove from the row once read
+func rowFields(db *gorm.DB, table model.Tables, fields []string, expand expansion) ([]string, []string, error) {
+	columns, err := fetchColumns(db, table.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	known := map[string]bool{}
+	for _, column := range columns {
+		if !(table.IsAuth && authSecretColumns[column.Name]) {
+			known[column.Name] = true
+		}
+	}
+
+	selected := []string{}
+	requested := map[string]bool{}
+	if len(fields) == 0 {
+		for _, column := range columns {
+			if known[column.Name] && !bulkyTypes[strings.ToUpper(column.Type)] {
+				selected = append(selected, column.Name)
+				requested[column.Name] = true
+			}
+		}
+	}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || requested[field] {
+			continue
+		}
+		if !known[field] {
+			return nil, nil, fmt.Errorf("%s is not a column of %s", field, table.Name)
+		}
+		selected = append(selected, field)
+		requested[field] = true
+	}
+
+	needed := []string{"id", ownerColumn(table)}
+	for column := range expand.relations {
+		needed = append(needed, column)
+	}
+	hidden := []string{}
+	for _, column := range needed {
+		if column != "" && known[column] && !requested[column] {
+			selected = append(selected, column)
+			requested[column] = true
+			hidden = append(hidden, column)
+		}
+	}
+
+	return selected, hidden, nil
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"react-golang/src/backend/model"
+	"slices"
+	"strconv"
+	"time"
+
+	auth_libraries "react-golang/src/backend/library/auth"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSignedURLTTL = time.Hour
+	maxSignedURLTTL     = 7 * 24 * time.Hour
+)
+
+var (
+	errFileForbidden     = errors.New("not allowed to access this file")
+	errSignatureRequired = errors.New("file is protected, request a signed url")
+	errInvalidSignature  = errors.New("signed url is invalid or expired")
+)
+
+// fileProtected tells whether a file is only served through signed URLs,
+// which is when the file column holding it or its whole table is protected
+func fileProtected(db *gorm.DB, file model.File) (bool, error) {
+	if file.Table == "" {
+		return false, nil
+	}
+
+	table, err := getTableInfo(db, file.Table)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the table was deleted, its files stay protected from then on
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if table.ProtectFiles {
+		return true, nil
+	}
+
+	metas, err := fetchColumnMeta(db, file.Table)
+	if err != nil {
+		return false, err
+	}
+
+	return metas[file.Column].Protected, nil
+}
+
+// canAccessFile tells whether the caller may read a file through the rules
+// of the row holding it. Files which aren't held by any row are only
+// readable by their uploader
+func canAccessFile(db *gorm.DB, c echo.Context, file model.File) (bool, error) {
+	if isAdmin(c) {
+		return true, nil
+	}
+	if file.Table == "" {
+		return file.UploadedBy != "" && file.UploadedBy == currentUserID(c), nil
+	}
+
+	table, err := getTableInfo(db, file.Table)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var rows []map[string]interface{}
+	err = db.Table(file.Table).
+		Where("id = ?", file.RowID).
+		Limit(1).
+		Find(&rows).Error
+	if err != nil {
+		return false, err
+	}
+	// the row no longer holds the file once it is replaced or deleted
+	if len(rows) == 0 || !slices.Contains(fileKeys(rows[0][file.Column]), file.Key) {
+		return false, nil
+	}
+
+	metas, err := fetchColumnMeta(db, file.Table)
+	if err != nil {
+		return false, err
+	}
+
+	return canAccessColumn(c, table, metas[file.Column], rows[0]), nil
+}
+
+// fileURLKey derives the key signing the file URLs from the secret of a
+// signing key, the secret itself only signs tokens
+func fileURLKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("file-url"))
+
+	return mac.Sum(nil)
+}
+
+func fileSignature(secret []byte, key string, expires int64) string {
+	mac := hmac.New(sha256.New, fileURLKey(secret))
+	mac.Write([]byte(key + ":" + strconv.FormatInt(expires, 10)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signFileURL returns the query of a URL serving the file until expiresAt,
+// signed with the active signing key so retiring the key revokes it
+func signFileURL(key string, expiresAt time.Time) (url.Values, error) {
+	signingKey, ok := auth_libraries.ActiveSigningKey()
+	if !ok {
+		return nil, errors.New("no signing key available")
+	}
+
+	expires := expiresAt.Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("kid", signingKey.ID)
+	query.Set("signature", fileSignature(signingKey.Secret, key, expires))
+
+	return query, nil
+}
+
+func verifyFileURL(c echo.Context, key string) error {
+	signature := c.QueryParam("signature")
+	if signature == "" {
+		return errSignatureRequired
+	}
+
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return errInvalidSignature
+	}
+
+	secret, ok := auth_libraries.VerificationKey(c.QueryParam("kid"))
+	if !ok {
+		return errInvalidSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(fileSignature(secret, key, expires))) {
+		return errInvalidSignature
+	}
+
+	return nil
+}
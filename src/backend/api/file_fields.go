@@ -0,0 +1,588 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"react-golang/src/backend/model"
+	"react-golang/src/backend/service"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// fileColumns returns the names of the file columns of a table
+func fileColumns(db *gorm.DB, tableName string) ([]string, error) {
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, column := range columns {
+		if strings.EqualFold(column.Type, "FILE") {
+			names = append(names, column.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// fileKeys returns the keys held by the value of a file column, a single key
+// or a JSON array of keys for columns holding several files. SQLite has no
+// FILE type so the values are scanned without a known type
+func fileKeys(value interface{}) []string {
+	switch v := value.(type) {
+	case *interface{}:
+		if v == nil {
+			return nil
+		}
+		return fileKeys(*v)
+	case []byte:
+		return fileKeys(string(v))
+	case string:
+		if strings.HasPrefix(v, "[") {
+			var keys []string
+			json.Unmarshal([]byte(v), &keys)
+			return keys
+		}
+		if v != "" {
+			return []string{v}
+		}
+	case []string:
+		return v
+	case []interface{}:
+		var keys []string
+		for _, item := range v {
+			keys = append(keys, fileKeys(item)...)
+		}
+		return keys
+	}
+
+	return nil
+}
+
+func encodeFileKeys(keys []string) string {
+	if keys == nil {
+		keys = []string{}
+	}
+	encoded, _ := json.Marshal(keys)
+
+	return string(encoded)
+}
+
+// prepareFileColumns brings the values of the file columns to their stored
+// form. Columns holding several files are stored as a JSON array, to which
+// "column+" appends keys and from which "column-" removes keys, starting
+// from the files of the row being updated unless the column itself is set
+func prepareFileColumns(db *gorm.DB, tableName string, rowID string, data map[string]interface{}) error {
+	columns, err := fileColumns(db, tableName)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	metas, err := fetchColumnMeta(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		maxFiles := metas[column].MaxFiles
+		appended, hasAppend := data[column+"+"]
+		removed, hasRemove := data[column+"-"]
+		delete(data, column+"+")
+		delete(data, column+"-")
+		value, hasValue := data[column]
+
+		if maxFiles <= 1 {
+			if hasAppend || hasRemove {
+				return &fileRejectedError{Column: column, Reason: "only columns holding several files can append or remove files"}
+			}
+			if keys := fileKeys(value); len(keys) > 1 {
+				return &fileRejectedError{Column: column, Reason: "column holds a single file"}
+			} else if len(keys) == 1 {
+				data[column] = keys[0]
+			}
+			continue
+		}
+
+		if !hasValue && !hasAppend && !hasRemove {
+			continue
+		}
+
+		var keys []string
+		if hasValue {
+			keys = fileKeys(value)
+		} else if rowID != "" {
+			var current []map[string]interface{}
+			err := db.Table(tableName).
+				Select(column).
+				Where("id = ?", rowID).
+				Limit(1).
+				Find(&current).Error
+			if err != nil {
+				return err
+			}
+			if len(current) > 0 {
+				keys = fileKeys(current[0][column])
+			}
+		}
+
+		drop := map[string]bool{}
+		for _, key := range fileKeys(removed) {
+			drop[key] = true
+		}
+		var result []string
+		for _, key := range append(keys, fileKeys(appended)...) {
+			if !drop[key] {
+				drop[key] = true
+				result = append(result, key)
+			}
+		}
+		if len(result) > maxFiles {
+			return &fileRejectedError{Column: column, Reason: fmt.Sprintf("column holds at most %d files", maxFiles)}
+		}
+
+		data[column] = encodeFileKeys(result)
+	}
+
+	return nil
+}
+
+// isMultipart tells whether the row is sent as a multipart form, which lets
+// the files be sent along with the row instead of being uploaded first
+func isMultipart(c echo.Context) bool {
+	return strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm)
+}
+
+// multipartRowData reads the values of a row from a multipart form. The
+// files are stored and their fields set to their keys, several files sent
+// under the same field are set as a list. The keys of the stored files are
+// returned so they can be deleted when the row isn't written after all
+func multipartRowData(c echo.Context, storage service.StorageService, form *multipart.Form) (map[string]interface{}, []string, error) {
+	data := map[string]interface{}{}
+	for field, values := range form.Value {
+		if len(values) == 1 {
+			data[field] = values[0]
+			continue
+		}
+		list := make([]interface{}, len(values))
+		for i, value := range values {
+			list[i] = value
+		}
+		data[field] = list
+	}
+
+	var stored []string
+	for field, headers := range form.File {
+		keys := make([]interface{}, 0, len(headers))
+		for _, header := range headers {
+			src, err := header.Open()
+			if err != nil {
+				return data, stored, err
+			}
+			file, err := storage.Save(c.Request().Context(), header.Filename, currentUserID(c), src)
+			src.Close()
+			if err != nil {
+				return data, stored, err
+			}
+			stored = append(stored, file.Key)
+			keys = append(keys, file.Key)
+		}
+
+		if len(keys) == 1 {
+			data[field] = keys[0]
+		} else {
+			data[field] = keys
+		}
+	}
+
+	return data, stored, nil
+}
+
+// attachFiles records which row holds the files written to its file
+// columns, so the files can be looked up by the row they belong to. The row
+// is already written, a file that can't be attached is only logged
+func attachFiles(ctx context.Context, db *gorm.DB, storage service.StorageService, tableName string, rowID string, data map[string]interface{}) {
+	columns, err := fileColumns(db, tableName)
+	if err != nil {
+		log.Printf("failed to attach files of %s: %v", tableName, err)
+		return
+	}
+
+	for _, column := range columns {
+		for _, key := range fileKeys(data[column]) {
+			if err := storage.Attach(ctx, key, tableName, rowID, column); err != nil {
+				log.Printf("failed to attach file %s to %s: %v", key, tableName, err)
+			}
+		}
+	}
+}
+
+// copyTableFiles gives the rows of a duplicated table copies of the files
+// held by the same rows of the source, attached to the copied rows. The rows
+// are already written, a file that can't be copied is only logged and left
+// out of its row
+func copyTableFiles(ctx context.Context, db *gorm.DB, storage service.StorageService, source string, target string) {
+	columns, err := fileColumns(db, source)
+	if err != nil || len(columns) == 0 {
+		if err != nil {
+			log.Printf("failed to copy the files of %s: %v", source, err)
+		}
+		return
+	}
+	metas, err := fetchColumnMeta(db, source)
+	if err != nil {
+		log.Printf("failed to copy the files of %s: %v", source, err)
+		return
+	}
+
+	var rows []map[string]interface{}
+	if err := db.Table(source).Select(append([]string{"id"}, columns...)).Find(&rows).Error; err != nil {
+		log.Printf("failed to copy the files of %s: %v", source, err)
+		return
+	}
+
+	for _, row := range rows {
+		copies := map[string]interface{}{}
+		for _, column := range columns {
+			keys := fileKeys(row[column])
+			if len(keys) == 0 {
+				continue
+			}
+
+			copied := []string{}
+			for _, key := range keys {
+				file, err := copyFile(ctx, storage, key)
+				if err != nil {
+					log.Printf("failed to copy file %s to %s: %v", key, target, err)
+					continue
+				}
+				copied = append(copied, file.Key)
+			}
+			if metas[column].MaxFiles > 1 {
+				copies[column] = encodeFileKeys(copied)
+			} else if len(copied) == 1 {
+				copies[column] = copied[0]
+			}
+		}
+		if len(copies) == 0 {
+			continue
+		}
+
+		if err := db.Table(target).Where("id = ?", row["id"]).Updates(copies).Error; err != nil {
+			log.Printf("failed to copy the files of %s to %s: %v", source, target, err)
+			continue
+		}
+		attachFiles(ctx, db, storage, target, fmt.Sprint(row["id"]), copies)
+	}
+}
+
+// copyFile stores a copy of a file, held by no row and uploaded by the same
+// user
+func copyFile(ctx context.Context, storage service.StorageService, key string) (model.File, error) {
+	content, file, err := storage.Open(ctx, key)
+	if err != nil {
+		return model.File{}, err
+	}
+	defer content.Close()
+
+	return storage.Save(ctx, file.Name, file.UploadedBy, content)
+}
+
+// rowFileKeys returns the keys held by the file columns of the rows with the
+// given ids. It is read before the rows are deleted so their files can be
+// deleted along with them
+func rowFileKeys(db *gorm.DB, tableName string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	return fileKeysWhere(db, tableName, func(query *gorm.DB) *gorm.DB {
+		return query.Where("id IN ?", ids)
+	})
+}
+
+// tableFileKeys returns the keys held by the file columns of every row of
+// the table, read before the table is dropped
+func tableFileKeys(db *gorm.DB, tableName string) ([]string, error) {
+	return fileKeysWhere(db, tableName, func(query *gorm.DB) *gorm.DB {
+		return query
+	})
+}
+
+// fileKeysWhere returns the keys held by the file columns of the rows the
+// scope selects. A key whose file is recorded as held by another row or
+// table is left out, its file isn't the rows' to delete
+func fileKeysWhere(db *gorm.DB, tableName string, scope func(*gorm.DB) *gorm.DB) ([]string, error) {
+	columns, err := fileColumns(db, tableName)
+	if err != nil || len(columns) == 0 {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+	if err := db.Table(tableName).Select(append([]string{"id"}, columns...)).Scopes(scope).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	rowOf := map[string]string{}
+	for _, row := range rows {
+		for _, column := range columns {
+			for _, key := range fileKeys(row[column]) {
+				rowOf[key] = fmt.Sprint(row["id"])
+			}
+		}
+	}
+	if len(rowOf) == 0 {
+		return nil, nil
+	}
+
+	candidates := make([]string, 0, len(rowOf))
+	for key := range rowOf {
+		candidates = append(candidates, key)
+	}
+	var files []model.File
+	if err := db.Where("key IN ?", candidates).Where("`table` = ?", tableName).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, file := range files {
+		if file.RowID == rowOf[file.Key] {
+			keys = append(keys, file.Key)
+		}
+	}
+
+	return keys, nil
+}
+
+// fileChange is how an update changed the files held by a file column
+type fileChange struct {
+	Old []string `json:"old"`
+	New []string `json:"new"`
+}
+
+// rowFiles returns the keys held by the file columns of a row which are
+// about to be written, read before an update so the files it replaces can
+// be deleted once it is written
+func rowFiles(db *gorm.DB, tableName string, rowID string, data map[string]interface{}) (map[string][]string, error) {
+	columns, err := fileColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for _, column := range columns {
+		if _, ok := data[column]; ok {
+			written = append(written, column)
+		}
+	}
+	if len(written) == 0 {
+		return nil, nil
+	}
+
+	var rows []map[string]interface{}
+	err = db.Table(tableName).
+		Select(written).
+		Where("id = ?", rowID).
+		Limit(1).
+		Find(&rows).Error
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+
+	files := map[string][]string{}
+	for _, column := range written {
+		files[column] = fileKeys(rows[0][column])
+	}
+
+	return files, nil
+}
+
+// replaceFiles deletes the files an update replaced or removed from a row,
+// unless the row still holds them in another column or they were moved to
+// another row meanwhile, and returns the files of each column before and
+// after the update
+func replaceFiles(ctx context.Context, storage service.StorageService, tableName string, rowID string, previous map[string][]string, data map[string]interface{}) map[string]fileChange {
+	held := map[string]bool{}
+	changes := map[string]fileChange{}
+	for column, old := range previous {
+		keys := fileKeys(data[column])
+		for _, key := range keys {
+			held[key] = true
+		}
+		if !slices.Equal(old, keys) {
+			changes[column] = fileChange{Old: append([]string{}, old...), New: append([]string{}, keys...)}
+		}
+	}
+
+	var replaced []string
+	for _, change := range changes {
+		for _, key := range change.Old {
+			if held[key] {
+				continue
+			}
+			file, err := storage.Fetch(ctx, key)
+			if err != nil || file.Table != tableName || file.RowID != rowID {
+				continue
+			}
+			replaced = append(replaced, key)
+		}
+	}
+	deleteFiles(storage, replaced)
+
+	return changes
+}
+
+// deleteFiles removes the stored files of deleted rows or of files replaced
+// in a row. The rows are already written, a file that can't be deleted is
+// only logged
+func deleteFiles(storage service.StorageService, keys []string) {
+	for _, key := range keys {
+		err := storage.Delete(context.Background(), key)
+		if err != nil && !errors.Is(err, service.ErrFileNotFound) {
+			log.Printf("failed to delete file %s: %v", key, err)
+		}
+	}
+}
+
+// fileRejectedError is returned when a file written to a file column doesn't
+// satisfy the restrictions of the column
+type fileRejectedError struct {
+	Column string
+	Reason string
+}
+
+func (e *fileRejectedError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Column, e.Reason)
+}
+
+func validMimePattern(pattern string) bool {
+	mediaType, _, err := mime.ParseMediaType(pattern)
+	return err == nil && strings.Count(mediaType, "/") == 1
+}
+
+// mimeTypeAllowed tells whether mimeType matches one of the comma separated
+// patterns, a pattern such as image/* matching every image
+func mimeTypeAllowed(mimeType string, patterns string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkFile checks a file against the restrictions of its column
+func checkFile(file model.File, column string, meta model.ColumnMeta) error {
+	if file.ScanStatus == model.FILE_SCAN_INFECTED {
+		return &fileRejectedError{Column: column, Reason: service.ErrFileQuarantined.Error()}
+	}
+	if meta.MaxFileSize > 0 && file.Size > meta.MaxFileSize {
+		return &fileRejectedError{Column: column, Reason: fmt.Sprintf("file is larger than %d bytes", meta.MaxFileSize)}
+	}
+	if meta.AllowedMimeTypes != "" && !mimeTypeAllowed(file.MimeType, meta.AllowedMimeTypes) {
+		return &fileRejectedError{Column: column, Reason: fmt.Sprintf("file type %s is not allowed", file.MimeType)}
+	}
+
+	return nil
+}
+
+// fileUsable tells whether a file can be written to the column of the row,
+// empty for a new row. A file is held by a single row, the files held by
+// none are only usable by their uploader
+func fileUsable(file model.File, tableName string, rowID string, column string, userID string) bool {
+	if file.Table == "" {
+		return file.UploadedBy == userID
+	}
+
+	return rowID != "" && file.Table == tableName && file.RowID == rowID && file.Column == column
+}
+
+// validateFiles checks the files written to the file columns of a table
+// against the MIME types and size allowed by each column, and the files new
+// to the table against its storage quota. The files must be usable by the
+// user for the row, see fileUsable. It runs before the row is written, a
+// file that is rejected never ends up in a row
+func validateFiles(ctx context.Context, db *gorm.DB, storage service.StorageService, tableName string, rowID string, userID string, data map[string]interface{}) error {
+	columns, err := fileColumns(db, tableName)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+
+	metas, err := fetchColumnMeta(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	var added int64
+	for _, column := range columns {
+		for _, key := range fileKeys(data[column]) {
+			file, err := storage.Fetch(ctx, key)
+			if errors.Is(err, service.ErrFileNotFound) {
+				return &fileRejectedError{Column: column, Reason: fmt.Sprintf("file %s does not exist", key)}
+			}
+			if err != nil {
+				return err
+			}
+			if !fileUsable(file, tableName, rowID, column, userID) {
+				return &fileRejectedError{Column: column, Reason: fmt.Sprintf("file %s is held by another row", key)}
+			}
+			if err := checkFile(file, column, metas[column]); err != nil {
+				return err
+			}
+
+			if file.Table != tableName {
+				added += file.Size
+			}
+		}
+	}
+	if added == 0 {
+		return nil
+	}
+
+	table, err := getTableInfo(db, tableName)
+	if err != nil || table.StorageQuotaMB <= 0 {
+		return err
+	}
+	used, err := storage.TableSize(ctx, tableName)
+	if err != nil {
+		return err
+	}
+	if used+added > int64(table.StorageQuotaMB)<<20 {
+		return fmt.Errorf("%w for table %s (%d MB)", service.ErrQuotaExceeded, tableName, table.StorageQuotaMB)
+	}
+
+	return nil
+}
+
+// fileErrorStatus returns 422 for rejected files, 413 when they don't fit in
+// the quota and 500 for anything else
+func fileErrorStatus(err error) int {
+	var rejected *fileRejectedError
+	if errors.As(err, &rejected) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, service.ErrQuotaExceeded) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusInternalServerError
+}
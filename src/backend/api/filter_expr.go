@@ -0,0 +1,642 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"react-golang/src/backend/model"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// filterExpr is a parsed filter expression in the PocketBase syntax, such as
+//
+//	status = "active" && (title ~ "go" || views >= 10)
+//
+// A comparison is a column on the left and a string, number, true, false,
+// null or variable on the right. The operators are =, !=, >, >=, <, <=, ~
+// (contains, or LIKE when the value holds a %) and !~. Prefixed with ?, an
+// operator matches when any value of a JSON array column does, a column
+// holding a single value is compared as is. Variables such as
+// @request.auth.id or $user.email are resolved by the server and bound as
+// values, see requestVariables, as are the date macros such as @todayStart
+// or @daysAgo(7), see dateMacro. They can also be on the left of a
+// comparison other than ~ and !~, as in @request.auth.id = owner_id
+//
+// = and != follow the collation of the column: the case of the values counts
+// unless the column was made nocase, when the case of ASCII letters doesn't.
+// ~ and !~ ignore the case of ASCII letters on every column, as LIKE does
+type filterExpr interface {
+	// sql returns the condition with its values as parameters
+	sql() (string, []interface{})
+	match(row map[string]interface{}) bool
+	// conditions lists the comparisons, for the access checks
+	conditions() []Filter
+	String() string
+}
+
+type filterGroup struct {
+	// or joins the nodes with ||, with && otherwise
+	or    bool
+	nodes []filterExpr
+}
+
+type filterCondition struct {
+	column   string
+	operator string
+	any      bool
+	// value is nil for null
+	value interface{}
+	// text is the value as compared by matchFilter
+	text string
+	// nocase is set when the column has the nocase collation
+	nocase bool
+}
+
+var filterExprOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	">":  ">",
+	">=": ">=",
+	"<":  "<",
+	"<=": "<=",
+	"~":  "LIKE",
+	"!~": "NOT LIKE",
+}
+
+// parseFilterExpr parses an expression whose columns must be among the
+// given ones, their names are matched regardless of case. vars resolves the
+// variables
+func parseFilterExpr(input string, columns []string, vars filterVariables) (filterExpr, error) {
+	tokens, err := tokenizeFilterExpr(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &filterParser{tokens: tokens, columns: columns, vars: vars}
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+
+	return expr, nil
+}
+
+// rowFilterExpr parses the expression of a request on the table, the secret
+// columns of the auth tables can't be filtered on
+func rowFilterExpr(db *gorm.DB, c echo.Context, table model.Tables, input string) (filterExpr, error) {
+	if strings.TrimSpace(input) == "" {
+		return nil, nil
+	}
+
+	columns, err := fetchColumns(db, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	nocase := map[string]bool{}
+	for _, column := range columns {
+		if table.IsAuth && authSecretColumns[column.Name] {
+			continue
+		}
+		names = append(names, column.Name)
+		nocase[column.Name] = column.Collation == COLLATION_NOCASE
+	}
+
+	expr, err := parseFilterExpr(input, names, requestVariables(db, c))
+	if err != nil {
+		return nil, err
+	}
+	setCollations(expr, nocase)
+
+	return expr, nil
+}
+
+// setCollations tells the comparisons of an expression which columns are
+// nocase, for matching the rows as SQLite does
+func setCollations(expr filterExpr, nocase map[string]bool) {
+	switch e := expr.(type) {
+	case *filterGroup:
+		for _, node := range e.nodes {
+			setCollations(node, nocase)
+		}
+	case *filterCondition:
+		e.nocase = nocase[e.column]
+	}
+}
+
+// foldASCII lowers the ASCII letters of a text, as the nocase collation
+// compares it
+func foldASCII(text string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, text)
+}
+
+func applyFilterExpr(query *gorm.DB, expr filterExpr) *gorm.DB {
+	if expr == nil {
+		return query
+	}
+	condition, values := expr.sql()
+
+	return query.Where("("+condition+")", values...)
+}
+
+const (
+	tokenIdent = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenOpen
+	tokenClose
+	tokenVariable
+)
+
+type filterToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// filterExprSymbols is ordered so the longest symbols are matched first
+var filterExprSymbols = []string{
+	"?!~", "?!=", "?>=", "?<=",
+	"&&", "||", "!=", "!~", ">=", "<=", "?=", "?>", "?<", "?~",
+	"=", ">", "<", "~", "(", ")",
+}
+
+func tokenizeFilterExpr(input string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(input); {
+		ch := input[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '"' || ch == '\'':
+			var b strings.Builder
+			start := i
+			i++
+			for ; i < len(input) && input[i] != ch; i++ {
+				if input[i] == '\\' && i+1 < len(input) {
+					i++
+				}
+				b.WriteByte(input[i])
+			}
+			if i >= len(input) {
+				return nil, fmt.Errorf("invalid filter at %d: unterminated string", start)
+			}
+			i++
+			tokens = append(tokens, filterToken{kind: tokenString, text: b.String(), pos: start})
+		case ch == '-' || ch >= '0' && ch <= '9':
+			start := i
+			i++
+			for i < len(input) && (input[i] >= '0' && input[i] <= '9' || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenNumber, text: input[start:i], pos: start})
+		case ch == '@' || ch == '$':
+			start := i
+			i++
+			for i < len(input) && (input[i] == '_' || input[i] == '.' || input[i] >= 'a' && input[i] <= 'z' ||
+				input[i] >= 'A' && input[i] <= 'Z' || input[i] >= '0' && input[i] <= '9') {
+				i++
+			}
+			// the macros taking an argument, such as @daysAgo(7)
+			if i < len(input) && input[i] == '(' {
+				end := strings.IndexByte(input[i:], ')')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid filter at %d: missing )", start)
+				}
+				i += end + 1
+			}
+			tokens = append(tokens, filterToken{kind: tokenVariable, text: strings.ReplaceAll(input[start:i], " ", ""), pos: start})
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			start := i
+			for i < len(input) && (input[i] == '_' || input[i] >= 'a' && input[i] <= 'z' ||
+				input[i] >= 'A' && input[i] <= 'Z' || input[i] >= '0' && input[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenIdent, text: input[start:i], pos: start})
+		default:
+			symbol := ""
+			for _, s := range filterExprSymbols {
+				if strings.HasPrefix(input[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("invalid filter at %d: unexpected %c", i, ch)
+			}
+
+			kind := tokenOperator
+			switch symbol {
+			case "&&":
+				kind = tokenAnd
+			case "||":
+				kind = tokenOr
+			case "(":
+				kind = tokenOpen
+			case ")":
+				kind = tokenClose
+			}
+			tokens = append(tokens, filterToken{kind: kind, text: symbol, pos: i})
+			i += len(symbol)
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens  []filterToken
+	pos     int
+	columns []string
+	vars    filterVariables
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	at := -1
+	if p.pos < len(p.tokens) {
+		at = p.tokens[p.pos].pos
+	}
+	if at < 0 {
+		return fmt.Errorf("invalid filter: %s", fmt.Sprintf(format, args...))
+	}
+
+	return fmt.Errorf("invalid filter at %d: %s", at, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) next(kind int) (filterToken, bool) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind {
+		p.pos++
+		return p.tokens[p.pos-1], true
+	}
+
+	return filterToken{}, false
+}
+
+func (p *filterParser) or() (filterExpr, error) {
+	return p.group(tokenOr, p.and)
+}
+
+func (p *filterParser) and() (filterExpr, error) {
+	return p.group(tokenAnd, p.primary)
+}
+
+func (p *filterParser) group(separator int, operand func() (filterExpr, error)) (filterExpr, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []filterExpr{first}
+	for {
+		if _, ok := p.next(separator); !ok {
+			break
+		}
+		node, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 1 {
+		return first, nil
+	}
+
+	return &filterGroup{or: separator == tokenOr, nodes: nodes}, nil
+}
+
+func (p *filterParser) primary() (filterExpr, error) {
+	if _, ok := p.next(tokenOpen); ok {
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.next(tokenClose); !ok {
+			return nil, p.errorf("missing )")
+		}
+		return expr, nil
+	}
+
+	return p.comparison()
+}
+
+// filterFlippedOperators are the operators of a comparison whose variable is
+// on the left, once the column is moved to the left
+var filterFlippedOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	">":  "<",
+	">=": "<=",
+	"<":  ">",
+	"<=": ">=",
+}
+
+// column reads the name of a column
+func (p *filterParser) column() (string, error) {
+	ident, ok := p.next(tokenIdent)
+	if !ok {
+		return "", p.errorf("expected a column")
+	}
+	for _, name := range p.columns {
+		if strings.EqualFold(name, ident.text) {
+			return name, nil
+		}
+	}
+	p.pos--
+
+	return "", p.errorf("column %s not found", ident.text)
+}
+
+// variable reads the value of a variable
+func (p *filterParser) variable() (interface{}, error) {
+	token, ok := p.next(tokenVariable)
+	if !ok {
+		return nil, p.errorf("expected a variable")
+	}
+	p.pos--
+
+	value, known, err := p.vars(token.text)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	if !known {
+		return nil, p.errorf("unknown variable %s", token.text)
+	}
+	p.pos++
+
+	return value, nil
+}
+
+func (p *filterParser) comparison() (filterExpr, error) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenVariable {
+		return p.flippedComparison()
+	}
+
+	column, err := p.column()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.next(tokenOperator)
+	if !ok {
+		return nil, p.errorf("expected an operator after %s", column)
+	}
+	condition := &filterCondition{column: column, operator: strings.TrimPrefix(op.text, "?")}
+	condition.any = condition.operator != op.text
+
+	if p.pos >= len(p.tokens) {
+		return nil, p.errorf("expected a value after %s", op.text)
+	}
+	value := p.tokens[p.pos]
+	switch value.kind {
+	case tokenString:
+		condition.value = value.text
+		condition.text = value.text
+	case tokenNumber:
+		number, err := strconv.ParseFloat(value.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", value.text)
+		}
+		condition.value = number
+		if integer, err := strconv.ParseInt(value.text, 10, 64); err == nil {
+			condition.value = integer
+		}
+		condition.text = value.text
+	case tokenVariable:
+		resolved, err := p.variable()
+		if err != nil {
+			return nil, err
+		}
+		p.pos--
+		condition.setValue(resolved)
+	case tokenIdent:
+		switch strings.ToLower(value.text) {
+		case "true":
+			condition.value = 1
+			condition.text = "1"
+		case "false":
+			condition.value = 0
+			condition.text = "0"
+		case "null":
+			if condition.operator != "=" && condition.operator != "!=" {
+				return nil, p.errorf("null can only be compared with = or !=")
+			}
+		default:
+			return nil, p.errorf("expected a value, columns can't be compared with each other")
+		}
+	default:
+		return nil, p.errorf("expected a value after %s", op.text)
+	}
+	p.pos++
+
+	if condition.operator == "~" || condition.operator == "!~" {
+		text, ok := condition.value.(string)
+		if !ok {
+			text = condition.text
+		}
+		if !strings.Contains(text, "%") {
+			text = "%" + text + "%"
+		}
+		condition.value = text
+		condition.text = text
+	}
+
+	return condition, nil
+}
+
+// flippedComparison reads a comparison of a variable with a column, such as
+// @request.auth.id = owner_id, as the column compared with the variable
+func (p *filterParser) flippedComparison() (filterExpr, error) {
+	value, err := p.variable()
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := p.next(tokenOperator)
+	if !ok {
+		return nil, p.errorf("expected an operator after %s", p.tokens[p.pos-1].text)
+	}
+	operator := strings.TrimPrefix(op.text, "?")
+	flipped, ok := filterFlippedOperators[operator]
+	if !ok {
+		p.pos--
+		return nil, p.errorf("%s needs the column on its left", op.text)
+	}
+
+	column, err := p.column()
+	if err != nil {
+		return nil, err
+	}
+
+	condition := &filterCondition{column: column, operator: flipped, any: operator != op.text}
+	condition.setValue(value)
+
+	return condition, nil
+}
+
+// setValue sets the value of a variable as the compared value
+func (f *filterCondition) setValue(value interface{}) {
+	f.value = value
+	f.text = fmt.Sprint(value)
+}
+
+func (g *filterGroup) sql() (string, []interface{}) {
+	parts := make([]string, 0, len(g.nodes))
+	values := []interface{}{}
+	for _, node := range g.nodes {
+		part, nodeValues := node.sql()
+		parts = append(parts, "("+part+")")
+		values = append(values, nodeValues...)
+	}
+
+	separator := " AND "
+	if g.or {
+		separator = " OR "
+	}
+
+	return strings.Join(parts, separator), values
+}
+
+func (g *filterGroup) match(row map[string]interface{}) bool {
+	for _, node := range g.nodes {
+		if node.match(row) == g.or {
+			return g.or
+		}
+	}
+
+	return !g.or
+}
+
+func (g *filterGroup) conditions() []Filter {
+	filters := []Filter{}
+	for _, node := range g.nodes {
+		filters = append(filters, node.conditions()...)
+	}
+
+	return filters
+}
+
+func (g *filterGroup) String() string {
+	parts := make([]string, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		parts = append(parts, "("+node.String()+")")
+	}
+	if g.or {
+		return strings.Join(parts, " || ")
+	}
+
+	return strings.Join(parts, " && ")
+}
+
+func (f *filterCondition) sql() (string, []interface{}) {
+	column := fmt.Sprintf(`"%s"`, f.column)
+	if f.value == nil {
+		if f.operator == "=" {
+			return column + " IS NULL", nil
+		}
+		return column + " IS NOT NULL", nil
+	}
+
+	operator := filterExprOperators[f.operator]
+	if f.any {
+		// a JSON array is compared by its values, anything else as one
+		// value. The values lose the collation of the column
+		item := "value"
+		if f.nocase {
+			item = "value COLLATE NOCASE"
+		}
+		return fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM json_each(CASE WHEN NOT json_valid(%[1]s) THEN json_array(%[1]s) "+
+				"WHEN json_type(%[1]s) = 'array' THEN %[1]s ELSE json_array(%[1]s) END) WHERE %[3]s %[2]s ?)",
+			column, operator, item,
+		), []interface{}{f.value}
+	}
+
+	return fmt.Sprintf("%s %s ?", column, operator), []interface{}{f.value}
+}
+
+func (f *filterCondition) match(row map[string]interface{}) bool {
+	value := row[f.column]
+	if v, ok := value.(*interface{}); ok {
+		value = nil
+		if v != nil {
+			value = *v
+		}
+	}
+	if f.value == nil {
+		return (value == nil) == (f.operator == "=")
+	}
+
+	filter := f.conditions()[0]
+	if f.nocase && f.operator != "~" && f.operator != "!~" {
+		switch v := value.(type) {
+		case string:
+			value = foldASCII(v)
+		case []byte:
+			value = foldASCII(string(v))
+		}
+		filter.Value = foldASCII(filter.Value)
+	}
+	if f.any {
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		}
+		var items []interface{}
+		if strings.HasPrefix(strings.TrimSpace(text), "[") && json.Unmarshal([]byte(text), &items) == nil {
+			for _, item := range items {
+				if matchFilter(item, filter) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return matchFilter(value, filter)
+}
+
+func (f *filterCondition) conditions() []Filter {
+	operator := strings.ToLower(filterExprOperators[f.operator])
+	if f.value == nil {
+		operator = f.operator
+	}
+
+	return []Filter{{Column: f.column, Operator: operator, Value: f.text}}
+}
+
+func (f *filterCondition) String() string {
+	operator := f.operator
+	if f.any {
+		operator = "?" + operator
+	}
+	if f.value == nil {
+		return fmt.Sprintf("%s %s null", f.column, operator)
+	}
+
+	if _, ok := f.value.(string); ok {
+		return fmt.Sprintf("%s %s %s", f.column, operator, strconv.Quote(f.text))
+	}
+
+	return fmt.Sprintf("%s %s %s", f.column, operator, f.text)
+}
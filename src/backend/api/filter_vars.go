@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// filterVariables resolves the variables of the filters and of the
+// templates of the functions, such as @request.auth.id or $user.email.
+// known is false for names which aren't variables
+type filterVariables func(name string) (value interface{}, known bool, err error)
+
+// sqliteTimeFormat is the format of CURRENT_TIMESTAMP, times formatted with
+// it compare with the timestamps of the rows
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// requestVariables returns the variables of a request:
+//
+//	@request.auth.id, $user.id  the id of the caller, empty when anonymous
+//	$user.<column>              a column of the auth record of the caller
+//	$request.ip                 the IP address of the caller
+//	$now                        the current time, in UTC
+//
+// The columns of the caller are empty for admins and anonymous requests,
+// so they match no row. c is nil outside of requests, only the times are set then
+func requestVariables(db *gorm.DB, c echo.Context) filterVariables {
+	var (
+		record map[string]interface{}
+		loaded bool
+	)
+
+	return func(name string) (interface{}, bool, error) {
+		if value, known, err := dateMacro(name, time.Now()); known {
+			return value, true, err
+		}
+
+		switch name {
+		case "$now":
+			return dateMacro("@now", time.Now())
+		case "@request.auth.id", "$user.id":
+			if c == nil {
+				return "", true, nil
+			}
+			return currentUserID(c), true, nil
+		case "$request.ip":
+			if c == nil {
+				return "", true, nil
+			}
+			return c.RealIP(), true, nil
+		}
+
+		column, ok := strings.CutPrefix(name, "$user.")
+		if !ok {
+			return nil, false, nil
+		}
+		if authSecretColumns[column] {
+			return nil, true, fmt.Errorf("%s can't be used", name)
+		}
+		if c == nil {
+			return "", true, nil
+		}
+
+		if !loaded {
+			var err error
+			if record, err = callerRecord(db, c); err != nil {
+				return nil, true, err
+			}
+			loaded = true
+		}
+		if record == nil {
+			return "", true, nil
+		}
+		value, ok := record[column]
+		if !ok {
+			return nil, true, fmt.Errorf("unknown variable %s", name)
+		}
+		if value == nil {
+			return "", true, nil
+		}
+
+		return value, true, nil
+	}
+}
+
+// dateMacro returns the time of a date macro, in UTC:
+//
+//	@now                     the current time
+//	@todayStart, @todayEnd   the first and last second of the day
+//	@monthStart, @yearStart  the first second of the month or the year
+//	@daysAgo(n)              n days before now
+//	@hoursAgo(n)             n hours before now
+//
+// known is false for names which aren't date macros
+func dateMacro(name string, now time.Time) (value interface{}, known bool, err error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var t time.Time
+	switch name {
+	case "@now":
+		t = now
+	case "@todayStart":
+		t = today
+	case "@todayEnd":
+		t = today.AddDate(0, 0, 1).Add(-time.Second)
+	case "@monthStart":
+		t = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "@yearStart":
+		t = time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		macro, arg, ok := strings.Cut(strings.TrimSuffix(name, ")"), "(")
+		if !ok || !strings.HasSuffix(name, ")") {
+			return nil, false, nil
+		}
+
+		var unit time.Duration
+		switch macro {
+		case "@daysAgo":
+			unit = 24 * time.Hour
+		case "@hoursAgo":
+			unit = time.Hour
+		default:
+			return nil, false, nil
+		}
+
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return nil, true, fmt.Errorf("%s takes a whole number, 0 or more", macro)
+		}
+		t = now.Add(-time.Duration(n) * unit)
+	}
+
+	return t.Format(sqliteTimeFormat), true, nil
+}
+
+// callerRecord returns the auth record of the user behind a request, nil for
+// admins and anonymous requests
+func callerRecord(db *gorm.DB, c echo.Context) (map[string]interface{}, error) {
+	tableName, _ := c.Get("user_table").(string)
+	userID := currentUserID(c)
+	if tableName == "" || userID == "" {
+		return nil, nil
+	}
+
+	record := map[string]interface{}{}
+	err := db.Table(tableName).Where("id = ?", userID).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return record, err
+}
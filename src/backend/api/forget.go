@@ -0,0 +1,432 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	forgetDelete    = "delete"
+	forgetAnonymize = "anonymize"
+
+	// forgetChunk bounds the ids bound to a single statement
+	forgetChunk = 500
+)
+
+type forgetReq struct {
+	// Mode is delete, the default, or anonymize to keep the rows the user
+	// owns with their owner column cleared
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run"`
+}
+
+type forgetTable struct {
+	Table   string         `json:"table"`
+	Deleted int            `json:"deleted"`
+	Cleared map[string]int `json:"cleared,omitempty"`
+}
+
+type forgetReport struct {
+	Mode     string        `json:"mode"`
+	DryRun   bool          `json:"dry_run"`
+	Tables   []forgetTable `json:"tables"`
+	Files    int           `json:"files"`
+	Sessions int64         `json:"sessions"`
+	Tokens   int64         `json:"tokens"`
+	Roles    int64         `json:"roles"`
+}
+
+type forgetRows struct {
+	table string
+	ids   []string
+}
+
+// forgetPlan holds the rows to delete and the relation columns to clear to
+// forget a user. Rows referencing a deleted row through a NOT NULL column
+// are deleted as well, the other references are cleared
+type forgetPlan struct {
+	columns map[string][]model.Column
+	// deleted holds the ids of the rows to delete by table, order the tables
+	// in the order they were found
+	deleted map[string]map[string]bool
+	order   []string
+	// cleared holds the ids of the rows to clear by table and column
+	cleared map[string]map[string]map[string]bool
+	queue   []forgetRows
+
+	// files are deleted, orphaned files are kept without their uploader
+	files    []model.File
+	orphaned []string
+}
+
+func (p *forgetPlan) delete(table string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	if p.deleted[table] == nil {
+		p.deleted[table] = map[string]bool{}
+		p.order = append(p.order, table)
+	}
+
+	added := []string{}
+	for _, id := range ids {
+		if !p.deleted[table][id] {
+			p.deleted[table][id] = true
+			added = append(added, id)
+		}
+	}
+	if len(added) > 0 {
+		p.queue = append(p.queue, forgetRows{table: table, ids: added})
+	}
+}
+
+func (p *forgetPlan) clear(table string, column string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	if p.cleared[table] == nil {
+		p.cleared[table] = map[string]map[string]bool{}
+	}
+	if p.cleared[table][column] == nil {
+		p.cleared[table][column] = map[string]bool{}
+	}
+	for _, id := range ids {
+		p.cleared[table][column][id] = true
+	}
+}
+
+// clearedIDs returns the rows of table whose column is cleared, leaving out
+// the rows which are deleted anyway
+func (p *forgetPlan) clearedIDs(table string, column string) []string {
+	ids := []string{}
+	for id := range p.cleared[table][column] {
+		if !p.deleted[table][id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+func (p *forgetPlan) deletedIDs(table string) []string {
+	ids := make([]string, 0, len(p.deleted[table]))
+	for id := range p.deleted[table] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// idChunks splits ids into slices of at most forgetChunk ids
+func idChunks(ids []string) [][]string {
+	result := [][]string{}
+	for start := 0; start < len(ids); start += forgetChunk {
+		result = append(result, ids[start:min(start+forgetChunk, len(ids))])
+	}
+
+	return result
+}
+
+// selectIDs returns the ids of the rows of table whose column holds one of
+// values
+func selectIDs(db *gorm.DB, table string, column string, values []string) ([]string, error) {
+	ids := []string{}
+	for _, chunk := range idChunks(values) {
+		var found []string
+		err := db.Table(table).Where(fmt.Sprintf("`%s` IN ?", column), chunk).Pluck("id", &found).Error
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, found...)
+	}
+
+	return ids, nil
+}
+
+// planForget finds the rows and files belonging to a user of table: their
+// auth record, the rows they own, the rows referencing those through the
+// relations and the files uploaded by the user or attached to deleted rows
+func planForget(db *gorm.DB, table model.Tables, userID string, mode string) (*forgetPlan, error) {
+	var exists int64
+	if err := db.Table(table.Name).Where("id = ?", userID).Count(&exists).Error; err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	plan := &forgetPlan{
+		columns: map[string][]model.Column{},
+		deleted: map[string]map[string]bool{},
+		cleared: map[string]map[string]map[string]bool{},
+	}
+
+	var tables []model.Tables
+	err := db.Model(&model.Tables{}).
+		Where("is_system = ? AND is_view = ?", false, false).
+		Order("name").
+		Find(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tables {
+		if plan.columns[t.Name], err = fetchColumns(db, t.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	plan.delete(table.Name, []string{userID})
+
+	owned, err := ownedTables(db, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range owned {
+		ids, err := selectIDs(db, t.Name, t.OwnerColumn, []string{userID})
+		if err != nil {
+			return nil, err
+		}
+
+		notNull := false
+		for _, column := range plan.columns[t.Name] {
+			if column.Name == t.OwnerColumn {
+				notNull = column.NotNull
+			}
+		}
+		if mode == forgetDelete || notNull {
+			plan.delete(t.Name, ids)
+		} else {
+			plan.clear(t.Name, t.OwnerColumn, ids)
+		}
+	}
+
+	for len(plan.queue) > 0 {
+		rows := plan.queue[0]
+		plan.queue = plan.queue[1:]
+
+		for _, t := range tables {
+			for _, column := range plan.columns[t.Name] {
+				if column.Reference != rows.table {
+					continue
+				}
+
+				ids, err := selectIDs(db, t.Name, column.Name, rows.ids)
+				if err != nil {
+					return nil, err
+				}
+				if column.NotNull {
+					plan.delete(t.Name, ids)
+				} else {
+					plan.clear(t.Name, column.Name, ids)
+				}
+			}
+		}
+	}
+
+	files := map[string]model.File{}
+	for _, name := range plan.order {
+		for _, chunk := range idChunks(plan.deletedIDs(name)) {
+			var attached []model.File
+			if err := db.Where("`table` = ? AND row_id IN ?", name, chunk).Find(&attached).Error; err != nil {
+				return nil, err
+			}
+			for _, file := range attached {
+				files[file.Key] = file
+			}
+		}
+	}
+
+	var uploaded []model.File
+	if err := db.Where("uploaded_by = ?", userID).Find(&uploaded).Error; err != nil {
+		return nil, err
+	}
+	for _, file := range uploaded {
+		if _, ok := files[file.Key]; ok {
+			continue
+		}
+		// the files of the rows which are kept stay with them
+		if mode == forgetAnonymize && file.Table != "" {
+			plan.orphaned = append(plan.orphaned, file.Key)
+			continue
+		}
+		files[file.Key] = file
+	}
+	for _, file := range files {
+		plan.files = append(plan.files, file)
+	}
+	sort.Strings(plan.orphaned)
+	sort.Slice(plan.files, func(i, j int) bool { return plan.files[i].Key < plan.files[j].Key })
+
+	return plan, nil
+}
+
+// report tells how many rows of each table are deleted or cleared
+func (p *forgetPlan) report(mode string, dryRun bool) forgetReport {
+	tables := map[string]*forgetTable{}
+	entry := func(name string) *forgetTable {
+		if tables[name] == nil {
+			tables[name] = &forgetTable{Table: name}
+		}
+		return tables[name]
+	}
+
+	for name, ids := range p.deleted {
+		entry(name).Deleted = len(ids)
+	}
+	for name, columns := range p.cleared {
+		for column := range columns {
+			if count := len(p.clearedIDs(name, column)); count > 0 {
+				t := entry(name)
+				if t.Cleared == nil {
+					t.Cleared = map[string]int{}
+				}
+				t.Cleared[column] = count
+			}
+		}
+	}
+
+	report := forgetReport{Mode: mode, DryRun: dryRun, Tables: []forgetTable{}, Files: len(p.files)}
+	for _, t := range tables {
+		report.Tables = append(report.Tables, *t)
+	}
+	sort.Slice(report.Tables, func(i, j int) bool { return report.Tables[i].Table < report.Tables[j].Table })
+
+	return report
+}
+
+// apply deletes and clears the rows of the plan within tx, along with the
+// sessions, tokens and roles of the user. The foreign keys are checked once
+// every row is gone
+func (p *forgetPlan) apply(tx *gorm.DB, table string, userID string, report *forgetReport) error {
+	if err := tx.Exec("PRAGMA defer_foreign_keys = ON").Error; err != nil {
+		return err
+	}
+
+	for name, columns := range p.cleared {
+		for column := range columns {
+			for _, chunk := range idChunks(p.clearedIDs(name, column)) {
+				if err := tx.Table(name).Where("id IN ?", chunk).Update(column, nil).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, name := range p.order {
+		for _, chunk := range idChunks(p.deletedIDs(name)) {
+			if err := tx.Table(name).Where("id IN ?", chunk).Delete(nil).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(p.orphaned) > 0 {
+		if err := tx.Model(&model.File{}).Where("key IN ?", p.orphaned).Update("uploaded_by", "").Error; err != nil {
+			return err
+		}
+	}
+
+	result := tx.Where("`table` = ? AND user_id = ?", table, userID).Delete(&model.Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	report.Sessions = result.RowsAffected
+
+	result = tx.Where("`table` = ? AND user_id = ?", table, userID).Delete(&model.UserToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	report.Tokens = result.RowsAffected
+
+	result = tx.Where("`table` = ? AND user_id = ?", table, userID).Delete(&model.UserRole{})
+	if result.Error != nil {
+		return result.Error
+	}
+	report.Roles = result.RowsAffected
+
+	return tx.Where("`table` = ? AND user_id = ?", table, userID).Delete(&model.MagicLinkToken{}).Error
+}
+
+// ForgetUser deletes everything belonging to a user, following the relations
+// to the rows referencing them, within a transaction. The files are deleted
+// once it is committed. With dry_run it only reports what would be affected
+func (p *PrivacyAPIImpl) ForgetUser(c echo.Context) error {
+	tableName := c.Param("table_name")
+	userID := c.Param("user_id")
+
+	var params *forgetReq = new(forgetReq)
+	if err := c.Bind(params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	switch params.Mode {
+	case "":
+		params.Mode = forgetDelete
+	case forgetDelete, forgetAnonymize:
+	default:
+		return pkg_apierror.Message(c, http.StatusBadRequest, "mode must be delete or anonymize")
+	}
+
+	table, err := getTableInfo(p.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !table.IsAuth {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+	}
+
+	plan, err := planForget(p.db, table, userID, params.Mode)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "user does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	report := plan.report(params.Mode, params.DryRun)
+	if params.DryRun {
+		query := p.db.Where("`table` = ? AND user_id = ?", tableName, userID)
+		err := errors.Join(
+			query.Session(&gorm.Session{}).Model(&model.Session{}).Count(&report.Sessions).Error,
+			query.Session(&gorm.Session{}).Model(&model.UserToken{}).Count(&report.Tokens).Error,
+			query.Session(&gorm.Session{}).Model(&model.UserRole{}).Count(&report.Roles).Error,
+		)
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+
+		return c.JSON(http.StatusOK, report)
+	}
+
+	err = p.db.Transaction(func(tx *gorm.DB) error {
+		return plan.apply(tx, tableName, userID, &report)
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	for _, name := range plan.order {
+		invalidateRowCounts(name)
+	}
+	for _, file := range plan.files {
+		if err := p.storage.Delete(c.Request().Context(), file.Key); err != nil {
+			log.Printf("failed to delete file %s of forgotten user %s: %v\n", file.Key, userID, err)
+		}
+	}
+
+	recordActivity(p.db, c, model.ACTIVITY_FORGET_USER, tableName, fmt.Sprintf("%s (%s)", userID, params.Mode))
+
+	return c.JSON(http.StatusOK, report)
+}
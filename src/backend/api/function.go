@@ -7,7 +7,7 @@ import (
 	"net/http"
 	"react-golang/src/backend/constants"
 	"react-golang/src/backend/model"
-	"react-golang/src/backend/utils"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -48,32 +48,36 @@ type Function struct {
 }
 
 type functionReq struct {
-	Name      string     `json:"name"`
-	Functions []Function `json:"functions"`
+	Name         string     `json:"name"`
+	Functions    []Function `json:"functions"`
+	AllowedRoles []string   `json:"allowed_roles"`
 }
 
 func (f FunctionAPIImpl) CreateFunction(c echo.Context) error {
 	var body *functionReq = new(functionReq)
 	if err := c.Bind(body); err != nil {
-		return c.JSON(http.StatusBadRequest, errors.New("Failed to bind: "+err.Error()))
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
 	// convert functions to json
 	jsonFunc, err := json.Marshal(body.Functions)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	newFunction := model.FunctionStored{
-		Name:     body.Name,
-		Function: string(jsonFunc),
+		Name:         body.Name,
+		Function:     string(jsonFunc),
+		AllowedRoles: strings.Join(body.AllowedRoles, ","),
 	}
 
 	err = f.db.Model(&model.FunctionStored{}).Create(&newFunction).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
+	recordActivity(f.db, c, model.ACTIVITY_CREATE_FUNCTION, body.Name, "")
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "success",
 	})
@@ -89,7 +93,7 @@ func (f FunctionAPIImpl) FetchFunctionList(c echo.Context) error {
 	}
 	err := table.Find(&functions).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, functions)
@@ -101,14 +105,18 @@ func (f FunctionAPIImpl) FetchFunctionDetail(c echo.Context) error {
 	var funcStored model.FunctionStored
 	err := f.db.Where("name = ?", funcName).First(&funcStored).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	var function functionReq
 	function.Name = funcName
+	function.AllowedRoles = []string{}
+	if funcStored.AllowedRoles != "" {
+		function.AllowedRoles = strings.Split(funcStored.AllowedRoles, ",")
+	}
 	err = json.Unmarshal([]byte(funcStored.Function), &function.Functions)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, function)
@@ -118,9 +126,11 @@ func (f FunctionAPIImpl) DeleteFunction(c echo.Context) error {
 	funcName := c.Param("func_name")
 	err := f.db.Model(&model.FunctionStored{}).Where("name = ?", funcName).Delete(&model.FunctionStored{}).Error
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
+	recordActivity(f.db, c, model.ACTIVITY_DELETE_FUNCTION, funcName, "")
+
 	return c.JSON(http.StatusOK, nil)
 }
 
@@ -128,55 +138,107 @@ func (f FunctionAPIImpl) RunFunction(c echo.Context) error {
 	funcName := c.Param("func_name")
 	var function *model.FunctionStored
 
-	paramUser := c.Get("user_id")
-	var userID string
-	if paramUser != nil {
-		userID = paramUser.(string)
-	}
-
 	err := f.db.Model(&model.FunctionStored{}).Where("name = ?", funcName).First(&function).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.JSON(http.StatusNotFound, map[string]interface{}{
-				"error": "function does not exist",
-			})
+			return pkg_apierror.Message(c, http.StatusNotFound, "function does not exist")
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if function.AllowedRoles != "" {
+		allowed, err := callerHasRole(f.db, c, strings.Split(function.AllowedRoles, ","))
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		if !allowed {
+			return pkg_apierror.Message(c, http.StatusForbidden, "not allowed to run this function")
 		}
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
 	}
 
 	functions := []Function{}
 	err = json.Unmarshal([]byte(function.Function), &functions)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
 	}
 
 	var caller *Caller = new(Caller)
 	if err := c.Bind(caller); err != nil {
-		return c.JSON(http.StatusBadRequest, errors.New("Failed to bind: "+err.Error()))
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
 	}
 
+	savedData, err := runFunctions(f.db, functions, caller, c)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, savedData)
+}
+
+// ExecuteFunction runs a stored function outside of a request, as the cron
+// jobs do. The allowed roles of the function don't apply
+func ExecuteFunction(db *gorm.DB, name string, data map[string]interface{}) (map[string]interface{}, error) {
+	var function model.FunctionStored
+	if err := db.Where("name = ?", name).First(&function).Error; err != nil {
+		return nil, err
+	}
+
+	functions := []Function{}
+	if err := json.Unmarshal([]byte(function.Function), &functions); err != nil {
+		return nil, err
+	}
+
+	return runFunctions(db, functions, &Caller{Data: data}, nil)
+}
+
+// runFunctions runs the steps of a stored function in a single transaction
+// and returns the data saved by the steps. The variables of the templates
+// are those of the request c, which is nil outside of requests
+func runFunctions(db *gorm.DB, functions []Function, caller *Caller, c echo.Context) (map[string]interface{}, error) {
 	savedData := map[string]interface{}{}
-	err = f.db.Transaction(func(db *gorm.DB) error {
+	// the cached reads of the written tables are dropped once the steps are
+	// committed
+	written := map[string]bool{}
+	defer func() {
+		for table := range written {
+			invalidateRowCounts(table)
+		}
+	}()
+	err := db.Transaction(func(db *gorm.DB) error {
+		vars := requestVariables(db, c)
 		for _, f := range functions {
+			if f.Action != "fetch" {
+				written[f.Table] = true
+			}
 			switch f.Action {
 			case "insert":
+				table, err := getTableInfo(db, f.Table)
+				if err != nil {
+					return err
+				}
 				if f.Multiple {
-					bindedInput := BindMultipleInput(f.Values, caller.Data[f.Name].([]interface{}), savedData, userID)
+					bindedInput, err := BindMultipleInput(f.Values, caller.Data[f.Name].([]interface{}), savedData, vars)
+					if err != nil {
+						return err
+					}
 					for i := range bindedInput {
-						bindedInput[i]["id"], _ = utils.GenerateRandomString(16)
+						if err := assignRowID(table, bindedInput[i]); err != nil {
+							return err
+						}
 					}
-					err := db.Table(f.Table).Create(bindedInput).Error
+					err = db.Table(f.Table).Create(bindedInput).Error
 					if err != nil {
 						return err
 					}
 				} else {
-					bindedInput := BindSingularInput(f.Values, caller.Data[f.Name].(map[string]interface{}), savedData, userID)
-					bindedInput["id"], _ = utils.GenerateRandomString(16)
-					err := db.Table(f.Table).Create(bindedInput).Error
+					bindedInput, err := BindSingularInput(f.Values, caller.Data[f.Name].(map[string]interface{}), savedData, vars)
+					if err != nil {
+						return err
+					}
+					if err := assignRowID(table, bindedInput); err != nil {
+						return err
+					}
+					err = createRow(db, f.Table, bindedInput)
 					if err != nil {
 						return err
 					}
@@ -190,12 +252,15 @@ func (f FunctionAPIImpl) RunFunction(c echo.Context) error {
 							"id = ?": input["id"],
 						}
 
-						bindedInput := BindSingularInput(f.Values, input, savedData, userID)
+						bindedInput, err := BindSingularInput(f.Values, input, savedData, vars)
+						if err != nil {
+							return err
+						}
 						table := db.Table(f.Table)
 						for k, v := range filter {
 							table = table.Where(k, v)
 						}
-						err := table.Updates(bindedInput).Error
+						err = table.Updates(bindedInput).Error
 						if err != nil {
 							return err
 						}
@@ -206,12 +271,15 @@ func (f FunctionAPIImpl) RunFunction(c echo.Context) error {
 						"id = ?": data["id"],
 					}
 
-					bindedInput := BindSingularInput(f.Values, caller.Data[f.Name].(map[string]interface{}), savedData, userID)
+					bindedInput, err := BindSingularInput(f.Values, caller.Data[f.Name].(map[string]interface{}), savedData, vars)
+					if err != nil {
+						return err
+					}
 					table := db.Table(f.Table)
 					for k, v := range filter {
 						table = table.Where(k, v)
 					}
-					err := table.Updates(bindedInput).Error
+					err = table.Updates(bindedInput).Error
 					if err != nil {
 						return err
 					}
@@ -223,6 +291,14 @@ func (f FunctionAPIImpl) RunFunction(c echo.Context) error {
 				for _, f := range f.Filter {
 					if f.Value == "" {
 						filter[f.Column+f.Operator] = data[f.Column]
+						continue
+					}
+					value, known, err := vars(f.Value)
+					if err != nil {
+						return err
+					}
+					if known {
+						filter[f.Column+f.Operator] = value
 					} else {
 						filter[f.Column+f.Operator] = f.Value
 					}
@@ -250,10 +326,10 @@ func (f FunctionAPIImpl) RunFunction(c echo.Context) error {
 		return nil
 	})
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]interface{}{"error": err.Error()})
+		return nil, err
 	}
 
-	return c.JSON(http.StatusOK, savedData)
+	return savedData, nil
 }
 
 func applyFilter(query *gorm.DB, filter map[string]interface{}) *gorm.DB {
@@ -293,31 +369,45 @@ func Or(query *gorm.DB, key string, value interface{}) *gorm.DB {
 	return query.Or(fmt.Sprintf("%s = ?", key), value)
 }
 
-func BindSingularInput(template map[string]interface{}, input map[string]interface{}, savedData map[string]interface{}, userID string) map[string]interface{} {
+// BindSingularInput fills a template of a function. A value of the template
+// is a variable such as $user.email, $<step> for the data saved by a
+// previous step, or empty for the input of the caller. Saved steps take
+// precedence over the variables of the same name
+func BindSingularInput(template map[string]interface{}, input map[string]interface{}, savedData map[string]interface{}, vars filterVariables) (map[string]interface{}, error) {
 	result := map[string]interface{}{}
 	for k, v := range template {
-		if strings.HasPrefix(v.(string), "$") {
-			key := v.(string)[1:]
-			if v == "$user.id" {
-				result[k] = userID
-			} else {
-				result[k] = savedData[key]
-			}
+		name := v.(string)
+		if saved, ok := savedData[strings.TrimPrefix(name, "$")]; ok && strings.HasPrefix(name, "$") {
+			result[k] = saved
+			continue
+		}
+
+		value, known, err := vars(name)
+		if err != nil {
+			return nil, err
+		}
+		if known {
+			result[k] = value
+		} else if strings.HasPrefix(name, "$") {
+			result[k] = savedData[name[1:]]
 		} else {
 			result[k] = input[k]
 		}
-
 	}
 
-	return result
+	return result, nil
 }
 
-func BindMultipleInput(template map[string]interface{}, inputs []interface{}, savedData map[string]interface{}, userID string) []map[string]interface{} {
+func BindMultipleInput(template map[string]interface{}, inputs []interface{}, savedData map[string]interface{}, vars filterVariables) ([]map[string]interface{}, error) {
 	result := []map[string]interface{}{}
 
 	for _, input := range inputs {
 		// currently testing, if broken, just change to the bottom one
-		result = append(result, BindSingularInput(template, input.(map[string]interface{}), savedData, userID))
+		binded, err := BindSingularInput(template, input.(map[string]interface{}), savedData, vars)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, binded)
 
 		/* ================================================================== */
 		// current := map[string]interface{}{}
@@ -336,5 +426,5 @@ func BindMultipleInput(template map[string]interface{}, inputs []interface{}, sa
 		// result = append(result, current)
 	}
 
-	return result
+	return result, nil
 }
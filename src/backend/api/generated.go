@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// validateExpression checks the expression of a generated field is a single
+// scalar expression which stays inside the parentheses of its GENERATED
+// ALWAYS AS: balanced parentheses, no statement separator, comment, top level
+// comma or subquery outside of the quotes
+func (f *fields) validateExpression() error {
+	expression := strings.TrimSpace(f.Expression)
+	if f.Expression != "" && expression == "" {
+		return fmt.Errorf("expression of %s is empty", f.FieldName)
+	}
+
+	depth := 0
+	var quote rune
+	word := []rune{}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); i++ {
+		ch := runes[i]
+		if quote != 0 {
+			if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		if ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+			word = append(word, ch)
+			continue
+		}
+		if strings.EqualFold(string(word), "SELECT") {
+			return fmt.Errorf("expression of %s can't hold a subquery", f.FieldName)
+		}
+		word = word[:0]
+
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '[':
+			quote = ']'
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("expression of %s has unbalanced parentheses", f.FieldName)
+			}
+		case ch == ';':
+			return fmt.Errorf("expression of %s must be a single expression", f.FieldName)
+		case ch == ',' && depth == 0:
+			return fmt.Errorf("expression of %s must be a single expression", f.FieldName)
+		case ch == '-' && i+1 < len(runes) && runes[i+1] == '-',
+			ch == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			return fmt.Errorf("expression of %s can't hold a comment", f.FieldName)
+		}
+	}
+	if strings.EqualFold(string(word), "SELECT") {
+		return fmt.Errorf("expression of %s can't hold a subquery", f.FieldName)
+	}
+	if quote != 0 {
+		return fmt.Errorf("expression of %s has an unterminated quote", f.FieldName)
+	}
+	if depth != 0 {
+		return fmt.Errorf("expression of %s has unbalanced parentheses", f.FieldName)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// GeoPoint is stored as JSON text inside a LATLNG column
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// encodeGeoPoint converts a {"lat": .., "lng": ..} object into its stored
+// JSON form, any other value is returned as is
+func encodeGeoPoint(value interface{}) (interface{}, error) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value, nil
+	}
+
+	lat, latOk := object["lat"].(float64)
+	lng, lngOk := object["lng"].(float64)
+	if !latOk || !lngOk {
+		return value, nil
+	}
+
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return nil, fmt.Errorf("invalid geopoint (%v, %v)", lat, lng)
+	}
+
+	encoded, err := json.Marshal(GeoPoint{Lat: lat, Lng: lng})
+	if err != nil {
+		return nil, err
+	}
+
+	return string(encoded), nil
+}
+
+// applyWithinFilter filters rows whose geopoint column is inside a radius.
+// The filter value is formatted as "lat,lng,radius" with the radius in meters
+func applyWithinFilter(query *gorm.DB, filter Filter) (*gorm.DB, error) {
+	parts := strings.Split(filter.Value, ",")
+	if len(parts) != 3 {
+		return query, errors.New("within filter value must be formatted as lat,lng,radius")
+	}
+
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return query, fmt.Errorf("invalid within filter value %s", part)
+		}
+		values[i] = value
+	}
+
+	return query.Where(fmt.Sprintf(
+		"haversine(json_extract(`%s`, '$.lat'), json_extract(`%s`, '$.lng'), ?, ?) <= ?",
+		filter.Column, filter.Column,
+	), values[0], values[1], values[2]), nil
+}
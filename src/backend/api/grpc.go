@@ -0,0 +1,266 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	pkg_grpc "react-golang/src/backend/pkg/grpc"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// grpcHeaders are the metadata passed on to the REST handlers as headers
+var grpcHeaders = []string{"x-api-key", "authorization", "x-forwarded-for", "user-agent"}
+
+// GRPCServer serves the services of fullbase.proto. A call runs the handler
+// of the matching REST route with the middlewares of the app, so both APIs
+// answer the same way
+type GRPCServer struct {
+	pkg_grpc.UnimplementedRecordsServer
+	pkg_grpc.UnimplementedAuthServer
+	pkg_grpc.UnimplementedFunctionsServer
+
+	app *echo.Echo
+}
+
+// NewGRPCServer returns a gRPC server with the services registered, the REST
+// routes must be served by the app
+func (api *API) NewGRPCServer() *grpc.Server {
+	s := &GRPCServer{app: api.app}
+
+	server := grpc.NewServer()
+	pkg_grpc.RegisterRecordsServer(server, s)
+	pkg_grpc.RegisterAuthServer(server, s)
+	pkg_grpc.RegisterFunctionsServer(server, s)
+
+	return server
+}
+
+func (s *GRPCServer) List(ctx context.Context, req *pkg_grpc.ListRecordsRequest) (*pkg_grpc.ListRecordsResponse, error) {
+	filters := []Filter{}
+	for _, filter := range req.Filters {
+		filters = append(filters, Filter{Column: filter.Column, Operator: filter.Operator, Value: filter.Value})
+	}
+
+	var rows []map[string]interface{}
+	header, err := s.call(ctx, http.MethodPost, "/main/"+url.PathEscape(req.Table)+"/rows", fetchRowsParam{
+		Filter:     filters,
+		Expression: req.Filter,
+		Limit:      int(req.Limit),
+		Count:      req.Count,
+		Expand:     req.Expand,
+	}, &rows)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pkg_grpc.ListRecordsResponse{}
+	for _, row := range rows {
+		record, err := structpb.NewStruct(row)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		res.Records = append(res.Records, record)
+	}
+	if total, err := strconv.ParseInt(header.Get("X-Total-Count"), 10, 64); err == nil {
+		res.Total = &total
+	}
+
+	return res, nil
+}
+
+func (s *GRPCServer) Get(ctx context.Context, req *pkg_grpc.GetRecordRequest) (*structpb.Struct, error) {
+	return s.callStruct(ctx, http.MethodGet, "/main/"+url.PathEscape(req.Table)+"/"+url.PathEscape(req.Id), nil)
+}
+
+func (s *GRPCServer) Create(ctx context.Context, req *pkg_grpc.CreateRecordRequest) (*structpb.Struct, error) {
+	return s.callStruct(ctx, http.MethodPost, "/main/"+url.PathEscape(req.Table)+"/insert", insertDataReq{
+		Data: req.Data.AsMap(),
+	})
+}
+
+func (s *GRPCServer) Update(ctx context.Context, req *pkg_grpc.UpdateRecordRequest) (*structpb.Struct, error) {
+	return s.callStruct(ctx, http.MethodPut, "/main/"+url.PathEscape(req.Table)+"/update", updateDataReq{
+		ID:   req.Id,
+		Data: req.Data.AsMap(),
+	})
+}
+
+func (s *GRPCServer) Delete(ctx context.Context, req *pkg_grpc.DeleteRecordsRequest) (*pkg_grpc.DeleteRecordsResponse, error) {
+	_, err := s.call(ctx, http.MethodDelete, "/main/"+url.PathEscape(req.Table)+"/rows", deleteDataReq{ID: req.Ids}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkg_grpc.DeleteRecordsResponse{}, nil
+}
+
+func (s *GRPCServer) Register(ctx context.Context, req *pkg_grpc.RegisterRequest) (*structpb.Struct, error) {
+	return s.callStruct(ctx, http.MethodPost, "/auth/register/"+url.PathEscape(req.Table), registerReq{
+		Data:         req.Data.AsMap(),
+		ReturnsToken: req.ReturnsToken,
+	})
+}
+
+func (s *GRPCServer) Login(ctx context.Context, req *pkg_grpc.LoginRequest) (*structpb.Struct, error) {
+	return s.callStruct(ctx, http.MethodPost, "/auth/login/"+url.PathEscape(req.Table), loginReq{
+		Data: req.Data.AsMap(),
+		Code: req.Code,
+	})
+}
+
+func (s *GRPCServer) Invoke(ctx context.Context, req *pkg_grpc.InvokeRequest) (*structpb.Value, error) {
+	var result interface{}
+	_, err := s.call(ctx, http.MethodPost, "/"+url.PathEscape(req.Name), Caller{Data: req.Data.AsMap()}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := structpb.NewValue(result)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return value, nil
+}
+
+func (s *GRPCServer) callStruct(ctx context.Context, method string, path string, body interface{}) (*structpb.Struct, error) {
+	var result map[string]interface{}
+	if _, err := s.call(ctx, method, path, body, &result); err != nil {
+		return nil, err
+	}
+
+	record, err := structpb.NewStruct(result)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return record, nil
+}
+
+// call runs the REST route and decodes its response into result. A failed
+// request is turned into the status of its HTTP code
+func (s *GRPCServer) call(ctx context.Context, method string, path string, body interface{}, result interface{}) (http.Header, error) {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "/api"+path, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	req.RequestURI = req.URL.RequestURI()
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range grpcHeaders {
+			if values := md.Get(key); len(values) > 0 {
+				req.Header.Set(key, values[0])
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	res := &grpcResponse{header: http.Header{}}
+	s.app.ServeHTTP(res, req)
+
+	if res.status >= http.StatusBadRequest {
+		return nil, status.Error(grpcCode(res.status), grpcErrorMessage(res))
+	}
+	if result != nil && res.body.Len() > 0 {
+		if err := json.Unmarshal(res.body.Bytes(), result); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return res.header, nil
+}
+
+// grpcResponse records the response of a REST handler
+type grpcResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *grpcResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *grpcResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *grpcResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if httpStatus >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+
+	return codes.Unknown
+}
+
+// grpcErrorMessage reads the message of the error bodies, or returns the body
+// as is
+func grpcErrorMessage(res *grpcResponse) string {
+	var body map[string]interface{}
+	if json.Unmarshal(res.body.Bytes(), &body) == nil {
+		if message, ok := body["message"].(string); ok {
+			return message
+		}
+	}
+
+	if message := strings.TrimSpace(res.body.String()); message != "" {
+		return message
+	}
+
+	return fmt.Sprintf("request failed with status %d", res.status)
+}
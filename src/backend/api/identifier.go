@@ -0,0 +1,19 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// identifierPattern matches the names the queries of the API take unquoted
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateIdentifier checks a name written into a statement, such as the
+// name of a table, a view or a column
+func validateIdentifier(kind string, name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("%s must be letters, digits and underscores, not starting with a digit", kind)
+	}
+
+	return nil
+}
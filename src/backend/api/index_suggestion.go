@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// planScanPattern matches the steps of a query plan reading a whole table,
+// older SQLite versions name them SCAN TABLE
+var planScanPattern = regexp.MustCompile(`^SCAN (?:TABLE )?(\S+)(.*)$`)
+
+// fullScan tells whether the plan of the statement reads every row of the
+// table without an index
+func fullScan(db *gorm.DB, tableName string, statement string, vars []interface{}) (bool, error) {
+	rows, err := db.Raw("EXPLAIN QUERY PLAN "+statement, vars...).Rows()
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, parent, unused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return false, err
+		}
+		match := planScanPattern.FindStringSubmatch(detail)
+		if match != nil && strings.EqualFold(strings.Trim(match[1], "`\""), tableName) && !strings.Contains(match[2], "USING") {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// suggestIndex records an index on the filtered columns of a read of the rows
+// which ran longer than the slow query threshold, when it scanned the whole
+// table. The plan is explained in the background, after the response
+func suggestIndex(read *gorm.DB, write *gorm.DB, tableName string, filters []Filter, query *gorm.DB, duration time.Duration) {
+	threshold := time.Duration(config.GetInstance().SlowQueryThresholdMs) * time.Millisecond
+	if threshold <= 0 || duration < threshold || len(filters) == 0 {
+		return
+	}
+
+	seen := map[string]bool{}
+	columns := []string{}
+	for _, filter := range filters {
+		if filter.Column != "" && !seen[filter.Column] {
+			seen[filter.Column] = true
+			columns = append(columns, filter.Column)
+		}
+	}
+	sort.Strings(columns)
+
+	// gorm resets the statement once it ran, it is built again without
+	// running it
+	dryRun := query.Session(&gorm.Session{DryRun: true}).Find(&[]map[string]interface{}{})
+	statement := dryRun.Statement.SQL.String()
+	vars := dryRun.Statement.Vars
+
+	go func() {
+		ctx := context.Background()
+		scan, err := fullScan(read.Session(&gorm.Session{NewDB: true, Context: ctx}), tableName, statement, vars)
+		if err != nil || !scan {
+			return
+		}
+
+		now := time.Now().UTC()
+		durationMs := float64(duration.Microseconds()) / 1000
+		err = write.Session(&gorm.Session{NewDB: true, Context: ctx}).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "table"}, {Name: "columns"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"occurrences":     gorm.Expr("occurrences + 1"),
+				"max_duration_ms": gorm.Expr("MAX(max_duration_ms, excluded.max_duration_ms)"),
+				"last_seen_at":    gorm.Expr("excluded.last_seen_at"),
+			}),
+		}).Create(&model.IndexSuggestion{
+			Table:         tableName,
+			Columns:       strings.Join(columns, ","),
+			Occurrences:   1,
+			MaxDurationMs: durationMs,
+			FirstSeenAt:   now,
+			LastSeenAt:    now,
+		}).Error
+		if err != nil {
+			log.Printf("failed to record an index suggestion for %s: %v\n", tableName, err)
+		}
+	}()
+}
+
+// indexSuggestion is a suggestion along with the statement creating the index
+type indexSuggestion struct {
+	model.IndexSuggestion
+	Statement string `json:"statement"`
+}
+
+// indexed tells whether an index of the table starts with the columns, in any
+// order, so the suggestion was followed
+func indexed(indexes []indexStats, columns []string) bool {
+	for _, index := range indexes {
+		if len(index.Columns) < len(columns) {
+			continue
+		}
+		leading := append([]string{}, index.Columns[:len(columns)]...)
+		sort.Strings(leading)
+		if strings.Join(leading, ",") == strings.Join(columns, ",") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FetchIndexSuggestions lists the indexes the slow reads of the rows would
+// have used, the most frequent first. The suggestions followed since, or
+// whose table was deleted, are left out
+func (s *StatsAPIImpl) FetchIndexSuggestions(c echo.Context) error {
+	suggestions := []model.IndexSuggestion{}
+	if err := s.db.Order("occurrences DESC, max_duration_ms DESC").Find(&suggestions).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	result := []indexSuggestion{}
+	indexes := map[string][]indexStats{}
+	for _, suggestion := range suggestions {
+		if _, err := getTableInfo(s.db, suggestion.Table); err != nil {
+			continue
+		}
+		if _, ok := indexes[suggestion.Table]; !ok {
+			tableIndexes, err := fetchIndexStats(s.db, suggestion.Table)
+			if err != nil {
+				return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+			}
+			indexes[suggestion.Table] = tableIndexes
+		}
+
+		columns := strings.Split(suggestion.Columns, ",")
+		if indexed(indexes[suggestion.Table], columns) {
+			continue
+		}
+
+		result = append(result, indexSuggestion{
+			IndexSuggestion: suggestion,
+			Statement: fmt.Sprintf("CREATE INDEX idx_%s_%s ON %s (%s)",
+				suggestion.Table, strings.Join(columns, "_"), suggestion.Table, strings.Join(columns, ", ")),
+		})
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// ClearIndexSuggestions forgets the index suggestions
+func (s *StatsAPIImpl) ClearIndexSuggestions(c echo.Context) error {
+	result := s.db.Where("1 = 1").Delete(&model.IndexSuggestion{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deleted": result.RowsAffected,
+	})
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxLockout = 24 * time.Hour
+
+	// an IP may fail for several accounts before being locked out
+	ipAttemptsMultiplier = 4
+)
+
+func loginLimits() (maxAttempts int, lockout time.Duration) {
+	appConfig := config.GetInstance()
+
+	maxAttempts = appConfig.LoginMaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+
+	minutes := appConfig.LoginLockoutMinutes
+	if minutes <= 0 {
+		minutes = 15
+	}
+
+	return maxAttempts, time.Duration(minutes) * time.Minute
+}
+
+// loginAttemptKeys identifies the account being logged in to and the IP
+// trying to log in
+func loginAttemptKeys(c echo.Context, scope string, email string) []string {
+	return []string{
+		fmt.Sprintf("%s:%s", scope, strings.ToLower(strings.TrimSpace(email))),
+		fmt.Sprintf("ip:%s", c.RealIP()),
+	}
+}
+
+func isIPKey(key string) bool {
+	return strings.HasPrefix(key, "ip:")
+}
+
+// nextLockout returns how long a key stays locked after its nth failure.
+// Before the threshold a short exponential backoff is applied, after it the
+// lockout doubles with every failure
+func nextLockout(key string, failures int) time.Duration {
+	maxAttempts, lockout := loginLimits()
+	if isIPKey(key) {
+		maxAttempts *= ipAttemptsMultiplier
+	}
+
+	var wait time.Duration
+	if failures < maxAttempts {
+		wait = time.Duration(math.Pow(2, float64(failures-1))) * time.Second
+	} else {
+		wait = lockout * time.Duration(math.Pow(2, math.Min(float64(failures-maxAttempts), 10)))
+	}
+
+	if wait > maxLockout {
+		wait = maxLockout
+	}
+
+	return wait
+}
+
+func attemptsRemaining(key string, failures int) int {
+	maxAttempts, _ := loginLimits()
+	if isIPKey(key) {
+		maxAttempts *= ipAttemptsMultiplier
+	}
+
+	return int(math.Max(float64(maxAttempts-failures), 0))
+}
+
+func loginAttemptKey(key string) string {
+	return "login_attempt:" + key
+}
+
+// fetchLoginAttempt reads the attempts of key from the cache, so instances
+// sharing it lock out the same accounts and IPs
+func fetchLoginAttempt(key string) (model.LoginAttempt, error) {
+	attempt := model.LoginAttempt{Key: key}
+	if _, err := Cache.Get(loginAttemptKey(key), &attempt); err != nil {
+		return attempt, err
+	}
+
+	return attempt, nil
+}
+
+// saveLoginAttempt keeps the failures of key until a day after its lockout
+// ends, after which the count starts over
+func saveLoginAttempt(attempt model.LoginAttempt) error {
+	attempt.UpdatedAt = time.Now()
+
+	return Cache.Set(loginAttemptKey(attempt.Key), attempt, time.Until(attempt.LockedUntil)+maxLockout)
+}
+
+func lockedResponse(c echo.Context, lockedUntil time.Time) error {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	c.Response().Header().Set("Retry-After", fmt.Sprint(retryAfter))
+
+	return pkg_apierror.Write(c, http.StatusTooManyRequests, pkg_apierror.Response{
+		Message: "too many failed login attempts",
+		Details: map[string]interface{}{
+			"locked":       true,
+			"locked_until": lockedUntil,
+			"retry_after":  retryAfter,
+		},
+	})
+}
+
+// loginLockedUntil returns when the lockout of keys ends, or the zero time
+// when none of them is locked
+func loginLockedUntil(keys []string) (time.Time, error) {
+	lockedUntil := time.Time{}
+	for _, key := range keys {
+		attempt, err := fetchLoginAttempt(key)
+		if err != nil {
+			return lockedUntil, err
+		}
+
+		if time.Now().Before(attempt.LockedUntil) && attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = attempt.LockedUntil
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+// loginFailed records a failed attempt for every key and writes the
+// unauthorized response along with the remaining attempts of the account
+func loginFailed(c echo.Context, keys []string, message string) error {
+	remaining := -1
+	for _, key := range keys {
+		attempt, err := fetchLoginAttempt(key)
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+
+		attempt.Failures++
+		attempt.LockedUntil = time.Now().Add(nextLockout(key, attempt.Failures))
+		if err := saveLoginAttempt(attempt); err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+
+		if !isIPKey(key) {
+			remaining = attemptsRemaining(key, attempt.Failures)
+		}
+	}
+
+	response := pkg_apierror.Response{Message: message}
+	if remaining >= 0 {
+		response.Details = map[string]interface{}{"attempts_remaining": remaining}
+	}
+
+	return pkg_apierror.Write(c, http.StatusUnauthorized, response)
+}
+
+// clearLoginAttempts resets the account keys after a successful login, IP
+// keys are kept so logging in to another account doesn't reset them
+func clearLoginAttempts(keys []string) {
+	for _, key := range keys {
+		if !isIPKey(key) {
+			if err := Cache.Delete(loginAttemptKey(key)); err != nil {
+				log.Printf("failed to clear the login attempts of %s: %v\n", key, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+const (
+	MAINTENANCE_VACUUM     = "vacuum"
+	MAINTENANCE_ANALYZE    = "analyze"
+	MAINTENANCE_CHECKPOINT = "checkpoint"
+)
+
+type MaintenanceAPI interface {
+	RunMaintenance(c echo.Context) error
+	CheckIntegrity(c echo.Context) error
+}
+
+type MaintenanceAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewMaintenanceAPI(ioc di.Container) MaintenanceAPI {
+	return &MaintenanceAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+// MaintenanceResult is the size of the database file and of its WAL before
+// and after a maintenance operation
+type MaintenanceResult struct {
+	Operation     string `json:"operation"`
+	SizeBefore    int64  `json:"size_before"`
+	SizeAfter     int64  `json:"size_after"`
+	WALSizeBefore int64  `json:"wal_size_before"`
+	WALSizeAfter  int64  `json:"wal_size_after"`
+	// Reclaimed is how many bytes the file and its WAL shrank by together
+	Reclaimed  int64   `json:"reclaimed"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Maintain runs a maintenance operation on the database: vacuum rebuilds the
+// file without its free pages, analyze refreshes the statistics of the query
+// planner and checkpoint copies the WAL into the file and truncates it
+func Maintain(db *gorm.DB, operation string) (MaintenanceResult, error) {
+	var run func(*gorm.DB) error
+	switch operation {
+	case MAINTENANCE_VACUUM:
+		run = pkg_sqlite.Vacuum
+	case MAINTENANCE_ANALYZE:
+		run = pkg_sqlite.Analyze
+	case MAINTENANCE_CHECKPOINT:
+		run = pkg_sqlite.Checkpoint
+	default:
+		return MaintenanceResult{}, fmt.Errorf("operation must be %s, %s or %s", MAINTENANCE_VACUUM, MAINTENANCE_ANALYZE, MAINTENANCE_CHECKPOINT)
+	}
+
+	path := os.Getenv("DB_PATH")
+	result := MaintenanceResult{
+		Operation:     operation,
+		SizeBefore:    fileSize(path),
+		WALSizeBefore: fileSize(path + "-wal"),
+	}
+
+	start := time.Now()
+	err := run(db)
+	result.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	result.SizeAfter = fileSize(path)
+	result.WALSizeAfter = fileSize(path + "-wal")
+	result.Reclaimed = result.SizeBefore + result.WALSizeBefore - result.SizeAfter - result.WALSizeAfter
+
+	return result, err
+}
+
+// RunMaintenance runs the maintenance operation of the path on the database
+// and reports the size of its file before and after, the file only grows
+// otherwise
+func (m *MaintenanceAPIImpl) RunMaintenance(c echo.Context) error {
+	operation := c.Param("operation")
+
+	result, err := Maintain(m.db.WithContext(c.Request().Context()), operation)
+	if errors.Is(err, pkg_sqlite.ErrCheckpointBusy) {
+		return pkg_apierror.Error(c, http.StatusConflict, err)
+	}
+	if err != nil && result.Operation == "" {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(m.db, c, model.ACTIVITY_MAINTENANCE, operation, fmt.Sprintf("reclaimed %d bytes", result.Reclaimed))
+
+	return c.JSON(http.StatusOK, result)
+}
+
+type integrityCheckReq struct {
+	// Quick skips checking the indexes against the rows, which takes as long
+	// as the rest of the check on large databases
+	Quick bool `query:"quick"`
+}
+
+// CheckIntegrity looks for corruption and for rows referencing missing rows,
+// the findings are returned with ok unset when there are any
+func (m *MaintenanceAPIImpl) CheckIntegrity(c echo.Context) error {
+	var params *integrityCheckReq = new(integrityCheckReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	report, err := pkg_sqlite.CheckIntegrity(m.db.WithContext(c.Request().Context()), params.Quick)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
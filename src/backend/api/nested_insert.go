@@ -0,0 +1,283 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxNestedDepth is how deep the children of a nested insert can go, a
+// parent with children having their own children is 3 levels deep
+const maxNestedDepth = 3
+
+// nestedInputError is a nested insert which can't be run as sent
+type nestedInputError struct {
+	Path   string
+	Reason string
+}
+
+func (e *nestedInputError) Error() string {
+	if e.Path == "" {
+		return e.Reason
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Reason)
+}
+
+// invalidValuesError holds the values of a nested insert rejected by the
+// validators of their columns, by the path of the values
+type invalidValuesError struct {
+	Fields fieldErrors
+}
+
+func (e *invalidValuesError) Error() string {
+	return "invalid values"
+}
+
+// nestedInsertErrorStatus returns the status of the errors of nested inserts
+func nestedInsertErrorStatus(err error) int {
+	var input *nestedInputError
+	if errors.As(err, &input) {
+		return http.StatusBadRequest
+	}
+
+	return fileErrorStatus(err)
+}
+
+// nestedRow is a row written by a nested insert, its files are attached and
+// its change published once every row is written
+type nestedRow struct {
+	table string
+	id    string
+	data  map[string]interface{}
+}
+
+// nestedRelation returns the child table of a key of a nested insert and its
+// column referencing the parent table. The key is the child table, such as
+// comments, or the child table along with the column when it references the
+// parent more than once, such as comments(post_id)
+func nestedRelation(db *gorm.DB, parent string, key string) (model.Tables, string, error) {
+	tableName, column := key, ""
+	if open := strings.Index(key, "("); open > 0 && strings.HasSuffix(key, ")") {
+		tableName, column = key[:open], strings.TrimSpace(key[open+1:len(key)-1])
+	}
+
+	table, err := getTableInfo(db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return table, "", &nestedInputError{Path: key, Reason: "is neither a column nor a table"}
+	}
+	if err != nil {
+		return table, "", err
+	}
+	if table.IsAuth || table.IsView {
+		return table, "", &nestedInputError{Path: key, Reason: "can't be inserted into"}
+	}
+
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return table, "", err
+	}
+	references := []string{}
+	for _, c := range columns {
+		if c.Reference == parent && (column == "" || c.Name == column) {
+			references = append(references, c.Name)
+		}
+	}
+
+	switch {
+	case len(references) == 0 && column != "":
+		return table, "", &nestedInputError{Path: key, Reason: fmt.Sprintf("%s doesn't reference %s", column, parent)}
+	case len(references) == 0:
+		return table, "", &nestedInputError{Path: key, Reason: fmt.Sprintf("%s has no relation to %s", tableName, parent)}
+	case len(references) > 1:
+		sort.Strings(references)
+		return table, "", &nestedInputError{Path: key, Reason: fmt.Sprintf("%s references %s through %s, name one as %s(column)", tableName, parent, strings.Join(references, ", "), tableName)}
+	}
+
+	return table, references[0], nil
+}
+
+// insertNested writes a row of table along with the rows of the arrays of
+// its data which aren't columns, as children referencing it. The row is
+// returned as written, its children under their keys
+func (d *DatabaseAPIImpl) insertNested(tx *gorm.DB, c echo.Context, table model.Tables, data map[string]interface{}, path string, depth int, written *[]nestedRow) (map[string]interface{}, error) {
+	columns, err := fetchColumns(tx, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	isColumn := map[string]bool{}
+	for _, column := range columns {
+		isColumn[column.Name] = true
+	}
+
+	values := map[string]interface{}{}
+	children := map[string][]interface{}{}
+	for k, v := range data {
+		rows, isArray := v.([]interface{})
+		switch {
+		case !isColumn[k] && !isArray:
+			return nil, &nestedInputError{Path: path + k, Reason: "is not a column"}
+		case isColumn[k]:
+			if k == "id" && (v == 0 || v == "") {
+				continue
+			}
+			if v == nil || v == "" {
+				continue
+			}
+			value, err := encodeGeoPoint(v)
+			if err != nil {
+				return nil, &nestedInputError{Path: path + k, Reason: err.Error()}
+			}
+			values[k] = value
+		case depth >= maxNestedDepth:
+			return nil, &nestedInputError{Path: path + k, Reason: fmt.Sprintf("children can't be nested more than %d levels deep", maxNestedDepth)}
+		default:
+			children[k] = rows
+		}
+	}
+
+	if err := removeGeneratedColumns(tx, table.Name, values); err != nil {
+		return nil, err
+	}
+	errs, err := validateRow(tx, table.Name, values, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		fields := fieldErrors{}
+		for column, message := range errs {
+			fields[path+column] = message
+		}
+		return nil, &invalidValuesError{Fields: fields}
+	}
+	if err := prepareFileColumns(tx, table.Name, "", values); err != nil {
+		return nil, err
+	}
+	if err := validateFiles(c.Request().Context(), tx, d.storage, table.Name, "", currentUserID(c), values); err != nil {
+		return nil, err
+	}
+
+	// the rows are owned by the users inserting them, admins may set the
+	// owner of a row
+	if table.OwnerColumn != "" && !isAdmin(c) {
+		values[table.OwnerColumn] = currentUserID(c)
+	}
+
+	if err := assignRowID(table, values); err != nil {
+		return nil, err
+	}
+	if err := createRow(tx, table.Name, values); err != nil {
+		return nil, err
+	}
+	id := values["id"]
+	*written = append(*written, nestedRow{table: table.Name, id: fmt.Sprint(id), data: values})
+
+	result := map[string]interface{}{}
+	for k, v := range values {
+		result[k] = v
+	}
+	// the rowid gorm sets on the maps it creates
+	delete(result, "@id")
+
+	keys := make([]string, 0, len(children))
+	for key := range children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child, column, err := nestedRelation(tx, table.Name, key)
+		if err != nil {
+			return nil, err
+		}
+
+		inserted := []map[string]interface{}{}
+		for i, row := range children[key] {
+			childPath := fmt.Sprintf("%s%s[%d].", path, key, i)
+			childData, ok := row.(map[string]interface{})
+			if !ok {
+				return nil, &nestedInputError{Path: strings.TrimSuffix(childPath, "."), Reason: "must be an object"}
+			}
+			childData[column] = id
+
+			childRow, err := d.insertNested(tx, c, child, childData, childPath, depth+1, written)
+			if err != nil {
+				return nil, err
+			}
+			inserted = append(inserted, childRow)
+		}
+		result[key] = inserted
+	}
+
+	return result, nil
+}
+
+// InsertNested inserts a row along with children in other tables, in a
+// single transaction. The arrays of the data which aren't columns hold the
+// children, keyed by their table or by their table and the column
+// referencing the parent, as in
+//
+//	{"data": {"title": "post", "comments(post_id)": [{"body": "first"}]}}
+//
+// The column is set to the id of the parent. Children can have their own
+// children, the rows are returned as written along with their ids
+func (d *DatabaseAPIImpl) InsertNested(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var params *insertDataReq = new(insertDataReq)
+	if err := c.Bind(&params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if table.IsAuth {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "Insertion to user type table can only be done through auth API")
+	}
+	if table.IsView {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "view is read-only")
+	}
+
+	var (
+		row     map[string]interface{}
+		written []nestedRow
+	)
+	err = d.db.WithContext(c.Request().Context()).Transaction(func(tx *gorm.DB) error {
+		row, err = d.insertNested(tx, c, table, params.Data, "", 1, &written)
+		return err
+	})
+	var invalid *invalidValuesError
+	if errors.As(err, &invalid) {
+		return pkg_apierror.Write(c, http.StatusUnprocessableEntity, pkg_apierror.Response{
+			Code:    pkg_apierror.CODE_VALIDATION_FAILED,
+			Message: "invalid values",
+			Fields:  invalid.Fields,
+		})
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, nestedInsertErrorStatus(err), err)
+	}
+
+	ids := map[string][]string{}
+	tables := []string{}
+	for _, w := range written {
+		attachFiles(c.Request().Context(), d.db, d.storage, w.table, w.id, w.data)
+		if _, ok := ids[w.table]; !ok {
+			tables = append(tables, w.table)
+		}
+		ids[w.table] = append(ids[w.table], w.id)
+	}
+	for _, name := range tables {
+		invalidateRowCounts(name)
+		publishChange(d.db, name, CHANGE_CREATE, changedRows(d.db, name, CHANGE_CREATE, ids[name]))
+	}
+
+	return c.JSON(http.StatusOK, row)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxDecimalPrecision caps the digits of the decimal fields, SQLite keeps 15
+// significant digits of the numbers it doesn't store as integers
+const maxDecimalPrecision = 15
+
+var (
+	decimalTypePattern = regexp.MustCompile(`(?i)^\s*decimal\s*\(\s*(\d+)\s*,\s*(\d+)\s*\)\s*$`)
+	// decimalPattern matches a decimal number without an exponent
+	decimalPattern = regexp.MustCompile(`^[-+]?(\d*)(?:\.(\d*))?$`)
+)
+
+// decimalType reads the precision and the scale of a decimal(p,s) field or
+// column type
+func decimalType(fieldType string) (int, int, bool) {
+	match := decimalTypePattern.FindStringSubmatch(fieldType)
+	if match == nil {
+		return 0, 0, false
+	}
+	precision, _ := strconv.Atoi(match[1])
+	scale, _ := strconv.Atoi(match[2])
+
+	return precision, scale, true
+}
+
+// validateType checks the precision and the scale of a decimal field
+func (f *fields) validateType() error {
+	precision, scale, ok := decimalType(f.FieldType)
+	if !ok {
+		return nil
+	}
+	if precision < 1 || precision > maxDecimalPrecision {
+		return fmt.Errorf("precision of %s must be between 1 and %d", f.FieldName, maxDecimalPrecision)
+	}
+	if scale > precision {
+		return fmt.Errorf("scale of %s must not be greater than its precision", f.FieldName)
+	}
+
+	return nil
+}
+
+// decodeExactNumbers decodes the JSON of a request keeping the numbers of
+// the row data as sent: the integers as int64 and the other numbers as
+// json.Number, a float64 would round them. The nested numbers are float64
+func decodeExactNumbers(data []byte, target interface{}, row *map[string]interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+
+	for column, value := range *row {
+		if number, ok := value.(json.Number); ok {
+			if integer, err := number.Int64(); err == nil {
+				(*row)[column] = integer
+			}
+			continue
+		}
+		(*row)[column] = floatNumbers(value)
+	}
+
+	return nil
+}
+
+// floatNumbers turns the json.Number of a decoded value back into float64
+func floatNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		number, _ := v.Float64()
+		return number
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = floatNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = floatNumbers(item)
+		}
+	}
+
+	return value
+}
+
+func (r *insertDataReq) UnmarshalJSON(data []byte) error {
+	type plain insertDataReq
+	return decodeExactNumbers(data, (*plain)(r), &r.Data)
+}
+
+func (r *updateDataReq) UnmarshalJSON(data []byte) error {
+	type plain updateDataReq
+	return decodeExactNumbers(data, (*plain)(r), &r.Data)
+}
+
+// numberText returns the digits of a number sent as JSON or as the text of a
+// form, without its exponent
+func numberText(value interface{}) (string, bool) {
+	var text string
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case int:
+		return strconv.Itoa(v), true
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		text = v.String()
+	case string:
+		text = strings.TrimSpace(v)
+	default:
+		return "", false
+	}
+
+	if strings.ContainsAny(text, "eE") {
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return "", false
+		}
+		text = strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	if !decimalPattern.MatchString(text) || strings.Trim(text, "+-.") == "" {
+		return "", false
+	}
+
+	return text, true
+}
+
+// validateNumeric returns why a value doesn't fit an integer or a decimal
+// column, or an empty string
+func validateNumeric(columnType string, value interface{}) string {
+	if value == nil || value == "" {
+		return ""
+	}
+
+	precision, scale, isDecimal := decimalType(columnType)
+	if !isDecimal && !strings.EqualFold(columnType, "INTEGER") {
+		return ""
+	}
+
+	text, ok := numberText(value)
+	if !ok {
+		return "must be a number"
+	}
+	match := decimalPattern.FindStringSubmatch(text)
+	whole := strings.TrimLeft(match[1], "0")
+	fraction := strings.TrimRight(match[2], "0")
+
+	if !isDecimal {
+		if fraction != "" {
+			return "must be an integer"
+		}
+		if _, err := strconv.ParseInt(strings.TrimSuffix(text, "."+match[2]), 10, 64); err != nil {
+			return "must fit in 64 bits"
+		}
+		return ""
+	}
+
+	if len(fraction) > scale {
+		return fmt.Sprintf("must have at most %d decimals", scale)
+	}
+	if len(whole) > precision-scale {
+		return fmt.Sprintf("must have at most %d digits before the decimal point", precision-scale)
+	}
+
+	return ""
+}
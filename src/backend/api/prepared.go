@@ -0,0 +1,70 @@
+package api
+
+import (
+	"log"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"sync"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// the statements are only dropped once this many are cached, clients
+// filtering on arbitrary columns would grow the cache without bound otherwise
+const maxPreparedStatements = 500
+
+// the statements are dropped whenever a schema changes too, a statement
+// selecting * would return the old columns on its next run otherwise
+var schemaGeneration atomic.Int64
+
+// readPool is the pool of read-only connections when there is one. Its
+// connections only load a changed schema once a statement runs, so a
+// statement they prepare right after a change would return the old columns
+var readPool *gorm.DB
+
+// schemaChanged drops the prepared statements on their next use and the
+// idle connections of the read pool, the new ones load the current schema
+func schemaChanged() {
+	schemaGeneration.Add(1)
+	if readPool != nil {
+		if err := pkg_sqlite.DropIdleConnections(readPool); err != nil {
+			log.Printf("failed to reset the read pool: %v\n", err)
+		}
+	}
+}
+
+// preparedGenerations holds the schema generation the statements of each
+// pool were prepared at, the writer and the read pool cache their own
+var preparedGenerations sync.Map
+
+// preparedDB reads through statements prepared once and reused, so the hot
+// row endpoints don't parse their query on every request. Statements are
+// keyed by their SQL, which depends on the table, the columns and the shape
+// of the filters since the values are bound
+func preparedDB(db *gorm.DB) *gorm.DB {
+	tx := db.Session(&gorm.Session{PrepareStmt: true})
+
+	if pool, ok := tx.Statement.ConnPool.(*gorm.PreparedStmtDB); ok {
+		value, _ := preparedGenerations.LoadOrStore(pool, new(atomic.Int64))
+		preparedGeneration := value.(*atomic.Int64)
+
+		pool.Mux.RLock()
+		stale := len(pool.Stmts) > maxPreparedStatements || preparedGeneration.Load() != schemaGeneration.Load()
+		pool.Mux.RUnlock()
+
+		if stale {
+			pool.Mux.Lock()
+			preparedGeneration.Store(schemaGeneration.Load())
+			for query, stmt := range pool.Stmts {
+				// statements still being prepared are left to finish
+				if stmt.Stmt != nil {
+					delete(pool.Stmts, query)
+					go stmt.Close()
+				}
+			}
+			pool.Mux.Unlock()
+		}
+	}
+
+	return tx
+}
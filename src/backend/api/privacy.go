@@ -0,0 +1,246 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/service"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type PrivacyAPI interface {
+	ExportUserData(c echo.Context) error
+	ForgetUser(c echo.Context) error
+}
+
+type PrivacyAPIImpl struct {
+	db      *gorm.DB
+	storage service.StorageService
+}
+
+func NewPrivacyAPI(ioc di.Container) PrivacyAPI {
+	return &PrivacyAPIImpl{
+		db:      ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		storage: ioc.Get(constants.CONTAINER_STORAGE_NAME).(service.StorageService),
+	}
+}
+
+// ownedTables returns the tables whose owner column holds the ids of the
+// users of authTable. Owner columns referencing another table are skipped
+func ownedTables(db *gorm.DB, authTable string) ([]model.Tables, error) {
+	var tables []model.Tables
+	err := db.Model(&model.Tables{}).
+		Where("is_system = ? AND is_view = ?", false, false).
+		Where("owner_column <> ''").
+		Where("name <> ?", authTable).
+		Order("name").
+		Find(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []model.Tables{}
+	for _, table := range tables {
+		columns, err := fetchColumns(db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range columns {
+			if column.Name == table.OwnerColumn && (column.Reference == "" || column.Reference == authTable) {
+				owned = append(owned, table)
+				break
+			}
+		}
+	}
+
+	return owned, nil
+}
+
+// userData is everything tied to a user, as exported
+type userData struct {
+	User     map[string]interface{}
+	Tables   map[string][]map[string]interface{}
+	Sessions []model.Session
+	Tokens   []model.UserToken
+	Roles    []string
+	Files    []model.File
+}
+
+// collectUserData gathers the auth record of a user, without its secrets,
+// the rows owned by the user in every table, along with their sessions,
+// tokens, roles and the files they uploaded or which are attached to their
+// rows
+func collectUserData(db *gorm.DB, c echo.Context, table model.Tables, userID string) (userData, error) {
+	data := userData{Tables: map[string][]map[string]interface{}{}, Files: []model.File{}}
+
+	err := db.Table(table.Name).Where("id = ?", userID).Take(&data.User).Error
+	if err != nil {
+		return data, err
+	}
+	if err := stripRestrictedColumns(db, c, table, []map[string]interface{}{data.User}); err != nil {
+		return data, err
+	}
+
+	tables, err := ownedTables(db, table.Name)
+	if err != nil {
+		return data, err
+	}
+
+	files := map[string]model.File{}
+	for _, owned := range tables {
+		rows := []map[string]interface{}{}
+		err := db.Table(owned.Name).Where(fmt.Sprintf("`%s` = ?", owned.OwnerColumn), userID).Find(&rows).Error
+		if err != nil {
+			return data, err
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		if err := stripRestrictedColumns(db, c, owned, rows); err != nil {
+			return data, err
+		}
+		data.Tables[owned.Name] = rows
+
+		ids := make([]string, 0, len(rows))
+		for _, row := range rows {
+			ids = append(ids, fmt.Sprint(row["id"]))
+		}
+		var attached []model.File
+		if err := db.Where("`table` = ? AND row_id IN ?", owned.Name, ids).Find(&attached).Error; err != nil {
+			return data, err
+		}
+		for _, file := range attached {
+			files[file.Key] = file
+		}
+	}
+
+	var uploaded []model.File
+	err = db.Where("uploaded_by = ?", userID).
+		Or("`table` = ? AND row_id = ?", table.Name, userID).
+		Find(&uploaded).Error
+	if err != nil {
+		return data, err
+	}
+	for _, file := range uploaded {
+		files[file.Key] = file
+	}
+	for _, file := range files {
+		data.Files = append(data.Files, file)
+	}
+
+	err = db.Where("`table` = ? AND user_id = ?", table.Name, userID).Order("created_at").Find(&data.Sessions).Error
+	if err != nil {
+		return data, err
+	}
+	err = db.Where("`table` = ? AND user_id = ?", table.Name, userID).Order("created_at").Find(&data.Tokens).Error
+	if err != nil {
+		return data, err
+	}
+	roles, err := fetchUserRoles(db, table.Name, userID)
+	data.Roles = append([]string{}, roles...)
+
+	return data, err
+}
+
+// ExportUserData sends a zip of everything tied to a user: their auth record
+// without its secrets, the rows they own in every table, their sessions,
+// tokens, roles and files
+func (p *PrivacyAPIImpl) ExportUserData(c echo.Context) error {
+	tableName := c.Param("table_name")
+	userID := c.Param("user_id")
+
+	table, err := getTableInfo(p.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !table.IsAuth {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+	}
+
+	data, err := collectUserData(p.db, c, table, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "user does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(p.db, c, model.ACTIVITY_EXPORT_USER_DATA, tableName, userID)
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s.zip"`, tableName, userID))
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	documents := map[string]interface{}{
+		"user.json": data.User,
+		"export.json": map[string]interface{}{
+			"table":       tableName,
+			"user_id":     userID,
+			"exported_at": time.Now().UTC(),
+		},
+		"sessions.json": data.Sessions,
+		"tokens.json":   data.Tokens,
+		"roles.json":    data.Roles,
+		"files.json":    data.Files,
+	}
+	for name, rows := range data.Tables {
+		documents[path.Join("tables", name+".json")] = rows
+	}
+	for name, document := range documents {
+		w, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(document); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range data.Files {
+		if err := p.archiveFile(c, archive, file); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// archiveFile adds the content of a file to the export, files missing from
+// the storage are left out
+func (p *PrivacyAPIImpl) archiveFile(c echo.Context, archive *zip.Writer, file model.File) error {
+	content, _, err := p.storage.Open(c.Request().Context(), file.Key)
+	if errors.Is(err, service.ErrFileNotFound) {
+		log.Printf("file %s of the user data export is missing from the storage\n", file.Key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	w, err := archive.Create(path.Join("files", file.Key, path.Base(file.Name)))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+
+	return err
+}
@@ -0,0 +1,673 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/events"
+	"react-golang/src/backend/middleware"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+// the actions of the change events
+const (
+	CHANGE_CREATE = "create"
+	CHANGE_UPDATE = "update"
+	CHANGE_DELETE = "delete"
+)
+
+const (
+	// the connections must authenticate this soon after being opened
+	realtimeAuthTimeout  = 10 * time.Second
+	realtimePingInterval = 30 * time.Second
+	realtimePongTimeout  = 60 * time.Second
+	realtimeWriteTimeout = 10 * time.Second
+
+	// a client which doesn't keep up with its events is disconnected once
+	// this many are waiting
+	realtimeSendBuffer       = 256
+	realtimeMaxSubscriptions = 50
+	realtimeMaxMessageSize   = 64 << 10
+
+	// the clients are told to reconnect after this many seconds when the
+	// server shuts down
+	realtimeReconnectDelay = 5
+)
+
+type RealtimeAPI interface {
+	Connect(c echo.Context) error
+}
+
+type RealtimeAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewRealtimeAPI(ioc di.Container) RealtimeAPI {
+	return &RealtimeAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+var realtimeUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get(echo.HeaderOrigin)
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+			return true
+		}
+
+		return middleware.AllowedOrigin(origin)
+	},
+}
+
+// realtimeMessage is sent by the clients
+type realtimeMessage struct {
+	Type    string   `json:"type"`
+	ID      string   `json:"id"`
+	Token   string   `json:"token"`
+	Table   string   `json:"table"`
+	Filters []Filter `json:"filters"`
+	// Filter is an expression in the PocketBase syntax, see filterExpr
+	Filter string `json:"filter"`
+}
+
+// realtimeEvent is sent to the clients, the replies to their messages carry
+// the id of the subscription they are about
+type realtimeEvent struct {
+	Type   string                 `json:"type"`
+	ID     string                 `json:"id,omitempty"`
+	Action string                 `json:"action,omitempty"`
+	Table  string                 `json:"table,omitempty"`
+	Record map[string]interface{} `json:"record,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	// RetryAfter is the number of seconds to wait before reconnecting, sent
+	// along with the reconnect event when the server shuts down
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+type realtimeSubscription struct {
+	id      string
+	table   string
+	filters []Filter
+	expr    filterExpr
+}
+
+type realtimeClient struct {
+	send      chan realtimeEvent
+	done      chan struct{}
+	closeOnce sync.Once
+	// restart is set when the server shuts down, the client is told to
+	// reconnect rather than closed normally
+	restart atomic.Bool
+
+	mu sync.RWMutex
+	// user holds the claims of the token the client authenticated with, in
+	// a context of its own as the events are filtered from another goroutine
+	user          echo.Context
+	token         string
+	subscriptions map[string]realtimeSubscription
+}
+
+func (client *realtimeClient) close() {
+	client.closeOnce.Do(func() {
+		close(client.done)
+	})
+}
+
+// deliver queues an event without waiting, a client too slow to take it is
+// disconnected rather than holding up the others
+func (client *realtimeClient) deliver(event realtimeEvent) {
+	select {
+	case client.send <- event:
+	case <-client.done:
+	default:
+		client.close()
+	}
+}
+
+func (client *realtimeClient) authenticated() (echo.Context, bool) {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	return client.user, client.user != nil
+}
+
+// authenticate checks token as the Authorization header of a request would
+// be, the claims of the client are only replaced when it is valid
+func (client *realtimeClient) authenticate(c echo.Context, token string) bool {
+	user := c.Echo().NewContext(c.Request(), c.Response())
+	if !middleware.Authenticate(user, token) {
+		return false
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.user = user
+	client.token = token
+
+	return true
+}
+
+// stillAuthenticated tells whether the token of the client is still valid,
+// so an expired token or a revoked session ends the connection
+func (client *realtimeClient) stillAuthenticated(c echo.Context) bool {
+	client.mu.RLock()
+	token := client.token
+	client.mu.RUnlock()
+	if token == "" {
+		return true
+	}
+
+	return middleware.Authenticate(c.Echo().NewContext(c.Request(), c.Response()), token)
+}
+
+func (client *realtimeClient) subscriptionsOf(table string) []realtimeSubscription {
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	var subscriptions []realtimeSubscription
+	for _, subscription := range client.subscriptions {
+		if subscription.table == table {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	return subscriptions
+}
+
+// realtimeChange is a change of rows to send to the subscriptions of their
+// table
+type realtimeChange struct {
+	db     *gorm.DB
+	table  string
+	action string
+	rows   []map[string]interface{}
+}
+
+// realtimeHub sends the changes of the rows to the connected clients. The
+// changes are sent one at a time so the clients receive them in order
+type realtimeHub struct {
+	mu      sync.RWMutex
+	clients map[*realtimeClient]bool
+	closing bool
+
+	changes chan realtimeChange
+	start   sync.Once
+}
+
+var realtime = &realtimeHub{
+	clients: map[*realtimeClient]bool{},
+	changes: make(chan realtimeChange, 1024),
+}
+
+// add refuses the clients once the server shuts down
+func (h *realtimeHub) add(client *realtimeClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closing {
+		return false
+	}
+	h.clients[client] = true
+
+	return true
+}
+
+func (h *realtimeHub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+func (h *realtimeHub) remove(client *realtimeClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
+
+// shutdown tells the clients to reconnect and waits until they are gone or
+// ctx is done
+func (h *realtimeHub) shutdown(ctx context.Context) {
+	h.mu.Lock()
+	h.closing = true
+	for client := range h.clients {
+		client.restart.Store(true)
+		client.close()
+	}
+	h.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for h.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watching tells whether a client subscribed to the changes of table, the
+// changed rows are only read when one did
+func (h *realtimeHub) watching(table string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		if len(client.subscriptionsOf(table)) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *realtimeHub) publish(change realtimeChange) {
+	h.start.Do(func() {
+		go h.run()
+	})
+	h.changes <- change
+}
+
+func (h *realtimeHub) run() {
+	for change := range h.changes {
+		h.dispatch(change)
+	}
+}
+
+// dispatch sends the changed rows matching the filters of each subscription,
+// stripped of the columns its client isn't allowed to read
+func (h *realtimeHub) dispatch(change realtimeChange) {
+	table, err := getTableInfo(change.db, change.table)
+	if err != nil {
+		log.Printf("failed to send the changes of %s: %v\n", change.table, err)
+		return
+	}
+
+	h.mu.RLock()
+	clients := make([]*realtimeClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		user, ok := client.authenticated()
+		if !ok {
+			continue
+		}
+
+		for _, subscription := range client.subscriptionsOf(change.table) {
+			for _, row := range change.rows {
+				if !matchFilters(row, subscription.filters) ||
+					subscription.expr != nil && !subscription.expr.match(row) {
+					continue
+				}
+
+				record := maps.Clone(row)
+				if err := stripRestrictedColumns(change.db, user, table, []map[string]interface{}{record}); err != nil {
+					log.Printf("failed to send the changes of %s: %v\n", change.table, err)
+					continue
+				}
+				client.deliver(realtimeEvent{
+					Type:   "event",
+					ID:     subscription.id,
+					Action: change.action,
+					Table:  change.table,
+					Record: record,
+				})
+			}
+		}
+	}
+}
+
+// the events published for the changes of the rows
+var changeEvents = map[string]string{
+	CHANGE_CREATE: events.RECORD_CREATED,
+	CHANGE_UPDATE: events.RECORD_UPDATED,
+	CHANGE_DELETE: events.RECORD_DELETED,
+}
+
+// changedRows reads the rows with the given ids for the change events of
+// their table, nothing is read when no client subscribed to it and no event
+// handler is registered. The deleted rows are read before they are deleted
+func changedRows(db *gorm.DB, tableName string, action string, ids []string) []map[string]interface{} {
+	if len(ids) == 0 || (!realtime.watching(tableName) && !events.Handled(changeEvents[action])) {
+		return nil
+	}
+
+	var rows []map[string]interface{}
+	if err := db.Table(tableName).Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		log.Printf("failed to read the changes of %s: %v\n", tableName, err)
+		return nil
+	}
+
+	return rows
+}
+
+func publishChange(db *gorm.DB, tableName string, action string, rows []map[string]interface{}) {
+	if len(rows) == 0 {
+		return
+	}
+
+	for _, row := range rows {
+		events.Publish(events.Event{Name: changeEvents[action], Table: tableName, Record: row})
+	}
+	if realtime.watching(tableName) {
+		realtime.publish(realtimeChange{db: db, table: tableName, action: action, rows: rows})
+	}
+}
+
+// Connect opens a WebSocket receiving the changes of the rows. The client
+// authenticates with the Authorization header of the handshake or by sending
+// {"type":"auth","token":"..."} first, then subscribes to the changes of a
+// table with {"type":"subscribe","id":"...","table":"...","filters":[...]},
+// the filters being those of FetchRows. The matching rows are sent as
+// {"type":"event","id":"...","action":"create|update|delete","record":{...}}
+// without the columns the user isn't allowed to read. When the server shuts
+// down, the clients receive {"type":"reconnect","retry_after":5} and the
+// connection is closed with the 1012 service restart code
+func (r *RealtimeAPIImpl) Connect(c echo.Context) error {
+	conn, err := realtimeUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader already replied with the error
+		return nil
+	}
+	defer conn.Close()
+
+	client := &realtimeClient{
+		send:          make(chan realtimeEvent, realtimeSendBuffer),
+		done:          make(chan struct{}),
+		subscriptions: map[string]realtimeSubscription{},
+	}
+	if token := c.Request().Header.Get(echo.HeaderAuthorization); token != "" && !client.authenticate(c, token) {
+		closeRealtime(conn, websocket.ClosePolicyViolation, "unauthorized")
+		return nil
+	}
+
+	if !realtime.add(client) {
+		closeRealtime(conn, websocket.CloseServiceRestart, "server restarting")
+		return nil
+	}
+	defer realtime.remove(client)
+	defer client.close()
+
+	go r.write(c, conn, client)
+
+	conn.SetReadLimit(realtimeMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(realtimeAuthTimeout))
+	if _, ok := client.authenticated(); ok {
+		conn.SetReadDeadline(time.Now().Add(realtimePongTimeout))
+	}
+	conn.SetPongHandler(func(string) error {
+		if _, ok := client.authenticated(); ok {
+			conn.SetReadDeadline(time.Now().Add(realtimePongTimeout))
+		}
+		return nil
+	})
+
+	for {
+		_, content, err := conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+
+		var message realtimeMessage
+		if err := json.Unmarshal(content, &message); err != nil {
+			client.deliver(realtimeEvent{Type: "error", Error: "invalid message: " + err.Error()})
+			continue
+		}
+		if err := r.handle(c, conn, client, message); err != nil {
+			client.deliver(realtimeEvent{Type: "error", ID: message.ID, Error: err.Error()})
+		}
+	}
+}
+
+func (r *RealtimeAPIImpl) handle(c echo.Context, conn *websocket.Conn, client *realtimeClient, message realtimeMessage) error {
+	if message.Type == "auth" {
+		if !client.authenticate(c, message.Token) {
+			client.deliver(realtimeEvent{Type: "error", Error: "unauthorized"})
+			client.close()
+			return nil
+		}
+		conn.SetReadDeadline(time.Now().Add(realtimePongTimeout))
+		client.deliver(realtimeEvent{Type: "authenticated"})
+		return nil
+	}
+
+	user, ok := client.authenticated()
+	if !ok {
+		return errors.New("authenticate first")
+	}
+
+	switch message.Type {
+	case "subscribe":
+		if message.ID == "" {
+			return errors.New("id is required")
+		}
+
+		table, err := getTableInfo(r.db, message.Table)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("table %s not found", message.Table)
+		}
+		if err != nil {
+			return err
+		}
+		if table.IsView {
+			return errors.New("views have no change events")
+		}
+		if err := validateFilters(r.db, table, message.Filters); err != nil {
+			return err
+		}
+		for _, filter := range message.Filters {
+			if _, ok := realtimeOperators[strings.ToLower(filter.Operator)]; !ok {
+				return fmt.Errorf("unsupported filter operator %s", filter.Operator)
+			}
+		}
+		expr, err := rowFilterExpr(r.db, user, table, message.Filter)
+		if err != nil {
+			return err
+		}
+		filters := message.Filters
+		if expr != nil {
+			filters = append(append([]Filter{}, message.Filters...), expr.conditions()...)
+		}
+		if err := checkFilterAccess(r.db, user, table.Name, filters); err != nil {
+			return err
+		}
+
+		client.mu.Lock()
+		_, exists := client.subscriptions[message.ID]
+		if !exists && len(client.subscriptions) >= realtimeMaxSubscriptions {
+			client.mu.Unlock()
+			return fmt.Errorf("at most %d subscriptions per connection", realtimeMaxSubscriptions)
+		}
+		client.subscriptions[message.ID] = realtimeSubscription{
+			id:      message.ID,
+			table:   table.Name,
+			filters: message.Filters,
+			expr:    expr,
+		}
+		client.mu.Unlock()
+
+		client.deliver(realtimeEvent{Type: "subscribed", ID: message.ID, Table: table.Name})
+	case "unsubscribe":
+		client.mu.Lock()
+		delete(client.subscriptions, message.ID)
+		client.mu.Unlock()
+
+		client.deliver(realtimeEvent{Type: "unsubscribed", ID: message.ID})
+	default:
+		return fmt.Errorf("unknown message type %s", message.Type)
+	}
+
+	return nil
+}
+
+// write sends the queued events and pings the client, the connection is
+// closed once the client is done or its token no longer valid
+func (r *RealtimeAPIImpl) write(c echo.Context, conn *websocket.Conn, client *realtimeClient) {
+	ticker := time.NewTicker(realtimePingInterval)
+	defer ticker.Stop()
+	defer conn.Close()
+
+	for {
+		select {
+		case event := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(realtimeWriteTimeout))
+			if err := conn.WriteJSON(event); err != nil {
+				client.close()
+				return
+			}
+		case <-ticker.C:
+			if !client.stillAuthenticated(c) {
+				client.close()
+				closeRealtime(conn, websocket.ClosePolicyViolation, "session expired")
+				return
+			}
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(realtimeWriteTimeout)); err != nil {
+				client.close()
+				return
+			}
+		case <-client.done:
+			// the replies queued before the client was closed, such as an
+			// authentication error, are still sent
+			for len(client.send) > 0 {
+				conn.SetWriteDeadline(time.Now().Add(realtimeWriteTimeout))
+				if conn.WriteJSON(<-client.send) != nil {
+					break
+				}
+			}
+			if client.restart.Load() {
+				conn.SetWriteDeadline(time.Now().Add(realtimeWriteTimeout))
+				conn.WriteJSON(realtimeEvent{Type: "reconnect", RetryAfter: realtimeReconnectDelay})
+				closeRealtime(conn, websocket.CloseServiceRestart, "server restarting")
+				return
+			}
+			closeRealtime(conn, websocket.CloseNormalClosure, "")
+			return
+		}
+	}
+}
+
+func closeRealtime(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(realtimeWriteTimeout))
+}
+
+// realtimeOperators are the filter operators of the subscriptions, which are
+// matched against the changed rows rather than run in SQL
+var realtimeOperators = map[string]func(cmp int) bool{
+	"=":  func(cmp int) bool { return cmp == 0 },
+	"==": func(cmp int) bool { return cmp == 0 },
+	"!=": func(cmp int) bool { return cmp != 0 },
+	"<>": func(cmp int) bool { return cmp != 0 },
+	"<":  func(cmp int) bool { return cmp < 0 },
+	"<=": func(cmp int) bool { return cmp <= 0 },
+	">":  func(cmp int) bool { return cmp > 0 },
+	">=": func(cmp int) bool { return cmp >= 0 },
+	// the like operators are matched apart
+	"like":     nil,
+	"not like": nil,
+}
+
+func matchFilters(row map[string]interface{}, filters []Filter) bool {
+	for _, filter := range filters {
+		if !matchFilter(row[filter.Column], filter) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchFilter compares a value of a row with the value of a filter as
+// SQLite would, numerically when both are numbers. NULL matches nothing
+func matchFilter(value interface{}, filter Filter) bool {
+	if value == nil {
+		return false
+	}
+	if v, ok := value.(*interface{}); ok {
+		if v == nil || *v == nil {
+			return false
+		}
+		value = *v
+	}
+
+	var text string
+	switch v := value.(type) {
+	case []byte:
+		text = string(v)
+	case time.Time:
+		text = v.Format(time.RFC3339Nano)
+	case bool:
+		text = "0"
+		if v {
+			text = "1"
+		}
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	operator := strings.ToLower(filter.Operator)
+	switch operator {
+	case "like", "not like":
+		return likePattern(filter.Value).MatchString(text) == (operator == "like")
+	}
+
+	compare, ok := realtimeOperators[operator]
+	if !ok || compare == nil {
+		return false
+	}
+
+	left, leftErr := strconv.ParseFloat(text, 64)
+	right, rightErr := strconv.ParseFloat(filter.Value, 64)
+	if leftErr == nil && rightErr == nil {
+		switch {
+		case left < right:
+			return compare(-1)
+		case left > right:
+			return compare(1)
+		default:
+			return compare(0)
+		}
+	}
+
+	return compare(strings.Compare(text, filter.Value))
+}
+
+// likePattern turns a LIKE pattern into a regexp, case insensitive as the
+// LIKE of SQLite
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	for _, ch := range pattern {
+		switch ch {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
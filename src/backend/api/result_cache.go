@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// the reads of the tables setting cache_ttl_seconds are answered from the
+// cache until the table is written through the API, its schema changes or
+// the TTL runs out
+const (
+	resultCachePrefix = "result:"
+	// maxResultCacheTTL caps the cache_ttl_seconds of the tables
+	maxResultCacheTTL = time.Hour
+)
+
+// cachedResult is a response of a read, Total is its X-Total-Count
+type cachedResult struct {
+	Body  json.RawMessage `json:"body"`
+	Total string          `json:"total,omitempty"`
+}
+
+func resultCacheTablePrefix(tableName string) string {
+	return resultCachePrefix + strings.ToLower(tableName) + ":"
+}
+
+// resultCacheKey keys a read of the table by its route, the parameters of
+// the request and the caller, whose access decides the rows and the columns
+// of the result
+func resultCacheKey(c echo.Context, tableName string, request interface{}) string {
+	caller := "admin"
+	if !isAdmin(c) {
+		userTable, _ := c.Get("user_table").(string)
+		caller = userTable + ":" + currentUserID(c)
+	}
+
+	encoded, _ := json.Marshal([]interface{}{c.Path(), caller, request})
+	sum := sha1.Sum(encoded)
+
+	return resultCacheTablePrefix(tableName) + hex.EncodeToString(sum[:])
+}
+
+// respondCached answers with the cached response of key, it reports whether
+// there was one
+func respondCached(c echo.Context, key string) (bool, error) {
+	var cached cachedResult
+	if !cacheGet(key, &cached) {
+		c.Response().Header().Set("X-Cache", "MISS")
+		return false, nil
+	}
+
+	c.Response().Header().Set("X-Cache", "HIT")
+	if cached.Total != "" {
+		c.Response().Header().Set("X-Total-Count", cached.Total)
+	}
+
+	return true, respondBlobWithETag(c, cached.Body)
+}
+
+// cacheResult keeps the response of a read under key for ttlSeconds, along
+// with the X-Total-Count already set
+func cacheResult(c echo.Context, key string, result interface{}, ttlSeconds int) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("failed to encode %s for the cache: %v\n", key, err)
+		return
+	}
+
+	ttl := min(time.Duration(ttlSeconds)*time.Second, maxResultCacheTTL)
+	cacheSet(key, cachedResult{Body: body, Total: c.Response().Header().Get("X-Total-Count")}, ttl)
+}
+
+// invalidateResults drops the cached reads of the tables
+func invalidateResults(tableNames ...string) {
+	for _, tableName := range tableNames {
+		if err := Cache.DeletePrefix(resultCacheTablePrefix(tableName)); err != nil {
+			log.Printf("failed to invalidate the cached results of %s: %v\n", tableName, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type RoleAPI interface {
+	FetchRoles(c echo.Context) error
+	CreateRole(c echo.Context) error
+	DeleteRole(c echo.Context) error
+	FetchUserRoles(c echo.Context) error
+	AssignRole(c echo.Context) error
+	UnassignRole(c echo.Context) error
+}
+
+type RoleAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewRoleAPI(ioc di.Container) RoleAPI {
+	return &RoleAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+// fetchUserRoles returns the names of the roles assigned to a user of an auth table
+func fetchUserRoles(db *gorm.DB, tableName string, userID string) ([]string, error) {
+	roles := []string{}
+	err := db.Model(&model.UserRole{}).
+		Where("`table` = ?", tableName).
+		Where("user_id = ?", userID).
+		Pluck("role", &roles).
+		Error
+
+	return roles, err
+}
+
+func (r *RoleAPIImpl) FetchRoles(c echo.Context) error {
+	roles := []model.Role{}
+	if err := r.db.Order("name ASC").Find(&roles).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (r *RoleAPIImpl) CreateRole(c echo.Context) error {
+	var body *model.Role = new(model.Role)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if body.Name == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "role name is required")
+	}
+
+	if err := r.db.Create(body).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, body)
+}
+
+func (r *RoleAPIImpl) DeleteRole(c echo.Context) error {
+	roleName := c.Param("role")
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role = ?", roleName).Delete(&model.UserRole{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("name = ?", roleName).Delete(&model.Role{}).Error
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (r *RoleAPIImpl) FetchUserRoles(c echo.Context) error {
+	roles, err := fetchUserRoles(r.db, c.Param("table_name"), c.Param("user_id"))
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+type assignRoleReq struct {
+	Role string `json:"role"`
+}
+
+func (r *RoleAPIImpl) AssignRole(c echo.Context) error {
+	tableName := c.Param("table_name")
+	userID := c.Param("user_id")
+
+	var body *assignRoleReq = new(assignRoleReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(r.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !table.IsAuth {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "table is not user type")
+	}
+
+	var role model.Role
+	if err := r.db.Where("name = ?", body.Role).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg_apierror.Message(c, http.StatusNotFound, "role does not exist")
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	var exist int64
+	err = r.db.Table(tableName).
+		Where("id = ?", userID).
+		Count(&exist).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if exist == 0 {
+		return pkg_apierror.Message(c, http.StatusNotFound, "user does not exist")
+	}
+
+	assignment := model.UserRole{
+		Table:  tableName,
+		UserID: userID,
+		Role:   role.Name,
+	}
+	if err := r.db.Save(&assignment).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, assignment)
+}
+
+func (r *RoleAPIImpl) UnassignRole(c echo.Context) error {
+	err := r.db.
+		Where("`table` = ?", c.Param("table_name")).
+		Where("user_id = ?", c.Param("user_id")).
+		Where("role = ?", c.Param("role")).
+		Delete(&model.UserRole{}).
+		Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	countNone     = "none"
+	countExact    = "exact"
+	countEstimate = "estimate"
+)
+
+// counts are cached briefly so paging through a large table doesn't count
+// it again on every page, they may lag behind the writes by this much
+const rowCountTTL = 10 * time.Second
+
+func rowCountGenerationKey(tableName string) string {
+	return "count_generation:" + strings.ToLower(tableName)
+}
+
+// rowCountKey includes the generation of the table, which changes whenever
+// its rows are written through the API so the cached counts stop matching
+func rowCountKey(tableName string, filters []Filter, expr filterExpr) string {
+	var generation int64
+	cacheGet(rowCountGenerationKey(tableName), &generation)
+
+	encoded, _ := json.Marshal(filters)
+	if expr != nil {
+		encoded = append(encoded, expr.String()...)
+	}
+	sum := sha1.Sum(encoded)
+
+	return fmt.Sprintf("count:%s:%d:%s", strings.ToLower(tableName), generation, hex.EncodeToString(sum[:]))
+}
+
+// invalidateRowCounts drops the cached counts and reads of the table after a
+// write, and records the write for the stats of the table
+func invalidateRowCounts(tableName string) {
+	recordWrite(tableName)
+	invalidateResults(tableName)
+	cacheSet(rowCountGenerationKey(tableName), time.Now().UnixNano(), rowCountTTL)
+}
+
+// countRows counts the rows of the table matching the filters. An estimate
+// is read from the statistics of ANALYZE or the largest rowid when there are
+// no filters, which doesn't scan the table. Otherwise the rows are counted
+func countRows(db *gorm.DB, tableName string, filters []Filter, expr filterExpr, mode string) (int64, error) {
+	key := rowCountKey(tableName, filters, expr)
+
+	var count int64
+	if cacheGet(key, &count) {
+		return count, nil
+	}
+
+	if mode == countEstimate && len(filters) == 0 && expr == nil {
+		if estimate, ok := estimateRows(db, tableName); ok {
+			cacheSet(key, estimate, rowCountTTL)
+			return estimate, nil
+		}
+	}
+
+	query := preparedDB(db).Table(tableName)
+	for _, filter := range filters {
+		var err error
+		query, err = applyRowFilter(query, filter)
+		if err != nil {
+			return 0, err
+		}
+	}
+	query = applyFilterExpr(query, expr)
+	if err := query.Count(&count).Error; err != nil {
+		return 0, err
+	}
+	cacheSet(key, count, rowCountTTL)
+
+	return count, nil
+}
+
+func estimateRows(db *gorm.DB, tableName string) (int64, bool) {
+	var stat string
+	err := db.Raw("SELECT stat FROM sqlite_stat1 WHERE tbl = ? LIMIT 1", tableName).
+		Scan(&stat).Error
+	if err == nil && stat != "" {
+		// the first number of the statistics is the row count of the table
+		var estimate int64
+		if _, err := fmt.Sscan(stat, &estimate); err == nil {
+			return estimate, true
+		}
+	}
+
+	// tables without rowid have no cheap estimate
+	var maxRowID *int64
+	err = db.Raw(fmt.Sprintf("SELECT MAX(_rowid_) FROM `%s`", tableName)).Scan(&maxRowID).Error
+	if err != nil {
+		return 0, false
+	}
+	if maxRowID == nil {
+		return 0, true
+	}
+
+	return *maxRowID, true
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"react-golang/src/backend/model"
+	"react-golang/src/backend/utils"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultNanoIDLength = 21
+	minNanoIDLength     = 8
+	maxNanoIDLength     = 64
+)
+
+// maxIDPrefixLength caps the prefixes of the ids
+const maxIDPrefixLength = 16
+
+var (
+	nanoIDTypePattern = regexp.MustCompile(`^nanoid(?:\((\d+)\))?$`)
+	// idPrefixPattern matches the prefixes safe in URLs and filters, letters
+	// and digits with an optional separator
+	idPrefixPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*[_-]?$`)
+)
+
+// parseIDType returns the kind of an id type along with the length of the
+// nanoid ones
+func parseIDType(idType string) (string, int, error) {
+	switch idType {
+	case "", model.ID_TYPE_STRING:
+		return model.ID_TYPE_STRING, 0, nil
+	case model.ID_TYPE_MANUAL, model.ID_TYPE_UUIDV7, model.ID_TYPE_ULID, model.ID_TYPE_AUTOINCREMENT:
+		return idType, 0, nil
+	}
+
+	match := nanoIDTypePattern.FindStringSubmatch(idType)
+	if match == nil {
+		return "", 0, fmt.Errorf("id_type must be %s, %s, %s, %s, %s or %s(length)",
+			model.ID_TYPE_STRING, model.ID_TYPE_MANUAL, model.ID_TYPE_UUIDV7, model.ID_TYPE_ULID, model.ID_TYPE_AUTOINCREMENT, model.ID_TYPE_NANOID)
+	}
+	length := defaultNanoIDLength
+	if match[1] != "" {
+		length, _ = strconv.Atoi(match[1])
+	}
+	if length < minNanoIDLength || length > maxNanoIDLength {
+		return "", 0, fmt.Errorf("nanoid length must be between %d and %d", minNanoIDLength, maxNanoIDLength)
+	}
+
+	return model.ID_TYPE_NANOID, length, nil
+}
+
+// validateIDPrefix checks the prefix of the ids of a table, the integer ids
+// of autoincrement tables can't have one
+func validateIDPrefix(idType string, prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if idType == model.ID_TYPE_AUTOINCREMENT {
+		return fmt.Errorf("%s ids can't have a prefix", model.ID_TYPE_AUTOINCREMENT)
+	}
+	if len(prefix) > maxIDPrefixLength || !idPrefixPattern.MatchString(prefix) {
+		return fmt.Errorf("id prefix must be at most %d letters and digits starting with a letter, optionally ending with _ or -", maxIDPrefixLength)
+	}
+
+	return nil
+}
+
+// idColumn returns the definition of the id column of a table whose ids are
+// of the type
+func idColumn(idType string) (string, error) {
+	kind, _, err := parseIDType(idType)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind {
+	case model.ID_TYPE_STRING:
+		return "id TEXT PRIMARY KEY DEFAULT (hex(randomblob(8)))", nil
+	case model.ID_TYPE_AUTOINCREMENT:
+		return "id INTEGER PRIMARY KEY AUTOINCREMENT", nil
+	default:
+		return "id TEXT PRIMARY KEY", nil
+	}
+}
+
+// generateRowID returns the id of a new row of the table, starting with the
+// prefix of the table. It is nil when SQLite numbers the rows
+func generateRowID(table model.Tables) (interface{}, error) {
+	kind, length, err := parseIDType(table.IDType)
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	switch kind {
+	case model.ID_TYPE_UUIDV7:
+		id, err = utils.GenerateUUIDV7()
+	case model.ID_TYPE_ULID:
+		id, err = utils.GenerateULID()
+	case model.ID_TYPE_NANOID:
+		id, err = utils.GenerateNanoID(length)
+	case model.ID_TYPE_AUTOINCREMENT:
+		return nil, nil
+	default:
+		id, err = utils.GenerateRandomString(16)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return table.IDPrefix + id, nil
+}
+
+// assignRowID sets the id of a row about to be inserted in the table. The
+// ids sent for the rows of manual tables are kept, SQLite numbers the rows of
+// autoincrement tables
+func assignRowID(table model.Tables, data map[string]interface{}) error {
+	if table.IDType == model.ID_TYPE_MANUAL {
+		if id, ok := data["id"]; ok && id != nil && id != "" {
+			return nil
+		}
+	}
+
+	id, err := generateRowID(table)
+	if err != nil {
+		return err
+	}
+	if id == nil {
+		delete(data, "id")
+		return nil
+	}
+	data["id"] = id
+
+	return nil
+}
+
+// createRow inserts a row whose id was assigned, the id SQLite gave the rows
+// of autoincrement tables is set on the row
+func createRow(db *gorm.DB, tableName string, data map[string]interface{}) error {
+	if err := db.Table(tableName).Create(&data).Error; err != nil {
+		return err
+	}
+	// gorm sets the rowid of the maps it creates, the id of the integer
+	// primary keys
+	if _, ok := data["id"]; !ok {
+		data["id"] = data["@id"]
+	}
+
+	return nil
+}
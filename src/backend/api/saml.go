@@ -0,0 +1,342 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/constants"
+	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type SAMLAPI interface {
+	Metadata(c echo.Context) error
+	Login(c echo.Context) error
+	AssertionConsumer(c echo.Context) error
+}
+
+type SAMLAPIImpl struct {
+	db *gorm.DB
+
+	// the IdP metadata is cached per source so it isn't fetched on every login
+	mu            sync.Mutex
+	idpSource     string
+	idpMetadata   *saml.EntityDescriptor
+	idpFetchedAt  time.Time
+	idpHTTPClient *http.Client
+}
+
+func NewSAMLAPI(ioc di.Container) SAMLAPI {
+	api := &SAMLAPIImpl{
+		db:            ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		idpHTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	// the cached IdP metadata is dropped when the saml settings change
+	config.OnChange(func(c *config.Config, keys []string) {
+		for _, key := range keys {
+			if strings.HasPrefix(key, "saml_") {
+				api.mu.Lock()
+				api.idpMetadata = nil
+				api.mu.Unlock()
+				return
+			}
+		}
+	})
+
+	return api
+}
+
+const (
+	samlRequestCookie = "saml_request_id"
+	samlMetadataTTL   = time.Hour
+)
+
+func (s *SAMLAPIImpl) fetchIDPMetadata() (*saml.EntityDescriptor, error) {
+	settings := config.GetInstance()
+	source := settings.SAMLIDPMetadata
+	if source == "" {
+		source = settings.SAMLIDPMetadataURL
+	}
+	if source == "" {
+		return nil, errors.New("saml idp metadata is not configured")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idpMetadata != nil && s.idpSource == source && time.Since(s.idpFetchedAt) < samlMetadataTTL {
+		return s.idpMetadata, nil
+	}
+
+	data := []byte(settings.SAMLIDPMetadata)
+	if len(data) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, settings.SAMLIDPMetadataURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := s.idpHTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch idp metadata: %s", res.Status)
+		}
+		if data, err = io.ReadAll(res.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata := &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(data, metadata); err != nil {
+		// some IdPs wrap their descriptor in an EntitiesDescriptor
+		entities := &saml.EntitiesDescriptor{}
+		if err := xml.Unmarshal(data, entities); err != nil || len(entities.EntityDescriptors) == 0 {
+			return nil, errors.New("invalid idp metadata")
+		}
+		metadata = &entities.EntityDescriptors[0]
+	}
+
+	s.idpSource = source
+	s.idpMetadata = metadata
+	s.idpFetchedAt = time.Now()
+
+	return metadata, nil
+}
+
+// serviceProvider builds the SP from the current settings so changes made
+// through the settings API apply without a restart
+func (s *SAMLAPIImpl) serviceProvider(withIDP bool) (*saml.ServiceProvider, error) {
+	settings := config.GetInstance()
+	if !settings.SAMLEnabled {
+		return nil, errors.New("saml is not enabled")
+	}
+
+	appURL := strings.TrimRight(settings.AppURL, "/")
+	metadataURL, err := url.Parse(appURL + "/saml/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(appURL + "/saml/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          settings.SAMLEntityID,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		AuthnNameIDFormat: saml.EmailAddressNameIDFormat,
+		AllowIDPInitiated: true,
+	}
+
+	if withIDP {
+		if sp.IDPMetadata, err = s.fetchIDPMetadata(); err != nil {
+			return nil, err
+		}
+	}
+
+	return sp, nil
+}
+
+func (s *SAMLAPIImpl) Metadata(c echo.Context) error {
+	sp, err := s.serviceProvider(false)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusNotFound, err)
+	}
+
+	metadata, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// Login redirects the admin to the IdP, the request id is kept in a cookie
+// to validate the response
+func (s *SAMLAPIImpl) Login(c echo.Context) error {
+	sp, err := s.serviceProvider(true)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	request, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	redirectURL, err := request.Redirect("", sp)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// the IdP posts back cross site, so the cookie must allow it
+	c.SetCookie(&http.Cookie{
+		Name:     samlRequestCookie,
+		Value:    request.ID,
+		Path:     "/saml",
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	return c.Redirect(http.StatusFound, redirectURL.String())
+}
+
+func assertionAttribute(assertion *saml.Assertion, name string) string {
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			if len(attribute.Values) > 0 {
+				return attribute.Values[0].Value
+			}
+		}
+	}
+
+	return ""
+}
+
+// adminFromAssertion maps the assertion attributes to an admin, creating it
+// when auto provisioning is enabled
+func (s *SAMLAPIImpl) adminFromAssertion(assertion *saml.Assertion) (model.Admin, error) {
+	settings := config.GetInstance()
+	var admin model.Admin
+
+	email := ""
+	if settings.SAMLEmailAttribute != "" {
+		email = assertionAttribute(assertion, settings.SAMLEmailAttribute)
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		email = assertion.Subject.NameID.Value
+	}
+	if email == "" {
+		return admin, errors.New("assertion has no email")
+	}
+
+	username := email
+	if settings.SAMLUsernameAttribute != "" {
+		if value := assertionAttribute(assertion, settings.SAMLUsernameAttribute); value != "" {
+			username = value
+		}
+	}
+
+	role := ""
+	if settings.SAMLRoleAttribute != "" {
+		role = assertionAttribute(assertion, settings.SAMLRoleAttribute)
+	}
+	if !IsValidAdminRole(role) {
+		role = settings.SAMLDefaultRole
+	}
+	if !IsValidAdminRole(role) {
+		role = model.ADMIN_ROLE_READ_ONLY
+	}
+
+	err := s.db.Where("email = ?", email).Take(&admin).Error
+	if err == nil {
+		// the IdP stays the source of truth for the role of SSO admins
+		if settings.SAMLRoleAttribute != "" && admin.Role != role {
+			admin.Role = role
+			err = s.db.Model(&model.Admin{}).Where("id = ?", admin.ID).Update("role", role).Error
+		}
+		return admin, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return admin, err
+	}
+
+	if !settings.SAMLAutoProvision {
+		return admin, errors.New("admin does not exist")
+	}
+
+	// SSO admins never log in with a password, a random one keeps the
+	// password login unusable
+	password, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return admin, err
+	}
+	hashedPassword, salt, err := auth_libraries.EncryptPassword(password)
+	if err != nil {
+		return admin, err
+	}
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return admin, err
+	}
+
+	admin = model.Admin{
+		ID:       id,
+		Email:    email,
+		Username: username,
+		Password: hashedPassword,
+		Salt:     salt,
+		Role:     role,
+	}
+	err = s.db.Create(&admin).Error
+
+	return admin, err
+}
+
+// AssertionConsumer validates the IdP response and sends the admin back to
+// the dashboard with a token in the url fragment
+func (s *SAMLAPIImpl) AssertionConsumer(c echo.Context) error {
+	sp, err := s.serviceProvider(true)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	possibleRequestIDs := []string{}
+	if cookie, err := c.Cookie(samlRequestCookie); err == nil && cookie.Value != "" {
+		possibleRequestIDs = append(possibleRequestIDs, cookie.Value)
+	}
+
+	assertion, err := sp.ParseResponse(c.Request(), possibleRequestIDs)
+	if err != nil {
+		var invalidResponse *saml.InvalidResponseError
+		if errors.As(err, &invalidResponse) {
+			c.Logger().Errorf("saml: %v", invalidResponse.PrivateErr)
+		}
+		return pkg_apierror.Message(c, http.StatusUnauthorized, "invalid saml response")
+	}
+
+	admin, err := s.adminFromAssertion(assertion)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusUnauthorized, err)
+	}
+
+	token, err := generateAdminToken(s.db, c, admin)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:   samlRequestCookie,
+		Path:   "/saml",
+		MaxAge: -1,
+	})
+
+	redirect := fmt.Sprintf("%s/signin#token=%s", strings.TrimRight(config.GetInstance().AppURL, "/"), url.QueryEscape(token))
+	return c.Redirect(http.StatusFound, redirect)
+}
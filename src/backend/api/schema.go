@@ -0,0 +1,414 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type SchemaAPI interface {
+	ExportSchema(c echo.Context) error
+	ImportSchema(c echo.Context) error
+	ImportDump(c echo.Context) error
+	DiffSchema(c echo.Context) error
+	GenerateSDK(c echo.Context) error
+}
+
+type SchemaAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewSchemaAPI(ioc di.Container) SchemaAPI {
+	return &SchemaAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+const schemaVersion = 1
+
+type tableSchema struct {
+	Name     string         `json:"name"`
+	IsAuth   bool           `json:"is_auth"`
+	IsView   bool           `json:"is_view"`
+	SQL      string         `json:"sql"`
+	Columns  []model.Column `json:"columns"`
+	Indexes  []schemaObject `json:"indexes"`
+	Triggers []schemaObject `json:"triggers"`
+}
+
+type schemaDocument struct {
+	Version int           `json:"version"`
+	Tables  []tableSchema `json:"tables"`
+}
+
+func buildSchemaDocument(db *gorm.DB) (schemaDocument, error) {
+	document := schemaDocument{
+		Version: schemaVersion,
+		Tables:  []tableSchema{},
+	}
+
+	var tables []model.Tables
+	err := db.Model(&model.Tables{}).
+		Where("is_system = ?", false).
+		Order("name ASC").
+		Find(&tables).Error
+	if err != nil {
+		return document, err
+	}
+
+	for _, table := range tables {
+		objects, err := fetchSchemaObjects(db, table.Name)
+		if err != nil {
+			return document, err
+		}
+
+		columns, err := fetchColumns(db, table.Name)
+		if err != nil {
+			return document, err
+		}
+
+		schema := tableSchema{
+			Name:     table.Name,
+			IsAuth:   table.IsAuth,
+			IsView:   table.IsView,
+			Columns:  columns,
+			Indexes:  []schemaObject{},
+			Triggers: []schemaObject{},
+		}
+		for _, object := range objects {
+			switch object.Type {
+			case "table", "view":
+				schema.SQL = object.SQL
+			case "index":
+				schema.Indexes = append(schema.Indexes, object)
+			case "trigger":
+				schema.Triggers = append(schema.Triggers, object)
+			}
+		}
+
+		document.Tables = append(document.Tables, schema)
+	}
+
+	return document, nil
+}
+
+func tableExists(db *gorm.DB, name string) (bool, error) {
+	var count int64
+	err := db.Table("sqlite_master").
+		Where("type IN ?", []string{"table", "view"}).
+		Where("name = ?", name).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+func (s *SchemaAPIImpl) ExportSchema(c echo.Context) error {
+	document, err := buildSchemaDocument(s.db)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, document)
+}
+
+func (s *SchemaAPIImpl) ImportSchema(c echo.Context) error {
+	var document *schemaDocument = new(schemaDocument)
+	if err := c.Bind(document); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	defer flushTableCache()
+
+	created := []string{}
+	skipped := []string{}
+
+	// views may select from any table, so they are created last
+	ordered := []tableSchema{}
+	for _, table := range document.Tables {
+		if !table.IsView {
+			ordered = append(ordered, table)
+		}
+	}
+	for _, table := range document.Tables {
+		if table.IsView {
+			ordered = append(ordered, table)
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range ordered {
+			exist, err := tableExists(tx, table.Name)
+			if err != nil {
+				return err
+			}
+			if exist {
+				skipped = append(skipped, table.Name)
+				continue
+			}
+
+			statements := []string{table.SQL}
+			for _, index := range table.Indexes {
+				statements = append(statements, index.SQL)
+			}
+			for _, trigger := range table.Triggers {
+				statements = append(statements, trigger.SQL)
+			}
+
+			for _, statement := range statements {
+				if err := tx.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+
+			err = tx.Create(
+				&model.Tables{
+					Name:     table.Name,
+					IsAuth:   table.IsAuth,
+					IsSystem: false,
+					IsView:   table.IsView,
+				}).
+				Error
+			if err != nil {
+				return err
+			}
+
+			created = append(created, table.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(s.db, c, model.ACTIVITY_IMPORT_SCHEMA, "", strings.Join(created, ", "))
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"created": created,
+		"skipped": skipped,
+	})
+}
+
+// ImportDump creates the tables of a Postgres or MySQL dump along with their
+// rows, the dump being the file field of a multipart form or the body. Its
+// dialect is guessed unless the dialect query parameter is set
+func (s *SchemaAPIImpl) ImportDump(c echo.Context) error {
+	var body io.Reader = c.Request().Body
+	if isMultipart(c) {
+		header, err := c.FormFile("file")
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusBadRequest, err)
+		}
+		file, err := header.Open()
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	reader := bufio.NewReader(body)
+	dialect := c.QueryParam("dialect")
+	if dialect == "" {
+		head, _ := reader.Peek(4096)
+		dialect = DetectDumpDialect(head)
+	}
+	defer flushTableCache()
+
+	result, err := ImportDump(s.db, reader, dialect)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidDump) {
+			status = http.StatusBadRequest
+		}
+		return pkg_apierror.Error(c, status, err)
+	}
+
+	names := []string{}
+	for _, table := range result.Tables {
+		names = append(names, table.Name)
+	}
+	recordActivity(s.db, c, model.ACTIVITY_IMPORT_DUMP, "", strings.Join(names, ", "))
+
+	return c.JSON(http.StatusOK, result)
+}
+
+type schemaAction struct {
+	Action string `json:"action"`
+	Table  string `json:"table"`
+	Name   string `json:"name,omitempty"`
+	SQL    string `json:"sql"`
+}
+
+// addColumnSQL builds the ALTER TABLE statement adding column to table.
+// SQLite rejects NOT NULL columns without a default, so the constraint is
+// only kept when a default exists
+func addColumnSQL(table string, column model.Column) string {
+	columnType := column.Type
+	if column.Reference != "" {
+		columnType = "TEXT"
+	}
+
+	statement := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column.Name, columnType)
+	if collation, err := collationClause(column.Collation); err == nil {
+		statement += collation
+	}
+	if column.Default != "" {
+		statement += fmt.Sprintf(" DEFAULT %s", column.Default)
+		if column.NotNull {
+			statement += " NOT NULL"
+		}
+	}
+	if column.Reference != "" {
+		statement += fmt.Sprintf(" REFERENCES %s(id) ON UPDATE CASCADE", column.Reference)
+	}
+
+	return statement
+}
+
+// diffSchema lists the DDL needed for the live database to contain
+// everything declared in document. Nothing is ever dropped
+func diffSchema(db *gorm.DB, document schemaDocument) ([]schemaAction, error) {
+	actions := []schemaAction{}
+	views := []schemaAction{}
+
+	for _, table := range document.Tables {
+		exist, err := tableExists(db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exist {
+			if table.IsView {
+				views = append(views, schemaAction{Action: "create_view", Table: table.Name, SQL: table.SQL})
+				continue
+			}
+
+			actions = append(actions, schemaAction{Action: "create_table", Table: table.Name, SQL: table.SQL})
+			for _, index := range table.Indexes {
+				actions = append(actions, schemaAction{Action: "add_index", Table: table.Name, Name: index.Name, SQL: index.SQL})
+			}
+			for _, trigger := range table.Triggers {
+				actions = append(actions, schemaAction{Action: "add_trigger", Table: table.Name, Name: trigger.Name, SQL: trigger.SQL})
+			}
+			continue
+		}
+
+		if table.IsView {
+			continue
+		}
+
+		columns, err := fetchColumns(db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+		liveColumns := map[string]bool{}
+		for _, column := range columns {
+			liveColumns[column.Name] = true
+		}
+
+		for _, column := range table.Columns {
+			// generated columns can't be rebuilt since their expression
+			// isn't part of the column info
+			if liveColumns[column.Name] || column.Generated {
+				continue
+			}
+			actions = append(actions, schemaAction{
+				Action: "add_column",
+				Table:  table.Name,
+				Name:   column.Name,
+				SQL:    addColumnSQL(table.Name, column),
+			})
+		}
+
+		objects, err := fetchSchemaObjects(db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+		liveObjects := map[string]bool{}
+		for _, object := range objects {
+			liveObjects[object.Name] = true
+		}
+
+		for _, index := range table.Indexes {
+			if !liveObjects[index.Name] {
+				actions = append(actions, schemaAction{Action: "add_index", Table: table.Name, Name: index.Name, SQL: index.SQL})
+			}
+		}
+	}
+
+	return append(actions, views...), nil
+}
+
+type diffSchemaReq struct {
+	Schema schemaDocument `json:"schema"`
+	Apply  bool           `json:"apply"`
+}
+
+func (s *SchemaAPIImpl) DiffSchema(c echo.Context) error {
+	var params *diffSchemaReq = new(diffSchemaReq)
+	if err := c.Bind(params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	actions, err := diffSchema(s.db, params.Schema)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if !params.Apply {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"actions": actions,
+			"applied": false,
+		})
+	}
+
+	tables := map[string]tableSchema{}
+	for _, table := range params.Schema.Tables {
+		tables[table.Name] = table
+	}
+	defer flushTableCache()
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		for _, action := range actions {
+			if err := tx.Exec(action.SQL).Error; err != nil {
+				return fmt.Errorf("%s %s: %w", action.Action, action.Table, err)
+			}
+
+			if action.Action == "create_table" || action.Action == "create_view" {
+				err := tx.Create(
+					&model.Tables{
+						Name:     action.Table,
+						IsAuth:   tables[action.Table].IsAuth,
+						IsSystem: false,
+						IsView:   tables[action.Table].IsView,
+					}).
+					Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(s.db, c, model.ACTIVITY_APPLY_SCHEMA_DIFF, "", fmt.Sprintf("%d actions applied", len(actions)))
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"actions": actions,
+		"applied": true,
+	})
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	SDK_TYPESCRIPT = "ts"
+	SDK_GO         = "go"
+	SDK_DART       = "dart"
+)
+
+var ErrUnknownSDKLanguage = errors.New("lang must be one of ts, go or dart")
+
+// SDKFile is a source file of a generated client
+type SDKFile struct {
+	Name    string
+	Content []byte
+}
+
+// the kinds of the values of the columns, as sent by the REST API
+const (
+	sdkString  = "string"
+	sdkNumber  = "number"
+	sdkInteger = "integer"
+	sdkBoolean = "boolean"
+	// sdkAny is a column of a view whose type SQLite doesn't know
+	sdkAny = "any"
+)
+
+type sdkColumn struct {
+	Name string
+	Kind string
+	// Format tells what a string holds: datetime, geopoint (JSON encoded),
+	// file, files (a JSON array of keys) or relation
+	Format   string
+	Nullable bool
+}
+
+type sdkTable struct {
+	Name    string
+	IsAuth  bool
+	IsView  bool
+	Columns []sdkColumn
+}
+
+var sdkGenerators = map[string]func(tables []sdkTable) ([]SDKFile, error){
+	SDK_TYPESCRIPT: generateTypeScriptSDK,
+	SDK_GO:         generateGoSDK,
+	SDK_DART:       generateDartSDK,
+}
+
+// GenerateSDK generates a typed client of the tables of the database. The
+// client calls the REST API, the secret columns of the auth tables are left
+// out of the records
+func GenerateSDK(db *gorm.DB, lang string) ([]SDKFile, error) {
+	generate, ok := sdkGenerators[lang]
+	if !ok {
+		return nil, ErrUnknownSDKLanguage
+	}
+
+	tables, err := buildSDKSchema(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return generate(tables)
+}
+
+func buildSDKSchema(db *gorm.DB) ([]sdkTable, error) {
+	var tables []model.Tables
+	err := db.Model(&model.Tables{}).
+		Where("is_system = ?", false).
+		Order("name ASC").
+		Find(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+
+	schema := []sdkTable{}
+	for _, table := range tables {
+		columns, err := fetchColumns(db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+		metas, err := fetchColumnMeta(db, table.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		sdk := sdkTable{Name: table.Name, IsAuth: table.IsAuth, IsView: table.IsView}
+		for _, column := range columns {
+			if table.IsAuth && authSecretColumns[column.Name] {
+				continue
+			}
+			sdk.Columns = append(sdk.Columns, sdkColumnOf(column, metas[column.Name]))
+		}
+		schema = append(schema, sdk)
+	}
+
+	return schema, nil
+}
+
+func sdkColumnOf(column model.Column, meta model.ColumnMeta) sdkColumn {
+	declared := strings.ToUpper(column.Type)
+	result := sdkColumn{
+		Name:     column.Name,
+		Kind:     sdkString,
+		Nullable: !column.NotNull && column.PK == 0,
+	}
+
+	switch {
+	case declared == "":
+		result.Kind = sdkAny
+	case declared == "BOOLEAN" || declared == "BOOL":
+		result.Kind = sdkBoolean
+	case strings.Contains(declared, "INT"):
+		result.Kind = sdkInteger
+	case strings.Contains(declared, "REAL") || strings.Contains(declared, "FLOA") ||
+		strings.Contains(declared, "DOUB") || strings.Contains(declared, "NUMERIC") ||
+		strings.Contains(declared, "DECIMAL"):
+		result.Kind = sdkNumber
+	case declared == "DATETIME" || declared == "DATE" || declared == "TIMESTAMP":
+		result.Format = "datetime"
+	case declared == "LATLNG":
+		result.Format = "geopoint"
+	case declared == "FILE":
+		result.Format = "file"
+		if meta.MaxFiles > 1 {
+			result.Format = "files"
+		}
+	}
+	if column.Reference != "" {
+		result.Format = "relation"
+	}
+
+	return result
+}
+
+// GenerateSDK sends a zip of the client of the lang query parameter
+func (s *SchemaAPIImpl) GenerateSDK(c echo.Context) error {
+	lang := c.QueryParam("lang")
+	files, err := GenerateSDK(s.db, lang)
+	if errors.Is(err, ErrUnknownSDKLanguage) {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="fullbase-sdk-%s.zip"`, lang))
+	c.Response().WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(c.Response())
+	for _, file := range files {
+		w, err := archive.Create(file.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(file.Content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// sdkWords splits a name on the characters which aren't letters or digits
+// and on the case changes
+func sdkWords(name string) []string {
+	words := []string{}
+	var word []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+				word = nil
+			}
+			continue
+		}
+		if len(word) > 0 && unicode.IsUpper(r) && i > 0 && unicode.IsLower(runes[i-1]) {
+			words = append(words, string(word))
+			word = nil
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	if len(words) == 0 {
+		words = append(words, "x")
+	}
+
+	return words
+}
+
+// sdkInitialisms are written in capitals in Go names
+var sdkInitialisms = map[string]bool{
+	"id": true, "url": true, "api": true, "json": true, "http": true, "ip": true, "uuid": true, "sql": true,
+}
+
+func sdkPascal(name string, initialisms bool) string {
+	var b strings.Builder
+	for _, word := range sdkWords(name) {
+		lower := strings.ToLower(word)
+		if initialisms && sdkInitialisms[lower] {
+			b.WriteString(strings.ToUpper(lower))
+			continue
+		}
+		runes := []rune(lower)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+
+	result := b.String()
+	if unicode.IsDigit([]rune(result)[0]) {
+		result = "T" + result
+	}
+
+	return result
+}
+
+func sdkCamel(name string) string {
+	pascal := sdkPascal(name, false)
+	runes := []rune(pascal)
+	runes[0] = unicode.ToLower(runes[0])
+
+	return string(runes)
+}
+
+// sdkUnique makes the names of the keys unique by numbering the repeats, in
+// the order of the keys
+func sdkUnique(keys []string, name func(string) string) map[string]string {
+	names := map[string]string{}
+	used := map[string]bool{}
+	for _, key := range keys {
+		candidate := name(key)
+		for i := 2; used[candidate]; i++ {
+			candidate = fmt.Sprintf("%s%d", name(key), i)
+		}
+		used[candidate] = true
+		names[key] = candidate
+	}
+
+	return names
+}
+
+func sdkTableNames(tables []sdkTable, name func(string) string) map[string]string {
+	keys := []string{}
+	for _, table := range tables {
+		keys = append(keys, table.Name)
+	}
+	sort.Strings(keys)
+
+	return sdkUnique(keys, name)
+}
+
+func sdkColumnNames(table sdkTable, name func(string) string) map[string]string {
+	keys := []string{}
+	for _, column := range table.Columns {
+		keys = append(keys, column.Name)
+	}
+
+	return sdkUnique(keys, name)
+}
+
+// sdkFormatComment describes the content of the string columns whose format
+// isn't obvious from their type
+func sdkFormatComment(column sdkColumn) string {
+	switch column.Format {
+	case "geopoint":
+		return `JSON encoded {"lat": .., "lng": ..}`
+	case "file":
+		return "key of a file"
+	case "files":
+		return "JSON array of the keys of the files"
+	case "relation":
+		return "id of the referenced row"
+	case "datetime":
+		return "RFC 3339 time"
+	}
+
+	return ""
+}
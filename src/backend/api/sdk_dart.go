@@ -0,0 +1,300 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dartReserved can't name the fields of the records
+var dartReserved = map[string]bool{
+	"assert": true, "break": true, "case": true, "catch": true, "class": true, "const": true,
+	"continue": true, "default": true, "do": true, "else": true, "enum": true, "extends": true,
+	"false": true, "final": true, "finally": true, "for": true, "if": true, "in": true, "is": true,
+	"new": true, "null": true, "rethrow": true, "return": true, "super": true, "switch": true,
+	"this": true, "throw": true, "true": true, "try": true, "var": true, "void": true, "while": true,
+	"with": true, "toJson": true, "hashCode": true, "runtimeType": true, "toString": true,
+}
+
+func dartName(name string) string {
+	camel := sdkCamel(name)
+	if dartReserved[camel] {
+		return camel + "_"
+	}
+
+	return camel
+}
+
+func dartString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `$`, `\$`)
+	return "'" + replacer.Replace(value) + "'"
+}
+
+func dartType(column sdkColumn) string {
+	var result string
+	switch column.Kind {
+	case sdkNumber:
+		result = "double"
+	case sdkInteger:
+		result = "int"
+	case sdkBoolean:
+		result = "bool"
+	case sdkAny:
+		return "dynamic"
+	default:
+		result = "String"
+	}
+	if column.Nullable {
+		result += "?"
+	}
+
+	return result
+}
+
+// dartDecode reads the column from the json map of fromJson
+func dartDecode(column sdkColumn) string {
+	value := "json[" + dartString(column.Name) + "]"
+	optional := ""
+	if column.Nullable {
+		optional = "?"
+	}
+
+	switch column.Kind {
+	case sdkNumber:
+		return fmt.Sprintf("(%s as num%s)%s.toDouble()", value, optional, optional)
+	case sdkInteger:
+		return fmt.Sprintf("(%s as num%s)%s.toInt()", value, optional, optional)
+	case sdkBoolean:
+		return fmt.Sprintf("%s as bool%s", value, optional)
+	case sdkAny:
+		return value
+	}
+
+	return fmt.Sprintf("%s as String%s", value, optional)
+}
+
+const dartClient = `// Code generated by fullbase gen sdk. DO NOT EDIT.
+
+import 'dart:convert';
+
+import 'package:http/http.dart' as http;
+
+class FullbaseException implements Exception {
+  final int status;
+  final String message;
+  final String? code;
+
+  FullbaseException(this.status, this.message, [this.code]);
+
+  @override
+  String toString() => 'FullbaseException($status): $message';
+}
+
+class Filter {
+  final String column;
+  final String operator;
+  final String value;
+
+  Filter(this.column, this.operator, this.value);
+
+  Map<String, dynamic> toJson() => {'column': column, 'operator': operator, 'value': value};
+}
+
+class ListResult<T> {
+  final List<T> records;
+
+  /// total is set when the rows are counted
+  final int? total;
+
+  ListResult(this.records, this.total);
+}
+
+class FullbaseClient {
+  final String baseUrl;
+  final String apiKey;
+
+  /// token authenticates the requests, login and register set it
+  String? token;
+  final http.Client _http;
+  late final FullbaseTables tables = FullbaseTables(this);
+
+  FullbaseClient(String baseUrl, this.apiKey, {http.Client? httpClient})
+      : baseUrl = baseUrl.replaceAll(RegExp(r'/+$'), ''),
+        _http = httpClient ?? http.Client();
+
+  Future<http.Response> send(String method, String path, [Object? body]) async {
+    final request = http.Request(method, Uri.parse('$baseUrl/api$path'));
+    request.headers['X-API-KEY'] = apiKey;
+    if (token != null) request.headers['Authorization'] = token!;
+    if (body != null) {
+      request.headers['Content-Type'] = 'application/json';
+      request.body = jsonEncode(body);
+    }
+
+    final response = await http.Response.fromStream(await _http.send(request));
+    if (response.statusCode >= 400) {
+      var message = response.body.trim();
+      String? code;
+      try {
+        final decoded = jsonDecode(response.body);
+        if (decoded is Map) {
+          message = (decoded['message'] ?? message).toString();
+          code = decoded['code']?.toString();
+        }
+      } on FormatException {
+        // the body is the message
+      }
+      throw FullbaseException(response.statusCode, message, code);
+    }
+
+    return response;
+  }
+
+  Future<dynamic> request(String method, String path, [Object? body]) async {
+    final response = await send(method, path, body);
+    return response.body.isEmpty ? null : jsonDecode(response.body);
+  }
+
+  /// login keeps the token of the user for the next requests
+  Future<Map<String, dynamic>> login(String table, Map<String, dynamic> data, {String? code}) async {
+    final result = await request('POST', '/auth/login/${Uri.encodeComponent(table)}', {'data': data, 'code': code ?? ''});
+    if (result['token'] is String) token = result['token'];
+    return result;
+  }
+
+  Future<Map<String, dynamic>> register(String table, Map<String, dynamic> data, {bool returnsToken = false}) async {
+    final result = await request('POST', '/auth/register/${Uri.encodeComponent(table)}', {'data': data, 'returns_token': returnsToken});
+    if (result['token'] is String) token = result['token'];
+    return result;
+  }
+
+  Future<dynamic> invoke(String name, [Map<String, dynamic> data = const {}]) {
+    return request('POST', '/${Uri.encodeComponent(name)}', {'data': data});
+  }
+}
+
+class FullbaseView<T> {
+  final FullbaseClient client;
+  final String name;
+  final T Function(Map<String, dynamic>) fromJson;
+
+  FullbaseView(this.client, this.name, this.fromJson);
+
+  Future<ListResult<T>> list({List<Filter>? filters, String? filter, String? q, int? page, int? pageSize, int? limit, String? count, List<String>? expand}) async {
+    final response = await client.send('POST', '/main/${Uri.encodeComponent(name)}/rows', {
+      if (filters != null) 'filters': filters.map((f) => f.toJson()).toList(),
+      if (filter != null) 'filter': filter,
+      if (q != null) 'q': q,
+      if (page != null) 'page': page,
+      if (pageSize != null) 'page_size': pageSize,
+      if (limit != null) 'limit': limit,
+      if (count != null) 'count': count,
+      if (expand != null) 'expand': expand,
+    });
+    final rows = jsonDecode(response.body) as List<dynamic>;
+    final total = response.headers['x-total-count'];
+    return ListResult(rows.map((row) => fromJson(row as Map<String, dynamic>)).toList(), total == null ? null : int.tryParse(total));
+  }
+
+  /// fields lists the columns to read, the blob and file columns are only read when listed
+  Future<T> get(Object id, {List<String>? fields}) async {
+    var path = '/main/${Uri.encodeComponent(name)}/${Uri.encodeComponent(id.toString())}';
+    if (fields != null) path += '?fields=${Uri.encodeComponent(fields.join(','))}';
+    final row = await client.request('GET', path);
+    return fromJson(row as Map<String, dynamic>);
+  }
+}
+
+/// the writes take the columns to set, the unset columns keep their default
+class FullbaseTable<T> extends FullbaseView<T> {
+  FullbaseTable(super.client, super.name, super.fromJson);
+
+  Future<Map<String, dynamic>> create(Map<String, dynamic> data) async {
+    return await client.request('POST', '/main/${Uri.encodeComponent(name)}/insert', {'data': data});
+  }
+
+  Future<Map<String, dynamic>> update(Object id, Map<String, dynamic> data) async {
+    return await client.request('PUT', '/main/${Uri.encodeComponent(name)}/update', {'id': id.toString(), 'data': data});
+  }
+
+  Future<void> delete(List<Object> ids) async {
+    await client.request('DELETE', '/main/${Uri.encodeComponent(name)}/rows', {'id': ids.map((id) => id.toString()).toList()});
+  }
+}
+`
+
+func generateDartSDK(tables []sdkTable) ([]SDKFile, error) {
+	var b strings.Builder
+	b.WriteString(dartClient)
+
+	types := sdkTableNames(tables, func(name string) string { return sdkPascal(name, false) + "Record" })
+	getters := sdkTableNames(tables, dartName)
+	for _, table := range tables {
+		fields := sdkColumnNames(table, dartName)
+		class := types[table.Name]
+
+		fmt.Fprintf(&b, "\n/// %s is a row of %s\n", class, table.Name)
+		fmt.Fprintf(&b, "class %s {\n", class)
+		for _, column := range table.Columns {
+			if comment := sdkFormatComment(column); comment != "" {
+				fmt.Fprintf(&b, "  /// %s\n", comment)
+			}
+			fmt.Fprintf(&b, "  final %s %s;\n", dartType(column), fields[column.Name])
+		}
+
+		b.WriteString("\n")
+		if len(table.Columns) == 0 {
+			fmt.Fprintf(&b, "  %s();\n", class)
+		} else {
+			fmt.Fprintf(&b, "  %s({\n", class)
+			for _, column := range table.Columns {
+				required := "required "
+				if column.Nullable || column.Kind == sdkAny {
+					required = ""
+				}
+				fmt.Fprintf(&b, "    %sthis.%s,\n", required, fields[column.Name])
+			}
+			b.WriteString("  });\n")
+		}
+
+		fmt.Fprintf(&b, "\n  factory %s.fromJson(Map<String, dynamic> json) => %s(\n", class, class)
+		for _, column := range table.Columns {
+			fmt.Fprintf(&b, "        %s: %s,\n", fields[column.Name], dartDecode(column))
+		}
+		b.WriteString("      );\n")
+
+		b.WriteString("\n  Map<String, dynamic> toJson() => {\n")
+		for _, column := range table.Columns {
+			fmt.Fprintf(&b, "        %s: %s,\n", dartString(column.Name), fields[column.Name])
+		}
+		b.WriteString("      };\n}\n")
+	}
+
+	b.WriteString("\nclass FullbaseTables {\n")
+	for _, table := range tables {
+		class := "FullbaseTable"
+		if table.IsView {
+			class = "FullbaseView"
+		}
+		fmt.Fprintf(&b, "  final %s<%s> %s;\n", class, types[table.Name], getters[table.Name])
+	}
+	b.WriteString("\n  FullbaseTables(FullbaseClient client)")
+	for i, table := range tables {
+		class := "FullbaseTable"
+		if table.IsView {
+			class = "FullbaseView"
+		}
+		separator := ","
+		if i == 0 {
+			separator = "\n      :"
+		}
+		if i > 0 {
+			b.WriteString(separator + "\n       ")
+		} else {
+			b.WriteString(separator)
+		}
+		fmt.Fprintf(&b, " %s = %s(client, %s, %s.fromJson)", getters[table.Name], class, dartString(table.Name), types[table.Name])
+	}
+	b.WriteString(";\n}\n")
+
+	return []SDKFile{{Name: "fullbase.dart", Content: []byte(b.String())}}, nil
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+)
+
+func goType(column sdkColumn) string {
+	var result string
+	switch column.Kind {
+	case sdkNumber:
+		result = "float64"
+	case sdkInteger:
+		result = "int64"
+	case sdkBoolean:
+		result = "bool"
+	case sdkAny:
+		return "interface{}"
+	default:
+		result = "string"
+	}
+	if column.Nullable {
+		result = "*" + result
+	}
+
+	return result
+}
+
+const goClient = `// Code generated by fullbase gen sdk. DO NOT EDIT.
+
+// Package fullbase is a typed client of the REST API of a fullbase server
+package fullbase
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type Filter struct {
+	Column   string ` + "`json:\"column\"`" + `
+	Operator string ` + "`json:\"operator\"`" + `
+	Value    string ` + "`json:\"value\"`" + `
+}
+
+type ListOptions struct {
+	Filters []Filter ` + "`json:\"filters,omitempty\"`" + `
+	// Filter is an expression such as status = "active" && title ~ "go"
+	Filter string   ` + "`json:\"filter,omitempty\"`" + `
+	// Q searches the text columns for the rows holding it
+	Q string ` + "`json:\"q,omitempty\"`" + `
+	// Page is the page read from 1, of PageSize rows
+	Page     int ` + "`json:\"page,omitempty\"`" + `
+	PageSize int ` + "`json:\"page_size,omitempty\"`" + `
+	Limit    int ` + "`json:\"limit,omitempty\"`" + `
+	// Count is none, exact or estimate
+	Count  string   ` + "`json:\"count,omitempty\"`" + `
+	Expand []string ` + "`json:\"expand,omitempty\"`" + `
+}
+
+// Error is a request the server refused, Code tells the failures apart such
+// as unique_violation or not_found
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  map[string]string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("fullbase: %d %s", e.Status, e.Message)
+}
+
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	// Token authenticates the requests, Login and Register set it
+	Token      string
+	HTTPClient *http.Client
+
+	Tables Tables
+}
+
+func NewClient(baseURL string, apiKey string) *Client {
+	c := &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+	}
+	c.Tables = newTables(c)
+
+	return c
+}
+
+// Do sends a request to the API and decodes its response into result
+func (c *Client) Do(ctx context.Context, method string, path string, body interface{}, result interface{}) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+"/api"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-KEY", c.APIKey)
+	if c.Token != "" {
+		req.Header.Set("Authorization", c.Token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	content, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			Code    string            ` + "`json:\"code\"`" + `
+			Message string            ` + "`json:\"message\"`" + `
+			Fields  map[string]string ` + "`json:\"fields\"`" + `
+		}
+		failed := &Error{Status: res.StatusCode, Message: strings.TrimSpace(string(content))}
+		if json.Unmarshal(content, &failure) == nil && failure.Message != "" {
+			failed.Code, failed.Message, failed.Fields = failure.Code, failure.Message, failure.Fields
+		}
+		return nil, failed
+	}
+	if result != nil && len(content) > 0 {
+		if err := json.Unmarshal(content, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return res.Header, nil
+}
+
+// Login keeps the token of the user for the next requests
+func (c *Client) Login(ctx context.Context, table string, data map[string]interface{}, code string) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	body := map[string]interface{}{"data": data, "code": code}
+	if _, err := c.Do(ctx, http.MethodPost, "/auth/login/"+url.PathEscape(table), body, &result); err != nil {
+		return nil, err
+	}
+	if token, ok := result["token"].(string); ok {
+		c.Token = token
+	}
+
+	return result, nil
+}
+
+func (c *Client) Register(ctx context.Context, table string, data map[string]interface{}, returnsToken bool) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	body := map[string]interface{}{"data": data, "returns_token": returnsToken}
+	if _, err := c.Do(ctx, http.MethodPost, "/auth/register/"+url.PathEscape(table), body, &result); err != nil {
+		return nil, err
+	}
+	if token, ok := result["token"].(string); ok {
+		c.Token = token
+	}
+
+	return result, nil
+}
+
+// Invoke runs a function and decodes its result into result
+func (c *Client) Invoke(ctx context.Context, name string, data map[string]interface{}, result interface{}) error {
+	_, err := c.Do(ctx, http.MethodPost, "/"+url.PathEscape(name), map[string]interface{}{"data": data}, result)
+	return err
+}
+
+// View reads the rows of a table or view
+type View[T any] struct {
+	client *Client
+	Name   string
+}
+
+// List returns the rows and their count when the options count them
+func (v *View[T]) List(ctx context.Context, options ListOptions) ([]T, *int64, error) {
+	var rows []T
+	header, err := v.client.Do(ctx, http.MethodPost, "/main/"+url.PathEscape(v.Name)+"/rows", options, &rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	if total, err := strconv.ParseInt(header.Get("X-Total-Count"), 10, 64); err == nil {
+		return rows, &total, nil
+	}
+
+	return rows, nil, nil
+}
+
+// Get reads a row, fields lists the columns to read. The blob and file
+// columns are only read when listed
+func (v *View[T]) Get(ctx context.Context, id string, fields ...string) (T, error) {
+	var row T
+	path := "/main/" + url.PathEscape(v.Name) + "/" + url.PathEscape(id)
+	if len(fields) > 0 {
+		path += "?fields=" + url.QueryEscape(strings.Join(fields, ","))
+	}
+	_, err := v.client.Do(ctx, http.MethodGet, path, nil, &row)
+
+	return row, err
+}
+
+// Table writes the rows of a table. The writes take the columns to set, the
+// unset columns keep their default
+type Table[T any] struct {
+	View[T]
+}
+
+func (t *Table[T]) Create(ctx context.Context, data map[string]interface{}) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	_, err := t.client.Do(ctx, http.MethodPost, "/main/"+url.PathEscape(t.Name)+"/insert", map[string]interface{}{"data": data}, &result)
+
+	return result, err
+}
+
+func (t *Table[T]) Update(ctx context.Context, id string, data map[string]interface{}) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	body := map[string]interface{}{"id": id, "data": data}
+	_, err := t.client.Do(ctx, http.MethodPut, "/main/"+url.PathEscape(t.Name)+"/update", body, &result)
+
+	return result, err
+}
+
+func (t *Table[T]) Delete(ctx context.Context, ids ...string) error {
+	_, err := t.client.Do(ctx, http.MethodDelete, "/main/"+url.PathEscape(t.Name)+"/rows", map[string]interface{}{"id": ids}, nil)
+	return err
+}
+`
+
+func generateGoSDK(tables []sdkTable) ([]SDKFile, error) {
+	var b strings.Builder
+	b.WriteString(goClient)
+
+	types := sdkTableNames(tables, func(name string) string { return sdkPascal(name, true) + "Record" })
+	fieldNames := sdkTableNames(tables, func(name string) string { return sdkPascal(name, true) })
+	for _, table := range tables {
+		fields := sdkColumnNames(table, func(name string) string { return sdkPascal(name, true) })
+
+		fmt.Fprintf(&b, "\n// %s is a row of %s\n", types[table.Name], table.Name)
+		fmt.Fprintf(&b, "type %s struct {\n", types[table.Name])
+		for _, column := range table.Columns {
+			if comment := sdkFormatComment(column); comment != "" {
+				fmt.Fprintf(&b, "\t// %s\n", comment)
+			}
+			fmt.Fprintf(&b, "\t%s %s `json:%s`\n", fields[column.Name], goType(column), strconv.Quote(column.Name))
+		}
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("\ntype Tables struct {\n")
+	for _, table := range tables {
+		class := "Table"
+		if table.IsView {
+			class = "View"
+		}
+		fmt.Fprintf(&b, "\t%s *%s[%s]\n", fieldNames[table.Name], class, types[table.Name])
+	}
+	b.WriteString("}\n")
+
+	b.WriteString("\nfunc newTables(c *Client) Tables {\n\treturn Tables{\n")
+	for _, table := range tables {
+		if table.IsView {
+			fmt.Fprintf(&b, "\t\t%s: &View[%s]{client: c, Name: %s},\n", fieldNames[table.Name], types[table.Name], strconv.Quote(table.Name))
+			continue
+		}
+		fmt.Fprintf(&b, "\t\t%s: &Table[%s]{View[%s]{client: c, Name: %s}},\n", fieldNames[table.Name], types[table.Name], types[table.Name], strconv.Quote(table.Name))
+	}
+	b.WriteString("\t}\n}\n")
+
+	source, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	return []SDKFile{{Name: "fullbase.go", Content: source}}, nil
+}
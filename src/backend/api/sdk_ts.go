@@ -0,0 +1,198 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var tsIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+func tsKey(name string) string {
+	if tsIdentifier.MatchString(name) {
+		return name
+	}
+
+	return strconv.Quote(name)
+}
+
+func tsType(column sdkColumn) string {
+	var result string
+	switch column.Kind {
+	case sdkNumber, sdkInteger:
+		result = "number"
+	case sdkBoolean:
+		result = "boolean"
+	case sdkAny:
+		return "unknown"
+	default:
+		result = "string"
+	}
+	if column.Nullable {
+		result += " | null"
+	}
+
+	return result
+}
+
+const tsClient = `// Code generated by fullbase gen sdk. DO NOT EDIT.
+
+export interface Filter {
+  column: string
+  operator: string
+  value: string
+}
+
+export interface ListOptions {
+  filters?: Filter[]
+  // filter is an expression such as status = "active" && title ~ "go"
+  filter?: string
+  // q searches the text columns for the rows holding it
+  q?: string
+  // page is the page read from 1, of page_size rows
+  page?: number
+  page_size?: number
+  limit?: number
+  count?: "none" | "exact" | "estimate"
+  expand?: string[]
+}
+
+export interface ListResult<T> {
+  records: T[]
+  // total is set when the rows are counted
+  total?: number
+}
+
+export class FullbaseError extends Error {
+  constructor(readonly status: number, message: string, readonly code?: string, readonly fields?: Record<string, string>) {
+    super(message)
+  }
+}
+
+export class FullbaseClient {
+  token?: string
+  readonly tables: Tables
+
+  constructor(readonly baseURL: string, readonly apiKey: string) {
+    this.tables = createTables(this)
+  }
+
+  async request<R>(method: string, path: string, body?: unknown): Promise<{ data: R; headers: Headers }> {
+    const headers: Record<string, string> = { "X-API-KEY": this.apiKey }
+    if (this.token) headers["Authorization"] = this.token
+    if (body !== undefined) headers["Content-Type"] = "application/json"
+
+    const res = await fetch(this.baseURL.replace(/\/+$/, "") + "/api" + path, {
+      method,
+      headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    })
+    const text = await res.text()
+    let data: any = null
+    try {
+      data = text ? JSON.parse(text) : null
+    } catch {
+      data = text
+    }
+    if (!res.ok) {
+      throw new FullbaseError(res.status, (data && data.message) || text || res.statusText, data?.code, data?.fields)
+    }
+
+    return { data, headers: res.headers }
+  }
+
+  // login keeps the token of the user for the next requests
+  async login(table: string, data: Record<string, unknown>, code?: string): Promise<Record<string, unknown>> {
+    const res = await this.request<Record<string, unknown>>("POST", "/auth/login/" + encodeURIComponent(table), { data, code })
+    if (typeof res.data.token === "string") this.token = res.data.token
+    return res.data
+  }
+
+  async register(table: string, data: Record<string, unknown>, returnsToken = false): Promise<Record<string, unknown>> {
+    const res = await this.request<Record<string, unknown>>("POST", "/auth/register/" + encodeURIComponent(table), {
+      data,
+      returns_token: returnsToken,
+    })
+    if (typeof res.data.token === "string") this.token = res.data.token
+    return res.data
+  }
+
+  async invoke<R = unknown>(name: string, data: Record<string, unknown> = {}): Promise<R> {
+    return (await this.request<R>("POST", "/" + encodeURIComponent(name), { data })).data
+  }
+}
+
+export class View<T> {
+  constructor(protected readonly client: FullbaseClient, readonly name: string) {}
+
+  async list(options: ListOptions = {}): Promise<ListResult<T>> {
+    const res = await this.client.request<T[]>("POST", "/main/" + encodeURIComponent(this.name) + "/rows", options)
+    const total = res.headers.get("X-Total-Count")
+    return { records: res.data, total: total === null ? undefined : Number(total) }
+  }
+
+  // fields lists the columns to read, the blob and file columns are only read when listed
+  async get(id: string | number, fields?: string[]): Promise<T> {
+    let path = "/main/" + encodeURIComponent(this.name) + "/" + encodeURIComponent(String(id))
+    if (fields) path += "?fields=" + encodeURIComponent(fields.join(","))
+    return (await this.client.request<T>("GET", path)).data
+  }
+}
+
+export class Table<T> extends View<T> {
+  async create(data: Partial<T>): Promise<Partial<T>> {
+    return (await this.client.request<Partial<T>>("POST", "/main/" + encodeURIComponent(this.name) + "/insert", { data })).data
+  }
+
+  async update(id: string | number, data: Partial<T>): Promise<Partial<T>> {
+    const path = "/main/" + encodeURIComponent(this.name) + "/update"
+    return (await this.client.request<Partial<T>>("PUT", path, { id: String(id), data })).data
+  }
+
+  async delete(...ids: (string | number)[]): Promise<void> {
+    await this.client.request("DELETE", "/main/" + encodeURIComponent(this.name) + "/rows", { id: ids.map(String) })
+  }
+}
+`
+
+func generateTypeScriptSDK(tables []sdkTable) ([]SDKFile, error) {
+	var b strings.Builder
+	b.WriteString(tsClient)
+
+	types := sdkTableNames(tables, func(name string) string { return sdkPascal(name, false) + "Record" })
+	for _, table := range tables {
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "// %s is a row of %s\n", types[table.Name], table.Name)
+		fmt.Fprintf(&b, "export interface %s {\n", types[table.Name])
+		for _, column := range table.Columns {
+			if comment := sdkFormatComment(column); comment != "" {
+				fmt.Fprintf(&b, "  // %s\n", comment)
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", tsKey(column.Name), tsType(column))
+		}
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("\nexport interface Tables {\n")
+	for _, table := range tables {
+		class := "Table"
+		if table.IsView {
+			class = "View"
+		}
+		fmt.Fprintf(&b, "  %s: %s<%s>\n", tsKey(table.Name), class, types[table.Name])
+	}
+	b.WriteString("}\n")
+
+	b.WriteString("\nfunction createTables(client: FullbaseClient): Tables {\n  return {\n")
+	for _, table := range tables {
+		class := "Table"
+		if table.IsView {
+			class = "View"
+		}
+		fmt.Fprintf(&b, "    %s: new %s<%s>(client, %s),\n", tsKey(table.Name), class, types[table.Name], strconv.Quote(table.Name))
+	}
+	b.WriteString("  }\n}\n")
+
+	return []SDKFile{{Name: "fullbase.ts", Content: []byte(b.String())}}, nil
+}
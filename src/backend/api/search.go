@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"react-golang/src/backend/model"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// searchLikeEscaper escapes the wildcards of a searched text, which is
+// matched as is
+var searchLikeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// searchExpr matches the rows holding the text in any of the columns,
+// regardless of case
+type searchExpr struct {
+	columns []string
+	text    string
+}
+
+func (s *searchExpr) sql() (string, []interface{}) {
+	pattern := "%" + searchLikeEscaper.Replace(s.text) + "%"
+	parts := make([]string, 0, len(s.columns))
+	values := make([]interface{}, 0, len(s.columns))
+	for _, column := range s.columns {
+		parts = append(parts, fmt.Sprintf(`"%s" LIKE ? ESCAPE '\'`, column))
+		values = append(values, pattern)
+	}
+
+	return strings.Join(parts, " OR "), values
+}
+
+func (s *searchExpr) match(row map[string]interface{}) bool {
+	text := strings.ToLower(s.text)
+	for _, column := range s.columns {
+		value := row[column]
+		if v, ok := value.(*interface{}); ok && v != nil {
+			value = *v
+		}
+		if value != nil && strings.Contains(strings.ToLower(fmt.Sprint(value)), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *searchExpr) conditions() []Filter {
+	filters := make([]Filter, 0, len(s.columns))
+	for _, column := range s.columns {
+		filters = append(filters, Filter{Column: column, Operator: "like", Value: s.text})
+	}
+
+	return filters
+}
+
+func (s *searchExpr) String() string {
+	parts := make([]string, 0, len(s.columns))
+	for _, column := range s.columns {
+		parts = append(parts, fmt.Sprintf("(%s ~ %s)", column, strconv.Quote(s.text)))
+	}
+
+	return strings.Join(parts, " || ")
+}
+
+// isTextType tells whether SQLite gives the declared type text affinity
+func isTextType(columnType string) bool {
+	columnType = strings.ToUpper(columnType)
+	return strings.Contains(columnType, "TEXT") || strings.Contains(columnType, "CHAR") || strings.Contains(columnType, "CLOB")
+}
+
+// searchColumns returns the columns searched by q: the searchable ones when
+// some are set, every text column otherwise. The columns the caller can't
+// filter on are left out
+func searchColumns(db *gorm.DB, table model.Tables, admin bool) ([]string, error) {
+	columns, err := fetchColumns(db, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := fetchColumnMeta(db, table.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	configured := false
+	for _, meta := range metas {
+		configured = configured || meta.Searchable
+	}
+
+	names := []string{}
+	for _, column := range columns {
+		meta := metas[column.Name]
+		switch {
+		case table.IsAuth && authSecretColumns[column.Name]:
+		case !admin && meta.Access != model.ACCESS_PUBLIC:
+		case configured && !meta.Searchable:
+		case !configured && !isTextType(column.Type):
+		default:
+			names = append(names, column.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// withSearch narrows expr down to the rows matching the search q
+func withSearch(db *gorm.DB, table model.Tables, admin bool, expr filterExpr, q string) (filterExpr, error) {
+	q = strings.TrimSpace(q)
+	if q == "" {
+		return expr, nil
+	}
+
+	columns, err := searchColumns(db, table, admin)
+	if err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s has no column to search", table.Name)
+	}
+
+	search := &searchExpr{columns: columns, text: q}
+	if expr == nil {
+		return search, nil
+	}
+
+	return &filterGroup{nodes: []filterExpr{expr, search}}, nil
+}
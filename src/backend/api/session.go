@@ -0,0 +1,246 @@
+package api
+
+import (
+	"net/http"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/constants"
+	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type SessionAPI interface {
+	FetchMySessions(c echo.Context) error
+	RevokeMySession(c echo.Context) error
+	RevokeMySessions(c echo.Context) error
+	FetchUserSessions(c echo.Context) error
+	RevokeUserSessions(c echo.Context) error
+	RevokeSession(c echo.Context) error
+}
+
+type SessionAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewSessionAPI(ioc di.Container) SessionAPI {
+	return &SessionAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+// sessionTouchInterval limits how often the last use of a session is written
+const sessionTouchInterval = time.Minute
+
+func lifetimeSetting(minutes int, fallback time.Duration) time.Duration {
+	if minutes <= 0 {
+		return fallback
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
+
+// adminTokenLifetime and userTokenLifetime are read from the settings on
+// every login so changes apply to the next issued token
+func adminTokenLifetime() time.Duration {
+	return lifetimeSetting(config.GetInstance().AdminTokenTTLMinutes, auth_libraries.TokenLifetime)
+}
+
+func userTokenLifetime() time.Duration {
+	return lifetimeSetting(config.GetInstance().UserTokenTTLMinutes, auth_libraries.TokenLifetime)
+}
+
+// issueToken stores a new session for the user of table and returns a token
+// bound to it through its jti
+func issueToken(db *gorm.DB, c echo.Context, table string, userID string, lifetime time.Duration, claims map[string]interface{}) (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	session := model.Session{
+		ID:         id.String(),
+		Table:      table,
+		UserID:     userID,
+		UserAgent:  c.Request().UserAgent(),
+		IP:         c.RealIP(),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(lifetime),
+	}
+	if impersonator, ok := claims["impersonated_by"].(string); ok {
+		session.ImpersonatedBy = impersonator
+	}
+
+	// expired sessions are of no use anymore
+	db.Where("expires_at < ?", now).Delete(&model.Session{})
+
+	if err := db.Create(&session).Error; err != nil {
+		return "", err
+	}
+
+	claims["jti"] = session.ID
+	claims["exp"] = session.ExpiresAt.Unix()
+	return auth_libraries.GenerateJWT(claims)
+}
+
+// NewSessionValidator returns the check used by the auth middleware to
+// reject tokens whose session was revoked
+func NewSessionValidator(db *gorm.DB) func(c echo.Context, sessionID string) bool {
+	return func(c echo.Context, sessionID string) bool {
+		if sessionID == "" {
+			return false
+		}
+
+		now := time.Now()
+		db.Model(&model.Session{}).
+			Where("id = ?", sessionID).
+			Where("last_used_at < ?", now.Add(-sessionTouchInterval)).
+			Updates(map[string]interface{}{
+				"last_used_at": now,
+				"ip":           c.RealIP(),
+			})
+
+		var count int64
+		err := db.Model(&model.Session{}).
+			Where("id = ?", sessionID).
+			Where("expires_at > ?", now).
+			Count(&count).Error
+
+		return err == nil && count > 0
+	}
+}
+
+// currentSessionOwner returns the table and id the current token belongs to,
+// admins are stored under the admin table
+func currentSessionOwner(c echo.Context) (string, string) {
+	if isAdmin(c) {
+		return "admin", currentUserID(c)
+	}
+
+	table, _ := c.Get("user_table").(string)
+	return table, currentUserID(c)
+}
+
+type sessionEntry struct {
+	model.Session
+	Current bool `json:"current"`
+}
+
+func fetchSessions(db *gorm.DB, c echo.Context, table string, userID string) ([]sessionEntry, error) {
+	sessions := []model.Session{}
+	err := db.Where("`table` = ?", table).
+		Where("user_id = ?", userID).
+		Where("expires_at > ?", time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	currentSession, _ := c.Get("session_id").(string)
+	entries := []sessionEntry{}
+	for _, session := range sessions {
+		entries = append(entries, sessionEntry{
+			Session: session,
+			Current: session.ID == currentSession,
+		})
+	}
+
+	return entries, nil
+}
+
+func (s *SessionAPIImpl) FetchMySessions(c echo.Context) error {
+	table, userID := currentSessionOwner(c)
+
+	sessions, err := fetchSessions(s.db, c, table, userID)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+func (s *SessionAPIImpl) RevokeMySession(c echo.Context) error {
+	table, userID := currentSessionOwner(c)
+
+	result := s.db.
+		Where("id = ?", c.Param("id")).
+		Where("`table` = ?", table).
+		Where("user_id = ?", userID).
+		Delete(&model.Session{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return pkg_apierror.Message(c, http.StatusNotFound, "session not found")
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+type revokeSessionsReq struct {
+	KeepCurrent bool `query:"keep_current"`
+}
+
+// RevokeMySessions logs the current user out everywhere, optionally keeping
+// the session of the request
+func (s *SessionAPIImpl) RevokeMySessions(c echo.Context) error {
+	var params *revokeSessionsReq = new(revokeSessionsReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, userID := currentSessionOwner(c)
+
+	query := s.db.
+		Where("`table` = ?", table).
+		Where("user_id = ?", userID)
+	if params.KeepCurrent {
+		query = query.Where("id != ?", c.Get("session_id"))
+	}
+
+	if err := query.Delete(&model.Session{}).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *SessionAPIImpl) FetchUserSessions(c echo.Context) error {
+	sessions, err := fetchSessions(s.db, c, c.Param("table_name"), c.Param("user_id"))
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+func (s *SessionAPIImpl) RevokeUserSessions(c echo.Context) error {
+	err := s.db.
+		Where("`table` = ?", c.Param("table_name")).
+		Where("user_id = ?", c.Param("user_id")).
+		Delete(&model.Session{}).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *SessionAPIImpl) RevokeSession(c echo.Context) error {
+	result := s.db.Where("id = ?", c.Param("id")).Delete(&model.Session{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return pkg_apierror.Message(c, http.StatusNotFound, "session not found")
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
@@ -4,6 +4,9 @@ import (
 	"net/http"
 	"react-golang/src/backend/config"
 	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"sort"
 	"strings"
 
 	"github.com/labstack/echo/v4"
@@ -14,17 +17,19 @@ import (
 type SettingAPI interface {
 	Get(c echo.Context) error
 	Update(c echo.Context) error
+	Reload(c echo.Context) error
+	FetchSigningKeys(c echo.Context) error
+	AddSigningKey(c echo.Context) error
+	RetireSigningKey(c echo.Context) error
 }
 
 type SettingAPIImpl struct {
-	db     *gorm.DB
-	config *config.Config
+	db *gorm.DB
 }
 
 func NewSettingAPI(ioc di.Container) SettingAPI {
 	return &SettingAPIImpl{
-		db:     ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
-		config: config.GetInstance(),
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
 	}
 }
 
@@ -35,16 +40,22 @@ type getSettingReq struct {
 func (s *SettingAPIImpl) Get(c echo.Context) error {
 	var params *getSettingReq = new(getSettingReq)
 	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
-		})
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if params.Keys == "" {
+		return c.JSON(http.StatusOK, config.GetInstance().Values())
 	}
 
 	keys := strings.Split(params.Keys, ",")
 
+	current := config.GetInstance()
 	settings := map[string]interface{}{}
 	for _, key := range keys {
-		settings[key] = s.config.Get(key)
+		if config.IsSecret(key) {
+			continue
+		}
+		settings[key] = current.Get(key)
 	}
 
 	return c.JSON(http.StatusOK, settings)
@@ -57,15 +68,48 @@ type updateSettingReq struct {
 func (s *SettingAPIImpl) Update(c echo.Context) error {
 	var params *updateSettingReq = new(updateSettingReq)
 	if err := c.Bind(&params); err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]interface{}{
-			"error": err.Error(),
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	invalid, err := config.Update(params.Data)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if len(invalid) > 0 {
+		return pkg_apierror.Write(c, http.StatusBadRequest, pkg_apierror.Response{
+			Code:    pkg_apierror.CODE_VALIDATION_FAILED,
+			Message: "invalid settings",
+			Fields:  invalid,
 		})
 	}
 
-	for k, v := range params.Data {
-		s.config.Set(k, v)
+	keys := []string{}
+	for k := range params.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	recordActivity(s.db, c, model.ACTIVITY_UPDATE_SETTINGS, "", strings.Join(keys, ", "))
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
+
+// Reload applies changes made to the settings file while the server runs
+func (s *SettingAPIImpl) Reload(c echo.Context) error {
+	invalid, err := config.Reload()
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if len(invalid) > 0 {
+		return pkg_apierror.Write(c, http.StatusBadRequest, pkg_apierror.Response{
+			Code:    pkg_apierror.CODE_VALIDATION_FAILED,
+			Message: "invalid settings",
+			Fields:  invalid,
+		})
 	}
-	s.config.Save()
+
+	recordActivity(s.db, c, model.ACTIVITY_UPDATE_SETTINGS, "", "reload")
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "success",
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// shuttingDown is closed when the server shuts down, to end the streams
+var shuttingDown = make(chan struct{})
+
+var shutdownOnce sync.Once
+
+// Shutdown ends the connections the HTTP server doesn't drain by itself. The
+// realtime clients are told to reconnect and the stats streams end, it waits
+// for the realtime clients to go until ctx is done
+func Shutdown(ctx context.Context) {
+	shutdownOnce.Do(func() {
+		close(shuttingDown)
+	})
+	realtime.shutdown(ctx)
+}
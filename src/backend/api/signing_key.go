@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// LoadSigningKeys refreshes the keys used to sign and verify tokens. The
+// newest key signs new tokens, the JWT_SECRET_KEY secret stays valid as the
+// oldest key until it's retired
+func LoadSigningKeys(db *gorm.DB) error {
+	var stored []model.SigningKey
+	if err := db.Order("created_at DESC").Find(&stored).Error; err != nil {
+		return err
+	}
+
+	keys := []auth_libraries.SigningKey{}
+	defaultRetired := false
+	for _, key := range stored {
+		if key.ID == auth_libraries.DefaultKeyID {
+			defaultRetired = key.RetiredAt != nil
+			continue
+		}
+		if key.RetiredAt != nil {
+			continue
+		}
+
+		secret, err := auth_libraries.DecryptSecret(key.Secret)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, auth_libraries.SigningKey{ID: key.ID, Secret: []byte(secret)})
+	}
+	if !defaultRetired {
+		keys = append(keys, auth_libraries.DefaultSigningKey())
+	}
+
+	auth_libraries.SetSigningKeys(keys)
+
+	return nil
+}
+
+type signingKeyRes struct {
+	model.SigningKey
+	Active bool `json:"active"`
+}
+
+func fetchSigningKeys(db *gorm.DB) ([]signingKeyRes, error) {
+	var stored []model.SigningKey
+	if err := db.Order("created_at DESC").Find(&stored).Error; err != nil {
+		return nil, err
+	}
+
+	hasDefault := false
+	for _, key := range stored {
+		if key.ID == auth_libraries.DefaultKeyID {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		stored = append(stored, model.SigningKey{ID: auth_libraries.DefaultKeyID})
+	}
+
+	active, _ := auth_libraries.ActiveSigningKey()
+	keys := []signingKeyRes{}
+	for _, key := range stored {
+		keys = append(keys, signingKeyRes{
+			SigningKey: key,
+			Active:     key.ID == active.ID,
+		})
+	}
+
+	return keys, nil
+}
+
+func (s *SettingAPIImpl) FetchSigningKeys(c echo.Context) error {
+	keys, err := fetchSigningKeys(s.db)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, keys)
+}
+
+// AddSigningKey generates a new key which signs every token issued from now
+// on, tokens signed with the previous keys stay valid until they're retired
+func (s *SettingAPIImpl) AddSigningKey(c echo.Context) error {
+	secret := make([]byte, 64)
+	if _, err := rand.Read(secret); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	encrypted, err := auth_libraries.EncryptSecret(base64.RawStdEncoding.EncodeToString(secret))
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	key := model.SigningKey{
+		ID:     id,
+		Secret: encrypted,
+	}
+	if err := s.db.Create(&key).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if err := LoadSigningKeys(s.db); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(s.db, c, model.ACTIVITY_ADD_SIGNING_KEY, key.ID, "")
+
+	return c.JSON(http.StatusOK, signingKeyRes{SigningKey: key, Active: true})
+}
+
+// RetireSigningKey stops a key from verifying tokens, every session signed
+// with it is logged out. The last active key can't be retired
+func (s *SettingAPIImpl) RetireSigningKey(c echo.Context) error {
+	kid := c.Param("kid")
+
+	keys, err := fetchSigningKeys(s.db)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	var target *model.SigningKey
+	remaining := 0
+	for i, key := range keys {
+		if key.RetiredAt != nil {
+			continue
+		}
+		if key.ID == kid {
+			target = &keys[i].SigningKey
+			continue
+		}
+		remaining++
+	}
+	if target == nil {
+		return pkg_apierror.Message(c, http.StatusNotFound, "signing key does not exist or is already retired")
+	}
+	if remaining == 0 {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "cannot retire the last active signing key")
+	}
+
+	now := time.Now()
+	target.RetiredAt = &now
+	if err := s.db.Save(target).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if err := LoadSigningKeys(s.db); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	recordActivity(s.db, c, model.ACTIVITY_RETIRE_KEY, kid, "")
+
+	return c.JSON(http.StatusOK, nil)
+}
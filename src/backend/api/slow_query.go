@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+type slowQueryReq struct {
+	Endpoint  string `query:"endpoint"`
+	RequestID string `query:"request_id"`
+	// Sort is recent, the default, or duration for the slowest first
+	Sort     string `query:"sort"`
+	Page     int    `query:"page"`
+	PageSize int    `query:"page_size"`
+}
+
+func fetchSlowQueries(db *gorm.DB, params slowQueryReq) ([]model.SlowQuery, int64, error) {
+	if params.Page < 1 {
+		params.Page = 1
+	}
+	if params.PageSize < 1 || params.PageSize > 100 {
+		params.PageSize = 50
+	}
+
+	query := db.Model(&model.SlowQuery{})
+	if params.Endpoint != "" {
+		query = query.Where("endpoint = ?", params.Endpoint)
+	}
+	if params.RequestID != "" {
+		query = query.Where("request_id = ?", params.RequestID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	order := "id DESC"
+	if params.Sort == "duration" {
+		order = "duration_ms DESC, id DESC"
+	}
+
+	queries := []model.SlowQuery{}
+	err := query.
+		Order(order).
+		Offset((params.Page - 1) * params.PageSize).
+		Limit(params.PageSize).
+		Find(&queries).Error
+
+	return queries, total, err
+}
+
+// FetchSlowQueries lists the statements which ran longer than the slow query
+// threshold, the most recent or the slowest first
+func (s *StatsAPIImpl) FetchSlowQueries(c echo.Context) error {
+	var params *slowQueryReq = new(slowQueryReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if params.Sort != "" && params.Sort != "recent" && params.Sort != "duration" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "sort must be recent or duration")
+	}
+
+	queries, total, err := fetchSlowQueries(s.db, *params)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"queries": queries,
+		"total":   total,
+	})
+}
+
+// ClearSlowQueries empties the slow query log
+func (s *StatsAPIImpl) ClearSlowQueries(c echo.Context) error {
+	result := s.db.Where("1 = 1").Delete(&model.SlowQuery{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+
+	recordActivity(s.db, c, model.ACTIVITY_CLEAR_SLOW_QUERY, "", "")
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deleted": result.RowsAffected,
+	})
+}
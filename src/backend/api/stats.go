@@ -0,0 +1,232 @@
+package api
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/middleware"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/service"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type StatsAPI interface {
+	FetchStorageStats(c echo.Context) error
+	StreamStats(c echo.Context) error
+	FetchSlowQueries(c echo.Context) error
+	ClearSlowQueries(c echo.Context) error
+	FetchIndexSuggestions(c echo.Context) error
+	ClearIndexSuggestions(c echo.Context) error
+	FetchTraffic(c echo.Context) error
+	FetchLatency(c echo.Context) error
+	ResetLatency(c echo.Context) error
+}
+
+type StatsAPIImpl struct {
+	db      *gorm.DB
+	storage service.StorageService
+	backup  service.BackupService
+}
+
+func NewStatsAPI(ioc di.Container) StatsAPI {
+	trackLastBackup()
+
+	return &StatsAPIImpl{
+		db:      ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		storage: ioc.Get(constants.CONTAINER_STORAGE_NAME).(service.StorageService),
+		backup:  ioc.Get(constants.CONTAINER_BACKUP_NAME).(service.BackupService),
+	}
+}
+
+type databaseStats struct {
+	Size          int64 `json:"size"`
+	WALSize       int64 `json:"wal_size"`
+	PageSize      int64 `json:"page_size"`
+	PageCount     int64 `json:"page_count"`
+	FreelistCount int64 `json:"freelist_count"`
+}
+
+// tableStats holds the row count of a table, and its size along with its
+// indexes when SQLite was built with the dbstat table
+type tableStats struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+	Size *int64 `json:"size,omitempty"`
+}
+
+type storageStats struct {
+	Size        int64 `json:"size"`
+	UploadsSize int64 `json:"uploads_size"`
+	ThumbsSize  int64 `json:"thumbs_size"`
+}
+
+type backupStats struct {
+	Size int64 `json:"size"`
+}
+
+// dirSize sums the size of the files under dir, a missing dir is empty
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+func fetchDatabaseStats(db *gorm.DB) (databaseStats, error) {
+	stats := databaseStats{
+		Size:    fileSize(os.Getenv("DB_PATH")),
+		WALSize: fileSize(os.Getenv("DB_PATH") + "-wal"),
+	}
+
+	pragmas := map[string]*int64{
+		"page_size":      &stats.PageSize,
+		"page_count":     &stats.PageCount,
+		"freelist_count": &stats.FreelistCount,
+	}
+	for pragma, value := range pragmas {
+		if err := db.Raw("PRAGMA " + pragma).Scan(value).Error; err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+func fetchTableStats(db *gorm.DB) ([]tableStats, error) {
+	var names []string
+	err := db.Raw(`
+		SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+		ORDER BY name
+	`).Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// dbstat is optional, the sizes are left out when SQLite lacks it
+	var sizes []struct {
+		Name string
+		Size int64
+	}
+	sizeErr := db.Raw(`
+		SELECT m.tbl_name AS name, SUM(s.pgsize) AS size
+		FROM dbstat AS s
+		JOIN sqlite_master AS m ON m.name = s.name
+		GROUP BY m.tbl_name
+	`).Scan(&sizes).Error
+	sizeByTable := map[string]int64{}
+	for _, size := range sizes {
+		sizeByTable[size.Name] = size.Size
+	}
+
+	tables := make([]tableStats, 0, len(names))
+	for _, name := range names {
+		stats := tableStats{Name: name}
+		if err := db.Table(name).Count(&stats.Rows).Error; err != nil {
+			return nil, err
+		}
+		if sizeErr == nil {
+			size := sizeByTable[name]
+			stats.Size = &size
+		}
+		tables = append(tables, stats)
+	}
+
+	return tables, nil
+}
+
+// FetchStorageStats reports the size of the database and of its tables, of
+// the stored files and of the local backups, for capacity planning
+func (s *StatsAPIImpl) FetchStorageStats(c echo.Context) error {
+	database, err := fetchDatabaseStats(s.db)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	tables, err := fetchTableStats(s.db)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	var storage storageStats
+	if storage.UploadsSize, err = dirSize(filepath.Join(s.storage.Dir(), service.UploadsDir)); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	objects, err := s.storage.Objects(c.Request().Context(), "")
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	storage.Size = storage.UploadsSize
+	for _, object := range objects {
+		storage.Size += object.Size
+		if strings.HasPrefix(object.Key, service.ThumbsDir+"/") {
+			storage.ThumbsSize += object.Size
+		}
+	}
+
+	var backups backupStats
+	if backups.Size, err = dirSize(s.backup.Dir()); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"database": database,
+		"tables":   tables,
+		"storage":  storage,
+		"backups":  backups,
+	})
+}
+
+// FetchLatency reports the latency histograms of the main routes since the
+// server started or since they were reset
+func (s *StatsAPIImpl) FetchLatency(c echo.Context) error {
+	routes, since := middleware.FetchLatency()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"since":  since,
+		"routes": routes,
+	})
+}
+
+// ResetLatency empties the latency histograms, so they only hold the
+// requests served from now on
+func (s *StatsAPIImpl) ResetLatency(c echo.Context) error {
+	middleware.ResetLatency()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "success",
+	})
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"react-golang/src/backend/events"
+	"react-golang/src/backend/middleware"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/service"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultStatsInterval = 5 * time.Second
+	minStatsInterval     = time.Second
+	maxStatsInterval     = time.Minute
+)
+
+// backupOutcome is the outcome of the latest backup, Name is a backup file
+// or the restore point of an incremental backup
+type backupOutcome struct {
+	Mode  string    `json:"mode"`
+	Name  string    `json:"name,omitempty"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// lastBackup follows the backup.finished events, it starts from the newest
+// backup found on the first read
+var lastBackup struct {
+	mu      sync.Mutex
+	outcome *backupOutcome
+	loaded  bool
+}
+
+func trackLastBackup() {
+	events.On(events.BACKUP_FINISHED, func(event events.Event) {
+		outcome := backupOutcome{Time: event.Time}
+		outcome.Mode, _ = event.Data["mode"].(string)
+		outcome.Error, _ = event.Data["error"].(string)
+		switch backup := event.Data["backup"].(type) {
+		case service.Backup:
+			outcome.Name = backup.Name
+		case service.RestorePoint:
+			if !backup.Time.IsZero() {
+				outcome.Name = backup.Chain + "@" + backup.Time.Format(time.RFC3339Nano)
+			}
+		}
+
+		lastBackup.mu.Lock()
+		defer lastBackup.mu.Unlock()
+		lastBackup.outcome = &outcome
+		lastBackup.loaded = true
+	})
+}
+
+func (s *StatsAPIImpl) lastBackup(c echo.Context) *backupOutcome {
+	lastBackup.mu.Lock()
+	defer lastBackup.mu.Unlock()
+
+	if !lastBackup.loaded {
+		backups, err := s.backup.FetchBackups(c.Request().Context())
+		if err != nil {
+			return nil
+		}
+		lastBackup.loaded = true
+		for _, backup := range backups {
+			if lastBackup.outcome == nil || backup.CreatedAt.After(lastBackup.outcome.Time) {
+				lastBackup.outcome = &backupOutcome{Mode: "full", Name: backup.Name, Time: backup.CreatedAt}
+			}
+		}
+	}
+
+	return lastBackup.outcome
+}
+
+type liveStats struct {
+	Time              time.Time                 `json:"time"`
+	RequestsPerSecond float64                   `json:"requests_per_second"`
+	Requests          int64                     `json:"requests"`
+	Errors            int64                     `json:"errors"`
+	RealtimeClients   int                       `json:"realtime_clients"`
+	LastBackup        *backupOutcome            `json:"last_backup"`
+	RecentErrors      []middleware.RequestError `json:"recent_errors"`
+}
+
+// StreamStats pushes the live metrics of the server as server-sent events,
+// one stats event every interval seconds (5 by default) until the client
+// goes away. The requests per second are averaged over the last interval.
+// EventSource can't send the API key and the token, so the dashboard reads
+// the stream with fetch
+func (s *StatsAPIImpl) StreamStats(c echo.Context) error {
+	interval := defaultStatsInterval
+	if value := c.QueryParam("interval"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || time.Duration(seconds)*time.Second < minStatsInterval || time.Duration(seconds)*time.Second > maxStatsInterval {
+			return pkg_apierror.Message(c, http.StatusBadRequest, fmt.Sprintf("interval must be between %d and %d seconds", int(minStatsInterval.Seconds()), int(maxStatsInterval.Seconds())))
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	previous := middleware.FetchRequestMetrics()
+	previousAt := time.Now()
+	send := func() error {
+		metrics := middleware.FetchRequestMetrics()
+		now := time.Now()
+		stats := liveStats{
+			Time:            now.UTC(),
+			Requests:        metrics.Requests,
+			Errors:          metrics.Errors,
+			RealtimeClients: realtime.count(),
+			LastBackup:      s.lastBackup(c),
+			RecentErrors:    metrics.RecentErrors,
+		}
+		if elapsed := now.Sub(previousAt).Seconds(); elapsed > 0 {
+			stats.RequestsPerSecond = float64(metrics.Requests-previous.Requests) / elapsed
+		}
+		previous, previousAt = metrics, now
+
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(res, "event: stats\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		res.Flush()
+
+		return nil
+	}
+
+	if err := send(); err != nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-shuttingDown:
+			return nil
+		case <-ticker.C:
+			if err := send(); err != nil {
+				return nil
+			}
+		}
+	}
+}
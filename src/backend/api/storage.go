@@ -0,0 +1,429 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type StorageAPI interface {
+	UploadFile(c echo.Context) error
+	DownloadFile(c echo.Context) error
+	FetchFiles(c echo.Context) error
+	FetchFile(c echo.Context) error
+	FetchUsage(c echo.Context) error
+	SignFile(c echo.Context) error
+	MigrateFiles(c echo.Context) error
+
+	CreateUpload(c echo.Context) error
+	FetchUpload(c echo.Context) error
+	AppendUpload(c echo.Context) error
+	CompleteUpload(c echo.Context) error
+	AbortUpload(c echo.Context) error
+}
+
+type StorageAPIImpl struct {
+	db      *gorm.DB
+	storage service.StorageService
+}
+
+func NewStorageAPI(ioc di.Container) StorageAPI {
+	return &StorageAPIImpl{
+		db:      ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		storage: ioc.Get(constants.CONTAINER_STORAGE_NAME).(service.StorageService),
+	}
+}
+
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrFileNotFound), errors.Is(err, service.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrUploadOffset), errors.Is(err, service.ErrUploadIncomplete):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrUploadTooLarge), errors.Is(err, service.ErrFileTooLarge),
+		errors.Is(err, service.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrInvalidThumb), errors.Is(err, service.ErrInvalidFormat),
+		errors.Is(err, service.ErrNotAnImage), errors.Is(err, service.ErrImageTooLarge),
+		errors.Is(err, service.ErrSameStorage):
+		return http.StatusBadRequest
+	case errors.Is(err, errSignatureRequired), errors.Is(err, errInvalidSignature):
+		return http.StatusUnauthorized
+	case errors.Is(err, errFileForbidden), errors.Is(err, service.ErrFileQuarantined):
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// UploadFile stores the file of a multipart request in one go, larger files
+// are sent in chunks through an upload instead
+func (s *StorageAPIImpl) UploadFile(c echo.Context) error {
+	header, err := c.FormFile("file")
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	src, err := header.Open()
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	defer src.Close()
+
+	userID, _ := c.Get("user_id").(string)
+	file, err := s.storage.Save(c.Request().Context(), header.Filename, userID, src)
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	return c.JSON(http.StatusOK, file)
+}
+
+// DownloadFile serves a stored file. Images can be served as a thumbnail
+// instead with ?thumb=WxH, cropped around their center to fill the size, or
+// fit inside it with a f suffix as in 200x200f. A width or height of 0 keeps
+// the aspect ratio, and ?format= converts the thumbnail to jpeg, png or gif.
+// Protected files are only served through the URLs signed by SignFile
+func (s *StorageAPIImpl) DownloadFile(c echo.Context) error {
+	file, err := s.storage.Fetch(c.Request().Context(), c.Param("key"))
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	protected, err := fileProtected(s.db, file)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if protected {
+		if err := verifyFileURL(c, file.Key); err != nil {
+			return pkg_apierror.Error(c, storageErrorStatus(err), err)
+		}
+		// shared caches must not keep what was only meant for the caller
+		c.Response().Header().Set(echo.HeaderCacheControl, "private")
+	}
+
+	var content io.ReadSeekCloser
+	if thumb := c.QueryParam("thumb"); thumb != "" {
+		content, file, err = s.storage.OpenThumbnail(c.Request().Context(), c.Param("key"), thumb, c.QueryParam("format"))
+	} else {
+		content, file, err = s.storage.Open(c.Request().Context(), c.Param("key"))
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+	defer content.Close()
+
+	// the ETag lets clients resume a download with If-Range without risking
+	// mixing the bytes of two versions of the file
+	etag := file.Hash
+	if thumb := c.QueryParam("thumb"); thumb != "" && etag != "" {
+		etag += "-" + thumb + "-" + strings.TrimPrefix(file.MimeType, "image/")
+	}
+	if etag != "" {
+		c.Response().Header().Set("ETag", strconv.Quote(etag))
+	}
+	c.Response().Header().Set(echo.HeaderContentType, file.MimeType)
+	// the type is the uploader's, a page or an SVG must never run as script
+	// on the origin of the API
+	c.Response().Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	if !servedInline(file.MimeType) {
+		c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	}
+	// ServeContent answers Range requests with the 206 partial content media
+	// players rely on to stream and seek
+	http.ServeContent(c.Response(), c.Request(), file.Name, file.CreatedAt, content)
+
+	return nil
+}
+
+// inlineMimeTypes are the types a browser shows without running anything,
+// the other files are always downloaded
+var inlineMimeTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/avif":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"audio/wav":       true,
+	"audio/webm":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+func servedInline(mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	return err == nil && inlineMimeTypes[mediaType]
+}
+
+type fetchFilesReq struct {
+	Search     string `query:"search"`
+	Table      string `query:"table"`
+	RowID      string `query:"row_id"`
+	Column     string `query:"column"`
+	UploadedBy string `query:"uploaded_by"`
+	MimeType   string `query:"mime_type"`
+	ScanStatus string `query:"scan_status"`
+	Page       int    `query:"page"`
+	PageSize   int    `query:"page_size"`
+}
+
+// FetchFiles lists the stored files, newest first. The files can be searched
+// by name and narrowed to the row they belong to, their uploader, their
+// MIME type, where a type such as image matches every image, or their scan
+// status to find the quarantined files and the rows holding them
+func (s *StorageAPIImpl) FetchFiles(c echo.Context) error {
+	var params *fetchFilesReq = new(fetchFilesReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	files, err := s.storage.FetchFiles(c.Request().Context(), service.FileFilter{
+		Search:     params.Search,
+		Table:      params.Table,
+		RowID:      params.RowID,
+		Column:     params.Column,
+		UploadedBy: params.UploadedBy,
+		MimeType:   params.MimeType,
+		ScanStatus: params.ScanStatus,
+		Page:       params.Page,
+		PageSize:   params.PageSize,
+	})
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, files)
+}
+
+type signFileReq struct {
+	ExpiresIn int `json:"expires_in"`
+}
+
+// SignFile issues a URL serving a file for expires_in seconds, an hour by
+// default and a week at most. The caller must be allowed to read the file
+// column of the row holding the file
+func (s *StorageAPIImpl) SignFile(c echo.Context) error {
+	var body *signFileReq = new(signFileReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	ttl := defaultSignedURLTTL
+	if body.ExpiresIn > 0 {
+		ttl = min(time.Duration(body.ExpiresIn)*time.Second, maxSignedURLTTL)
+	}
+
+	file, err := s.storage.Fetch(c.Request().Context(), c.Param("key"))
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	allowed, err := canAccessFile(s.db, c, file)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if !allowed {
+		return pkg_apierror.Error(c, storageErrorStatus(errFileForbidden), errFileForbidden)
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	query, err := signFileURL(file.Key, expiresAt)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"url":        "/api/files/" + file.Key + "?" + query.Encode(),
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// FetchUsage reports the storage used by the files of every table along with
+// the quotas
+func (s *StorageAPIImpl) FetchUsage(c echo.Context) error {
+	usage, err := s.storage.Usage(c.Request().Context())
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	var tables []model.Tables
+	if err := s.db.Where("storage_quota_mb > 0").Find(&tables).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	quotas := map[string]int64{}
+	for _, table := range tables {
+		quotas[table.Name] = int64(table.StorageQuotaMB) << 20
+	}
+	for i := range usage.Tables {
+		usage.Tables[i].Quota = quotas[usage.Tables[i].Table]
+	}
+
+	return c.JSON(http.StatusOK, usage)
+}
+
+type migrateFilesReq struct {
+	From string `json:"from"`
+}
+
+// MigrateFiles copies the stored files, their thumbnails and the quarantined
+// files from the storage of the from backend to the storage of the settings.
+// The files are switched over by setting storage_backend first, then
+// migrating from the previous backend. Files already copied are skipped so
+// an interrupted migration can be run again
+func (s *StorageAPIImpl) MigrateFiles(c echo.Context) error {
+	var body *migrateFilesReq = new(migrateFilesReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if body.From != "local" && body.From != "s3" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "from must be local or s3")
+	}
+
+	migration, err := s.storage.Migrate(c.Request().Context(), body.From)
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	return c.JSON(http.StatusOK, migration)
+}
+
+func (s *StorageAPIImpl) FetchFile(c echo.Context) error {
+	file, err := s.storage.Fetch(c.Request().Context(), c.Param("key"))
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	return c.JSON(http.StatusOK, file)
+}
+
+type createUploadReq struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+}
+
+// CreateUpload starts a chunked upload of size bytes, the chunks are then
+// appended with AppendUpload. It follows the core of the tus protocol, the
+// offset of an upload is sent in the Upload-Offset header
+func (s *StorageAPIImpl) CreateUpload(c echo.Context) error {
+	var body *createUploadReq = new(createUploadReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	userID, _ := c.Get("user_id").(string)
+	upload, err := s.storage.CreateUpload(c.Request().Context(), service.Upload{
+		Name:     body.Name,
+		Size:     body.Size,
+		MimeType: body.MimeType,
+		UserID:   userID,
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrFileTooLarge) || errors.Is(err, service.ErrQuotaExceeded) {
+			return pkg_apierror.Error(c, http.StatusRequestEntityTooLarge, err)
+		}
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	return uploadResponse(c, upload)
+}
+
+func uploadResponse(c echo.Context, upload service.Upload) error {
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+
+	return c.JSON(http.StatusOK, upload)
+}
+
+// fetchOwnUpload returns the upload when it was created by the caller, the
+// uploads of others don't exist as far as the caller is concerned
+func (s *StorageAPIImpl) fetchOwnUpload(c echo.Context) (service.Upload, error) {
+	upload, err := s.storage.FetchUpload(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return upload, err
+	}
+
+	userID, _ := c.Get("user_id").(string)
+	if upload.UserID != userID {
+		return upload, service.ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+func (s *StorageAPIImpl) FetchUpload(c echo.Context) error {
+	upload, err := s.fetchOwnUpload(c)
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	return uploadResponse(c, upload)
+}
+
+// AppendUpload appends the body to the upload at the offset given in the
+// Upload-Offset header. A chunk sent at the wrong offset is rejected along
+// with the offset the upload is at, so the client can resume from there
+func (s *StorageAPIImpl) AppendUpload(c echo.Context) error {
+	if _, err := s.fetchOwnUpload(c); err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "the Upload-Offset header must be the offset of the chunk")
+	}
+
+	upload, err := s.storage.AppendUpload(c.Request().Context(), c.Param("id"), offset, c.Request().Body)
+	if err != nil {
+		c.Response().Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		return pkg_apierror.Write(c, storageErrorStatus(err), pkg_apierror.Response{
+			Message: err.Error(),
+			Details: map[string]interface{}{"offset": upload.Offset},
+		})
+	}
+
+	return uploadResponse(c, upload)
+}
+
+// CompleteUpload turns an upload whose every chunk was received into a
+// stored file
+func (s *StorageAPIImpl) CompleteUpload(c echo.Context) error {
+	if _, err := s.fetchOwnUpload(c); err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	file, err := s.storage.CompleteUpload(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	return c.JSON(http.StatusOK, file)
+}
+
+func (s *StorageAPIImpl) AbortUpload(c echo.Context) error {
+	if _, err := s.fetchOwnUpload(c); err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	if err := s.storage.AbortUpload(c.Request().Context(), c.Param("id")); err != nil {
+		return pkg_apierror.Error(c, storageErrorStatus(err), err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
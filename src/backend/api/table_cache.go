@@ -0,0 +1,72 @@
+package api
+
+import (
+	"log"
+	pkg_cache "react-golang/src/backend/pkg/cache"
+	"strings"
+	"time"
+)
+
+// Cache is shared by the instances of the app when its backend is redis, it
+// is set when the app starts and is local to the process until then
+var Cache pkg_cache.Cache = pkg_cache.NewMemoryCache(5 * time.Minute)
+
+// the infos and columns of the tables are read by nearly every request so
+// they are cached, the entries of a table are dropped whenever its schema
+// changes and expire after a while in case it was changed elsewhere
+const (
+	tableCachePrefix = "schema:"
+	tableCacheTTL    = 5 * time.Minute
+)
+
+func tableInfoKey(tableName string) string {
+	return tableCachePrefix + "table:" + strings.ToLower(tableName)
+}
+
+func columnsKey(tableName string) string {
+	return tableCachePrefix + "columns:" + strings.ToLower(tableName)
+}
+
+// cacheGet reads key into value, a cache that can't be reached is treated as
+// a miss so requests fall back to the database
+func cacheGet(key string, value interface{}) bool {
+	found, err := Cache.Get(key, value)
+	if err != nil {
+		log.Printf("failed to read %s from the cache: %v\n", key, err)
+		return false
+	}
+
+	return found
+}
+
+func cacheSet(key string, value interface{}, ttl time.Duration) {
+	if err := Cache.Set(key, value, ttl); err != nil {
+		log.Printf("failed to write %s to the cache: %v\n", key, err)
+	}
+}
+
+// invalidateTables drops the cached schema of the tables
+func invalidateTables(tableNames ...string) {
+	keys := []string{}
+	for _, tableName := range tableNames {
+		keys = append(keys, tableInfoKey(tableName), columnsKey(tableName))
+	}
+
+	schemaChanged()
+	if err := Cache.Delete(keys...); err != nil {
+		log.Printf("failed to invalidate the cached tables: %v\n", err)
+	}
+	invalidateResults(tableNames...)
+}
+
+// flushTableCache drops the cached schema of every table, for changes whose
+// tables aren't known such as raw queries
+func flushTableCache() {
+	schemaChanged()
+	if err := Cache.DeletePrefix(tableCachePrefix); err != nil {
+		log.Printf("failed to flush the cached tables: %v\n", err)
+	}
+	if err := Cache.DeletePrefix(resultCachePrefix); err != nil {
+		log.Printf("failed to flush the cached results: %v\n", err)
+	}
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// anonymizeHash hashes a value with the JWT_SECRET_KEY secret, the hashes of
+// the exports stay equal across exports but can't be reversed without it
+func anonymizeHash(value string) string {
+	mac := hmac.New(sha256.New, auth_libraries.DefaultSigningKey().Secret)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// anonymizeMask replaces the letters and digits of a value but the first
+// one with *. The domain of an email is kept
+func anonymizeMask(value string) string {
+	domain := ""
+	if at := strings.LastIndex(value, "@"); at > 0 {
+		value, domain = value[:at], value[at:]
+	}
+
+	masked := []rune(value)
+	for i, r := range masked {
+		if i > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			masked[i] = '*'
+		}
+	}
+
+	return string(masked) + domain
+}
+
+// anonymizeRows applies the anonymization strategies of the columns of a
+// table to rows. Null values are kept
+func anonymizeRows(metas map[string]model.ColumnMeta, rows []map[string]interface{}) {
+	for _, row := range rows {
+		for column, meta := range metas {
+			value, ok := row[column]
+			if !ok {
+				continue
+			}
+
+			switch meta.Anonymize {
+			case model.ANONYMIZE_DROP:
+				delete(row, column)
+			case model.ANONYMIZE_HASH, model.ANONYMIZE_MASK:
+				if value == nil {
+					continue
+				}
+				text := fmt.Sprint(value)
+				if bytes, ok := value.([]byte); ok {
+					text = string(bytes)
+				}
+				if meta.Anonymize == model.ANONYMIZE_HASH {
+					row[column] = anonymizeHash(text)
+				} else {
+					row[column] = anonymizeMask(text)
+				}
+			}
+		}
+	}
+}
+
+type exportRowsReq struct {
+	// Anonymize applies the anonymization strategies of the columns, so the
+	// export can be shared
+	Anonymize bool `query:"anonymize"`
+}
+
+// exportBatch is the number of rows read before they are written out
+const exportBatch = 500
+
+// ExportRows sends every row of a table as a JSON array, without the
+// credentials of auth tables. With anonymize the columns are anonymized as
+// set in their metadata
+func (d *DatabaseAPIImpl) ExportRows(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	var params *exportRowsReq = new(exportRowsReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	metas, err := fetchColumnMeta(d.db, tableName)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	rows, err := d.read.WithContext(c.Request().Context()).Table(tableName).Select("*").Rows()
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+
+	filename := tableName + ".json"
+	if params.Anonymize {
+		filename = tableName + "-anonymized.json"
+	}
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Response().WriteHeader(http.StatusOK)
+
+	written := 0
+	write := func(batch []map[string]interface{}) error {
+		if err := stripRestrictedColumns(d.db, c, table, batch); err != nil {
+			return err
+		}
+		if params.Anonymize {
+			anonymizeRows(metas, batch)
+		}
+		for _, row := range batch {
+			separator := ","
+			if written == 0 {
+				separator = "["
+			}
+			content, err := json.Marshal(row)
+			if err != nil {
+				return err
+			}
+			if _, err := c.Response().Write(append([]byte(separator), content...)); err != nil {
+				return err
+			}
+			written++
+		}
+		c.Response().Flush()
+
+		return nil
+	}
+
+	batch := make([]map[string]interface{}, 0, exportBatch)
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err := d.read.ScanRows(rows, &row); err != nil {
+			return err
+		}
+		batch = append(batch, row)
+		if len(batch) == exportBatch {
+			if err := write(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := write(batch); err != nil {
+		return err
+	}
+
+	if written == 0 {
+		_, err = c.Response().Write([]byte("[]"))
+	} else {
+		_, err = c.Response().Write([]byte("]"))
+	}
+
+	return err
+}
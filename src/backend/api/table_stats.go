@@ -0,0 +1,167 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// lastWrites holds when the rows of the tables were last written through the
+// API since the server started, by lowercased table name
+var lastWrites sync.Map
+
+func recordWrite(tableName string) {
+	lastWrites.Store(strings.ToLower(tableName), time.Now().UTC())
+}
+
+// timestampLayouts are the layouts of the timestamps stored by SQLite and by
+// the API
+var timestampLayouts = []string{"2006-01-02 15:04:05", time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"}
+
+type indexStats struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+	Size    *int64   `json:"size,omitempty"`
+	// Used tells whether the index was seen in the sampled query plans
+	Used     bool       `json:"used"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+type tableDetailStats struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+	// Size is the size of the rows and of the indexes, left out when
+	// SQLite was built without the dbstat table
+	Size    *int64       `json:"size,omitempty"`
+	Indexes []indexStats `json:"indexes"`
+	// IndexUsageSince is when the sampling of the query plans started, the
+	// indexes not used since then are reported unused
+	IndexUsageSince time.Time  `json:"index_usage_since"`
+	LastWrite       *time.Time `json:"last_write,omitempty"`
+}
+
+// fetchIndexStats returns the indexes of a table along with their columns,
+// their sizes when SQLite has the dbstat table and their sampled usage
+func fetchIndexStats(db *gorm.DB, tableName string) ([]indexStats, error) {
+	var list []struct {
+		Name   string
+		Unique bool
+	}
+	err := db.Raw(`SELECT name, "unique" FROM pragma_index_list(?) ORDER BY name`, tableName).Scan(&list).Error
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]indexStats, 0, len(list))
+	for _, index := range list {
+		stats := indexStats{Name: index.Name, Unique: index.Unique, Columns: []string{}}
+		err := db.Raw("SELECT name FROM pragma_index_info(?) ORDER BY seqno", index.Name).Scan(&stats.Columns).Error
+		if err != nil {
+			return nil, err
+		}
+
+		var size int64
+		if err := db.Raw("SELECT COALESCE(SUM(pgsize), 0) FROM dbstat WHERE name = ?", index.Name).Scan(&size).Error; err == nil {
+			stats.Size = &size
+		}
+
+		if used, ok := pkg_sqlite.IndexLastUsed(index.Name); ok {
+			stats.Used = true
+			stats.LastUsed = &used
+		}
+		indexes = append(indexes, stats)
+	}
+
+	return indexes, nil
+}
+
+// lastWrite returns when the rows of a table were last written, the latest of
+// its updated_at and created_at columns and of the writes through the API
+func lastWrite(db *gorm.DB, tableName string) (*time.Time, error) {
+	var latest *time.Time
+	if written, ok := lastWrites.Load(strings.ToLower(tableName)); ok {
+		at := written.(time.Time)
+		latest = &at
+	}
+
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		if column.Name != "updated_at" && column.Name != "created_at" {
+			continue
+		}
+
+		var value *string
+		err := db.Raw(fmt.Sprintf("SELECT CAST(MAX(`%s`) AS TEXT) FROM `%s`", column.Name, tableName)).Scan(&value).Error
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		for _, layout := range timestampLayouts {
+			if at, err := time.Parse(layout, *value); err == nil {
+				at = at.UTC()
+				if latest == nil || at.After(*latest) {
+					latest = &at
+				}
+				break
+			}
+		}
+	}
+
+	return latest, nil
+}
+
+// FetchTableStats reports the row count and the size of a table, its indexes
+// along with whether the reads use them and when its rows were last written
+func (d *DatabaseAPIImpl) FetchTableStats(c echo.Context) error {
+	tableName := c.Param("table_name")
+
+	_, err := getTableInfo(d.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	db := d.read.WithContext(c.Request().Context())
+	stats := tableDetailStats{Name: tableName, IndexUsageSince: pkg_sqlite.IndexUsageSince()}
+
+	if stats.Rows, err = countRows(db, tableName, nil, nil, countExact); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// dbstat is optional, the size is left out when SQLite lacks it
+	var size int64
+	err = db.Raw(`
+		SELECT COALESCE(SUM(s.pgsize), 0)
+		FROM dbstat AS s
+		JOIN sqlite_master AS m ON m.name = s.name
+		WHERE m.tbl_name = ?
+	`, tableName).Scan(&size).Error
+	if err == nil {
+		stats.Size = &size
+	}
+
+	if stats.Indexes, err = fetchIndexStats(db, tableName); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if stats.LastWrite, err = lastWrite(db, tableName); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
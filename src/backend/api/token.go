@@ -0,0 +1,184 @@
+package api
+
+import (
+	"net/http"
+	"react-golang/src/backend/constants"
+	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/middleware"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type TokenAPI interface {
+	FetchTokens(c echo.Context) error
+	CreateToken(c echo.Context) error
+	DeleteToken(c echo.Context) error
+}
+
+type TokenAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewTokenAPI(ioc di.Container) TokenAPI {
+	return &TokenAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+func isAPITokenRequest(c echo.Context) bool {
+	apiToken, _ := c.Get("api_token").(bool)
+	return apiToken
+}
+
+// NewAPITokenAuthenticator returns the lookup used by the auth middleware to
+// turn an API token into the claims of its owner. The claims are rebuilt on
+// every request so role changes apply immediately
+func NewAPITokenAuthenticator(db *gorm.DB) func(c echo.Context, token string) (map[string]interface{}, bool) {
+	return func(c echo.Context, token string) (map[string]interface{}, bool) {
+		var userToken model.UserToken
+		err := db.Where("token_hash = ?", auth_libraries.HashRecoveryCode(token)).
+			Take(&userToken).Error
+		if err != nil {
+			return nil, false
+		}
+
+		now := time.Now()
+		if userToken.ExpiresAt != nil && now.After(*userToken.ExpiresAt) {
+			return nil, false
+		}
+
+		var claims map[string]interface{}
+		if userToken.Table == "admin" {
+			var admin model.Admin
+			if err := db.Where("id = ?", userToken.UserID).Take(&admin).Error; err != nil {
+				return nil, false
+			}
+
+			claims = map[string]interface{}{
+				"sub":        admin.ID,
+				"email":      admin.Email,
+				"roles":      []interface{}{"user", "admin"},
+				"admin_role": admin.Role,
+			}
+		} else {
+			var user map[string]interface{}
+			err := db.Table(userToken.Table).
+				Where("id = ?", userToken.UserID).
+				Take(&user).Error
+			if err != nil {
+				return nil, false
+			}
+
+			claims, err = userTokenClaims(db, userToken.Table, user)
+			if err != nil {
+				return nil, false
+			}
+			claims["roles"] = []interface{}{"user", userToken.Table}
+		}
+
+		db.Model(&model.UserToken{}).
+			Where("id = ?", userToken.ID).
+			Update("last_used_at", now)
+
+		return claims, true
+	}
+}
+
+func (t *TokenAPIImpl) FetchTokens(c echo.Context) error {
+	table, userID := currentSessionOwner(c)
+
+	tokens := []model.UserToken{}
+	err := t.db.Where("`table` = ?", table).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+type createTokenReq struct {
+	Name          string `json:"name"`
+	ExpiresInDays int    `json:"expires_in_days"`
+}
+
+// CreateToken issues a new API token for the current user, the token itself
+// is only returned once
+func (t *TokenAPIImpl) CreateToken(c echo.Context) error {
+	// a leaked API token must not be able to issue more of them
+	if isAPITokenRequest(c) {
+		return pkg_apierror.Message(c, http.StatusForbidden, "API tokens can't create API tokens")
+	}
+
+	var body *createTokenReq = new(createTokenReq)
+	if err := c.Bind(body); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if body.Name == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "token name is required")
+	}
+
+	table, userID := currentSessionOwner(c)
+	if table == "" || userID == "" {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "unknown token owner")
+	}
+
+	id, err := utils.GenerateRandomString(16)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	secret, err := utils.GenerateRandomString(40)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	token := middleware.APITokenPrefix + secret
+
+	userToken := model.UserToken{
+		ID:        id,
+		Table:     table,
+		UserID:    userID,
+		Name:      body.Name,
+		TokenHash: auth_libraries.HashRecoveryCode(token),
+	}
+	if body.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, body.ExpiresInDays)
+		userToken.ExpiresAt = &expiresAt
+	}
+
+	if err := t.db.Create(&userToken).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token":   token,
+		"details": userToken,
+	})
+}
+
+func (t *TokenAPIImpl) DeleteToken(c echo.Context) error {
+	table, userID := currentSessionOwner(c)
+
+	result := t.db.
+		Where("id = ?", c.Param("id")).
+		Where("`table` = ?", table).
+		Where("user_id = ?", userID).
+		Delete(&model.UserToken{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return pkg_apierror.Message(c, http.StatusNotFound, "token not found")
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
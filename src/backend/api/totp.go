@@ -0,0 +1,111 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	auth_libraries "react-golang/src/backend/library/auth"
+
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// totpColumns are added to auth tables so users can enroll 2FA, totp_enabled
+// can be checked by table rules
+var totpColumns = []string{
+	"totp_secret TEXT",
+	"totp_enabled BOOLEAN NOT NULL DEFAULT 0",
+	"recovery_codes TEXT",
+}
+
+// ensureTOTPColumns adds the 2FA columns to auth tables created before they
+// were part of the auth fields
+func ensureTOTPColumns(db *gorm.DB, tableName string) error {
+	defer invalidateTables(tableName)
+
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, column := range columns {
+		existing[column.Name] = true
+	}
+
+	for _, definition := range totpColumns {
+		name := strings.SplitN(definition, " ", 2)[0]
+		if existing[name] {
+			continue
+		}
+
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", tableName, definition)).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enrollTOTP generates a new secret, returning it in plain text for the
+// authenticator app and encrypted for storage
+func enrollTOTP(issuer, account string) (secret string, encrypted string, url string, err error) {
+	secret, err = auth_libraries.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	encrypted, err = auth_libraries.EncryptSecret(secret)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return secret, encrypted, auth_libraries.TOTPURL(issuer, account, secret), nil
+}
+
+func verifyTOTPCode(encryptedSecret, code string) bool {
+	if encryptedSecret == "" {
+		return false
+	}
+
+	secret, err := auth_libraries.DecryptSecret(encryptedSecret)
+	if err != nil {
+		return false
+	}
+
+	return auth_libraries.VerifyTOTP(secret, code)
+}
+
+// verifySecondFactor accepts either a TOTP code or one of the recovery
+// codes. The returned recovery codes no longer contain the one that was used
+func verifySecondFactor(encryptedSecret, recoveryCodes, code string) (bool, string) {
+	if verifyTOTPCode(encryptedSecret, code) {
+		return true, recoveryCodes
+	}
+
+	hash := auth_libraries.HashRecoveryCode(code)
+	remaining := []string{}
+	found := false
+	for _, stored := range strings.Split(recoveryCodes, ",") {
+		if stored == "" {
+			continue
+		}
+		if stored == hash && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, stored)
+	}
+
+	return found, strings.Join(remaining, ",")
+}
+
+func generateRecoveryCodes() (codes []string, stored string, err error) {
+	codes, hashes, err := auth_libraries.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return codes, strings.Join(hashes, ","), nil
+}
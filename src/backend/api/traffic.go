@@ -0,0 +1,249 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"react-golang/src/backend/middleware"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the hourly rollups older than this are purged
+const trafficRetention = 90 * 24 * time.Hour
+
+// trafficDimensions are the columns the traffic can be broken down by
+var trafficDimensions = map[string]string{
+	"endpoint":     "endpoint",
+	"table":        `"table"`,
+	"status_class": "status_class",
+	"principal":    "principal",
+}
+
+// trafficMu keeps two flushes from adding the same counts twice
+var trafficMu sync.Mutex
+
+// FlushTraffic adds the requests counted since the last flush to the hourly
+// rollups
+func FlushTraffic(db *gorm.DB) error {
+	trafficMu.Lock()
+	defer trafficMu.Unlock()
+
+	counts := middleware.DrainTraffic()
+	if len(counts) == 0 {
+		return nil
+	}
+
+	rows := make([]model.Traffic, 0, len(counts))
+	for _, count := range counts {
+		rows = append(rows, model.Traffic{
+			Hour:        count.Hour,
+			Endpoint:    count.Endpoint,
+			Table:       count.Table,
+			StatusClass: count.StatusClass,
+			Principal:   count.Principal,
+			Requests:    count.Requests,
+			DurationMs:  count.DurationMs,
+		})
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hour"}, {Name: "endpoint"}, {Name: "table"}, {Name: "status_class"}, {Name: "principal"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":    gorm.Expr("requests + excluded.requests"),
+			"duration_ms": gorm.Expr("duration_ms + excluded.duration_ms"),
+		}),
+	}).Create(&rows).Error
+}
+
+// PurgeTraffic deletes the hourly rollups past the retention
+func PurgeTraffic(db *gorm.DB) (int64, error) {
+	result := db.Where("hour < ?", time.Now().UTC().Add(-trafficRetention)).Delete(&model.Traffic{})
+	return result.RowsAffected, result.Error
+}
+
+type trafficReq struct {
+	// Granularity is hour, the default, or day
+	Granularity string `query:"granularity"`
+	From        string `query:"from"`
+	To          string `query:"to"`
+	// GroupBy lists the dimensions separated by commas, table and
+	// status_class by default
+	GroupBy   string `query:"group_by"`
+	Table     string `query:"table"`
+	Principal string `query:"principal"`
+}
+
+type trafficBucket struct {
+	Time          string  `json:"time"`
+	Endpoint      *string `json:"endpoint,omitempty"`
+	Table         *string `json:"table,omitempty"`
+	StatusClass   *string `json:"status_class,omitempty"`
+	Principal     *string `json:"principal,omitempty"`
+	Requests      int64   `json:"requests"`
+	AvgDurationMs float64 `json:"avg_duration_ms"`
+}
+
+type trafficTotals struct {
+	Requests     int64   `json:"requests"`
+	ClientErrors int64   `json:"client_errors"`
+	ServerErrors int64   `json:"server_errors"`
+	ErrorRate    float64 `json:"error_rate"`
+}
+
+// parseTrafficTime reads a time of the query, either RFC 3339 or a date
+func parseTrafficTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	return time.Parse(time.DateOnly, value)
+}
+
+// FetchTraffic reports the requests per hour or day, broken down by the
+// dimensions of group_by, along with the totals and error rate of the range
+func (s *StatsAPIImpl) FetchTraffic(c echo.Context) error {
+	var params *trafficReq = new(trafficReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	format := "%Y-%m-%dT%H:00:00Z"
+	span := 24 * time.Hour
+	switch params.Granularity {
+	case "", "hour":
+		params.Granularity = "hour"
+	case "day":
+		format = "%Y-%m-%dT00:00:00Z"
+		span = 30 * 24 * time.Hour
+	default:
+		return pkg_apierror.Message(c, http.StatusBadRequest, "granularity must be hour or day")
+	}
+
+	to := time.Now().UTC()
+	if params.To != "" {
+		t, err := parseTrafficTime(params.To)
+		if err != nil {
+			return pkg_apierror.Message(c, http.StatusBadRequest, "to must be a date or an RFC 3339 time")
+		}
+		to = t
+	}
+	from := to.Add(-span)
+	if params.From != "" {
+		t, err := parseTrafficTime(params.From)
+		if err != nil {
+			return pkg_apierror.Message(c, http.StatusBadRequest, "from must be a date or an RFC 3339 time")
+		}
+		from = t
+	}
+
+	if params.GroupBy == "" {
+		params.GroupBy = "table,status_class"
+	}
+	dimensions := []string{}
+	for _, dimension := range strings.Split(params.GroupBy, ",") {
+		dimension = strings.TrimSpace(dimension)
+		if _, ok := trafficDimensions[dimension]; !ok {
+			return pkg_apierror.Message(c, http.StatusBadRequest, fmt.Sprintf("unknown dimension %q, group_by takes endpoint, table, status_class and principal", dimension))
+		}
+		dimensions = append(dimensions, dimension)
+	}
+
+	if err := FlushTraffic(s.db); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// the hours are compared from their start, a range ending within an hour
+	// holds it
+	query := s.db.Model(&model.Traffic{}).
+		Where("hour >= ? AND hour < ?", from.Truncate(time.Hour), to)
+	if params.Table != "" {
+		query = query.Where(`"table" = ?`, params.Table)
+	}
+	if params.Principal != "" {
+		query = query.Where("principal = ?", params.Principal)
+	}
+
+	var totals struct {
+		Requests     int64
+		ClientErrors int64
+		ServerErrors int64
+	}
+	err := query.Session(&gorm.Session{}).
+		Select(`COALESCE(SUM(requests), 0) AS requests,
+			COALESCE(SUM(CASE WHEN status_class = '4xx' THEN requests END), 0) AS client_errors,
+			COALESCE(SUM(CASE WHEN status_class = '5xx' THEN requests END), 0) AS server_errors`).
+		Scan(&totals).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	columns := []string{fmt.Sprintf("strftime('%s', hour) AS time", format)}
+	groups := []string{"1"}
+	for i, dimension := range dimensions {
+		columns = append(columns, fmt.Sprintf(`%s AS "%s"`, trafficDimensions[dimension], dimension))
+		groups = append(groups, fmt.Sprint(i+2))
+	}
+	columns = append(columns, "SUM(requests) AS requests", "SUM(duration_ms) / SUM(requests) AS avg_duration_ms")
+
+	rows, err := query.Session(&gorm.Session{}).
+		Select(strings.Join(columns, ", ")).
+		Group(strings.Join(groups, ", ")).
+		Order("1, requests DESC").
+		Rows()
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+
+	buckets := []trafficBucket{}
+	for rows.Next() {
+		var bucket trafficBucket
+		values := []interface{}{&bucket.Time}
+		for _, dimension := range dimensions {
+			value := new(string)
+			switch dimension {
+			case "endpoint":
+				bucket.Endpoint = value
+			case "table":
+				bucket.Table = value
+			case "status_class":
+				bucket.StatusClass = value
+			case "principal":
+				bucket.Principal = value
+			}
+			values = append(values, value)
+		}
+		values = append(values, &bucket.Requests, &bucket.AvgDurationMs)
+		if err := rows.Scan(values...); err != nil {
+			return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	result := trafficTotals{
+		Requests:     totals.Requests,
+		ClientErrors: totals.ClientErrors,
+		ServerErrors: totals.ServerErrors,
+	}
+	if totals.Requests > 0 {
+		result.ErrorRate = float64(totals.ClientErrors+totals.ServerErrors) / float64(totals.Requests)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"granularity": params.Granularity,
+		"from":        from,
+		"to":          to,
+		"totals":      result,
+		"buckets":     buckets,
+	})
+}
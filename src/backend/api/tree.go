@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// maxTreeDepth is how many levels the tree endpoints walk at most, it also
+// stops the walk of trees whose rows reference each other in a loop
+const maxTreeDepth = 100
+
+type treeReq struct {
+	// Column is the column referencing the parent row, needed when the
+	// table references itself through several columns
+	Column string `query:"column"`
+	// Depth is how many levels are walked, every level up to maxTreeDepth
+	// when it is zero
+	Depth int `query:"depth"`
+}
+
+// treeColumn returns the column through which a table references itself,
+// column names it when there are several
+func treeColumn(db *gorm.DB, tableName string, column string) (string, error) {
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return "", err
+	}
+
+	found := []string{}
+	for _, c := range columns {
+		if c.Reference == tableName && (column == "" || c.Name == column) {
+			found = append(found, c.Name)
+		}
+	}
+	switch {
+	case len(found) == 0 && column != "":
+		return "", fmt.Errorf("%s doesn't reference %s", column, tableName)
+	case len(found) == 0:
+		return "", fmt.Errorf("%s has no relation to itself", tableName)
+	case len(found) > 1:
+		return "", fmt.Errorf("%s references itself through several columns, name one as column", tableName)
+	}
+
+	return found[0], nil
+}
+
+// fetchTree answers the tree endpoints, query selects the rows of the tree
+// with their level as @depth from the id of the row, the column referencing
+// the parent and the depth
+func (d *DatabaseAPIImpl) fetchTree(c echo.Context, query string) error {
+	tableName := c.Param("table_name")
+	id := c.Param("id")
+
+	var params *treeReq = new(treeReq)
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, params); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+	if params.Depth <= 0 || params.Depth > maxTreeDepth {
+		params.Depth = maxTreeDepth
+	}
+
+	table, err := getTableInfo(d.db, tableName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg_apierror.Message(c, http.StatusNotFound, "table does not exist")
+	}
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	column, err := treeColumn(d.db, tableName, params.Column)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	db := d.read.WithContext(c.Request().Context())
+	var found int64
+	if err := db.Table(tableName).Where("id = ?", id).Count(&found).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if found == 0 {
+		return pkg_apierror.Message(c, http.StatusNotFound, "record does not exist")
+	}
+
+	rows := []map[string]interface{}{}
+	err = db.Raw(fmt.Sprintf(query, tableName, column), id, params.Depth).Scan(&rows).Error
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	// a row reached through a loop is kept at its first level
+	seen := map[string]bool{fmt.Sprint(id): true}
+	tree := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		key := fmt.Sprint(row["id"])
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		tree = append(tree, row)
+	}
+
+	if err := stripRestrictedColumns(d.db, c, table, tree); err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, tree)
+}
+
+// FetchDescendants returns the rows below a row of a table referencing
+// itself, such as categories with a parent_id, level by level. Every row has
+// its level in @depth, 1 for the children of the row
+func (d *DatabaseAPIImpl) FetchDescendants(c echo.Context) error {
+	return d.fetchTree(c, `
+	WITH RECURSIVE tree(id, depth) AS (
+		SELECT id, 0 FROM "%[1]s" WHERE id = ?
+		UNION
+		SELECT t.id, tree.depth + 1 FROM "%[1]s" t JOIN tree ON t."%[2]s" = tree.id WHERE tree.depth < ?
+	)
+	SELECT t.*, tree.depth AS "@depth" FROM "%[1]s" t JOIN tree ON t.id = tree.id
+	WHERE tree.depth > 0
+	ORDER BY tree.depth, t.id`)
+}
+
+// FetchAncestors returns the rows above a row of a table referencing itself,
+// from its parent up to the root. Every row has its level in @depth, 1 for
+// the parent of the row
+func (d *DatabaseAPIImpl) FetchAncestors(c echo.Context) error {
+	return d.fetchTree(c, `
+	WITH RECURSIVE tree(id, parent, depth) AS (
+		SELECT id, "%[2]s", 0 FROM "%[1]s" WHERE id = ?
+		UNION
+		SELECT t.id, t."%[2]s", tree.depth + 1 FROM "%[1]s" t JOIN tree ON t.id = tree.parent WHERE tree.depth < ?
+	)
+	SELECT t.*, tree.depth AS "@depth" FROM "%[1]s" t JOIN tree ON t.id = tree.id
+	WHERE tree.depth > 0
+	ORDER BY tree.depth`)
+}
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/events"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+var ErrTriggerNotFound = errors.New("trigger does not exist")
+
+// the record events running the triggers of each event
+var triggerEvents = map[string]string{
+	model.TRIGGER_INSERT: events.RECORD_CREATED,
+	model.TRIGGER_UPDATE: events.RECORD_UPDATED,
+	model.TRIGGER_DELETE: events.RECORD_DELETED,
+}
+
+// the handlers of the enabled triggers, replaced whenever the triggers change
+var triggerHandlers struct {
+	mu      sync.Mutex
+	removes []func()
+}
+
+// LoadTriggers registers the enabled triggers as handlers of the record
+// events. Nothing is registered for the events without triggers so their
+// rows aren't read for nothing
+func LoadTriggers(db *gorm.DB) error {
+	var triggers []model.FunctionTrigger
+	if err := db.Where("enabled = ?", true).Order("name").Find(&triggers).Error; err != nil {
+		return err
+	}
+
+	byEvent := map[string][]model.FunctionTrigger{}
+	for _, trigger := range triggers {
+		name := triggerEvents[trigger.Event]
+		byEvent[name] = append(byEvent[name], trigger)
+	}
+
+	triggerHandlers.mu.Lock()
+	defer triggerHandlers.mu.Unlock()
+
+	for _, remove := range triggerHandlers.removes {
+		remove()
+	}
+	triggerHandlers.removes = nil
+
+	for name, triggers := range byEvent {
+		triggerHandlers.removes = append(triggerHandlers.removes, events.On(name, func(event events.Event) {
+			for _, trigger := range triggers {
+				if trigger.Table != event.Table {
+					continue
+				}
+				if err := runTrigger(db, trigger, event.Record); err != nil {
+					log.Printf("trigger %s failed on %s of %s: %v\n", trigger.Name, trigger.Event, trigger.Table, err)
+				}
+			}
+		}))
+	}
+
+	return nil
+}
+
+func reloadTriggers(db *gorm.DB) {
+	if err := LoadTriggers(db); err != nil {
+		log.Printf("failed to load the triggers: %v\n", err)
+	}
+}
+
+// runTrigger runs the function of a trigger with the row as the input of
+// every step. The steps write to the database directly, so they don't run
+// other triggers
+func runTrigger(db *gorm.DB, trigger model.FunctionTrigger, row map[string]interface{}) error {
+	var function model.FunctionStored
+	if err := db.Where("name = ?", trigger.Function).First(&function).Error; err != nil {
+		return err
+	}
+
+	functions := []Function{}
+	if err := json.Unmarshal([]byte(function.Function), &functions); err != nil {
+		return err
+	}
+
+	input := map[string]interface{}{}
+	for _, step := range functions {
+		input[step.Name] = row
+	}
+
+	_, err := runFunctions(db, functions, &Caller{Data: input}, nil)
+	return err
+}
+
+type TriggerAPI interface {
+	FetchTriggers(c echo.Context) error
+	CreateTrigger(c echo.Context) error
+	UpdateTrigger(c echo.Context) error
+	DeleteTrigger(c echo.Context) error
+}
+
+type TriggerAPIImpl struct {
+	db *gorm.DB
+}
+
+func NewTriggerAPI(ioc di.Container) TriggerAPI {
+	return &TriggerAPIImpl{
+		db: ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+	}
+}
+
+type triggerReq struct {
+	Name     string `json:"name"`
+	Table    string `json:"table"`
+	Event    string `json:"event"`
+	Function string `json:"function"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+func validateTrigger(db *gorm.DB, trigger model.FunctionTrigger) error {
+	if _, ok := triggerEvents[trigger.Event]; !ok {
+		return errors.New("event must be insert, update or delete")
+	}
+
+	table, err := getTableInfo(db, trigger.Table)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("table %s does not exist", trigger.Table)
+	}
+	if err != nil {
+		return err
+	}
+	if table.IsView {
+		return errors.New("views have no row events")
+	}
+
+	var count int64
+	if err := db.Model(&model.FunctionStored{}).Where("name = ?", trigger.Function).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("function %s does not exist", trigger.Function)
+	}
+
+	return nil
+}
+
+func (t *TriggerAPIImpl) bindTrigger(c echo.Context, trigger *model.FunctionTrigger) error {
+	var body *triggerReq = new(triggerReq)
+	if err := c.Bind(body); err != nil {
+		return err
+	}
+
+	trigger.Table = body.Table
+	trigger.Event = strings.ToLower(body.Event)
+	trigger.Function = body.Function
+	if body.Enabled != nil {
+		trigger.Enabled = *body.Enabled
+	}
+	if trigger.Name == "" {
+		trigger.Name = body.Name
+	}
+
+	return validateTrigger(t.db, *trigger)
+}
+
+func (t *TriggerAPIImpl) FetchTriggers(c echo.Context) error {
+	query := t.db.Order("name")
+	if table := c.QueryParam("table"); table != "" {
+		query = query.Where("`table` = ?", table)
+	}
+
+	triggers := []model.FunctionTrigger{}
+	if err := query.Find(&triggers).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, triggers)
+}
+
+// CreateTrigger binds a stored function to the inserts, updates or deletes
+// of the rows of a table
+func (t *TriggerAPIImpl) CreateTrigger(c echo.Context) error {
+	trigger := model.FunctionTrigger{Enabled: true}
+	if err := t.bindTrigger(c, &trigger); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if trigger.Name == "" || strings.ContainsAny(trigger.Name, "/ ") {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "invalid trigger name")
+	}
+
+	var count int64
+	if err := t.db.Model(&model.FunctionTrigger{}).Where("name = ?", trigger.Name).Count(&count).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	if count > 0 {
+		return pkg_apierror.Message(c, http.StatusConflict, "trigger already exists")
+	}
+
+	if err := t.db.Create(&trigger).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadTriggers(t.db)
+
+	recordActivity(t.db, c, model.ACTIVITY_CREATE_TRIGGER, trigger.Name, trigger.Table)
+
+	return c.JSON(http.StatusOK, trigger)
+}
+
+func (t *TriggerAPIImpl) UpdateTrigger(c echo.Context) error {
+	var trigger model.FunctionTrigger
+	if err := t.db.Where("name = ?", c.Param("name")).First(&trigger).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg_apierror.Error(c, http.StatusNotFound, ErrTriggerNotFound)
+		}
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	if err := t.bindTrigger(c, &trigger); err != nil {
+		return pkg_apierror.Error(c, http.StatusBadRequest, err)
+	}
+
+	if err := t.db.Save(&trigger).Error; err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+	reloadTriggers(t.db)
+
+	recordActivity(t.db, c, model.ACTIVITY_UPDATE_TRIGGER, trigger.Name, trigger.Table)
+
+	return c.JSON(http.StatusOK, trigger)
+}
+
+func (t *TriggerAPIImpl) DeleteTrigger(c echo.Context) error {
+	name := c.Param("name")
+
+	result := t.db.Where("name = ?", name).Delete(&model.FunctionTrigger{})
+	if result.Error != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return pkg_apierror.Error(c, http.StatusNotFound, ErrTriggerNotFound)
+	}
+	reloadTriggers(t.db)
+
+	recordActivity(t.db, c, model.ACTIVITY_DELETE_TRIGGER, name, "")
+
+	return c.JSON(http.StatusOK, nil)
+}
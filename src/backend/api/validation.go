@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"react-golang/src/backend/model"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// fieldErrors holds why the values of a row were rejected, by column
+type fieldErrors map[string]string
+
+// checkValidators tells whether the validators of a column metadata can be
+// applied, the pattern must compile and the bounds must be ordered
+func checkValidators(meta model.ColumnMeta) error {
+	switch meta.Format {
+	case "", model.FORMAT_EMAIL, model.FORMAT_URL:
+	default:
+		return errors.New("format must be email or url")
+	}
+	if meta.Pattern != "" {
+		if _, err := regexp.Compile(meta.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	if meta.Min != nil && meta.Max != nil && *meta.Min > *meta.Max {
+		return errors.New("min must not be greater than max")
+	}
+	if meta.MaxLength < 0 {
+		return errors.New("max_length must not be negative")
+	}
+
+	return nil
+}
+
+// validationNumber reads a number from JSON or from the text of a form
+func validationNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		number, err := v.Float64()
+		return number, err == nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+// validateValue returns why a value breaks the validators of its column, or
+// an empty string
+func validateValue(meta model.ColumnMeta, value interface{}) string {
+	if value == nil || value == "" {
+		if meta.Required {
+			return "is required"
+		}
+		return ""
+	}
+
+	text, isText := value.(string)
+	if !isText {
+		text = fmt.Sprint(value)
+	}
+
+	switch meta.Format {
+	case model.FORMAT_EMAIL:
+		address, err := mail.ParseAddress(text)
+		if err != nil || address.Address != text {
+			return "must be an email address"
+		}
+	case model.FORMAT_URL:
+		u, err := url.Parse(text)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "must be an absolute url"
+		}
+	}
+
+	if meta.Pattern != "" {
+		pattern, err := regexp.Compile(meta.Pattern)
+		if err == nil && !pattern.MatchString(text) {
+			return fmt.Sprintf("must match %s", meta.Pattern)
+		}
+	}
+
+	if meta.Min != nil || meta.Max != nil {
+		number, ok := validationNumber(value)
+		if !ok {
+			return "must be a number"
+		}
+		if meta.Min != nil && number < *meta.Min {
+			return fmt.Sprintf("must be at least %v", *meta.Min)
+		}
+		if meta.Max != nil && number > *meta.Max {
+			return fmt.Sprintf("must be at most %v", *meta.Max)
+		}
+	}
+
+	if meta.MaxLength > 0 && isText && utf8.RuneCountInString(text) > meta.MaxLength {
+		return fmt.Sprintf("must be at most %d characters", meta.MaxLength)
+	}
+
+	return ""
+}
+
+// validateRow runs the validators of the columns of a table on the values
+// of an insert, or of an update when partial is set. Required columns must
+// be set by inserts, updates can leave them out but not empty them
+func validateRow(db *gorm.DB, tableName string, data map[string]interface{}, partial bool) (fieldErrors, error) {
+	metas, err := fetchColumnMeta(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := fetchColumns(db, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := fieldErrors{}
+	for _, column := range columns {
+		// the ids of the integer primary keys are given by SQLite
+		if column.PK > 0 {
+			continue
+		}
+		if message := validateNumeric(column.Type, data[column.Name]); message != "" {
+			errs[column.Name] = message
+		}
+	}
+	for column, meta := range metas {
+		if errs[column] != "" {
+			continue
+		}
+		value, ok := data[column]
+		if !ok && partial {
+			continue
+		}
+		if message := validateValue(meta, value); message != "" {
+			errs[column] = message
+		}
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/service"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+type WebhookAPI interface {
+	FetchDeliveries(c echo.Context) error
+	RedeliverDelivery(c echo.Context) error
+	DeleteDelivery(c echo.Context) error
+}
+
+type WebhookAPIImpl struct {
+	db      *gorm.DB
+	webhook service.WebhookService
+}
+
+func NewWebhookAPI(ioc di.Container) WebhookAPI {
+	return &WebhookAPIImpl{
+		db:      ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB),
+		webhook: ioc.Get(constants.CONTAINER_WEBHOOK_NAME).(service.WebhookService),
+	}
+}
+
+func webhookErrorStatus(err error) int {
+	if errors.Is(err, service.ErrWebhookDeliveryNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+func deliveryID(c echo.Context) (uint, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, service.ErrWebhookDeliveryNotFound
+	}
+
+	return uint(id), nil
+}
+
+// FetchDeliveries lists the webhook deliveries still being retried and the
+// failed ones, only those of the status query parameter when set
+func (w *WebhookAPIImpl) FetchDeliveries(c echo.Context) error {
+	status := c.QueryParam("status")
+	if status != "" && status != model.WEBHOOK_DELIVERY_PENDING && status != model.WEBHOOK_DELIVERY_FAILED {
+		return pkg_apierror.Message(c, http.StatusBadRequest, "status must be pending or failed")
+	}
+
+	deliveries, err := w.webhook.FetchDeliveries(c.Request().Context(), status)
+	if err != nil {
+		return pkg_apierror.Error(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// RedeliverDelivery posts a delivery again right away. When it fails again
+// it is retried like a new one and returned with the error
+func (w *WebhookAPIImpl) RedeliverDelivery(c echo.Context) error {
+	id, err := deliveryID(c)
+	if err != nil {
+		return pkg_apierror.Error(c, webhookErrorStatus(err), err)
+	}
+
+	delivery, err := w.webhook.Redeliver(c.Request().Context(), id)
+	if errors.Is(err, service.ErrWebhookDeliveryNotFound) {
+		return pkg_apierror.Error(c, http.StatusNotFound, err)
+	}
+	if err != nil {
+		return pkg_apierror.Write(c, http.StatusBadGateway, pkg_apierror.Response{
+			Message: err.Error(),
+			Details: map[string]interface{}{"delivery": delivery},
+		})
+	}
+
+	return c.JSON(http.StatusOK, delivery)
+}
+
+func (w *WebhookAPIImpl) DeleteDelivery(c echo.Context) error {
+	id, err := deliveryID(c)
+	if err != nil {
+		return pkg_apierror.Error(c, webhookErrorStatus(err), err)
+	}
+
+	if err := w.webhook.DeleteDelivery(c.Request().Context(), id); err != nil {
+		return pkg_apierror.Error(c, webhookErrorStatus(err), err)
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
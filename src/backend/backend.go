@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"react-golang/src/backend/config"
+	"syscall"
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -13,9 +19,16 @@ import (
 func main() {
 	godotenv.Load(".env")
 
-	config := config.GetInstance()
-	config.Load()
+	config.GetInstance()
 
+	if err := runCommand(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// serve starts the server
+func serve() {
 	app := echo.New()
 
 	module := Module{}
@@ -32,6 +45,28 @@ func main() {
 	app.Static("/admin", distDir)
 	app.File("", distDir+"/index.html")
 
-	port := "8080"
-	app.Logger.Fatal(app.Start(fmt.Sprintf(":%s", port)))
+	// HOST is empty to listen on every interface, the servers of the projects
+	// of a host use the listener it bound on the loopback
+	listener, err := inheritedListener()
+	if err != nil {
+		log.Fatalf("failed to use the listener of the host: %v", err)
+	}
+	app.Listener = listener
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	go func() {
+		if err := app.Start(fmt.Sprintf("%s:%s", os.Getenv("HOST"), port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.Logger.Fatal(err)
+		}
+	}()
+
+	// a second signal kills the server right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	log.Println("shutting down")
+	module.Shutdown(app)
 }
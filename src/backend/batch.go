@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"react-golang/src/backend/api"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/model"
+	pkg_mailer "react-golang/src/backend/pkg/mailer"
+	"react-golang/src/backend/plugins"
+	"react-golang/src/backend/service"
+	"react-golang/src/backend/utils"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+// Batch runs the cron jobs stored in the database along with the backups of
+// the backup_schedule setting. The jobs are scheduled again whenever they or
+// the setting change
+type Batch struct {
+	cron    *cron.Cron
+	db      *gorm.DB
+	backup  service.BackupService
+	storage service.StorageService
+	webhook service.WebhookService
+	notify  *service.BackupNotifier
+
+	mu      sync.Mutex
+	entries []cron.EntryID
+	running map[string]int
+}
+
+func NewBatch(db *gorm.DB, backup service.BackupService, storage service.StorageService, mailer pkg_mailer.Mailer, webhook service.WebhookService) *Batch {
+	return &Batch{
+		cron:    cron.New(),
+		db:      db,
+		backup:  backup,
+		storage: storage,
+		webhook: webhook,
+		notify:  service.NewBackupNotifier(mailer, webhook),
+		running: map[string]int{},
+	}
+}
+
+func (b *Batch) Start() {
+	b.Reload()
+
+	config.OnChange(func(c *config.Config, keys []string) {
+		for _, key := range keys {
+			if key == "backup_schedule" || key == "backup_schedule_timezone" {
+				b.Reload()
+				return
+			}
+		}
+	})
+
+	// the entries of Reload are replaced on every reload, this one stays
+	b.cron.AddFunc("@hourly", b.cleanupUploads)
+	b.cron.AddFunc("@every 15m", b.scanPendingFiles)
+	b.cron.AddFunc("@every 10s", b.retryWebhooks)
+	b.cron.AddFunc("@every 1m", b.flushTraffic)
+	b.cron.AddFunc("@hourly", b.purgeTraffic)
+
+	b.cron.Start()
+}
+
+// Stop stops scheduling the jobs and waits for the running ones until ctx is
+// done
+func (b *Batch) Stop(ctx context.Context) {
+	select {
+	case <-b.cron.Stop().Done():
+	case <-ctx.Done():
+		log.Println("cron jobs still running at shutdown")
+	}
+}
+
+// AddPluginJob schedules a job of a plugin, it stays scheduled like the
+// cleanups
+func (b *Batch) AddPluginJob(job plugins.Job, ioc di.Container) error {
+	_, err := b.cron.AddFunc(job.Schedule, func() {
+		if err := job.Run(context.Background(), ioc); err != nil {
+			log.Printf("plugin job %s failed: %v\n", job.Name, err)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q of plugin job %s: %w", job.Schedule, job.Name, err)
+	}
+
+	return nil
+}
+
+// retryWebhooks posts again the webhook deliveries which failed
+func (b *Batch) retryWebhooks() {
+	b.webhook.Retry(context.Background())
+}
+
+// flushTraffic saves the requests counted for the traffic stats
+func (b *Batch) flushTraffic() {
+	if err := api.FlushTraffic(b.db); err != nil {
+		log.Printf("failed to save the traffic stats: %v\n", err)
+	}
+}
+
+// purgeTraffic deletes the traffic stats past their retention
+func (b *Batch) purgeTraffic() {
+	if _, err := api.PurgeTraffic(b.db); err != nil {
+		log.Printf("failed to purge the traffic stats: %v\n", err)
+	}
+}
+
+// cleanupUploads removes the chunked uploads that were never completed
+func (b *Batch) cleanupUploads() {
+	removed, err := b.storage.CleanupUploads(context.Background())
+	if err != nil {
+		log.Printf("failed to clean up the expired uploads: %v\n", err)
+	}
+	if removed > 0 {
+		log.Printf("removed %d expired uploads\n", removed)
+	}
+}
+
+// scanPendingFiles scans the files whose scan failed or was interrupted
+func (b *Batch) scanPendingFiles() {
+	scanned, err := b.storage.ScanPending(context.Background())
+	if err != nil {
+		log.Printf("failed to scan the pending files: %v\n", err)
+	}
+	if scanned > 0 {
+		log.Printf("scanned %d pending files\n", scanned)
+	}
+}
+
+// Reload replaces the scheduled jobs with the enabled ones
+func (b *Batch) Reload() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, entry := range b.entries {
+		b.cron.Remove(entry)
+	}
+	b.entries = nil
+
+	jobs := []model.CronJob{}
+	if err := b.db.Where("enabled = ?", true).Find(&jobs).Error; err != nil {
+		log.Printf("failed to load cron jobs: %v\n", err)
+	}
+	if config.GetInstance().BackupSchedule != "" {
+		jobs = append(jobs, b.backupScheduleJob())
+	}
+
+	for _, job := range jobs {
+		job := job
+		entry, err := b.cron.AddFunc(utils.CronSpec(job.Schedule, job.Timezone), func() { b.runJob(job) })
+		if err != nil {
+			log.Printf("invalid schedule %q of cron job %s: %v\n", job.Schedule, job.Name, err)
+			continue
+		}
+		b.entries = append(b.entries, entry)
+	}
+}
+
+// runJob runs a job on its schedule, after its jitter. The run is skipped
+// when the previous one is still going and overlaps aren't allowed
+func (b *Batch) runJob(job model.CronJob) {
+	if job.JitterSeconds > 0 {
+		time.Sleep(time.Duration(rand.Int63n(int64(job.JitterSeconds) * int64(time.Second))))
+	}
+
+	run, err := b.startRun(job, false)
+	if errors.Is(err, api.ErrCronJobRunning) {
+		now := time.Now()
+		skipped := model.CronRun{
+			Job:        job.Name,
+			Status:     model.CRON_RUN_SKIPPED,
+			Error:      err.Error(),
+			StartedAt:  now,
+			FinishedAt: &now,
+		}
+		if err := b.db.Create(&skipped).Error; err != nil {
+			log.Printf("failed to record the run of cron job %s: %v\n", job.Name, err)
+		}
+		log.Printf("cron job %s skipped: %v\n", job.Name, err)
+		return
+	}
+	if err != nil {
+		log.Printf("cron job %s not started: %v\n", job.Name, err)
+		return
+	}
+
+	b.finishRun(job, run)
+}
+
+// Run starts a job right away, outside of its schedule. It is refused while
+// the job is already running, unless overlaps are allowed
+func (b *Batch) Run(name string) (model.CronRun, error) {
+	job, err := b.job(name)
+	if err != nil {
+		return model.CronRun{}, err
+	}
+
+	run, err := b.startRun(job, true)
+	if err != nil {
+		return model.CronRun{}, err
+	}
+	go b.finishRun(job, run)
+
+	return run, nil
+}
+
+// job returns the stored job of the name, or the job of the backup_schedule
+// setting
+func (b *Batch) job(name string) (model.CronJob, error) {
+	if name == model.CRON_JOB_BACKUP_SCHEDULE && config.GetInstance().BackupSchedule != "" {
+		return b.backupScheduleJob(), nil
+	}
+
+	var job model.CronJob
+	if err := b.db.Where("name = ?", name).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return job, api.ErrCronJobNotFound
+		}
+		return job, err
+	}
+
+	return job, nil
+}
+
+func (b *Batch) backupScheduleJob() model.CronJob {
+	settings := config.GetInstance()
+	return model.CronJob{
+		Name:     model.CRON_JOB_BACKUP_SCHEDULE,
+		Schedule: settings.BackupSchedule,
+		Timezone: settings.BackupScheduleTimezone,
+		Action:   model.CRON_ACTION_BACKUP,
+		Enabled:  true,
+	}
+}
+
+// startRun marks the job as running and records the start of the run
+func (b *Batch) startRun(job model.CronJob, manual bool) (model.CronRun, error) {
+	b.mu.Lock()
+	if !job.AllowOverlap && b.running[job.Name] > 0 {
+		b.mu.Unlock()
+		return model.CronRun{}, api.ErrCronJobRunning
+	}
+	b.running[job.Name]++
+	b.mu.Unlock()
+
+	run := model.CronRun{
+		Job:       job.Name,
+		Status:    model.CRON_RUN_RUNNING,
+		Manual:    manual,
+		StartedAt: time.Now(),
+	}
+	if err := b.db.Create(&run).Error; err != nil {
+		log.Printf("failed to record the run of cron job %s: %v\n", job.Name, err)
+	}
+
+	return run, nil
+}
+
+// finishRun runs the job and records the outcome of the run, the job is
+// cancelled once it exceeds its max runtime
+func (b *Batch) finishRun(job model.CronJob, run model.CronRun) {
+	defer func() {
+		b.mu.Lock()
+		b.running[job.Name]--
+		b.mu.Unlock()
+	}()
+
+	ctx := context.Background()
+	if job.MaxRuntimeSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(job.MaxRuntimeSeconds)*time.Second)
+		defer cancel()
+	}
+
+	err := b.execute(ctx, job)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("job exceeded its max runtime of %ds: %v", job.MaxRuntimeSeconds, err)
+	}
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	run.DurationMs = finishedAt.Sub(run.StartedAt).Milliseconds()
+	run.Status = model.CRON_RUN_SUCCESS
+	if err != nil {
+		run.Status = model.CRON_RUN_FAILED
+		run.Error = err.Error()
+		log.Printf("cron job %s failed: %v\n", job.Name, err)
+	} else {
+		log.Printf("cron job %s done\n", job.Name)
+	}
+
+	if run.ID != 0 {
+		if err := b.db.Save(&run).Error; err != nil {
+			log.Printf("failed to record the run of cron job %s: %v\n", job.Name, err)
+		}
+	}
+}
+
+func (b *Batch) execute(ctx context.Context, job model.CronJob) (err error) {
+	// stored functions panic on input they don't expect
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	switch job.Action {
+	case model.CRON_ACTION_BACKUP:
+		return b.runBackup(ctx)
+	case model.CRON_ACTION_FUNCTION:
+		input := map[string]interface{}{}
+		if job.Input != "" {
+			if err := json.Unmarshal([]byte(job.Input), &input); err != nil {
+				return err
+			}
+		}
+		_, err := api.ExecuteFunction(b.db.WithContext(ctx), job.Target, input)
+		return err
+	case model.CRON_ACTION_VACUUM, model.CRON_ACTION_ANALYZE, model.CRON_ACTION_CHECKPOINT:
+		// the cron actions are named after the maintenance operations
+		result, err := api.Maintain(b.db.WithContext(ctx), job.Action)
+		if err == nil {
+			log.Printf("cron job %s reclaimed %d bytes, the database is %d bytes\n", job.Name, result.Reclaimed, result.SizeAfter+result.WALSizeAfter)
+		}
+		return err
+	case model.CRON_ACTION_PURGE:
+		purged, err := api.PurgeRows(b.db.WithContext(ctx), job.Target, job.RetentionDays)
+		if err == nil {
+			log.Printf("cron job %s purged %d rows of %s\n", job.Name, purged, job.Target)
+		}
+		return err
+	}
+
+	return fmt.Errorf("unknown action %s", job.Action)
+}
+
+func (b *Batch) runBackup(ctx context.Context) error {
+	event := service.BackupEvent{Mode: "full", Time: time.Now()}
+
+	if config.GetInstance().BackupMode == "incremental" {
+		event.Mode = "incremental"
+		point, err := b.backup.IncrementalBackup(ctx)
+		if err == nil {
+			event.Backup = point.Chain + "@" + point.Time.Format(time.RFC3339Nano)
+		}
+		return b.finishBackup(event, err)
+	}
+
+	backup, err := b.backup.Backup(ctx)
+	if err == nil {
+		event.Backup = backup.Name
+	}
+	return b.finishBackup(event, err)
+}
+
+// finishBackup logs the outcome of a scheduled backup and notifies about it
+func (b *Batch) finishBackup(event service.BackupEvent, err error) error {
+	event.Event = service.BACKUP_EVENT_SUCCEEDED
+	if err != nil {
+		event.Event = service.BACKUP_EVENT_FAILED
+		event.Error = err.Error()
+		log.Printf("scheduled %s backup failed: %v\n", event.Mode, err)
+	} else {
+		log.Printf("scheduled %s backup created: %s\n", event.Mode, event.Backup)
+	}
+
+	b.notify.Notify(event)
+
+	return err
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/api"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/constants"
+	"react-golang/src/backend/model"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	"react-golang/src/backend/plugins"
+	"react-golang/src/backend/service"
+	"strings"
+	"time"
+
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage: fullbase <command> [flags]
+
+Commands:
+  serve                                    start the server, the default
+  migrate                                  migrate the database and run the plugin migrations
+  admin create --email <email> [flags]     add an admin, the password is read from stdin when not given
+  backup [--incremental]                   back up the database
+  restore <name or file>                   replace the database with a backup
+  restore --point <time>                   restore the incremental backups up to an RFC 3339 time
+  import [--dialect <dialect>] <file>      create the tables of a postgres or mysql dump with their rows
+  gen sdk --lang <ts|go|dart> [--out dir]  write a typed client of the tables, in ./sdk by default
+  projects create [--dir dir] <slug>       add a project with its own database, storage, admins and settings
+  projects list [--dir dir]                list the projects
+  projects serve [--dir dir]               serve every project as /api/<slug>/...
+  projects run [--dir dir] <slug> <cmd>    run one of the commands above on a project
+
+The commands other than serve work on the database of DB_PATH and are meant
+to run while the server is stopped.
+
+The projects are kept in --dir, PROJECTS_DIR or ./projects. Each runs in a
+server of its own started by projects serve, which doesn't serve the
+dashboard: a project is managed through its API or with projects run.
+`
+
+// runCommand runs the command of the arguments, the server is started when
+// there is none
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		serve()
+		return nil
+	}
+
+	switch args[0] {
+	case "serve":
+		serve()
+		return nil
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "admin":
+		if len(args) < 2 || args[1] != "create" {
+			return errors.New("usage: fullbase admin create --email <email>")
+		}
+		return createAdminCommand(args[2:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "restore":
+		return restoreCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	case "gen":
+		if len(args) < 2 || args[1] != "sdk" {
+			return errors.New("usage: fullbase gen sdk --lang ts|go|dart")
+		}
+		return genSDKCommand(args[2:])
+	case "projects":
+		return projectsCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	}
+
+	fmt.Fprint(os.Stderr, usage)
+	return fmt.Errorf("unknown command %s", args[0])
+}
+
+// container builds the services of the commands, opening the database
+// migrates it like the server does
+func container() (di.Container, *gorm.DB, error) {
+	registry, err := plugins.Setup()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := Module{plugins: registry}
+	ioc := m.IOC(nil)
+	db, err := ioc.SafeGet(constants.CONTAINER_DB_NAME)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := registry.Migrate(db.(*gorm.DB)); err != nil {
+		return nil, nil, err
+	}
+
+	return ioc, db.(*gorm.DB), nil
+}
+
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if _, _, err := container(); err != nil {
+		return err
+	}
+	fmt.Println("database migrated")
+
+	return nil
+}
+
+func createAdminCommand(args []string) error {
+	flags := flag.NewFlagSet("admin create", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin")
+	username := flags.String("username", "", "username of the admin")
+	password := flags.String("password", "", "password of the admin, read from stdin when empty")
+	role := flags.String("role", model.ADMIN_ROLE_OWNER, "owner, editor or read_only")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("--email is required")
+	}
+	if !api.IsValidAdminRole(*role) {
+		return errors.New("invalid admin role")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("no password given")
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		return errors.New("no password given")
+	}
+
+	_, db, err := container()
+	if err != nil {
+		return err
+	}
+
+	admin, err := api.CreateAdmin(db, *email, *username, *password, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("admin %s created as %s\n", admin.Email, admin.Role)
+
+	return nil
+}
+
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	incremental := flags.Bool("incremental", false, "store the pages changed since the previous incremental backup")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ioc, _, err := container()
+	if err != nil {
+		return err
+	}
+	backup := ioc.Get(constants.CONTAINER_BACKUP_NAME).(service.BackupService)
+
+	if *incremental {
+		point, err := backup.IncrementalBackup(context.Background())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restore point %s of chain %s created\n", point.Time.Format(time.RFC3339Nano), point.Chain)
+		return nil
+	}
+
+	created, err := backup.Backup(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("backup %s created in %s\n", created.Name, backup.Dir())
+
+	return nil
+}
+
+// restoreCommand restores a backup by its name, or a file. A file of the
+// backup directory is restored as the backup, any other file must be an
+// uncompressed database
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	point := flags.String("point", "", "restore the incremental backups up to this RFC 3339 time")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ioc, db, err := container()
+	if err != nil {
+		return err
+	}
+	backup := ioc.Get(constants.CONTAINER_BACKUP_NAME).(service.BackupService)
+
+	if *point != "" {
+		at, err := time.Parse(time.RFC3339Nano, *point)
+		if err != nil {
+			return fmt.Errorf("invalid time %s", *point)
+		}
+		restored, err := backup.RestoreToPoint(context.Background(), at)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restored to %s\n", restored.Time.Format(time.RFC3339Nano))
+		return nil
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: fullbase restore <name or file>")
+	}
+	name := flags.Arg(0)
+
+	if info, err := os.Stat(name); err == nil && !info.IsDir() {
+		path, _ := filepath.Abs(name)
+		dir, _ := filepath.Abs(backup.Dir())
+		if filepath.Dir(path) != dir {
+			if err := pkg_sqlite.Restore(db, path); err != nil {
+				return err
+			}
+			if config.GetInstance().BackupIntegrityCheck {
+				if err := pkg_sqlite.Verify(db); err != nil {
+					return fmt.Errorf("%s was restored: %w", name, err)
+				}
+			}
+			fmt.Printf("restored %s\n", name)
+			return nil
+		}
+		name = filepath.Base(path)
+	}
+
+	if err := backup.Restore(context.Background(), name); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s\n", name)
+
+	return nil
+}
+
+// importCommand imports a dump, read from stdin when the file is -
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dialect := flags.String("dialect", "", "postgres or mysql, guessed from the dump when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: fullbase import [--dialect postgres|mysql] <file>")
+	}
+
+	var input io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader := bufio.NewReader(input)
+	if *dialect == "" {
+		head, _ := reader.Peek(4096)
+		*dialect = api.DetectDumpDialect(head)
+	}
+
+	_, db, err := container()
+	if err != nil {
+		return err
+	}
+
+	result, err := api.ImportDump(db, reader, *dialect)
+	if err != nil {
+		return err
+	}
+	for _, table := range result.Tables {
+		fmt.Printf("table %s created with %d rows\n", table.Name, table.Rows)
+	}
+	for _, name := range result.Skipped {
+		fmt.Printf("table %s skipped, it already exists\n", name)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+
+	return nil
+}
+
+func genSDKCommand(args []string) error {
+	flags := flag.NewFlagSet("gen sdk", flag.ContinueOnError)
+	lang := flags.String("lang", "", "ts, go or dart")
+	out := flags.String("out", "./sdk", "directory the client is written to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *lang == "" {
+		return errors.New("--lang is required")
+	}
+
+	_, db, err := container()
+	if err != nil {
+		return err
+	}
+
+	files, err := api.GenerateSDK(db, *lang)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		path := filepath.Join(*out, file.Name)
+		if err := os.WriteFile(path, file.Content, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"reflect"
 	"sync"
+	"sync/atomic"
 )
 
 type Config struct {
@@ -13,20 +14,230 @@ type Config struct {
 	AppURL         string   `json:"app_url"`
 	APIKey         string   `json:"api_key"`
 	AllowedOrigins []string `json:"allowed_origins"`
+
+	// CORSCredentialOrigins are the allowed origins which may also send
+	// cookies and authorization headers, * isn't accepted
+	CORSCredentialOrigins []string `json:"cors_credential_origins"`
+
+	// RequireAdminTOTP forces every admin to enroll 2FA before using the dashboard
+	RequireAdminTOTP bool `json:"require_admin_totp"`
+
+	// LoginMaxAttempts failed logins lock an account for LoginLockoutMinutes,
+	// the lockout doubles with every further failure
+	LoginMaxAttempts    int `json:"login_max_attempts"`
+	LoginLockoutMinutes int `json:"login_lockout_minutes"`
+
+	// lifetimes of the tokens issued at login, the built in defaults are used
+	// when zero
+	AdminTokenTTLMinutes int `json:"admin_token_ttl_minutes"`
+	UserTokenTTLMinutes  int `json:"user_token_ttl_minutes"`
+	MagicLinkTTLMinutes  int `json:"magic_link_ttl_minutes"`
+
+	// SAML single sign on for the admin dashboard, the IdP metadata is read
+	// from SAMLIDPMetadata when set, otherwise fetched from SAMLIDPMetadataURL
+	SAMLEnabled           bool   `json:"saml_enabled"`
+	SAMLIDPMetadataURL    string `json:"saml_idp_metadata_url"`
+	SAMLIDPMetadata       string `json:"saml_idp_metadata"`
+	SAMLEntityID          string `json:"saml_entity_id"`
+	SAMLEmailAttribute    string `json:"saml_email_attribute"`
+	SAMLUsernameAttribute string `json:"saml_username_attribute"`
+	SAMLRoleAttribute     string `json:"saml_role_attribute"`
+	SAMLDefaultRole       string `json:"saml_default_role"`
+	SAMLAutoProvision     bool   `json:"saml_auto_provision"`
+
+	// backups are written to BackupDir, next to the database when empty, on
+	// the cron BackupSchedule, in BackupScheduleTimezone when set. BackupRemote
+	// (s3 or sftp) also uploads them to an off-host target
+	BackupDir              string `json:"backup_dir"`
+	BackupSchedule         string `json:"backup_schedule"`
+	BackupScheduleTimezone string `json:"backup_schedule_timezone"`
+	BackupRemote           string `json:"backup_remote"`
+
+	// BackupCompression is none, gzip or zstd
+	BackupCompression string `json:"backup_compression"`
+
+	// BackupMode is full or incremental. Incremental backups only store the
+	// changed pages, a full snapshot starts a new chain every BackupFullEvery
+	// backups
+	BackupMode      string `json:"backup_mode"`
+	BackupFullEvery int    `json:"backup_full_every"`
+
+	BackupS3Endpoint  string `json:"backup_s3_endpoint"`
+	BackupS3Region    string `json:"backup_s3_region"`
+	BackupS3Bucket    string `json:"backup_s3_bucket"`
+	BackupS3Prefix    string `json:"backup_s3_prefix"`
+	BackupS3AccessKey string `json:"backup_s3_access_key"`
+	BackupS3SecretKey string `json:"backup_s3_secret_key" setting:"secret"`
+	BackupS3PathStyle bool   `json:"backup_s3_path_style"`
+
+	// BackupSFTPHostKey is the public key of the server, in authorized_keys
+	// format, the connection is refused when it doesn't match
+	BackupSFTPHost     string `json:"backup_sftp_host"`
+	BackupSFTPPort     int    `json:"backup_sftp_port"`
+	BackupSFTPUsername string `json:"backup_sftp_username"`
+	BackupSFTPPassword string `json:"backup_sftp_password" setting:"secret"`
+	BackupSFTPHostKey  string `json:"backup_sftp_host_key"`
+	BackupSFTPPath     string `json:"backup_sftp_path"`
+
+	// the outcome of the scheduled backups is mailed to BackupNotifyEmails
+	// and posted to BackupNotifyWebhook, signed with BackupNotifyWebhookSecret
+	// when set. Only failures are reported unless BackupNotifyOnSuccess is set
+	BackupNotifyEmails        []string `json:"backup_notify_emails"`
+	BackupNotifyWebhook       string   `json:"backup_notify_webhook"`
+	BackupNotifyWebhookSecret string   `json:"backup_notify_webhook_secret" setting:"secret"`
+	BackupNotifyOnSuccess     bool     `json:"backup_notify_on_success"`
+
+	// BackupIntegrityCheck checks the integrity of the database before every
+	// backup and after every restore, a corrupted database isn't backed up
+	BackupIntegrityCheck bool `json:"backup_integrity_check"`
+
+	// failed webhooks are retried up to WebhookMaxAttempts times, waiting
+	// WebhookRetryDelaySeconds before the first retry and twice as long
+	// before each next one. The built in defaults are used when zero
+	WebhookMaxAttempts       int `json:"webhook_max_attempts"`
+	WebhookRetryDelaySeconds int `json:"webhook_retry_delay_seconds"`
+
+	// SQLite pragmas applied to every connection, they take effect when the
+	// server restarts. SQLiteBusyTimeout is in milliseconds and SQLiteCacheSizeKB
+	// keeps the default cache size when zero
+	SQLiteJournalMode        string `json:"sqlite_journal_mode"`
+	SQLiteSynchronous        string `json:"sqlite_synchronous"`
+	SQLiteBusyTimeout        int    `json:"sqlite_busy_timeout"`
+	SQLiteCacheSizeKB        int    `json:"sqlite_cache_size_kb"`
+	SQLiteDisableForeignKeys bool   `json:"sqlite_disable_foreign_keys"`
+
+	// the connection pool of the database, applied as soon as they change.
+	// The lifetimes are in minutes, the DB_* environment variables are used
+	// for the unset ones
+	DBMaxOpenConnection int `json:"db_max_open_connection"`
+	DBMaxIdleConnection int `json:"db_max_idle_connection"`
+	DBMaxLifetime       int `json:"db_max_lifetime"`
+	DBMaxIdleTime       int `json:"db_max_idle_time"`
+
+	// the rows and the SELECT queries are read through a separate pool of
+	// read-only connections, so they don't hold up the writer. The pool has
+	// up to DBReadMaxOpenConnection connections, 4 when zero. It is only
+	// opened in WAL mode unless DBDisableReadPool is set, which takes effect
+	// when the server restarts
+	DBReadMaxOpenConnection int  `json:"db_read_max_open_connection"`
+	DBDisableReadPool       bool `json:"db_disable_read_pool"`
+
+	// request bodies are limited to MaxBodySizeMB, multipart ones which carry
+	// files to MaxUploadSizeMB. Textual responses are compressed unless
+	// DisableCompression is set
+	MaxBodySizeMB      int  `json:"max_body_size_mb"`
+	MaxUploadSizeMB    int  `json:"max_upload_size_mb"`
+	DisableCompression bool `json:"disable_compression"`
+
+	// on shutdown, the requests in progress have ShutdownTimeoutSeconds to
+	// finish, 30 seconds when zero
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+
+	// the gRPC API listens on GRPCPort next to the REST API, it is off when
+	// zero. A change takes effect on restart
+	GRPCPort int `json:"grpc_port"`
+
+	// the spans of the requests, services and SQL statements are exported to
+	// the OTLP/HTTP collector at OTLPEndpoint, such as http://localhost:4318,
+	// tracing is off when empty. OTLPHeaders are sent with the spans as
+	// name=value and TraceSamplePercent of the traces are kept, all of them
+	// when zero. A change takes effect on restart
+	OTLPEndpoint       string   `json:"otlp_endpoint"`
+	OTLPHeaders        []string `json:"otlp_headers" setting:"secret"`
+	TraceSamplePercent int      `json:"trace_sample_percent"`
+
+	// the server errors and panics of the requests are reported to
+	// ErrorReportDSN, a Sentry DSN such as https://key@sentry.example.com/42
+	// or a URL receiving the reports as JSON. They are only logged when empty
+	ErrorReportDSN string `json:"error_report_dsn" setting:"secret"`
+
+	// the reads of rows return DefaultPageSize rows when they set no page
+	// size, 100 when zero, and never more than MaxPageSize, 1000 when zero
+	DefaultPageSize int `json:"default_page_size"`
+	MaxPageSize     int `json:"max_page_size"`
+
+	// the statements running for longer than SlowQueryThresholdMs are kept in
+	// the slow query log along with the endpoint which ran them, the log is
+	// off when zero
+	SlowQueryThresholdMs int `json:"slow_query_threshold_ms"`
+
+	// the uploaded files are stored in StorageDir, next to the database when
+	// empty, or in a S3 bucket when StorageBackend is s3. The uploads in
+	// progress are always kept in StorageDir. Files sent in chunks may be up
+	// to MaxFileSizeMB, and all files together up to StorageQuotaMB when set
+	StorageBackend string `json:"storage_backend"`
+	StorageDir     string `json:"storage_dir"`
+	MaxFileSizeMB  int    `json:"max_file_size_mb"`
+	StorageQuotaMB int    `json:"storage_quota_mb"`
+
+	StorageS3Endpoint  string `json:"storage_s3_endpoint"`
+	StorageS3Region    string `json:"storage_s3_region"`
+	StorageS3Bucket    string `json:"storage_s3_bucket"`
+	StorageS3Prefix    string `json:"storage_s3_prefix"`
+	StorageS3AccessKey string `json:"storage_s3_access_key"`
+	StorageS3SecretKey string `json:"storage_s3_secret_key" setting:"secret"`
+	StorageS3PathStyle bool   `json:"storage_s3_path_style"`
+
+	// the stored files are scanned for malware after upload when a scanner
+	// is set, clamav reaches clamd at ScannerAddress (unix:/path or
+	// tcp:host:port) and http posts the files to ScannerURL
+	ScannerBackend string `json:"scanner_backend"`
+	ScannerAddress string `json:"scanner_address"`
+	ScannerURL     string `json:"scanner_url" setting:"secret"`
+
+	// the cache holds the table schemas and the login attempts, redis lets
+	// several instances behind a load balancer share it. They take effect
+	// when the server restarts
+	CacheBackend  string `json:"cache_backend"`
+	CacheRedisURL string `json:"cache_redis_url" setting:"secret"`
+	CachePrefix   string `json:"cache_prefix"`
+
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password" setting:"secret"`
+	SMTPSender   string `json:"smtp_sender"`
 }
 
 var (
-	instance *Config
+	instance atomic.Pointer[Config]
 	once     sync.Once
 )
 
+// GetInstance returns the current settings. They are never changed in place,
+// Update and Reload publish new ones, so the settings read by a request stay
+// consistent and are read again by the next one
 func GetInstance() *Config {
 	once.Do(func() {
-		instance = &Config{}
-		instance.Load()
+		config := &Config{}
+		config.Load()
+		instance.Store(config)
 	})
 
-	return instance
+	return instance.Load()
+}
+
+// Default returns the settings written when there is no settings file
+func Default() Config {
+	return Config{
+		AppName: "Fullbase",
+		AppURL:  "https://fullbase.com",
+		APIKey:  "default-api-key",
+		AllowedOrigins: []string{
+			"http://localhost:8080",
+			"http://localhost:3000",
+		},
+		LoginMaxAttempts:    5,
+		LoginLockoutMinutes: 15,
+
+		AdminTokenTTLMinutes: 7 * 24 * 60,
+		UserTokenTTLMinutes:  7 * 24 * 60,
+		MagicLinkTTLMinutes:  15,
+
+		SQLiteJournalMode: "WAL",
+		SQLiteSynchronous: "NORMAL",
+		SQLiteBusyTimeout: 5000,
+	}
 }
 
 func (c *Config) Load() error {
@@ -35,18 +246,10 @@ func (c *Config) Load() error {
 	file, err := os.Open(configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			config := Config{
-				AppName: "Fullbase",
-				AppURL:  "https://fullbase.com",
-				APIKey:  "default-api-key",
-				AllowedOrigins: []string{
-					"http://localhost:8080",
-					"http://localhost:3000",
-				},
-			}
+			config := Default()
 			config.Save()
 
-			c = &config
+			*c = config
 		}
 
 		return err
@@ -54,7 +257,7 @@ func (c *Config) Load() error {
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&c); err != nil {
+	if err := decoder.Decode(c); err != nil {
 		return err
 	}
 
@@ -62,9 +265,13 @@ func (c *Config) Load() error {
 }
 
 func (c *Config) Save() error {
-	configPath := os.Getenv("CONFIG_PATH")
+	return c.SaveTo(os.Getenv("CONFIG_PATH"))
+}
 
-	file, err := os.Create(configPath)
+// SaveTo writes the settings to the file at path, for the settings of
+// another server such as the projects of a host
+func (c *Config) SaveTo(path string) error {
+	file, err := os.Create(path)
 	if err != nil {
 		return err
 	}
@@ -103,10 +310,31 @@ func (c *Config) Set(key string, value interface{}) error {
 		if tag == key {
 			fieldValue := val.Field(i)
 			if fieldValue.CanSet() {
+				if value == nil {
+					fieldValue.Set(reflect.Zero(fieldValue.Type()))
+					return nil
+				}
+
 				newValue := reflect.ValueOf(value)
 				if newValue.Type().AssignableTo(fieldValue.Type()) {
 					fieldValue.Set(newValue)
 					return nil
+				} else if newValue.Kind() == reflect.Float64 && fieldValue.Kind() == reflect.Int {
+					// numbers decoded from JSON are always float64
+					fieldValue.SetInt(int64(newValue.Float()))
+					return nil
+				} else if newValue.Kind() == reflect.Slice && fieldValue.Type() == reflect.TypeOf([]string{}) {
+					// so are arrays, as []interface{}
+					items := []string{}
+					for j := 0; j < newValue.Len(); j++ {
+						item, ok := newValue.Index(j).Interface().(string)
+						if !ok {
+							return fmt.Errorf("%s must be a list of strings", key)
+						}
+						items = append(items, item)
+					}
+					fieldValue.Set(reflect.ValueOf(items))
+					return nil
 				} else {
 					return fmt.Errorf("cannot assign value of type %s to field of type %s", newValue.Type(), fieldValue.Type())
 				}
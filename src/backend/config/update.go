@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ChangeHandler is called after the settings were changed with the json keys
+// of the fields whose value changed
+type ChangeHandler func(c *Config, keys []string)
+
+var (
+	updateMu sync.Mutex
+	handlers []ChangeHandler
+)
+
+// OnChange registers handler to be notified of every settings change, so
+// subsystems can apply the new values without a restart
+func OnChange(handler ChangeHandler) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	handlers = append(handlers, handler)
+}
+
+// Update validates and applies values, keyed by json tag, then saves the
+// settings and notifies the change handlers. Nothing is applied when a value
+// is invalid, the errors are returned keyed by json tag
+func Update(values map[string]interface{}) (map[string]string, error) {
+	updateMu.Lock()
+
+	next := *GetInstance()
+	errs := map[string]string{}
+	for key, value := range values {
+		if err := next.Set(key, value); err != nil {
+			errs[key] = err.Error()
+		}
+	}
+	if len(errs) > 0 {
+		updateMu.Unlock()
+		return errs, nil
+	}
+
+	return apply(next)
+}
+
+// Reload reads the settings file again, for changes made outside the API
+func Reload() (map[string]string, error) {
+	updateMu.Lock()
+
+	next := Config{}
+	if err := next.Load(); err != nil {
+		updateMu.Unlock()
+		return nil, err
+	}
+
+	return apply(next)
+}
+
+// apply publishes next once validated in place of the current settings, it
+// must be called holding updateMu
+func apply(next Config) (map[string]string, error) {
+	if errs := next.Validate(); len(errs) > 0 {
+		updateMu.Unlock()
+		return errs, nil
+	}
+
+	current := GetInstance()
+	changed := current.diff(&next)
+	err := next.Save()
+	instance.Store(&next)
+	notify := append([]ChangeHandler{}, handlers...)
+	updateMu.Unlock()
+
+	if len(changed) > 0 {
+		for _, handler := range notify {
+			handler(&next, changed)
+		}
+	}
+
+	return nil, err
+}
+
+func (c *Config) diff(other *Config) []string {
+	val := reflect.ValueOf(c).Elem()
+	otherVal := reflect.ValueOf(other).Elem()
+	typ := val.Type()
+
+	keys := []string{}
+	for i := 0; i < val.NumField(); i++ {
+		if !reflect.DeepEqual(val.Field(i).Interface(), otherVal.Field(i).Interface()) {
+			keys = append(keys, typ.Field(i).Tag.Get("json"))
+		}
+	}
+
+	return keys
+}
+
+// Values returns every setting keyed by json tag, secrets are left out
+func (c *Config) Values() map[string]interface{} {
+	val := reflect.ValueOf(c).Elem()
+	typ := val.Type()
+
+	values := map[string]interface{}{}
+	for i := 0; i < val.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Tag.Get("setting") == "secret" {
+			continue
+		}
+		values[field.Tag.Get("json")] = val.Field(i).Interface()
+	}
+
+	return values
+}
+
+// IsSecret tells whether the setting can be written but never read back
+func IsSecret(key string) bool {
+	typ := reflect.TypeOf(Config{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Tag.Get("json") == key {
+			return field.Tag.Get("setting") == "secret"
+		}
+	}
+
+	return false
+}
+
+// Validate checks the settings are usable, the errors are keyed by json tag
+func (c *Config) Validate() map[string]string {
+	errs := map[string]string{}
+
+	val := reflect.ValueOf(c).Elem()
+	typ := val.Type()
+	for i := 0; i < val.NumField(); i++ {
+		if val.Field(i).Kind() == reflect.Int && val.Field(i).Int() < 0 {
+			errs[typ.Field(i).Tag.Get("json")] = "must not be negative"
+		}
+	}
+
+	if c.DefaultPageSize > 0 && c.MaxPageSize > 0 && c.DefaultPageSize > c.MaxPageSize {
+		errs["default_page_size"] = "must not be greater than max_page_size"
+	}
+	if c.AppName == "" {
+		errs["app_name"] = "is required"
+	}
+	if u, err := url.Parse(c.AppURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs["app_url"] = "must be an absolute http or https url"
+	}
+	for _, origin := range c.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			errs["allowed_origins"] = err.Error()
+			break
+		}
+	}
+	for _, origin := range c.CORSCredentialOrigins {
+		if origin == "*" {
+			errs["cors_credential_origins"] = "cannot allow credentials from every origin"
+			break
+		}
+		if err := validateOrigin(origin); err != nil {
+			errs["cors_credential_origins"] = err.Error()
+			break
+		}
+	}
+	if c.BackupScheduleTimezone != "" {
+		if _, err := time.LoadLocation(c.BackupScheduleTimezone); err != nil {
+			errs["backup_schedule_timezone"] = "invalid timezone"
+		}
+	}
+	if c.BackupSchedule != "" {
+		if _, err := cron.ParseStandard(c.BackupSchedule); err != nil {
+			errs["backup_schedule"] = err.Error()
+		}
+	}
+	switch c.BackupCompression {
+	case "", "none", "gzip", "zstd":
+	default:
+		errs["backup_compression"] = "must be none, gzip or zstd"
+	}
+	switch c.BackupMode {
+	case "", "full", "incremental":
+	default:
+		errs["backup_mode"] = "must be full or incremental"
+	}
+	switch c.BackupRemote {
+	case "":
+	case "s3":
+		if c.BackupS3Endpoint == "" || c.BackupS3Bucket == "" {
+			errs["backup_remote"] = "backup_s3_endpoint and backup_s3_bucket are required"
+		}
+	case "sftp":
+		if c.BackupSFTPHost == "" || c.BackupSFTPUsername == "" || c.BackupSFTPHostKey == "" {
+			errs["backup_remote"] = "backup_sftp_host, backup_sftp_username and backup_sftp_host_key are required"
+		}
+	default:
+		errs["backup_remote"] = "must be s3 or sftp"
+	}
+	if c.BackupSFTPPort > 65535 {
+		errs["backup_sftp_port"] = "must be a valid port"
+	}
+	for _, email := range c.BackupNotifyEmails {
+		if _, err := mail.ParseAddress(email); err != nil {
+			errs["backup_notify_emails"] = fmt.Sprintf("invalid email %s", email)
+			break
+		}
+	}
+	if c.BackupNotifyWebhook != "" {
+		if u, err := url.Parse(c.BackupNotifyWebhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs["backup_notify_webhook"] = "must be an absolute http or https url"
+		}
+	}
+	if c.WebhookMaxAttempts > 20 {
+		errs["webhook_max_attempts"] = "must be at most 20"
+	}
+	switch strings.ToUpper(c.SQLiteJournalMode) {
+	case "", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		errs["sqlite_journal_mode"] = "must be DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF"
+	}
+	switch strings.ToUpper(c.SQLiteSynchronous) {
+	case "", "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		errs["sqlite_synchronous"] = "must be OFF, NORMAL, FULL or EXTRA"
+	}
+	switch c.CacheBackend {
+	case "", "memory":
+	case "redis":
+		if u, err := url.Parse(c.CacheRedisURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			errs["cache_redis_url"] = "must be a redis or rediss url"
+		}
+	default:
+		errs["cache_backend"] = "must be memory or redis"
+	}
+	switch c.StorageBackend {
+	case "", "local":
+	case "s3":
+		if c.StorageS3Endpoint == "" || c.StorageS3Bucket == "" {
+			errs["storage_backend"] = "storage_s3_endpoint and storage_s3_bucket are required"
+		}
+	default:
+		errs["storage_backend"] = "must be local or s3"
+	}
+	switch c.ScannerBackend {
+	case "":
+	case "clamav":
+		if network, address, _ := strings.Cut(c.ScannerAddress, ":"); (network != "unix" && network != "tcp") || address == "" {
+			errs["scanner_address"] = "must be unix:/path or tcp:host:port"
+		}
+	case "http":
+		if u, err := url.Parse(c.ScannerURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs["scanner_url"] = "must be a http or https url"
+		}
+	default:
+		errs["scanner_backend"] = "must be clamav or http"
+	}
+	if c.OTLPEndpoint != "" {
+		if u, err := url.Parse(c.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs["otlp_endpoint"] = "must be an absolute http or https url"
+		}
+	}
+	for _, header := range c.OTLPHeaders {
+		if name, _, ok := strings.Cut(header, "="); !ok || strings.TrimSpace(name) == "" {
+			errs["otlp_headers"] = "must be formatted as name=value"
+		}
+	}
+	if c.ErrorReportDSN != "" {
+		u, err := url.Parse(c.ErrorReportDSN)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs["error_report_dsn"] = "must be an absolute http or https url"
+		} else if u.User != nil && strings.Trim(u.Path, "/") == "" {
+			errs["error_report_dsn"] = "must end with the id of the Sentry project"
+		}
+	}
+	if c.TraceSamplePercent > 100 {
+		errs["trace_sample_percent"] = "must be at most 100"
+	}
+	if c.GRPCPort > 65535 {
+		errs["grpc_port"] = "must be a valid port"
+	}
+	if c.SMTPPort > 65535 {
+		errs["smtp_port"] = "must be a valid port"
+	}
+	if c.SAMLEnabled && c.SAMLIDPMetadata == "" && c.SAMLIDPMetadataURL == "" {
+		errs["saml_idp_metadata_url"] = "is required when saml is enabled"
+	}
+
+	return errs
+}
+
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return fmt.Errorf("invalid origin %s", origin)
+	}
+
+	// wildcards are only accepted for a whole subdomain, as in *.example.com
+	if strings.Contains(strings.TrimPrefix(u.Hostname(), "*."), "*") {
+		return fmt.Errorf("invalid origin %s", origin)
+	}
+
+	return nil
+}
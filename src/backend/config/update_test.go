@@ -0,0 +1,45 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestUpdateWhileReading is meant to be run with -race, the settings are read
+// by the requests while they are updated
+func TestUpdateWhileReading(t *testing.T) {
+	t.Setenv("CONFIG_PATH", filepath.Join(t.TempDir(), "config.json"))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					settings := GetInstance()
+					_ = settings.AppName + fmt.Sprint(settings.MaxPageSize, settings.AllowedOrigins)
+				}
+			}
+		}()
+	}
+
+	for i := 1; i <= 50; i++ {
+		invalid, err := Update(map[string]interface{}{"app_name": fmt.Sprintf("app %d", i), "max_page_size": float64(i * 10)})
+		if err != nil || len(invalid) > 0 {
+			t.Fatalf("Update: %v %v", invalid, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	if settings := GetInstance(); settings.AppName != "app 50" || settings.MaxPageSize != 500 {
+		t.Errorf("settings are %s and %d, want app 50 and 500", settings.AppName, settings.MaxPageSize)
+	}
+}
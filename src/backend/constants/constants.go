@@ -1,7 +1,13 @@
 package constants
 
 const (
-	CONTAINER_API_NAME    = "api"
-	CONTAINER_CONFIG_NAME = "config"
-	CONTAINER_DB_NAME     = "db"
+	CONTAINER_API_NAME     = "api"
+	CONTAINER_BACKUP_NAME  = "backup"
+	CONTAINER_CACHE_NAME   = "cache"
+	CONTAINER_CONFIG_NAME  = "config"
+	CONTAINER_DB_NAME      = "db"
+	CONTAINER_MAILER_NAME  = "mailer"
+	CONTAINER_READ_DB_NAME = "read_db"
+	CONTAINER_STORAGE_NAME = "storage"
+	CONTAINER_WEBHOOK_NAME = "webhook"
 )
@@ -0,0 +1,110 @@
+package events
+
+import (
+	"log"
+	"maps"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+// the names of the published events
+const (
+	RECORD_CREATED  = "record.created"
+	RECORD_UPDATED  = "record.updated"
+	RECORD_DELETED  = "record.deleted"
+	USER_REGISTERED = "user.registered"
+	BACKUP_FINISHED = "backup.finished"
+	FILE_UPLOADED   = "file.uploaded"
+	FILE_DELETED    = "file.deleted"
+)
+
+// Event is passed to the handlers of its name. Table and Record are set on
+// the record events and on user.registered, Data holds what the other events
+// are about such as the backup created
+type Event struct {
+	Name   string                 `json:"name"`
+	Time   time.Time              `json:"time"`
+	Table  string                 `json:"table,omitempty"`
+	Record map[string]interface{} `json:"record,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// Handler reacts to an event. The handlers run one after the other in the
+// goroutine publishing the event, so a slow one should do its work in its
+// own goroutine
+type Handler func(event Event)
+
+type registration struct {
+	id      int
+	handler Handler
+}
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string][]registration{}
+	nextID   int
+)
+
+// On registers handler for the events of the given name, the returned
+// function removes it
+func On(name string, handler Handler) (remove func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	nextID++
+	id := nextID
+	handlers[name] = append(handlers[name], registration{id: id, handler: handler})
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		handlers[name] = slices.DeleteFunc(handlers[name], func(r registration) bool {
+			return r.id == id
+		})
+		if len(handlers[name]) == 0 {
+			delete(handlers, name)
+		}
+	}
+}
+
+// Handled tells whether a handler is registered for the events of name, so
+// publishers can skip the work of building an event nobody listens to
+func Handled(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return len(handlers[name]) > 0
+}
+
+// Publish runs the handlers of the event in the order they were registered.
+// A panicking handler is logged and doesn't stop the others
+func Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	mu.RLock()
+	registered := slices.Clone(handlers[event.Name])
+	mu.RUnlock()
+
+	for _, r := range registered {
+		// each handler gets its own copy to change
+		copied := event
+		copied.Record = maps.Clone(event.Record)
+		copied.Data = maps.Clone(event.Data)
+		run(r.handler, copied)
+	}
+}
+
+func run(handler Handler, event Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("event handler of %s panicked: %v\n%s", event.Name, err, debug.Stack())
+		}
+	}()
+
+	handler(event)
+}
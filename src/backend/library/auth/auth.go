@@ -3,7 +3,7 @@ package auth_libraries
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"os"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -37,20 +37,29 @@ func VerifyPassword(password, salt, storedPassword string) bool {
 	return err == nil
 }
 
+// TokenLifetime is how long tokens from GenerateJWT stay valid
+const TokenLifetime = time.Hour * 24 * 7
+
 func GenerateJWT(payload map[string]interface{}) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS512)
 
 	claims := token.Claims.(jwt.MapClaims)
 
 	claims["iss"] = "fullbase"
-	claims["exp"] = time.Now().Add(time.Hour * 24 * 7).Unix()
+	claims["exp"] = time.Now().Add(TokenLifetime).Unix()
 	claims["iat"] = time.Now().Unix()
 	claims["jti"], _ = uuid.NewV7()
 	for k, v := range payload {
 		claims[k] = v
 	}
 
-	tokenStr, err := token.SignedString([]byte(os.Getenv("JWT_SECRET_KEY")))
+	key, ok := ActiveSigningKey()
+	if !ok {
+		return "", errors.New("no active signing key")
+	}
+	token.Header["kid"] = key.ID
+
+	tokenStr, err := token.SignedString(key.Secret)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,78 @@
+package auth_libraries
+
+import (
+	"os"
+	"sync"
+)
+
+// DefaultKeyID identifies the JWT_SECRET_KEY secret, tokens without a kid
+// header were signed with it
+const DefaultKeyID = "default"
+
+// SigningKey is a secret used to sign tokens, identified by the kid header
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+var keyring struct {
+	sync.RWMutex
+	loaded bool
+	keys   []SigningKey
+}
+
+// SetSigningKeys replaces the keys accepted when verifying tokens. The first
+// key signs new tokens
+func SetSigningKeys(keys []SigningKey) {
+	keyring.Lock()
+	defer keyring.Unlock()
+
+	keyring.loaded = true
+	keyring.keys = keys
+}
+
+// DefaultSigningKey returns the key built from the JWT_SECRET_KEY secret
+func DefaultSigningKey() SigningKey {
+	return SigningKey{ID: DefaultKeyID, Secret: []byte(os.Getenv("JWT_SECRET_KEY"))}
+}
+
+// ActiveSigningKey returns the key new tokens are signed with
+func ActiveSigningKey() (SigningKey, bool) {
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	if !keyring.loaded {
+		return DefaultSigningKey(), true
+	}
+	if len(keyring.keys) == 0 {
+		return SigningKey{}, false
+	}
+
+	return keyring.keys[0], true
+}
+
+// VerificationKey returns the secret of the key with the given id, as long
+// as the key hasn't been retired
+func VerificationKey(kid string) ([]byte, bool) {
+	if kid == "" {
+		kid = DefaultKeyID
+	}
+
+	keyring.RLock()
+	defer keyring.RUnlock()
+
+	if !keyring.loaded {
+		if kid == DefaultKeyID {
+			return DefaultSigningKey().Secret, true
+		}
+		return nil, false
+	}
+
+	for _, key := range keyring.keys {
+		if key.ID == kid {
+			return key.Secret, true
+		}
+	}
+
+	return nil, false
+}
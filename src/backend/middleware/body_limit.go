@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"react-golang/src/backend/config"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultMaxBodySizeMB   = 32
+	defaultMaxUploadSizeMB = 256
+)
+
+// maxBodySize returns the limit of the request body in bytes, multipart
+// requests and upload chunks carry files so they get the larger upload limit
+func maxBodySize(req *http.Request) int64 {
+	appConfig := config.GetInstance()
+
+	size := appConfig.MaxBodySizeMB
+	if size <= 0 {
+		size = defaultMaxBodySizeMB
+	}
+	contentType := req.Header.Get(echo.HeaderContentType)
+	if strings.HasPrefix(contentType, echo.MIMEMultipartForm) || strings.HasPrefix(contentType, "application/offset+octet-stream") {
+		size = appConfig.MaxUploadSizeMB
+		if size <= 0 {
+			size = defaultMaxUploadSizeMB
+		}
+	}
+
+	return int64(size) << 20
+}
+
+// BodyLimit rejects request bodies larger than the limits of the settings.
+// Gzip encoded bodies are decompressed here so the limit applies to what the
+// handlers read rather than to what was sent
+func BodyLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		limit := maxBodySize(req)
+
+		if req.ContentLength > limit {
+			return pkg_apierror.Message(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d MB", limit>>20))
+		}
+
+		if strings.EqualFold(req.Header.Get(echo.HeaderContentEncoding), "gzip") {
+			reader, err := gzip.NewReader(req.Body)
+			if err != nil {
+				return pkg_apierror.Message(c, http.StatusBadRequest, "invalid gzip body")
+			}
+			req.Body = gzipBody{Reader: reader, body: req.Body}
+			req.Header.Del(echo.HeaderContentEncoding)
+			req.Header.Del(echo.HeaderContentLength)
+			req.ContentLength = -1
+		}
+
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+
+		return next(c)
+	}
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
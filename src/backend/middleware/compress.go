@@ -0,0 +1,235 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"react-golang/src/backend/config"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/labstack/echo/v4"
+)
+
+// responses smaller than this aren't worth the overhead of compressing them
+const compressMinSize = 1024
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		writer, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return writer
+	}}
+	brotliPool = sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}}
+)
+
+// Compress compresses the responses with brotli or gzip, whichever the
+// client prefers. Only textual responses such as JSON and CSV are
+// compressed, files and backups are usually compressed already. Range and
+// upgrade requests are left alone since their bodies must be sent as is, as
+// are responses accepting ranges so the ranges match what was downloaded
+func Compress(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		res := c.Response()
+		if config.GetInstance().DisableCompression || req.Method == http.MethodHead ||
+			req.Header.Get("Range") != "" || req.Header.Get(echo.HeaderUpgrade) != "" {
+			return next(c)
+		}
+
+		res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+		encoding := acceptedEncoding(req.Header.Get(echo.HeaderAcceptEncoding))
+		if encoding == "" {
+			return next(c)
+		}
+
+		writer := &compressWriter{ResponseWriter: res.Writer, encoding: encoding}
+		res.Writer = writer
+		defer func() {
+			writer.Close()
+			res.Writer = writer.ResponseWriter
+		}()
+
+		return next(c)
+	}
+}
+
+// acceptedEncoding returns br or gzip according to the Accept-Encoding
+// header, br wins when both are accepted equally
+func acceptedEncoding(header string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "br" && name != "gzip" {
+			continue
+		}
+
+		quality := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality > bestQuality || (quality == bestQuality && name == "br") {
+			best, bestQuality = name, quality
+		}
+	}
+
+	return best
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case echo.MIMEApplicationJSON, echo.MIMEApplicationXML, echo.MIMEApplicationJavaScript,
+		"application/x-ndjson", "application/sql":
+		return true
+	}
+
+	return false
+}
+
+// compressWriter buffers the start of the response until it knows whether
+// compressing it is worth it, the headers are only written then
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.status = status
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < compressMinSize {
+			return len(b), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// decide writes the headers and the buffered start of the response,
+// compressed when it is large enough and of a compressible type
+func (w *compressWriter) decide() error {
+	w.decided = true
+
+	header := w.Header()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if header.Get(echo.HeaderContentType) == "" && len(w.buf) > 0 {
+		header.Set(echo.HeaderContentType, http.DetectContentType(w.buf))
+	}
+
+	if len(w.buf) >= compressMinSize && header.Get(echo.HeaderContentEncoding) == "" &&
+		header.Get("Accept-Ranges") == "" && w.status != http.StatusNoContent && w.status != http.StatusNotModified &&
+		compressibleType(header.Get(echo.HeaderContentType)) {
+		header.Set(echo.HeaderContentEncoding, w.encoding)
+		header.Del(echo.HeaderContentLength)
+
+		if w.encoding == "br" {
+			encoder := brotliPool.Get().(*brotli.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := gzipPool.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+
+	return err
+}
+
+// Flush sends what was written so far, streamed responses flush as they go
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close ends the compressed stream, responses that never wrote anything are
+// left untouched
+func (w *compressWriter) Close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return
+		}
+		w.decide()
+	}
+
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *brotli.Writer:
+		encoder.Reset(io.Discard)
+		brotliPool.Put(encoder)
+	case *gzip.Writer:
+		encoder.Reset(io.Discard)
+		gzipPool.Put(encoder)
+	}
+	w.encoder = nil
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"react-golang/src/backend/config"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	corsAllowHeaders = strings.Join([]string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAuthorization, "X-API-KEY", "Upload-Offset", echo.HeaderXRequestID, "If-None-Match"}, ",")
+	corsAllowMethods = strings.Join([]string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodPatch, http.MethodPost, http.MethodDelete}, ",")
+
+	// the headers of the responses readable by cross origin clients
+	corsExposeHeaders = strings.Join([]string{"X-Total-Count", "X-Page", "X-Page-Size", "Upload-Offset", "Upload-Length", echo.HeaderXRequestID, "ETag"}, ",")
+)
+
+// CORS answers cross origin requests from the allowed origins of the
+// settings. The settings are read on every request so changes apply without
+// a restart. Origins like https://*.example.com match every subdomain, only
+// the origins listed in the credential origins may send cookies
+func CORS(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		header := c.Response().Header()
+		origin := req.Header.Get(echo.HeaderOrigin)
+		preflight := req.Method == http.MethodOptions && req.Header.Get(echo.HeaderAccessControlRequestMethod) != ""
+
+		header.Add(echo.HeaderVary, echo.HeaderOrigin)
+		if preflight {
+			header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestMethod)
+			header.Add(echo.HeaderVary, echo.HeaderAccessControlRequestHeaders)
+		}
+
+		appConfig := config.GetInstance()
+		if origin == "" || !matchOrigin(appConfig.AllowedOrigins, origin) {
+			if preflight {
+				return c.NoContent(http.StatusNoContent)
+			}
+			return next(c)
+		}
+
+		// credentials can't be allowed along with the * origin, the request
+		// origin is echoed back instead
+		if matchOrigin(appConfig.CORSCredentialOrigins, origin) {
+			header.Set(echo.HeaderAccessControlAllowOrigin, origin)
+			header.Set(echo.HeaderAccessControlAllowCredentials, "true")
+		} else if containsString(appConfig.AllowedOrigins, "*") {
+			header.Set(echo.HeaderAccessControlAllowOrigin, "*")
+		} else {
+			header.Set(echo.HeaderAccessControlAllowOrigin, origin)
+		}
+
+		if !preflight {
+			header.Set(echo.HeaderAccessControlExposeHeaders, corsExposeHeaders)
+			return next(c)
+		}
+
+		header.Set(echo.HeaderAccessControlAllowMethods, corsAllowMethods)
+		header.Set(echo.HeaderAccessControlAllowHeaders, corsAllowHeaders)
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+// AllowedOrigin tells whether origin is one of the allowed origins of the
+// settings, for the WebSocket handshakes which CORS doesn't apply to
+func AllowedOrigin(origin string) bool {
+	return matchOrigin(config.GetInstance().AllowedOrigins, origin)
+}
+
+// matchOrigin tells whether origin matches one of patterns, * matches any
+// origin and a *. host prefix matches any subdomain
+func matchOrigin(patterns []string, origin string) bool {
+	originURL, err := url.Parse(strings.ToLower(origin))
+	if err != nil || originURL.Host == "" {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+
+		patternURL, err := url.Parse(strings.ToLower(strings.TrimSuffix(pattern, "/")))
+		if err != nil || patternURL.Scheme != originURL.Scheme || patternURL.Port() != originURL.Port() {
+			continue
+		}
+
+		host := patternURL.Hostname()
+		if strings.HasPrefix(host, "*.") {
+			if strings.HasSuffix(originURL.Hostname(), host[1:]) {
+				return true
+			}
+		} else if host == originURL.Hostname() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
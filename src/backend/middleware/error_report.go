@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"react-golang/src/backend/config"
+	pkg_errorreport "react-golang/src/backend/pkg/errorreport"
+	"runtime/debug"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// the start of the body of a server error kept to read its message
+const errorBodySize = 4096
+
+// reportedHeaders are the request headers sent with the reports, the other
+// ones may carry credentials
+var reportedHeaders = []string{
+	"Accept", "Accept-Encoding", "Accept-Language", "Content-Type", "Content-Length",
+	"Origin", "Referer", "User-Agent", "X-Forwarded-For", "X-Forwarded-Proto",
+}
+
+var serverName, _ = os.Hostname()
+
+// ErrorReporting recovers the panics of the handlers and reports the server
+// errors, returned or written, to the error report DSN and hooks. A panic
+// is turned into a 500 error
+func ErrorReporting(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) (err error) {
+		res := c.Response()
+		writer := &errorBodyWriter{ResponseWriter: res.Writer}
+		res.Writer = writer
+		defer func() {
+			res.Writer = writer.ResponseWriter
+		}()
+
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+
+			panicErr, ok := r.(error)
+			if !ok {
+				panicErr = fmt.Errorf("%v", r)
+			}
+			stack := string(debug.Stack())
+			log.Printf("[PANIC RECOVER] %v %s\n", panicErr, stack)
+
+			report := newErrorReport(c, http.StatusInternalServerError, panicErr)
+			report.Panic = true
+			report.Stacktrace = stack
+			pkg_errorreport.Capture(report, config.GetInstance().ErrorReportDSN)
+
+			err = panicErr
+		}()
+
+		err = next(c)
+
+		status := res.Status
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		} else if err != nil {
+			status = http.StatusInternalServerError
+		}
+		if status < http.StatusInternalServerError {
+			return err
+		}
+
+		var report pkg_errorreport.Report
+		if err != nil {
+			report = newErrorReport(c, status, err)
+		} else {
+			report = newErrorReport(c, status, errors.New(writer.message(status)))
+			report.Type = fmt.Sprintf("HTTP %d", status)
+		}
+		pkg_errorreport.Capture(report, config.GetInstance().ErrorReportDSN)
+
+		return err
+	}
+}
+
+func newErrorReport(c echo.Context, status int, err error) pkg_errorreport.Report {
+	req := c.Request()
+	headers := map[string]string{}
+	for _, name := range reportedHeaders {
+		if value := req.Header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+
+	report := pkg_errorreport.Report{
+		Message: err.Error(),
+		Type:    fmt.Sprintf("%T", err),
+		Status:  status,
+		Request: pkg_errorreport.Request{
+			Method:   req.Method,
+			URL:      requestURL(c),
+			Route:    c.Path(),
+			ID:       RequestID(c),
+			Headers:  headers,
+			ClientIP: c.RealIP(),
+		},
+		ServerName: serverName,
+	}
+	if userID, ok := c.Get("user_id").(string); ok {
+		report.UserID = userID
+	}
+
+	return report
+}
+
+// requestURL is the URL of the request without its query, which may hold an
+// api key or a token
+func requestURL(c echo.Context) string {
+	req := c.Request()
+	return fmt.Sprintf("%s://%s%s", c.Scheme(), req.Host, req.URL.Path)
+}
+
+// errorBodyWriter keeps the start of the server errors written by the
+// handlers, whose message is only in their body
+type errorBodyWriter struct {
+	http.ResponseWriter
+
+	status int
+	body   []byte
+}
+
+func (w *errorBodyWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorBodyWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusInternalServerError && len(w.body) < errorBodySize &&
+		w.Header().Get(echo.HeaderContentEncoding) == "" {
+		w.body = append(w.body, b[:min(len(b), errorBodySize-len(w.body))]...)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// message reads the message of the error bodies, or falls back to the status
+func (w *errorBodyWriter) message(status int) string {
+	var body map[string]interface{}
+	if json.Unmarshal(w.body, &body) == nil {
+		if message, ok := body["message"].(string); ok && message != "" {
+			return message
+		}
+	}
+	if message := strings.TrimSpace(string(w.body)); message != "" && !strings.HasPrefix(message, "{") {
+		return message
+	}
+
+	return http.StatusText(status)
+}
+
+func (w *errorBodyWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *errorBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *errorBodyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
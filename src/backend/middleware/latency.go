@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// latencyBounds are the upper bounds of the buckets of the histograms, in
+// milliseconds. The last bucket holds the slower requests
+var latencyBounds = []float64{1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// latencyRoutes names the routes whose latency is tracked, the other routes
+// of the API are tracked together as other
+var latencyRoutes = map[string]string{
+	"POST /api/main/:table_name/rows":     "rows.list",
+	"GET /api/main/:table_name/:id":       "record.get",
+	"POST /api/main/:table_name/insert":   "record.insert",
+	"PUT /api/main/:table_name/update":    "record.update",
+	"DELETE /api/main/:table_name/rows":   "record.delete",
+	"POST /api/:func_name":                "function.run",
+	"POST /api/main/query":                "query.run",
+	"POST /api/auth/login/:table_name":    "auth.login",
+	"POST /api/auth/register/:table_name": "auth.register",
+	"POST /api/files":                     "file.upload",
+	"GET /api/files/:key":                 "file.download",
+}
+
+type latencyHistogram struct {
+	count  int64
+	errors int64
+	sum    float64
+	min    float64
+	max    float64
+	// buckets has one more count than latencyBounds, for the slower requests
+	buckets []int64
+}
+
+func (h *latencyHistogram) observe(ms float64, failed bool) {
+	if h.count == 0 || ms < h.min {
+		h.min = ms
+	}
+	if ms > h.max {
+		h.max = ms
+	}
+	h.count++
+	h.sum += ms
+	if failed {
+		h.errors++
+	}
+	h.buckets[sort.SearchFloat64s(latencyBounds, ms)]++
+}
+
+// quantile estimates the latency under which q of the requests were served,
+// interpolating within its bucket
+func (h *latencyHistogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.count)
+	var seen float64
+	for i, count := range h.buckets {
+		if count == 0 || seen+float64(count) < rank {
+			seen += float64(count)
+			continue
+		}
+
+		lower, upper := 0.0, h.max
+		if i > 0 {
+			lower = latencyBounds[i-1]
+		}
+		if i < len(latencyBounds) {
+			upper = latencyBounds[i]
+		}
+		lower, upper = math.Max(lower, h.min), math.Min(upper, h.max)
+
+		return lower + (upper-lower)*(rank-seen)/float64(count)
+	}
+
+	return h.max
+}
+
+var latency = struct {
+	mu         sync.Mutex
+	since      time.Time
+	histograms map[string]*latencyHistogram
+}{since: time.Now().UTC()}
+
+// Latency keeps a histogram of the time taken to serve the requests of each
+// route of latencyRoutes
+func Latency(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+		ms := float64(time.Since(start).Microseconds()) / 1000
+
+		if !strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			return err
+		}
+		route, ok := latencyRoutes[c.Request().Method+" "+c.Path()]
+		if !ok {
+			route = "other"
+		}
+
+		status := c.Response().Status
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		} else if err != nil {
+			status = http.StatusInternalServerError
+		}
+
+		latency.mu.Lock()
+		if latency.histograms == nil {
+			latency.histograms = map[string]*latencyHistogram{}
+		}
+		histogram, ok := latency.histograms[route]
+		if !ok {
+			histogram = &latencyHistogram{buckets: make([]int64, len(latencyBounds)+1)}
+			latency.histograms[route] = histogram
+		}
+		histogram.observe(ms, status >= http.StatusInternalServerError)
+		latency.mu.Unlock()
+
+		return err
+	}
+}
+
+// LatencyBucket counts the requests served in at most LessOrEqual
+// milliseconds and more than the bound of the previous bucket. The bound of
+// the last bucket is nil
+type LatencyBucket struct {
+	LessOrEqual *float64 `json:"le"`
+	Count       int64    `json:"count"`
+}
+
+type RouteLatency struct {
+	Route   string          `json:"route"`
+	Count   int64           `json:"count"`
+	Errors  int64           `json:"errors"`
+	AvgMs   float64         `json:"avg_ms"`
+	MinMs   float64         `json:"min_ms"`
+	MaxMs   float64         `json:"max_ms"`
+	P50Ms   float64         `json:"p50_ms"`
+	P90Ms   float64         `json:"p90_ms"`
+	P99Ms   float64         `json:"p99_ms"`
+	Buckets []LatencyBucket `json:"buckets"`
+}
+
+// FetchLatency returns the histograms of the routes sorted by name, along
+// with when they started counting
+func FetchLatency() ([]RouteLatency, time.Time) {
+	latency.mu.Lock()
+	defer latency.mu.Unlock()
+
+	routes := []RouteLatency{}
+	for route, h := range latency.histograms {
+		result := RouteLatency{
+			Route:  route,
+			Count:  h.count,
+			Errors: h.errors,
+			AvgMs:  h.sum / float64(h.count),
+			MinMs:  h.min,
+			MaxMs:  h.max,
+			P50Ms:  h.quantile(0.5),
+			P90Ms:  h.quantile(0.9),
+			P99Ms:  h.quantile(0.99),
+		}
+		for i, count := range h.buckets {
+			bucket := LatencyBucket{Count: count}
+			if i < len(latencyBounds) {
+				bucket.LessOrEqual = &latencyBounds[i]
+			}
+			result.Buckets = append(result.Buckets, bucket)
+		}
+		routes = append(routes, result)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+
+	return routes, latency.since
+}
+
+// ResetLatency empties the histograms
+func ResetLatency() {
+	latency.mu.Lock()
+	defer latency.mu.Unlock()
+
+	latency.histograms = nil
+	latency.since = time.Now().UTC()
+}
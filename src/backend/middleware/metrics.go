@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// the number of server errors kept for the dashboard
+const recentErrorsSize = 20
+
+// RequestError is a request which failed with a server error, Error is only
+// known when the handler returned it instead of writing it
+type RequestError struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type RequestMetrics struct {
+	Requests     int64          `json:"requests"`
+	Errors       int64          `json:"errors"`
+	RecentErrors []RequestError `json:"recent_errors"`
+}
+
+var metrics struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+
+	mu     sync.Mutex
+	recent []RequestError
+}
+
+// Metrics counts the requests served since the server started and keeps the
+// latest server errors
+func Metrics(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+
+		metrics.requests.Add(1)
+
+		status := c.Response().Status
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		} else if err != nil {
+			status = http.StatusInternalServerError
+		}
+		if status < http.StatusInternalServerError {
+			return err
+		}
+
+		metrics.errors.Add(1)
+		requestErr := RequestError{
+			Time:   time.Now().UTC(),
+			Method: c.Request().Method,
+			Path:   c.Request().URL.Path,
+			Status: status,
+		}
+		if err != nil {
+			requestErr.Error = err.Error()
+		}
+
+		metrics.mu.Lock()
+		metrics.recent = append(metrics.recent, requestErr)
+		if len(metrics.recent) > recentErrorsSize {
+			metrics.recent = metrics.recent[len(metrics.recent)-recentErrorsSize:]
+		}
+		metrics.mu.Unlock()
+
+		return err
+	}
+}
+
+// FetchRequestMetrics returns the counts of Metrics along with the latest
+// server errors, the most recent first
+func FetchRequestMetrics() RequestMetrics {
+	metrics.mu.Lock()
+	recent := slices.Clone(metrics.recent)
+	metrics.mu.Unlock()
+	slices.Reverse(recent)
+
+	return RequestMetrics{
+		Requests:     metrics.requests.Load(),
+		Errors:       metrics.errors.Load(),
+		RecentErrors: append([]RequestError{}, recent...),
+	}
+}
@@ -5,6 +5,12 @@ import (
 	"net/http"
 	"os"
 	"react-golang/src/backend/config"
+	auth_libraries "react-golang/src/backend/library/auth"
+	"react-golang/src/backend/model"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	pkg_tracing "react-golang/src/backend/pkg/tracing"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -13,53 +19,169 @@ import (
 )
 
 func UseMiddleware(app *echo.Echo) {
-	app.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: config.GetInstance().AllowedOrigins,
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAuthorization, "X-API-KEY"},
-		AllowMethods: []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete},
-	}))
+	app.HTTPErrorHandler = pkg_apierror.HTTPErrorHandler
+	app.Use(AssignRequestID)
+	if pkg_tracing.Enabled() {
+		app.Use(Tracing)
+	}
+	app.Use(CORS)
 	app.Use(middleware.Logger())
-	app.Use(middleware.Recover())
+	app.Use(Metrics)
+	app.Use(Traffic)
+	app.Use(Latency)
+	app.Use(ErrorReporting)
+	app.Use(QueryEndpoint)
+	app.Use(BodyLimit)
+	app.Use(Compress)
+}
+
+// QueryEndpoint sets the route and the ID of the request on its context, the
+// slow queries run with that context are recorded along with them
+func QueryEndpoint(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		route := c.Path()
+		if route == "" {
+			route = req.URL.Path
+		}
+		ctx := pkg_sqlite.WithEndpoint(req.Context(), req.Method+" "+route)
+		c.SetRequest(req.WithContext(pkg_sqlite.WithRequestID(ctx, RequestID(c))))
+
+		return next(c)
+	}
 }
 
+// APITokenPrefix starts every API token so they can be told apart from JWTs
+const APITokenPrefix = "fbt_"
+
+// APITokenAuthenticator resolves an API token into the claims of the user it
+// belongs to, API tokens are rejected when it is unset
+var APITokenAuthenticator func(c echo.Context, token string) (map[string]interface{}, bool)
+
+// SessionValidator tells whether the session a token was issued with is
+// still active, tokens are only checked for expiry when it is unset
+var SessionValidator func(c echo.Context, sessionID string) bool
+
 func RequireAuth(required bool) echo.MiddlewareFunc {
+	return authenticate(required, "")
+}
+
+// RequireScopedAuth accepts regular tokens as well as tokens restricted to
+// scope, such as the ones issued to admins who still have to enroll 2FA
+func RequireScopedAuth(scope string) echo.MiddlewareFunc {
+	return authenticate(true, scope)
+}
+
+func authenticate(required bool, scope string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			unauthorizedErr := map[string]interface{}{
-				"code":   "401",
-				"status": "error",
-				"error":  "unauthorized",
-			}
-
 			authToken := c.Request().Header.Get("Authorization")
 			if authToken == "" {
 				if required {
-					return c.JSON(http.StatusUnauthorized, unauthorizedErr)
+					return pkg_apierror.Message(c, http.StatusUnauthorized, "unauthorized")
 				}
+				return next(c)
 			}
 
-			claims, err := parseJWT(authToken)
-			if err != nil {
-				if required {
-					return c.JSON(http.StatusUnauthorized, unauthorizedErr)
-				}
+			if authenticateToken(c, authToken, scope) {
+				return next(c)
 			}
 
-			// token is expired
-			if float64(time.Now().Unix()) > claims["exp"].(float64) {
-				if required {
-					return c.JSON(http.StatusUnauthorized, unauthorizedErr)
-				}
+			if required {
+				return pkg_apierror.Message(c, http.StatusUnauthorized, "unauthorized")
 			}
 
-			userID, ok := claims["sub"].(string)
-			if ok {
-				c.Set("user_id", userID)
-				return next(c)
+			return next(c)
+		}
+	}
+}
+
+// Authenticate validates token as the Authorization header of a request and
+// sets its claims on c, for connections which authenticate once opened
+func Authenticate(c echo.Context, token string) bool {
+	return authenticateToken(c, token, "")
+}
+
+func authenticateToken(c echo.Context, authToken string, scope string) bool {
+	var claims jwt.MapClaims
+	if APITokenAuthenticator != nil && strings.HasPrefix(authToken, APITokenPrefix) {
+		apiClaims, ok := APITokenAuthenticator(c, authToken)
+		if !ok {
+			return false
+		}
+		claims = apiClaims
+		c.Set("api_token", true)
+	} else {
+		jwtClaims, ok := validateJWT(c, authToken, scope)
+		if !ok {
+			return false
+		}
+		claims = jwtClaims
+	}
+
+	userID, ok := claims["sub"].(string)
+	if !ok {
+		return false
+	}
+	c.Set("session_id", claims["jti"])
+	c.Set("user_id", userID)
+	c.Set("roles", claims["roles"])
+	c.Set("user_table", claims["table"])
+	c.Set("admin_role", claims["admin_role"])
+
+	return true
+}
+
+var adminRoleLevel = map[string]int{
+	model.ADMIN_ROLE_READ_ONLY: 1,
+	model.ADMIN_ROLE_EDITOR:    2,
+	model.ADMIN_ROLE_OWNER:     3,
+}
+
+// HasAdminRole tells whether the request was authenticated by an admin whose
+// role is at least minimum
+func HasAdminRole(c echo.Context, minimum string) bool {
+	role, _ := c.Get("admin_role").(string)
+	if role == "" && isAdminRequest(c) {
+		// admin tokens issued before roles existed belong to owners
+		role = model.ADMIN_ROLE_OWNER
+	}
+
+	return adminRoleLevel[role] > 0 && adminRoleLevel[role] >= adminRoleLevel[minimum]
+}
+
+func isAdminRequest(c echo.Context) bool {
+	roles, _ := c.Get("roles").([]interface{})
+	for _, role := range roles {
+		if role == "admin" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireAdminRole only lets through admins whose role is at least minimum,
+// it must be chained after RequireAuth
+func RequireAdminRole(minimum string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !HasAdminRole(c, minimum) {
+				return pkg_apierror.Message(c, http.StatusForbidden, "insufficient admin role")
 			}
 
-			if required {
-				return c.JSON(http.StatusUnauthorized, unauthorizedErr)
+			return next(c)
+		}
+	}
+}
+
+// RestrictAdminRole applies RequireAdminRole to admins only, application
+// users are let through to be handled by the table and column rules
+func RestrictAdminRole(minimum string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if isAdminRequest(c) {
+				return RequireAdminRole(minimum)(next)(c)
 			}
 
 			return next(c)
@@ -67,12 +189,44 @@ func RequireAuth(required bool) echo.MiddlewareFunc {
 	}
 }
 
+// validateJWT checks the signature, expiry, scope and session of a token
+func validateJWT(c echo.Context, authToken string, scope string) (jwt.MapClaims, bool) {
+	claims, err := parseJWT(authToken)
+	if err != nil {
+		return nil, false
+	}
+
+	// token is expired
+	if exp, ok := claims["exp"].(float64); !ok || float64(time.Now().Unix()) > exp {
+		return nil, false
+	}
+
+	// scoped tokens are only valid on the routes asking for that scope
+	if tokenScope, _ := claims["scope"].(string); tokenScope != "" && tokenScope != scope {
+		return nil, false
+	}
+
+	// revoked sessions
+	sessionID, _ := claims["jti"].(string)
+	if SessionValidator != nil && !SessionValidator(c, sessionID) {
+		return nil, false
+	}
+
+	return claims, true
+}
+
 func parseJWT(tokenStr string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("invalid signing method")
 		}
-		return []byte(os.Getenv("JWT_SECRET_KEY")), nil
+
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := auth_libraries.VerificationKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key")
+		}
+		return secret, nil
 	})
 
 	if err != nil {
@@ -90,20 +244,16 @@ func parseJWT(tokenStr string) (jwt.MapClaims, error) {
 func ValidateAPIKey(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		key := c.Request().Header.Get("X-API-KEY")
+		// browsers can't set headers on WebSocket handshakes
+		if key == "" && c.IsWebSocket() {
+			key = c.QueryParam("api_key")
+		}
 		if key == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"code":   "401",
-				"status": "error",
-				"error":  "missing API key",
-			})
+			return pkg_apierror.Message(c, http.StatusUnauthorized, "missing API key")
 		}
 
 		if key != config.GetInstance().APIKey && key != os.Getenv("MAIN_APP_API_KEY") {
-			return c.JSON(http.StatusUnauthorized, map[string]interface{}{
-				"code":   "401",
-				"status": "error",
-				"error":  "api key invalid",
-			})
+			return pkg_apierror.Message(c, http.StatusUnauthorized, "api key invalid")
 		}
 
 		return next(c)
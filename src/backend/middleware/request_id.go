@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// the longest request ID accepted from the clients, longer ones are replaced
+const maxRequestIDLength = 128
+
+// AssignRequestID gives every request an ID, the X-Request-ID header of the
+// client when it is valid or a new one. The ID is sent back in the
+// X-Request-ID header of the response, and is found in the request logs,
+// the error bodies, the slow query log and the error reports
+func AssignRequestID(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(echo.HeaderXRequestID)
+		if !validRequestID(id) {
+			generated, err := uuid.NewV7()
+			if err != nil {
+				return err
+			}
+			id = generated.String()
+			// the request logs read the ID from the request
+			c.Request().Header.Set(echo.HeaderXRequestID, id)
+		}
+
+		c.Set("request_id", id)
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+
+		return next(c)
+	}
+}
+
+// RequestID returns the ID of a request, empty before AssignRequestID ran
+func RequestID(c echo.Context) string {
+	id, _ := c.Get("request_id").(string)
+	return id
+}
+
+// validRequestID accepts the IDs made of visible ASCII characters, so they
+// can't forge lines of the logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	pkg_tracing "react-golang/src/backend/pkg/tracing"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing starts a span for every request, continuing the trace of its
+// traceparent header. The span is in the context of the request, the
+// services and queries given that context nest their spans under it
+func Tracing(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		route := c.Path()
+		if route == "" {
+			route = req.URL.Path
+		}
+		ctx, span := pkg_tracing.Start(ctx, fmt.Sprintf("%s %s", req.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", req.URL.Path),
+				attribute.String("client.address", c.RealIP()),
+				attribute.String("http.request.id", RequestID(c)),
+			),
+		)
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+
+		status := c.Response().Status
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		} else if err != nil {
+			status = http.StatusInternalServerError
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if userID, ok := c.Get("user_id").(string); ok {
+			span.SetAttributes(attribute.String("enduser.id", userID))
+		}
+		if err != nil {
+			span.RecordError(err)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		return err
+	}
+}
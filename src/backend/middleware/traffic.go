@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// TrafficCount is the number of requests of an hour sharing their endpoint,
+// table, status class such as 2xx and principal. The principal is the table
+// and id of the user, admin:<id> for the admins, or anonymous
+type TrafficCount struct {
+	Hour        time.Time
+	Endpoint    string
+	Table       string
+	StatusClass string
+	Principal   string
+	Requests    int64
+	DurationMs  float64
+}
+
+type trafficKey struct {
+	hour        time.Time
+	endpoint    string
+	table       string
+	statusClass string
+	principal   string
+}
+
+var traffic struct {
+	mu     sync.Mutex
+	counts map[trafficKey]*TrafficCount
+}
+
+// Traffic counts the requests of the API by endpoint, table, status class
+// and principal until DrainTraffic takes the counts
+func Traffic(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !strings.HasPrefix(c.Request().URL.Path, "/api/") {
+			return next(c)
+		}
+
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			status = httpErr.Code
+		} else if err != nil {
+			status = http.StatusInternalServerError
+		}
+
+		endpoint := c.Path()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+
+		key := trafficKey{
+			hour:        start.UTC().Truncate(time.Hour),
+			endpoint:    c.Request().Method + " " + endpoint,
+			table:       c.Param("table_name"),
+			statusClass: fmt.Sprintf("%dxx", status/100),
+			principal:   trafficPrincipal(c),
+		}
+		duration := float64(time.Since(start).Microseconds()) / 1000
+
+		traffic.mu.Lock()
+		if traffic.counts == nil {
+			traffic.counts = map[trafficKey]*TrafficCount{}
+		}
+		count, ok := traffic.counts[key]
+		if !ok {
+			count = &TrafficCount{
+				Hour:        key.hour,
+				Endpoint:    key.endpoint,
+				Table:       key.table,
+				StatusClass: key.statusClass,
+				Principal:   key.principal,
+			}
+			traffic.counts[key] = count
+		}
+		count.Requests++
+		count.DurationMs += duration
+		traffic.mu.Unlock()
+
+		return err
+	}
+}
+
+func trafficPrincipal(c echo.Context) string {
+	userID, _ := c.Get("user_id").(string)
+	if userID == "" {
+		return "anonymous"
+	}
+	if table, _ := c.Get("user_table").(string); table != "" {
+		return table + ":" + userID
+	}
+	if isAdminRequest(c) {
+		return "admin:" + userID
+	}
+
+	return userID
+}
+
+// DrainTraffic returns the counts since the last call and resets them
+func DrainTraffic() []TrafficCount {
+	traffic.mu.Lock()
+	counts := traffic.counts
+	traffic.counts = nil
+	traffic.mu.Unlock()
+
+	result := make([]TrafficCount, 0, len(counts))
+	for _, count := range counts {
+		result = append(result, *count)
+	}
+
+	return result
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Admin struct {
@@ -12,29 +13,456 @@ type Admin struct {
 	Username  string    `json:"username"`
 	Password  string    `json:"-"`
 	Salt      string    `json:"-"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	// TOTPSecret is encrypted with the server secret, RecoveryCodes holds
+	// comma separated hashes of the unused recovery codes
+	TOTPSecret    string `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabled   bool   `json:"totp_enabled" gorm:"column:totp_enabled"`
+	RecoveryCodes string `json:"-" gorm:"column:recovery_codes"`
 }
 
+const (
+	ADMIN_ROLE_OWNER     = "owner"
+	ADMIN_ROLE_EDITOR    = "editor"
+	ADMIN_ROLE_READ_ONLY = "read_only"
+)
+
 type Tables struct {
 	Name     string `json:"name" gorm:"primaryKey"`
 	IsAuth   bool   `json:"is_auth" gorm:"column:is_auth"`
 	IsSystem bool   `json:"is_system" gorm:"column:is_system"`
+	IsView   bool   `json:"is_view" gorm:"column:is_view"`
+
+	// OwnerColumn holds the column referencing the user owning a row,
+	// auth tables are owned through their id when empty
+	OwnerColumn string `json:"owner_column" gorm:"column:owner_column"`
+
+	// AllowTOTP lets the users of an auth table enroll 2FA
+	AllowTOTP bool `json:"allow_totp" gorm:"column:allow_totp"`
+
+	// AllowMagicLink lets the users of an auth table log in with an emailed link
+	AllowMagicLink bool `json:"allow_magic_link" gorm:"column:allow_magic_link"`
+
+	// ProtectFiles protects every file column of the table, see ColumnMeta
+	ProtectFiles bool `json:"protect_files" gorm:"column:protect_files"`
+
+	// StorageQuotaMB limits the size of the files held by the rows, no limit
+	// when 0
+	StorageQuotaMB int `json:"storage_quota_mb" gorm:"column:storage_quota_mb"`
+
+	// CacheTTLSeconds caches the reads of the rows for that long, for
+	// lookup tables rarely written. No caching when 0
+	CacheTTLSeconds int `json:"cache_ttl_seconds" gorm:"column:cache_ttl_seconds"`
+
+	// IDType is how the ids of the rows are made, see the ID_TYPE constants.
+	// The tables created before it are string
+	IDType string `json:"id_type" gorm:"column:id_type"`
+
+	// IDPrefix starts the ids made for the rows, such as usr_ or ord_, so
+	// they tell their table in the logs and the URLs
+	IDPrefix string `json:"id_prefix" gorm:"column:id_prefix"`
+}
+
+// the id types of the tables. nanoid can be given a length, as nanoid(12)
+const (
+	// ID_TYPE_STRING ids are 16 random letters and digits
+	ID_TYPE_STRING = "string"
+	// ID_TYPE_MANUAL ids are sent by the clients, random when they aren't
+	ID_TYPE_MANUAL        = "manual"
+	ID_TYPE_UUIDV7        = "uuidv7"
+	ID_TYPE_ULID          = "ulid"
+	ID_TYPE_AUTOINCREMENT = "autoincrement"
+	ID_TYPE_NANOID        = "nanoid"
+)
+
+const (
+	ACCESS_PUBLIC = ""
+	ACCESS_ADMIN  = "admin"
+	ACCESS_OWNER  = "owner"
+)
+
+type ColumnMeta struct {
+	Table  string `json:"table" gorm:"primaryKey"`
+	Column string `json:"column" gorm:"primaryKey"`
+	Access string `json:"access"`
+
+	// Protected files of a file column are only served through signed URLs,
+	// issued to the callers who can read the column of their row
+	Protected bool `json:"protected"`
+
+	// AllowedMimeTypes restricts the files of a file column to the comma
+	// separated types, such as image/* or application/pdf
+	AllowedMimeTypes string `json:"allowed_mime_types" gorm:"column:allowed_mime_types"`
+	// MaxFileSize limits the size in bytes of the files of a file column
+	MaxFileSize int64 `json:"max_file_size"`
+	// MaxFiles above 1 lets a file column hold up to that many files, stored
+	// as a JSON array of keys
+	MaxFiles int `json:"max_files"`
+
+	// Anonymize is how the column is exported by anonymized exports, one of
+	// the ANONYMIZE_ strategies. It is exported as is when empty
+	Anonymize string `json:"anonymize"`
+
+	// Required, Format, Pattern, Min, Max and MaxLength validate the values
+	// written to the column by inserts and updates. Format is one of the
+	// FORMAT_ formats, Min and Max bound numbers and MaxLength counts
+	// characters
+	Required  bool     `json:"required"`
+	Format    string   `json:"format"`
+	Pattern   string   `json:"pattern"`
+	Min       *float64 `json:"min"`
+	Max       *float64 `json:"max"`
+	MaxLength int      `json:"max_length"`
+
+	// Searchable columns are the ones searched by the q of the reads, every
+	// text column is when none is
+	Searchable bool `json:"searchable"`
 }
 
+const (
+	FORMAT_EMAIL = "email"
+	FORMAT_URL   = "url"
+)
+
+const (
+	// ANONYMIZE_HASH replaces the values with a keyed hash, equal values
+	// keep equal hashes so the rows can still be joined
+	ANONYMIZE_HASH = "hash"
+	// ANONYMIZE_MASK keeps the first character and the punctuation of the
+	// values, along with the domain of emails
+	ANONYMIZE_MASK = "mask"
+	ANONYMIZE_DROP = "drop"
+)
+
 type QueryHistory struct {
 	ID        uint      `json:"id" gorm:"primaryKey"`
 	Query     string    `json:"query"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+const (
+	ACTIVITY_QUERY             = "query"
+	ACTIVITY_CREATE_TABLE      = "create_table"
+	ACTIVITY_CREATE_VIEW       = "create_view"
+	ACTIVITY_DUPLICATE_TABLE   = "duplicate_table"
+	ACTIVITY_RENAME_TABLE      = "rename_table"
+	ACTIVITY_DELETE_TABLE      = "delete_table"
+	ACTIVITY_UPDATE_COLUMN     = "update_column"
+	ACTIVITY_UPDATE_TABLE      = "update_table"
+	ACTIVITY_IMPORT_SCHEMA     = "import_schema"
+	ACTIVITY_IMPORT_DUMP       = "import_dump"
+	ACTIVITY_APPLY_SCHEMA_DIFF = "apply_schema_diff"
+	ACTIVITY_CREATE_FUNCTION   = "create_function"
+	ACTIVITY_DELETE_FUNCTION   = "delete_function"
+	ACTIVITY_CREATE_TRIGGER    = "create_trigger"
+	ACTIVITY_UPDATE_TRIGGER    = "update_trigger"
+	ACTIVITY_DELETE_TRIGGER    = "delete_trigger"
+	ACTIVITY_UPDATE_SETTINGS   = "update_settings"
+	ACTIVITY_IMPERSONATE       = "impersonate"
+	ACTIVITY_ADD_SIGNING_KEY   = "add_signing_key"
+	ACTIVITY_RETIRE_KEY        = "retire_signing_key"
+	ACTIVITY_BACKUP            = "backup"
+	ACTIVITY_RESTORE_BACKUP    = "restore_backup"
+	ACTIVITY_DELETE_BACKUP     = "delete_backup"
+	ACTIVITY_CREATE_CRON_JOB   = "create_cron_job"
+	ACTIVITY_UPDATE_CRON_JOB   = "update_cron_job"
+	ACTIVITY_DELETE_CRON_JOB   = "delete_cron_job"
+	ACTIVITY_RUN_CRON_JOB      = "run_cron_job"
+	ACTIVITY_CLEAR_SLOW_QUERY  = "clear_slow_queries"
+	ACTIVITY_EXPORT_USER_DATA  = "export_user_data"
+	ACTIVITY_FORGET_USER       = "forget_user"
+	ACTIVITY_MAINTENANCE       = "maintenance"
+)
+
+// AdminActivity records a change made by an admin from the dashboard
+type AdminActivity struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	AdminID   string    `json:"admin_id" gorm:"index"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MagicLinkToken is a single use login token sent by email, only its hash
+// is stored
+type MagicLinkToken struct {
+	TokenHash string    `json:"-" gorm:"primaryKey"`
+	Table     string    `json:"table"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttempt counts the consecutive failed logins of an account or an IP,
+// it is kept in the cache rather than the database
+type LoginAttempt struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Session is issued along with every token, its ID is the jti of the token.
+// Deleting a session revokes the token
+type Session struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	Table      string    `json:"table" gorm:"index:idx_session_user"`
+	UserID     string    `json:"user_id" gorm:"index:idx_session_user"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+
+	// ImpersonatedBy holds the admin who issued the session acting as the user
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
+}
+
+// UserToken is a long lived API token acting as the user who created it,
+// only its hash is stored
+type UserToken struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	Table      string     `json:"table" gorm:"index:idx_user_token_user"`
+	UserID     string     `json:"user_id" gorm:"index:idx_user_token_user"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// SigningKey is a secret tokens are signed with, referenced by the kid
+// header of the token. The secret is stored encrypted, retired keys no
+// longer verify tokens
+type SigningKey struct {
+	ID        string     `json:"id" gorm:"primaryKey"`
+	Secret    string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at"`
+}
+
+const (
+	CRON_ACTION_BACKUP     = "backup"
+	CRON_ACTION_FUNCTION   = "function"
+	CRON_ACTION_VACUUM     = "vacuum"
+	CRON_ACTION_ANALYZE    = "analyze"
+	CRON_ACTION_CHECKPOINT = "checkpoint"
+	CRON_ACTION_PURGE      = "purge"
+
+	// the backup_schedule setting is run as a job of this name
+	CRON_JOB_BACKUP_SCHEDULE = "backup_schedule"
+)
+
+// CronJob is an action run on a cron schedule. Function jobs run the stored
+// function named Target with the JSON Input, purge jobs delete the rows of
+// the table Target created more than RetentionDays ago.
+//
+// The schedule runs in Timezone, the server's when empty. A run is skipped
+// while the previous one is still going unless AllowOverlap
+// is set. Runs are delayed by up to JitterSeconds and cancelled after
+// MaxRuntimeSeconds, when set
+type CronJob struct {
+	Name              string    `json:"name" gorm:"primaryKey"`
+	Schedule          string    `json:"schedule"`
+	Timezone          string    `json:"timezone"`
+	Action            string    `json:"action"`
+	Target            string    `json:"target"`
+	Input             string    `json:"input"`
+	RetentionDays     int       `json:"retention_days"`
+	Enabled           bool      `json:"enabled"`
+	AllowOverlap      bool      `json:"allow_overlap"`
+	JitterSeconds     int       `json:"jitter_seconds"`
+	MaxRuntimeSeconds int       `json:"max_runtime_seconds"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// File is a file kept by the storage service under Key. Table, RowID and
+// Column tell which file field of which row holds it, they are empty until
+// the file is saved in a row
+type File struct {
+	Key        string    `json:"key" gorm:"primaryKey"`
+	Name       string    `json:"name" gorm:"index"`
+	Size       int64     `json:"size"`
+	MimeType   string    `json:"mime_type"`
+	Hash       string    `json:"hash" gorm:"index"`
+	Table      string    `json:"table" gorm:"index:idx_file_row"`
+	RowID      string    `json:"row_id" gorm:"index:idx_file_row"`
+	Column     string    `json:"column"`
+	UploadedBy string    `json:"uploaded_by" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// ScanStatus is empty when no scanner was set, ScanResult holds the
+	// threat found or why the scan failed
+	ScanStatus string `json:"scan_status" gorm:"index"`
+	ScanResult string `json:"scan_result"`
+}
+
+const (
+	FILE_SCAN_PENDING  = "pending"
+	FILE_SCAN_CLEAN    = "clean"
+	FILE_SCAN_INFECTED = "infected"
+	FILE_SCAN_FAILED   = "failed"
+)
+
+// TableName is prefixed so users can still name a table file
+func (File) TableName() string {
+	return "_file"
+}
+
+// SlowQuery is a statement which ran longer than the slow query threshold,
+// Endpoint is the route of the request which ran it when it is known
+type SlowQuery struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	Statement  string    `json:"statement"`
+	DurationMs float64   `json:"duration_ms"`
+	Endpoint   string    `json:"endpoint" gorm:"index"`
+	RequestID  string    `json:"request_id" gorm:"index"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (SlowQuery) TableName() string {
+	return "_slow_query"
+}
+
+// Traffic is the hourly rollup of the requests to the API sharing their
+// endpoint, table, status class and principal
+type Traffic struct {
+	Hour        time.Time `json:"hour" gorm:"primaryKey"`
+	Endpoint    string    `json:"endpoint" gorm:"primaryKey"`
+	Table       string    `json:"table" gorm:"primaryKey"`
+	StatusClass string    `json:"status_class" gorm:"primaryKey"`
+	Principal   string    `json:"principal" gorm:"primaryKey"`
+	Requests    int64     `json:"requests"`
+	DurationMs  float64   `json:"duration_ms"`
+}
+
+func (Traffic) TableName() string {
+	return "_traffic"
+}
+
+// IndexSuggestion is an index on Columns of Table which slow reads of the
+// rows filtering on them would use, they scanned the whole table instead.
+// Columns are comma separated and sorted
+type IndexSuggestion struct {
+	Table         string    `json:"table" gorm:"primaryKey"`
+	Columns       string    `json:"columns" gorm:"primaryKey"`
+	Occurrences   int64     `json:"occurrences"`
+	MaxDurationMs float64   `json:"max_duration_ms"`
+	FirstSeenAt   time.Time `json:"first_seen_at"`
+	LastSeenAt    time.Time `json:"last_seen_at"`
+}
+
+func (IndexSuggestion) TableName() string {
+	return "_index_suggestion"
+}
+
+const (
+	WEBHOOK_DELIVERY_PENDING = "pending"
+	WEBHOOK_DELIVERY_FAILED  = "failed"
+
+	// the delivered deliveries aren't kept, this is only reported by the
+	// manual redeliveries
+	WEBHOOK_DELIVERY_DELIVERED = "delivered"
+)
+
+// WebhookDelivery is a payload waiting to be posted to URL. A delivery is
+// retried until it succeeds, when it is deleted, or until it runs out of
+// attempts and is kept as failed so it can be redelivered by hand
+type WebhookDelivery struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	URL           string    `json:"url"`
+	Secret        string    `json:"-"`
+	Event         string    `json:"event"`
+	Payload       string    `json:"payload"`
+	Status        string    `json:"status" gorm:"index"`
+	Attempts      int       `json:"attempts"`
+	LastStatus    int       `json:"last_status"`
+	LastError     string    `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at" gorm:"index"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+const (
+	CRON_RUN_RUNNING = "running"
+	CRON_RUN_SUCCESS = "success"
+	CRON_RUN_FAILED  = "failed"
+	CRON_RUN_SKIPPED = "skipped"
+)
+
+// CronRun is a single execution of a cron job
+type CronRun struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Job        string     `json:"job" gorm:"index"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error"`
+	Manual     bool       `json:"manual"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	DurationMs int64      `json:"duration_ms"`
+}
+
 type FunctionStored struct {
 	Name     string `json:"name" gorm:"primaryKey"`
 	Function string `json:"function" gorm:"column:function"`
+
+	// comma separated roles allowed to run the function, anyone can run it
+	// when empty
+	AllowedRoles string `json:"allowed_roles" gorm:"column:allowed_roles"`
+}
+
+const (
+	TRIGGER_INSERT = "insert"
+	TRIGGER_UPDATE = "update"
+	TRIGGER_DELETE = "delete"
+)
+
+// FunctionTrigger runs the stored function Function after a row of Table is
+// inserted, updated or deleted through the API, as set by Event. Every step
+// of the function receives the row as its input
+type FunctionTrigger struct {
+	Name      string    `json:"name" gorm:"primaryKey"`
+	Table     string    `json:"table" gorm:"index"`
+	Event     string    `json:"event"`
+	Function  string    `json:"function"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PluginMigration records a migration of a plugin which already ran
+type PluginMigration struct {
+	Plugin    string    `json:"plugin" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"primaryKey"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
+type Role struct {
+	Name        string `json:"name" gorm:"primaryKey"`
+	Description string `json:"description"`
+}
+
+type UserRole struct {
+	Table  string `json:"table" gorm:"primaryKey"`
+	UserID string `json:"user_id" gorm:"primaryKey"`
+	Role   string `json:"role" gorm:"primaryKey"`
 }
 
 func Migrate(db *gorm.DB) error {
-	err := db.AutoMigrate(&Admin{}, &Tables{}, &QueryHistory{}, &FunctionStored{})
+	err := db.AutoMigrate(&Admin{}, &Tables{}, &QueryHistory{}, &FunctionStored{}, &ColumnMeta{}, &Role{}, &UserRole{}, &AdminActivity{}, &MagicLinkToken{}, &Session{}, &UserToken{}, &SigningKey{}, &CronJob{}, &CronRun{}, &File{}, &WebhookDelivery{}, &FunctionTrigger{}, &PluginMigration{}, &SlowQuery{}, &Traffic{}, &IndexSuggestion{})
+	if err != nil {
+		return err
+	}
+
+	// admins created before roles existed keep full access
+	err = db.Model(&Admin{}).
+		Where("role IS NULL OR role = ?", "").
+		Update("role", ADMIN_ROLE_OWNER).Error
 	if err != nil {
 		return err
 	}
@@ -42,8 +470,24 @@ func Migrate(db *gorm.DB) error {
 	databases := []Tables{
 		{Name: "admin", IsAuth: true, IsSystem: true},
 		{Name: "query_history", IsAuth: false, IsSystem: true},
+		{Name: "admin_activity", IsAuth: false, IsSystem: true},
+		{Name: "magic_link_token", IsAuth: false, IsSystem: true},
+		{Name: "session", IsAuth: false, IsSystem: true},
+		{Name: "user_token", IsAuth: false, IsSystem: true},
+		{Name: "signing_key", IsAuth: false, IsSystem: true},
+		{Name: "cron_job", IsAuth: false, IsSystem: true},
+		{Name: "cron_run", IsAuth: false, IsSystem: true},
+		{Name: "_file", IsAuth: false, IsSystem: true},
+		{Name: "webhook_delivery", IsAuth: false, IsSystem: true},
+		{Name: "function_trigger", IsAuth: false, IsSystem: true},
+		{Name: "plugin_migration", IsAuth: false, IsSystem: true},
+		{Name: "_slow_query", IsAuth: false, IsSystem: true},
+		{Name: "_traffic", IsAuth: false, IsSystem: true},
+		{Name: "_index_suggestion", IsAuth: false, IsSystem: true},
 	}
-	err = db.Model(&Tables{}).Create(databases).Error
+	err = db.Model(&Tables{}).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(databases).Error
 	if err != nil {
 		return err
 	}
@@ -54,11 +498,17 @@ func Migrate(db *gorm.DB) error {
 // OTHERS MODELS
 
 type Column struct {
-	CID       int    `json:"cid"`
-	Default   string `json:"dflt_value"`
+	CID       int    `json:"cid" gorm:"column:cid"`
+	Default   string `json:"dflt_value" gorm:"column:dflt_value"`
 	Name      string `json:"name"`
-	NotNull   bool   `json:"notnull"`
+	NotNull   bool   `json:"notnull" gorm:"column:notnull"`
 	PK        int    `json:"pk"`
 	Type      string `json:"type"`
+	Generated bool   `json:"generated"`
 	Reference string `json:"reference,omitempty"`
+	// Collation is nocase for the text columns compared regardless of case,
+	// left out for the binary ones
+	Collation string `json:"collation,omitempty" gorm:"-"`
+	Access    string `json:"access,omitempty" gorm:"-"`
+	Anonymize string `json:"anonymize,omitempty" gorm:"-"`
 }
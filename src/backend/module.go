@@ -1,25 +1,170 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"net"
 	"os"
 	"react-golang/src/backend/api"
+	"react-golang/src/backend/config"
 	"react-golang/src/backend/constants"
 	"react-golang/src/backend/middleware"
+	pkg_cache "react-golang/src/backend/pkg/cache"
+	pkg_mailer "react-golang/src/backend/pkg/mailer"
 	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	pkg_tracing "react-golang/src/backend/pkg/tracing"
+	"react-golang/src/backend/plugins"
+	"react-golang/src/backend/service"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/sarulabs/di"
+	"google.golang.org/grpc"
+	"gorm.io/gorm"
 )
 
 type Module struct {
+	plugins *plugins.Registry
+	db      *gorm.DB
+	read    *gorm.DB
+	batch   *Batch
+	grpc    *grpc.Server
+	// tracing flushes the spans left, when they are exported
+	tracing func(context.Context) error
 }
 
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	defaultReadPoolSize    = 4
+)
+
 func (m *Module) New(app *echo.Echo) {
+	registry, err := plugins.Setup()
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.plugins = registry
+
+	if settings := config.GetInstance(); settings.OTLPEndpoint != "" {
+		shutdown, err := pkg_tracing.Setup(context.Background(), pkg_tracing.TracingOption{
+			Endpoint:      settings.OTLPEndpoint,
+			Headers:       settings.OTLPHeaders,
+			SamplePercent: settings.TraceSamplePercent,
+			ServiceName:   settings.AppName,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.tracing = shutdown
+	}
+
 	ioc := m.IOC(app)
 
 	middleware.UseMiddleware(app)
+	db := ioc.Get(constants.CONTAINER_DB_NAME).(*gorm.DB)
+	cache, err := ioc.SafeGet(constants.CONTAINER_CACHE_NAME)
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.Cache = cache.(pkg_cache.Cache)
+	if err := m.plugins.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := api.LoadSigningKeys(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := api.LoadTriggers(db); err != nil {
+		log.Fatal(err)
+	}
+	middleware.SessionValidator = api.NewSessionValidator(db)
+	middleware.APITokenAuthenticator = api.NewAPITokenAuthenticator(db)
+
+	backup := ioc.Get(constants.CONTAINER_BACKUP_NAME).(service.BackupService)
+	storage := ioc.Get(constants.CONTAINER_STORAGE_NAME).(service.StorageService)
+	mailer := ioc.Get(constants.CONTAINER_MAILER_NAME).(pkg_mailer.Mailer)
+	webhook := ioc.Get(constants.CONTAINER_WEBHOOK_NAME).(service.WebhookService)
+	batch := NewBatch(db, backup, storage, mailer, webhook)
+	api.ReloadCronJobs = batch.Reload
+	api.StartCronJob = batch.Run
+
 	api := ioc.Get(constants.CONTAINER_API_NAME).(*api.API)
 	api.Serve()
+	m.plugins.Serve(api.Router(), ioc)
+
+	if port := config.GetInstance().GRPCPort; port > 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			log.Fatal(err)
+		}
+		m.grpc = api.NewGRPCServer()
+		go func() {
+			if err := m.grpc.Serve(listener); err != nil {
+				log.Printf("gRPC server stopped: %v\n", err)
+			}
+		}()
+		log.Printf("gRPC server started on :%d\n", port)
+	}
+
+	for _, job := range m.plugins.Jobs() {
+		if err := batch.AddPluginJob(job, ioc); err != nil {
+			log.Fatal(err)
+		}
+	}
+	batch.Start()
+
+	m.db = db
+	m.read = ioc.Get(constants.CONTAINER_READ_DB_NAME).(*gorm.DB)
+	m.batch = batch
+}
+
+// Shutdown stops the cron jobs, tells the realtime clients to reconnect,
+// lets the requests and gRPC calls in progress finish within the shutdown
+// timeout, then checkpoints the WAL so the database file is complete on its own
+func (m *Module) Shutdown(app *echo.Echo) {
+	timeout := defaultShutdownTimeout
+	if seconds := config.GetInstance().ShutdownTimeoutSeconds; seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	m.batch.Stop(ctx)
+	api.Shutdown(ctx)
+	if m.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			m.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			m.grpc.Stop()
+		}
+	}
+	if err := app.Shutdown(ctx); err != nil {
+		log.Printf("requests still in progress at shutdown: %v\n", err)
+	}
+	if err := api.FlushTraffic(m.db); err != nil {
+		log.Printf("failed to save the traffic stats: %v\n", err)
+	}
+
+	// the readers would keep the WAL from being truncated
+	if m.read != m.db {
+		if read, err := m.read.DB(); err == nil {
+			read.Close()
+		}
+	}
+	if err := pkg_sqlite.Close(m.db); err != nil {
+		log.Printf("failed to checkpoint the database: %v\n", err)
+	}
+	if m.tracing != nil {
+		if err := m.tracing(ctx); err != nil {
+			log.Printf("failed to export the last spans: %v\n", err)
+		}
+	}
 }
 
 func (m *Module) IOC(app *echo.Echo) di.Container {
@@ -34,12 +179,171 @@ func (m *Module) IOC(app *echo.Echo) di.Container {
 		di.Def{
 			Name: constants.CONTAINER_DB_NAME,
 			Build: func(ctn di.Container) (interface{}, error) {
+				settings := config.GetInstance()
 				db, err := pkg_sqlite.NewSQLiteClient(os.Getenv("DB_PATH"), pkg_sqlite.SQLiteOption{
 					Migrate: true,
+					Pragmas: pkg_sqlite.Pragmas{
+						JournalMode:        settings.SQLiteJournalMode,
+						Synchronous:        settings.SQLiteSynchronous,
+						BusyTimeout:        settings.SQLiteBusyTimeout,
+						CacheSizeKB:        settings.SQLiteCacheSizeKB,
+						DisableForeignKeys: settings.SQLiteDisableForeignKeys,
+					},
+					Pool: dbPool(settings),
+				})
+				if err != nil {
+					return db, err
+				}
+				if pkg_tracing.Enabled() {
+					if err := db.Use(pkg_tracing.GormPlugin{}); err != nil {
+						return db, err
+					}
+				}
+				if err := db.Use(&pkg_sqlite.SlowQueryLog{Writer: db, Threshold: slowQueryThreshold}); err != nil {
+					return db, err
+				}
+				if err := db.Use(&pkg_sqlite.IndexUsage{}); err != nil {
+					return db, err
+				}
+
+				// the pool is resized as soon as its settings change
+				config.OnChange(func(c *config.Config, keys []string) {
+					for _, key := range keys {
+						if strings.HasPrefix(key, "db_") {
+							if err := pkg_sqlite.ApplyPool(db, dbPool(c)); err != nil {
+								log.Printf("failed to resize the connection pool: %v\n", err)
+							}
+							return
+						}
+					}
+				})
+
+				return db, nil
+			},
+		},
+		di.Def{
+			Name: constants.CONTAINER_READ_DB_NAME,
+			Build: func(ctn di.Container) (interface{}, error) {
+				// the writer creates the database and switches it to WAL first
+				db := ctn.Get(constants.CONTAINER_DB_NAME).(*gorm.DB)
+
+				settings := config.GetInstance()
+				journalMode := strings.ToUpper(settings.SQLiteJournalMode)
+				if settings.DBDisableReadPool || (journalMode != "" && journalMode != "WAL") {
+					return db, nil
+				}
+
+				read, err := pkg_sqlite.NewSQLiteClient(os.Getenv("DB_PATH"), pkg_sqlite.SQLiteOption{
+					ReadOnly: true,
+					Pragmas: pkg_sqlite.Pragmas{
+						BusyTimeout: settings.SQLiteBusyTimeout,
+						CacheSizeKB: settings.SQLiteCacheSizeKB,
+					},
+					Pool: readPool(settings),
+				})
+				if err != nil {
+					return read, err
+				}
+				if pkg_tracing.Enabled() {
+					if err := read.Use(pkg_tracing.GormPlugin{}); err != nil {
+						return read, err
+					}
+				}
+				if err := read.Use(&pkg_sqlite.SlowQueryLog{Writer: db, Threshold: slowQueryThreshold}); err != nil {
+					return read, err
+				}
+				if err := read.Use(&pkg_sqlite.IndexUsage{}); err != nil {
+					return read, err
+				}
+
+				config.OnChange(func(c *config.Config, keys []string) {
+					for _, key := range keys {
+						if strings.HasPrefix(key, "db_") {
+							if err := pkg_sqlite.ApplyPool(read, readPool(c)); err != nil {
+								log.Printf("failed to resize the read pool: %v\n", err)
+							}
+							return
+						}
+					}
+				})
+
+				return read, nil
+			},
+		},
+		di.Def{
+			Name: constants.CONTAINER_BACKUP_NAME,
+			Build: func(ctn di.Container) (interface{}, error) {
+				db := ctn.Get(constants.CONTAINER_DB_NAME).(*gorm.DB)
+				return service.NewBackupService(db), nil
+			},
+		},
+		di.Def{
+			Name: constants.CONTAINER_CACHE_NAME,
+			Build: func(ctn di.Container) (interface{}, error) {
+				settings := config.GetInstance()
+				return pkg_cache.NewCache(pkg_cache.CacheOption{
+					Backend:  settings.CacheBackend,
+					RedisURL: settings.CacheRedisURL,
+					Prefix:   settings.CachePrefix,
 				})
-				return db, err
+			},
+		},
+		di.Def{
+			Name: constants.CONTAINER_STORAGE_NAME,
+			Build: func(ctn di.Container) (interface{}, error) {
+				db := ctn.Get(constants.CONTAINER_DB_NAME).(*gorm.DB)
+				return service.NewStorageService(db), nil
+			},
+		},
+		di.Def{
+			Name: constants.CONTAINER_WEBHOOK_NAME,
+			Build: func(ctn di.Container) (interface{}, error) {
+				db := ctn.Get(constants.CONTAINER_DB_NAME).(*gorm.DB)
+				return service.NewWebhookService(db), nil
+			},
+		},
+		di.Def{
+			Name: constants.CONTAINER_MAILER_NAME,
+			Build: func(ctn di.Container) (interface{}, error) {
+				return pkg_mailer.NewMailer(), nil
 			},
 		},
 	)
+	if m.plugins != nil {
+		if err := builder.Add(m.plugins.Services()...); err != nil {
+			log.Fatal(err)
+		}
+	}
 	return builder.Build()
 }
+
+// readPool keeps as many idle connections as it may open, its size doesn't
+// fall back to the DB_* environment variables of the writer
+func readPool(config *config.Config) pkg_sqlite.Pool {
+	size := config.DBReadMaxOpenConnection
+	if size == 0 {
+		size = defaultReadPoolSize
+	}
+
+	return pkg_sqlite.Pool{
+		MaxOpenConnection: size,
+		MaxIdleConnection: size,
+		MaxLifetime:       config.DBMaxLifetime,
+		MaxIdleTime:       config.DBMaxIdleTime,
+	}
+}
+
+func dbPool(config *config.Config) pkg_sqlite.Pool {
+	return pkg_sqlite.Pool{
+		MaxOpenConnection: config.DBMaxOpenConnection,
+		MaxIdleConnection: config.DBMaxIdleConnection,
+		MaxLifetime:       config.DBMaxLifetime,
+		MaxIdleTime:       config.DBMaxIdleTime,
+	}
+}
+
+// slowQueryThreshold follows the setting, so the slow query log can be
+// turned on without a restart
+func slowQueryThreshold() time.Duration {
+	return time.Duration(config.GetInstance().SlowQueryThresholdMs) * time.Millisecond
+}
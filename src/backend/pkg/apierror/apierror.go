@@ -0,0 +1,151 @@
+// Package pkg_apierror writes the errors of the APIs in a single envelope:
+//
+//	{"code":"unique_violation","message":"...","fields":{"email":"must be unique"},"request_id":"..."}
+//
+// The code is the snake case of the text of the status unless the failure
+// has its own, the rows not found and the constraint failures of SQLite are
+// given their own codes and statuses
+package pkg_apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+const (
+	CODE_NOT_FOUND              = "not_found"
+	CODE_UNIQUE_VIOLATION       = "unique_violation"
+	CODE_FOREIGN_KEY_VIOLATION  = "foreign_key_violation"
+	CODE_NOT_NULL_VIOLATION     = "not_null_violation"
+	CODE_CHECK_VIOLATION        = "check_violation"
+	CODE_VALIDATION_FAILED      = "validation_failed"
+	CODE_INTEGRITY_CHECK_FAILED = "integrity_check_failed"
+)
+
+// Response is the body of every failed request. Fields holds why the values
+// of a row were rejected by column, Details what some errors carry along
+// such as the time a locked account opens again
+type Response struct {
+	Code      string                 `json:"code"`
+	Message   string                 `json:"message"`
+	Fields    map[string]string      `json:"fields,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// Code returns the code of a status, the snake case of its text such as
+// bad_request or too_many_requests
+func Code(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+
+	return strings.ReplaceAll(strings.ToLower(strings.NewReplacer("-", " ", "'", "").Replace(text)), " ", "_")
+}
+
+// Classify returns the status, the code and the columns of the failures
+// which have their own code, ok is false for the others
+func Classify(err error) (status int, code string, fields map[string]string, ok bool) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusNotFound, CODE_NOT_FOUND, nil, true
+	}
+
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code != sqlite3.ErrConstraint {
+		return 0, "", nil, false
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return http.StatusConflict, CODE_UNIQUE_VIOLATION, constraintFields(sqliteErr, "must be unique"), true
+	case sqlite3.ErrConstraintForeignKey:
+		return http.StatusConflict, CODE_FOREIGN_KEY_VIOLATION, nil, true
+	case sqlite3.ErrConstraintNotNull:
+		return http.StatusBadRequest, CODE_NOT_NULL_VIOLATION, constraintFields(sqliteErr, "is required"), true
+	case sqlite3.ErrConstraintCheck:
+		return http.StatusBadRequest, CODE_CHECK_VIOLATION, nil, true
+	}
+
+	return 0, "", nil, false
+}
+
+// constraintFields reads the columns of a constraint failure, SQLite names
+// them as "UNIQUE constraint failed: table.a, table.b"
+func constraintFields(err sqlite3.Error, message string) map[string]string {
+	_, columns, ok := strings.Cut(err.Error(), "constraint failed: ")
+	if !ok {
+		return nil
+	}
+
+	fields := map[string]string{}
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if dot := strings.LastIndex(column, "."); dot >= 0 {
+			column = column[dot+1:]
+		}
+		if column != "" {
+			fields[column] = message
+		}
+	}
+
+	return fields
+}
+
+// Write sends an error, its code is the code of the status when empty
+func Write(c echo.Context, status int, response Response) error {
+	if response.Code == "" {
+		response.Code = Code(status)
+	}
+	if response.RequestID == "" {
+		response.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	}
+
+	return c.JSON(status, response)
+}
+
+// Message sends an error with a message
+func Message(c echo.Context, status int, message string) error {
+	return Write(c, status, Response{Message: message})
+}
+
+// Error sends an error, the failures with their own code replace the status
+func Error(c echo.Context, status int, err error) error {
+	response := Response{Message: err.Error()}
+	if classified, code, fields, ok := Classify(err); ok {
+		status, response.Code, response.Fields = classified, code, fields
+	}
+
+	return Write(c, status, response)
+}
+
+// HTTPErrorHandler sends the errors returned by the handlers and by echo,
+// such as the routes not found, in the envelope
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr) && c.Request().Method == http.MethodHead:
+		err = c.NoContent(httpErr.Code)
+	case httpErr != nil:
+		if inner, ok := httpErr.Internal.(*echo.HTTPError); ok {
+			httpErr = inner
+		}
+		err = Message(c, httpErr.Code, fmt.Sprint(httpErr.Message))
+	case c.Request().Method == http.MethodHead:
+		err = c.NoContent(http.StatusInternalServerError)
+	default:
+		err = Error(c, http.StatusInternalServerError, err)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
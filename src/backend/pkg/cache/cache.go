@@ -0,0 +1,57 @@
+package pkg_cache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	BACKEND_MEMORY = "memory"
+	BACKEND_REDIS  = "redis"
+)
+
+// Cache stores values encoded as JSON so every backend behaves the same, the
+// values read are copies that can be changed freely. A ttl of zero uses the
+// default expiration of the cache
+type Cache interface {
+	// Get decodes the value of key into value, found is false when the key
+	// doesn't exist or expired
+	Get(key string, value interface{}) (found bool, err error)
+	Set(key string, value interface{}, ttl time.Duration) error
+	Delete(keys ...string) error
+	// DeletePrefix drops every key starting with prefix
+	DeletePrefix(prefix string) error
+}
+
+type CacheOption struct {
+	// Backend is memory or redis, memory when empty
+	Backend string
+	// RedisURL is a redis:// or rediss:// url such as
+	// redis://:password@localhost:6379/0
+	RedisURL string
+	// Prefix namespaces the keys, so several apps can share a redis server
+	Prefix string
+
+	DefaultExpiration time.Duration
+}
+
+// NewCache returns the cache of the backend. Instances behind a load
+// balancer share their cache when it is redis
+func NewCache(option CacheOption) (Cache, error) {
+	if option.DefaultExpiration <= 0 {
+		option.DefaultExpiration = 5 * time.Minute
+	}
+
+	switch option.Backend {
+	case "", BACKEND_MEMORY:
+		return NewMemoryCache(option.DefaultExpiration), nil
+	case BACKEND_REDIS:
+		if option.RedisURL == "" {
+			return nil, errors.New("the redis url is required")
+		}
+		return NewRedisCache(option.RedisURL, option.Prefix, option.DefaultExpiration)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %s", option.Backend)
+	}
+}
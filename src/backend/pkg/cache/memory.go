@@ -0,0 +1,61 @@
+package pkg_cache
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+type memoryCache struct {
+	cache *cache.Cache
+}
+
+// NewMemoryCache returns a cache local to the process
+func NewMemoryCache(defaultExpiration time.Duration) Cache {
+	return &memoryCache{
+		cache: cache.New(defaultExpiration, 2*defaultExpiration),
+	}
+}
+
+func (m *memoryCache) Get(key string, value interface{}) (bool, error) {
+	cached, found := m.cache.Get(key)
+	if !found {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(cached.([]byte), value)
+}
+
+func (m *memoryCache) Set(key string, value interface{}, ttl time.Duration) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		ttl = cache.DefaultExpiration
+	}
+	m.cache.Set(key, encoded, ttl)
+
+	return nil
+}
+
+func (m *memoryCache) Delete(keys ...string) error {
+	for _, key := range keys {
+		m.cache.Delete(key)
+	}
+
+	return nil
+}
+
+func (m *memoryCache) DeletePrefix(prefix string) error {
+	for key := range m.cache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			m.cache.Delete(key)
+		}
+	}
+
+	return nil
+}
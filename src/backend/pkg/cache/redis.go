@@ -0,0 +1,131 @@
+package pkg_cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisTimeout = 3 * time.Second
+
+type redisCache struct {
+	client            *redis.Client
+	prefix            string
+	defaultExpiration time.Duration
+}
+
+// NewRedisCache returns a cache stored in redis, the connection is checked
+// right away so a misconfigured server fails at startup
+func NewRedisCache(url string, prefix string, defaultExpiration time.Duration) (Cache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &redisCache{
+		client:            client,
+		prefix:            prefix,
+		defaultExpiration: defaultExpiration,
+	}, nil
+}
+
+func (r *redisCache) Get(key string, value interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	cached, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(cached, value)
+}
+
+func (r *redisCache) Set(key string, value interface{}, ttl time.Duration) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		ttl = r.defaultExpiration
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return r.client.Set(ctx, r.prefix+key, encoded, ttl).Err()
+}
+
+func (r *redisCache) Delete(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = r.prefix + key
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	return r.client.Del(ctx, prefixed...).Err()
+}
+
+// DeletePrefix scans for the keys since redis can't delete by pattern, they
+// are deleted in batches as they are found
+func (r *redisCache) DeletePrefix(prefix string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*redisTimeout)
+	defer cancel()
+
+	iter := r.client.Scan(ctx, 0, escapePattern(r.prefix+prefix)+"*", 500).Iterator()
+	keys := []string{}
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == 500 {
+			if err := r.client.Del(ctx, keys...).Err(); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return r.client.Del(ctx, keys...).Err()
+	}
+
+	return nil
+}
+
+// escapePattern escapes the glob characters of a SCAN pattern
+func escapePattern(pattern string) string {
+	var escaped strings.Builder
+	for _, char := range pattern {
+		switch char {
+		case '*', '?', '[', ']', '\\':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(char)
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,273 @@
+// Package pkg_errorreport forwards the server errors to the hooks registered
+// by the plugins and to the endpoint of a DSN, either a Sentry project or a
+// webhook receiving the reports as JSON
+package pkg_errorreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the reports waiting to be sent, the ones reported while it is full are
+// dropped so a burst of errors doesn't pile up goroutines
+const queueSize = 100
+
+// Request is the request which failed, the headers carrying credentials are
+// left out
+type Request struct {
+	Method   string            `json:"method"`
+	URL      string            `json:"url"`
+	Route    string            `json:"route,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	ClientIP string            `json:"client_ip,omitempty"`
+}
+
+// Report is an error of a request, Stacktrace is set when it comes from a
+// panic
+type Report struct {
+	EventID    string    `json:"event_id"`
+	Time       time.Time `json:"time"`
+	Message    string    `json:"message"`
+	Type       string    `json:"type"`
+	Panic      bool      `json:"panic"`
+	Stacktrace string    `json:"stacktrace,omitempty"`
+	Status     int       `json:"status"`
+	Request    Request   `json:"request"`
+	UserID     string    `json:"user_id,omitempty"`
+	ServerName string    `json:"server_name,omitempty"`
+}
+
+// Hook receives every report in the goroutine sending them, a slow hook
+// delays the next reports
+type Hook func(report Report)
+
+var hooks struct {
+	mu    sync.RWMutex
+	hooks []Hook
+}
+
+// AddHook registers a hook receiving the reports, whether a DSN is set or not
+func AddHook(hook Hook) {
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+
+	hooks.hooks = append(hooks.hooks, hook)
+}
+
+type delivery struct {
+	report Report
+	dsn    string
+}
+
+var (
+	queue     = make(chan delivery, queueSize)
+	startOnce sync.Once
+	client    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Capture queues the report for the hooks and the endpoint of dsn, which is
+// skipped when empty
+func Capture(report Report, dsn string) {
+	hooks.mu.RLock()
+	hooked := len(hooks.hooks) > 0
+	hooks.mu.RUnlock()
+	if dsn == "" && !hooked {
+		return
+	}
+
+	if report.EventID == "" {
+		report.EventID = newEventID()
+	}
+	if report.Time.IsZero() {
+		report.Time = time.Now().UTC()
+	}
+
+	startOnce.Do(func() { go run() })
+	select {
+	case queue <- delivery{report: report, dsn: dsn}:
+	default:
+		log.Printf("error report %s dropped, too many reports are waiting\n", report.EventID)
+	}
+}
+
+func run() {
+	for d := range queue {
+		hooks.mu.RLock()
+		registered := append([]Hook{}, hooks.hooks...)
+		hooks.mu.RUnlock()
+		for _, hook := range registered {
+			callHook(hook, d.report)
+		}
+
+		if d.dsn == "" {
+			continue
+		}
+		if err := send(d.dsn, d.report); err != nil {
+			log.Printf("failed to send error report %s: %v\n", d.report.EventID, err)
+		}
+	}
+}
+
+// callHook keeps a panicking hook from stopping the reports
+func callHook(hook Hook, report Report) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("error report hook panicked: %v\n", r)
+		}
+	}()
+
+	hook(report)
+}
+
+// sentryProject returns the project of a Sentry DSN, the last segment of
+// its path
+func sentryProject(u *url.URL) string {
+	path := strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[i+1:]
+	}
+
+	return path
+}
+
+// send posts the report to a Sentry project when the DSN has a public key,
+// as in https://key@sentry.example.com/42, or as JSON to the DSN otherwise
+func send(dsn string, report Report) error {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if u.User != nil {
+		req, err = sentryRequest(u, report)
+	} else {
+		var body []byte
+		if body, err = json.Marshal(report); err == nil {
+			req, err = http.NewRequest(http.MethodPost, dsn, bytes.NewReader(body))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("endpoint responded %s", res.Status)
+	}
+
+	return nil
+}
+
+// sentryRequest builds an envelope holding the report as a Sentry event
+func sentryRequest(u *url.URL, report Report) (*http.Request, error) {
+	project := sentryProject(u)
+	base := strings.TrimSuffix(strings.TrimRight(u.Path, "/"), project)
+	endpoint := url.URL{Scheme: u.Scheme, Host: u.Host, Path: base + "api/" + project + "/envelope/"}
+
+	event, err := json.Marshal(sentryEvent(report))
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(map[string]interface{}{
+		"event_id": report.EventID,
+		"sent_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	body.Write(header)
+	fmt.Fprintf(&body, "\n{\"type\":\"event\",\"length\":%d}\n", len(event))
+	body.Write(event)
+	body.WriteString("\n")
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	auth := "Sentry sentry_version=7, sentry_client=fullbase/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	req.Header.Set("X-Sentry-Auth", auth)
+
+	return req, nil
+}
+
+func sentryEvent(report Report) map[string]interface{} {
+	mechanism := map[string]interface{}{
+		"type":    "echo",
+		"handled": !report.Panic,
+	}
+	if report.Stacktrace != "" {
+		// the stack is sent as is, the stacktrace interface of Sentry needs
+		// the frames parsed
+		mechanism["data"] = map[string]string{"stack": report.Stacktrace}
+	}
+	exception := map[string]interface{}{
+		"type":      report.Type,
+		"value":     report.Message,
+		"mechanism": mechanism,
+	}
+
+	tags := map[string]string{
+		"status": fmt.Sprint(report.Status),
+		"route":  report.Request.Route,
+	}
+	if report.Request.ID != "" {
+		tags["request_id"] = report.Request.ID
+	}
+
+	event := map[string]interface{}{
+		"event_id":    report.EventID,
+		"timestamp":   report.Time.Format(time.RFC3339Nano),
+		"level":       "error",
+		"platform":    "go",
+		"logger":      "fullbase",
+		"server_name": report.ServerName,
+		"transaction": report.Request.Method + " " + report.Request.Route,
+		"exception":   map[string]interface{}{"values": []interface{}{exception}},
+		"request": map[string]interface{}{
+			"method":  report.Request.Method,
+			"url":     report.Request.URL,
+			"headers": report.Request.Headers,
+			"env":     map[string]string{"REMOTE_ADDR": report.Request.ClientIP},
+		},
+		"tags": tags,
+	}
+	if report.UserID != "" {
+		event["user"] = map[string]string{"id": report.UserID, "ip_address": report.Request.ClientIP}
+	}
+
+	return event
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
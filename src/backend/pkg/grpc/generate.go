@@ -0,0 +1,5 @@
+// Package pkg_grpc holds the protobuf services of the gRPC API, generated
+// from fullbase.proto
+package pkg_grpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative fullbase.proto
@@ -0,0 +1,56 @@
+package pkg_mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"react-golang/src/backend/config"
+	"strings"
+)
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+type SMTPMailer struct{}
+
+// NewMailer returns a mailer sending through the SMTP server of the settings. The
+// settings are read on every send so they can be changed at runtime
+func NewMailer() Mailer {
+	return &SMTPMailer{}
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	settings := config.GetInstance()
+	if settings.SMTPHost == "" {
+		return errors.New("smtp is not configured")
+	}
+
+	port := settings.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := fmt.Sprintf("%s:%d", settings.SMTPHost, port)
+
+	sender := settings.SMTPSender
+	if sender == "" {
+		sender = settings.SMTPUsername
+	}
+
+	var auth smtp.Auth
+	if settings.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", settings.SMTPUsername, settings.SMTPPassword, settings.SMTPHost)
+	}
+
+	message := strings.Join([]string{
+		fmt.Sprintf("From: %s <%s>", settings.AppName, sender),
+		fmt.Sprintf("To: %s", to),
+		fmt.Sprintf("Subject: %s", subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+
+	return smtp.SendMail(addr, auth, sender, []string{to}, []byte(message))
+}
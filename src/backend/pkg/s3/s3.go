@@ -0,0 +1,323 @@
+package pkg_s3
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+)
+
+// Client is a minimal S3 client signing its requests with AWS signature v4.
+// It works with S3 compatible storages such as MinIO
+type Client struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// which most self hosted storages need
+	PathStyle bool
+
+	HTTPClient *http.Client
+}
+
+type Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type listResult struct {
+	Contents              []Object `xml:"Contents"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken"`
+}
+
+type errorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// statusError is the error of a request the storage refused
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// IsNotFound tells whether err is the error of a request for an object
+// which doesn't exist
+func IsNotFound(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound
+}
+
+// Put uploads size bytes of body to key, the payload is streamed unsigned
+func (c *Client) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	req, err := c.newRequest(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+
+	res, err := c.do(req, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// Get returns the content of key, the caller must close it
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// GetRange returns the content of key from offset on, the caller must close it
+func (c *Client) GetRange(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+
+	res, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// Head returns the size and modification time of key
+func (c *Client) Head(ctx context.Context, key string) (Object, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return Object{}, err
+	}
+
+	res, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		return Object{}, err
+	}
+	res.Body.Close()
+
+	object := Object{Key: key, Size: res.ContentLength}
+	object.LastModified, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+
+	return object, nil
+}
+
+func (c *Client) Delete(ctx context.Context, key string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+// List returns every object whose key starts with prefix
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		req, err := c.newRequest(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := c.do(req, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", c.Endpoint)
+	}
+
+	path := "/" + key
+	if c.PathStyle {
+		path = "/" + c.Bucket + path
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+	}
+	u.Path = path
+	u.RawPath = uriEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	return http.NewRequestWithContext(ctx, method, u.String(), body)
+}
+
+func (c *Client) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	c.sign(req, payloadHash, time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+
+		var s3Err errorResponse
+		if err := xml.NewDecoder(res.Body).Decode(&s3Err); err == nil && s3Err.Code != "" {
+			return nil, &statusError{status: res.StatusCode, message: fmt.Sprintf("s3: %s: %s", s3Err.Code, s3Err.Message)}
+		}
+		return nil, &statusError{status: res.StatusCode, message: fmt.Sprintf("s3: %s", res.Status)}
+	}
+
+	return res, nil
+}
+
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	region := c.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters as required by
+// the signature, slashes are kept in paths
+func uriEncode(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, ch := range []byte(value) {
+		switch {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9',
+			ch == '-', ch == '_', ch == '.', ch == '~':
+			b.WriteByte(ch)
+		case ch == '/' && !encodeSlash:
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+
+	return b.String()
+}
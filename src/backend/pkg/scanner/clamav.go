@@ -0,0 +1,100 @@
+package pkg_scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunks larger than StreamMaxLength of clamd are rejected, 64 KB is well
+// below its default
+const clamavChunkSize = 64 << 10
+
+type ClamAVScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamAVScanner streams the content to clamd with its INSTREAM command
+func NewClamAVScanner(address string, timeout time.Duration) (Scanner, error) {
+	network, addr, ok := strings.Cut(address, ":")
+	if !ok || (network != "unix" && network != "tcp") || addr == "" {
+		return nil, fmt.Errorf("clamd address %s must be unix:/path or tcp:host:port", address)
+	}
+
+	return &ClamAVScanner{network: network, address: addr, timeout: timeout}, nil
+}
+
+func (s *ClamAVScanner) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return Result{}, err
+	}
+
+	// the content is sent in chunks prefixed by their length, a chunk of
+	// length 0 ends the stream
+	buf := make([]byte, clamavChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := content.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return Result{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return Result{}, err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return parseClamAVReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamAVReply reads replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND"
+func parseClamAVReply(reply string) (Result, error) {
+	verdict := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+
+	switch {
+	case verdict == "OK":
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Threat: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", verdict)
+	}
+}
@@ -0,0 +1,55 @@
+package pkg_scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type HTTPScanner struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPScanner posts the content to an external scanner which answers
+// with a JSON body such as {"clean": false, "threat": "Eicar-Signature"}
+func NewHTTPScanner(url string, timeout time.Duration) Scanner {
+	return &HTTPScanner{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *HTTPScanner) Scan(ctx context.Context, content io.Reader) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, content)
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return Result{}, fmt.Errorf("scanner responded with status %d", res.StatusCode)
+	}
+
+	var body struct {
+		Clean  *bool  `json:"clean"`
+		Threat string `json:"threat"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return Result{}, err
+	}
+	if body.Clean == nil {
+		return Result{}, fmt.Errorf("scanner response has no verdict")
+	}
+
+	return Result{Clean: *body.Clean, Threat: body.Threat}, nil
+}
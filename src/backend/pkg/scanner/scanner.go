@@ -0,0 +1,63 @@
+package pkg_scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	BACKEND_CLAMAV = "clamav"
+	BACKEND_HTTP   = "http"
+)
+
+// Result is the verdict of a scan, Threat names what was found when the
+// content isn't clean
+type Result struct {
+	Clean  bool
+	Threat string
+}
+
+// Scanner checks content for malware. An error means the content couldn't
+// be scanned, not that it is infected
+type Scanner interface {
+	Scan(ctx context.Context, content io.Reader) (Result, error)
+}
+
+type ScannerOption struct {
+	// Backend is clamav or http, scanning is disabled when empty
+	Backend string
+	// Address of clamd, as unix:/path/to/clamd.sock or tcp:host:port
+	Address string
+	// URL the content is posted to by the http backend
+	URL string
+
+	Timeout time.Duration
+}
+
+// NewScanner returns the scanner of the backend, or nil when scanning is
+// disabled
+func NewScanner(option ScannerOption) (Scanner, error) {
+	if option.Timeout <= 0 {
+		option.Timeout = 5 * time.Minute
+	}
+
+	switch option.Backend {
+	case "":
+		return nil, nil
+	case BACKEND_CLAMAV:
+		if option.Address == "" {
+			return nil, errors.New("the clamd address is required")
+		}
+		return NewClamAVScanner(option.Address, option.Timeout)
+	case BACKEND_HTTP:
+		if option.URL == "" {
+			return nil, errors.New("the scanner url is required")
+		}
+		return NewHTTPScanner(option.URL, option.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown scanner backend %s", option.Backend)
+	}
+}
@@ -0,0 +1,96 @@
+package pkg_sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// Backup writes a consistent copy of the database to path while it keeps
+// serving requests
+func Backup(db *gorm.DB, path string) error {
+	return db.Exec("VACUUM INTO ?", path).Error
+}
+
+// Restore replaces the content of the database with the database at path.
+// The pages are copied through the SQLite backup API so open connections see
+// the restored data right away
+func Restore(db *gorm.DB, path string) error {
+	return withConns(db, path, true, func(live *sqlite3.SQLiteConn, file *sqlite3.SQLiteConn) error {
+		return copyDatabase(live, file)
+	})
+}
+
+// Snapshot copies the database page by page to path. Unlike Backup the pages
+// keep their layout, so successive snapshots can be diffed
+func Snapshot(db *gorm.DB, path string) error {
+	return withConns(db, path, false, func(live *sqlite3.SQLiteConn, file *sqlite3.SQLiteConn) error {
+		return copyDatabase(file, live)
+	})
+}
+
+// withConns runs fn with a raw connection to the live database and one to
+// the database file at path
+func withConns(db *gorm.DB, path string, readOnly bool, fn func(live *sqlite3.SQLiteConn, file *sqlite3.SQLiteConn) error) error {
+	ctx := context.Background()
+
+	dsn := fmt.Sprintf("file:%s", path)
+	if readOnly {
+		dsn += "?mode=ro"
+	}
+	fileDB, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		return err
+	}
+	defer fileDB.Close()
+
+	fileConn, err := fileDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer fileConn.Close()
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	liveConn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer liveConn.Close()
+
+	return liveConn.Raw(func(liveDriver interface{}) error {
+		live, ok := liveDriver.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("database is not sqlite")
+		}
+
+		return fileConn.Raw(func(fileDriver interface{}) error {
+			file, ok := fileDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup is not a sqlite database")
+			}
+
+			return fn(live, file)
+		})
+	})
+}
+
+func copyDatabase(dest *sqlite3.SQLiteConn, src *sqlite3.SQLiteConn) error {
+	backup, err := dest.Backup("main", src, "main")
+	if err != nil {
+		return err
+	}
+
+	if _, err := backup.Step(-1); err != nil {
+		backup.Finish()
+		return err
+	}
+
+	return backup.Finish()
+}
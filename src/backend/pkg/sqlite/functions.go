@@ -0,0 +1,47 @@
+package pkg_sqlite
+
+import (
+	"database/sql"
+	"math"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName is the sqlite3 driver with fullbase's custom SQL functions registered
+const DriverName = "sqlite3_fullbase"
+
+const earthRadiusMeter = 6371000.0
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("haversine", haversine, true)
+		},
+	})
+}
+
+// haversine returns the great-circle distance in meters between two points,
+// or NULL when any of the coordinates is not a number
+func haversine(lat1, lng1, lat2, lng2 interface{}) interface{} {
+	coords := []float64{}
+	for _, v := range []interface{}{lat1, lng1, lat2, lng2} {
+		switch n := v.(type) {
+		case int64:
+			coords = append(coords, float64(n))
+		case float64:
+			coords = append(coords, n)
+		default:
+			return nil
+		}
+	}
+
+	phi1 := coords[0] * math.Pi / 180
+	phi2 := coords[2] * math.Pi / 180
+	dPhi := (coords[2] - coords[0]) * math.Pi / 180
+	dLambda := (coords[3] - coords[1]) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+
+	return earthRadiusMeter * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,84 @@
+package pkg_sqlite
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// indexUsageSampleEvery is how many reads run for every read whose query
+// plan is sampled
+const indexUsageSampleEvery = 20
+
+var (
+	planIndexPattern = regexp.MustCompile(`USING (?:COVERING )?INDEX (\S+)`)
+
+	indexUsageMu sync.RWMutex
+	indexUsage   = map[string]time.Time{}
+	// indexUsageSince is when the sampling started, an index unused since
+	// then may never be used
+	indexUsageSince = time.Now().UTC()
+)
+
+// IndexLastUsed returns when an index was last seen in a sampled query plan
+func IndexLastUsed(name string) (time.Time, bool) {
+	indexUsageMu.RLock()
+	defer indexUsageMu.RUnlock()
+
+	used, ok := indexUsage[name]
+	return used, ok
+}
+
+// IndexUsageSince returns when the sampling of the query plans started
+func IndexUsageSince() time.Time {
+	return indexUsageSince
+}
+
+// IndexUsage is a gorm plugin sampling the query plans of the reads, to tell
+// which indexes are used. The plans are explained on the connection of the
+// read, which works within transactions holding the only connection
+type IndexUsage struct {
+	reads atomic.Int64
+}
+
+func (u *IndexUsage) Name() string {
+	return "index_usage"
+}
+
+func (u *IndexUsage) Initialize(db *gorm.DB) error {
+	return db.Callback().Query().After("gorm:query").Register("index_usage:after_query", u.sample)
+}
+
+func (u *IndexUsage) sample(tx *gorm.DB) {
+	if tx.Error != nil || u.reads.Add(1)%indexUsageSampleEvery != 0 {
+		return
+	}
+	statement := tx.Statement.SQL.String()
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(statement)), "SELECT") {
+		return
+	}
+
+	rows, err := tx.Statement.ConnPool.QueryContext(tx.Statement.Context, "EXPLAIN QUERY PLAN "+statement, tx.Statement.Vars...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	for rows.Next() {
+		var id, parent, unused int64
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return
+		}
+		if match := planIndexPattern.FindStringSubmatch(detail); match != nil {
+			indexUsageMu.Lock()
+			indexUsage[match[1]] = now
+			indexUsageMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package pkg_sqlite
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ForeignKeyViolation is a row of Table whose foreign key FKID references a
+// row of Parent which doesn't exist
+type ForeignKeyViolation struct {
+	Table  string `json:"table"`
+	RowID  *int64 `json:"rowid" gorm:"column:rowid"`
+	Parent string `json:"parent"`
+	FKID   int64  `json:"fkid"`
+}
+
+// IntegrityReport holds the findings of the integrity check, the database is
+// sound when OK is set
+type IntegrityReport struct {
+	OK          bool                  `json:"ok"`
+	Errors      []string              `json:"errors"`
+	ForeignKeys []ForeignKeyViolation `json:"foreign_keys"`
+}
+
+// IntegrityError is the report of a database which failed the integrity check
+type IntegrityError struct {
+	Report IntegrityReport
+}
+
+func (e *IntegrityError) Error() string {
+	if len(e.Report.Errors) > 0 {
+		return "the database failed its integrity check: " + e.Report.Errors[0]
+	}
+
+	violation := e.Report.ForeignKeys[0]
+	return fmt.Sprintf("the database failed its integrity check: a row of %s references a missing row of %s", violation.Table, violation.Parent)
+}
+
+// CheckIntegrity looks for corrupted pages and indexes with integrity_check,
+// or the faster quick_check which skips the indexes, and for rows referencing
+// missing rows with foreign_key_check
+func CheckIntegrity(db *gorm.DB, quick bool) (IntegrityReport, error) {
+	report := IntegrityReport{Errors: []string{}, ForeignKeys: []ForeignKeyViolation{}}
+
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	var messages []string
+	if err := db.Raw(pragma).Scan(&messages).Error; err != nil {
+		return report, err
+	}
+	for _, message := range messages {
+		if message != "ok" {
+			report.Errors = append(report.Errors, message)
+		}
+	}
+
+	if err := db.Raw("PRAGMA foreign_key_check").Scan(&report.ForeignKeys).Error; err != nil {
+		return report, err
+	}
+	report.OK = len(report.Errors) == 0 && len(report.ForeignKeys) == 0
+
+	return report, nil
+}
+
+// Verify runs the integrity check and returns an IntegrityError when the
+// database fails it
+func Verify(db *gorm.DB) error {
+	report, err := CheckIntegrity(db, false)
+	if err != nil {
+		return err
+	}
+	if !report.OK {
+		return &IntegrityError{Report: report}
+	}
+
+	return nil
+}
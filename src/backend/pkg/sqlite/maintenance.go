@@ -0,0 +1,43 @@
+package pkg_sqlite
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrCheckpointBusy is returned when readers or a writer kept the checkpoint
+// from copying the whole WAL into the database file
+var ErrCheckpointBusy = errors.New("the checkpoint was blocked by open transactions, try again later")
+
+// Vacuum rebuilds the database file without its free pages, then checkpoints
+// the WAL the rebuilt pages went into so the file actually shrinks
+func Vacuum(db *gorm.DB) error {
+	if err := db.Exec("VACUUM").Error; err != nil {
+		return err
+	}
+
+	return Checkpoint(db)
+}
+
+// Analyze refreshes the statistics the query planner picks the indexes with
+func Analyze(db *gorm.DB) error {
+	return db.Exec("ANALYZE").Error
+}
+
+// Checkpoint copies the WAL into the database file and truncates it
+func Checkpoint(db *gorm.DB) error {
+	var result struct {
+		Busy         int64
+		Log          int64
+		Checkpointed int64
+	}
+	if err := db.Raw("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&result).Error; err != nil {
+		return err
+	}
+	if result.Busy != 0 {
+		return ErrCheckpointBusy
+	}
+
+	return nil
+}
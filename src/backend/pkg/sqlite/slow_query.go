@@ -0,0 +1,128 @@
+package pkg_sqlite
+
+import (
+	"context"
+	"errors"
+	"log"
+	"react-golang/src/backend/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	slowQueryStartKey = "slow_query:start"
+
+	// the slow queries kept, the oldest ones are deleted past it
+	slowQueryLimit = 1000
+	// the slow queries waiting to be recorded, the ones found while it is
+	// full are dropped
+	slowQueryQueueSize = 100
+)
+
+type endpointKey struct{}
+
+type requestIDKey struct{}
+
+// WithEndpoint tells the slow query log which endpoint runs the statements
+// of ctx, such as POST /api/main/:table_name/rows
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+// WithRequestID tells the slow query log which request runs the statements
+// of ctx
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// SlowQueryLog is a gorm plugin recording the statements running longer than
+// Threshold into the _slow_query table through Writer. The statements are
+// recorded with their placeholders, the values aren't. Their endpoint and
+// request ID are known when they run with the context of the request
+type SlowQueryLog struct {
+	Writer *gorm.DB
+	// Threshold is read for every statement, the log is off while it is zero
+	Threshold func() time.Duration
+
+	queue chan model.SlowQuery
+}
+
+func (l *SlowQueryLog) Name() string {
+	return "slow_query_log"
+}
+
+func (l *SlowQueryLog) Initialize(db *gorm.DB) error {
+	l.queue = make(chan model.SlowQuery, slowQueryQueueSize)
+	go l.record()
+
+	callback := db.Callback()
+
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("slow_query:before_create", l.before),
+		callback.Create().After("gorm:create").Register("slow_query:after_create", l.after),
+		callback.Query().Before("gorm:query").Register("slow_query:before_query", l.before),
+		callback.Query().After("gorm:query").Register("slow_query:after_query", l.after),
+		callback.Update().Before("gorm:update").Register("slow_query:before_update", l.before),
+		callback.Update().After("gorm:update").Register("slow_query:after_update", l.after),
+		callback.Delete().Before("gorm:delete").Register("slow_query:before_delete", l.before),
+		callback.Delete().After("gorm:delete").Register("slow_query:after_delete", l.after),
+		callback.Row().Before("gorm:row").Register("slow_query:before_row", l.before),
+		callback.Row().After("gorm:row").Register("slow_query:after_row", l.after),
+		callback.Raw().Before("gorm:raw").Register("slow_query:before_raw", l.before),
+		callback.Raw().After("gorm:raw").Register("slow_query:after_raw", l.after),
+	)
+}
+
+func (l *SlowQueryLog) before(tx *gorm.DB) {
+	if l.Threshold() > 0 {
+		tx.InstanceSet(slowQueryStartKey, time.Now())
+	}
+}
+
+func (l *SlowQueryLog) after(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(slowQueryStartKey)
+	if !ok {
+		return
+	}
+	duration := time.Since(value.(time.Time))
+	threshold := l.Threshold()
+	// recording a slow insert of the log would record another one
+	if threshold <= 0 || duration < threshold || tx.Statement.Table == (model.SlowQuery{}).TableName() {
+		return
+	}
+
+	query := model.SlowQuery{
+		Statement:  tx.Statement.SQL.String(),
+		DurationMs: float64(duration.Microseconds()) / 1000,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if ctx := tx.Statement.Context; ctx != nil {
+		query.Endpoint, _ = ctx.Value(endpointKey{}).(string)
+		query.RequestID, _ = ctx.Value(requestIDKey{}).(string)
+	}
+
+	select {
+	case l.queue <- query:
+	default:
+	}
+}
+
+func (l *SlowQueryLog) record() {
+	for query := range l.queue {
+		db := l.Writer.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+		if err := db.Create(&query).Error; err != nil {
+			log.Printf("failed to record a slow query: %v\n", err)
+			continue
+		}
+
+		err := db.Exec(`
+		DELETE FROM _slow_query
+		WHERE id <= (
+			SELECT id FROM _slow_query ORDER BY id DESC LIMIT 1 OFFSET ?
+		)`, slowQueryLimit).Error
+		if err != nil {
+			log.Printf("failed to trim the slow query log: %v\n", err)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package pkg_sqlite
 
 import (
 	"log"
+	"net/url"
 	"os"
 	"react-golang/src/backend/model"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"gorm.io/driver/sqlite"
@@ -16,6 +19,114 @@ import (
 type SQLiteOption struct {
 	DryRun  bool
 	Migrate bool
+	// ReadOnly opens connections which can only read, for a pool kept apart
+	// from the writer
+	ReadOnly bool
+	Pragmas  Pragmas
+	Pool     Pool
+}
+
+// Pool sizes the connection pool, the lifetimes are in minutes. A zero value
+// falls back to its DB_* environment variable, then to the default of
+// database/sql
+type Pool struct {
+	MaxOpenConnection int
+	MaxIdleConnection int
+	MaxLifetime       int
+	MaxIdleTime       int
+}
+
+// defaultMaxIdleConnection is the default of database/sql
+const defaultMaxIdleConnection = 2
+
+func envInt(key string) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+func orEnv(value int, key string) int {
+	if value != 0 {
+		return value
+	}
+
+	return envInt(key)
+}
+
+// the idle sizes set by ApplyPool, restored by DropIdleConnections
+var idleSizes sync.Map
+
+// ApplyPool resizes the connection pool of conn, it can be called again at
+// any time to change it
+func ApplyPool(conn *gorm.DB, pool Pool) error {
+	db, err := conn.DB()
+	if err != nil {
+		return err
+	}
+
+	maxIdleConnection := orEnv(pool.MaxIdleConnection, "DB_MAX_IDLE_CONNECTION")
+	if maxIdleConnection == 0 {
+		maxIdleConnection = defaultMaxIdleConnection
+	}
+
+	db.SetMaxOpenConns(orEnv(pool.MaxOpenConnection, "DB_MAX_OPEN_CONNECTION"))
+	db.SetMaxIdleConns(maxIdleConnection)
+	idleSizes.Store(db, maxIdleConnection)
+	db.SetConnMaxLifetime(time.Duration(orEnv(pool.MaxLifetime, "DB_MAX_LIFETIME")) * time.Minute)
+	db.SetConnMaxIdleTime(time.Duration(orEnv(pool.MaxIdleTime, "DB_MAX_IDLE_TIME")) * time.Minute)
+
+	return nil
+}
+
+// Pragmas are applied to every connection of the pool when it is opened,
+// the zero values keep the defaults: WAL, NORMAL synchronous and a busy
+// timeout of 5 seconds
+type Pragmas struct {
+	JournalMode        string
+	Synchronous        string
+	BusyTimeout        int
+	CacheSizeKB        int
+	DisableForeignKeys bool
+}
+
+// dsn adds the pragmas to the database path as parameters of the driver.
+// The journal mode of a read-only connection is left to the writer
+func (p Pragmas) dsn(dbPath string, readOnly bool) string {
+	journalMode := p.JournalMode
+	if journalMode == "" {
+		journalMode = "WAL"
+	}
+	synchronous := p.Synchronous
+	if synchronous == "" {
+		synchronous = "NORMAL"
+	}
+	busyTimeout := p.BusyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = 5000
+	}
+	foreignKeys := "1"
+	if p.DisableForeignKeys {
+		foreignKeys = "0"
+	}
+
+	params := url.Values{}
+	if readOnly {
+		params.Set("mode", "ro")
+		params.Set("_query_only", "1")
+	} else {
+		params.Set("_journal_mode", strings.ToUpper(journalMode))
+	}
+	params.Set("_synchronous", strings.ToUpper(synchronous))
+	params.Set("_busy_timeout", strconv.Itoa(busyTimeout))
+	params.Set("_foreign_keys", foreignKeys)
+	if p.CacheSizeKB > 0 {
+		params.Set("_cache_size", strconv.Itoa(-p.CacheSizeKB))
+	}
+
+	return "file:" + dbPath + "?" + params.Encode()
 }
 
 func NewSQLiteClient(dbPath string, options ...SQLiteOption) (*gorm.DB, error) {
@@ -32,7 +143,7 @@ func NewSQLiteClient(dbPath string, options ...SQLiteOption) (*gorm.DB, error) {
 		option = options[0]
 	}
 
-	conn, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{
+	conn, err = gorm.Open(sqlite.Dialector{DriverName: DriverName, DSN: option.Pragmas.dsn(dbPath, option.ReadOnly)}, &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
 		NamingStrategy: schema.NamingStrategy{
 			SingularTable: true,
@@ -45,39 +156,52 @@ func NewSQLiteClient(dbPath string, options ...SQLiteOption) (*gorm.DB, error) {
 		return conn, err
 	}
 
-	db, err := conn.DB()
-	if err != nil {
+	if err := ApplyPool(conn, option.Pool); err != nil {
 		return conn, err
 	}
 
-	if _, found := os.LookupEnv("DB_MAX_OPEN_CONNECTION"); found {
-		if maxOpenConnection, err := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNECTION")); err == nil {
-			db.SetMaxOpenConns(maxOpenConnection)
-		}
+	if option.Migrate {
+		model.Migrate(conn)
+	}
+
+	if option.ReadOnly {
+		log.Printf("Opened the read pool of database: %s\n", os.Getenv("DB_PATH"))
+		return conn, nil
 	}
+	log.Printf("Connected to database: %s\n", os.Getenv("DB_PATH"))
+	return conn, nil
+}
 
-	if _, found := os.LookupEnv("DB_MAX_IDLE_CONNECTION"); found {
-		if maxIdleConnection, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNECTION")); err == nil {
-			db.SetMaxIdleConns(maxIdleConnection)
-		}
+// DropIdleConnections closes the idle connections of conn, the connections
+// in use are kept
+func DropIdleConnections(conn *gorm.DB) error {
+	db, err := conn.DB()
+	if err != nil {
+		return err
 	}
 
-	if _, found := os.LookupEnv("DB_MAX_LIFETIME"); found {
-		if maxLifetime, err := strconv.Atoi(os.Getenv("DB_MAX_LIFETIME")); err == nil {
-			db.SetConnMaxLifetime(time.Duration(maxLifetime) * time.Minute)
-		}
+	maxIdleConnection := defaultMaxIdleConnection
+	if size, ok := idleSizes.Load(db); ok {
+		maxIdleConnection = size.(int)
 	}
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(maxIdleConnection)
 
-	if _, found := os.LookupEnv("DB_MAX_IDLE_TIME"); found {
-		if maxIdleTime, err := strconv.Atoi(os.Getenv("DB_MAX_IDLE_TIME")); err == nil {
-			db.SetConnMaxIdleTime(time.Duration(maxIdleTime) * time.Minute)
-		}
+	return nil
+}
+
+// Close checkpoints the WAL into the database file, so the file is complete
+// without it, then closes the connections
+func Close(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
 	}
 
-	if option.Migrate {
-		model.Migrate(conn)
+	if err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error; err != nil {
+		sqlDB.Close()
+		return err
 	}
 
-	log.Printf("Connected to database: %s\n", os.Getenv("DB_PATH"))
-	return conn, nil
+	return sqlDB.Close()
 }
@@ -0,0 +1,42 @@
+package pkg_sqlite
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// ReadOnly prepares the first statement of query and tells whether SQLite
+// considers it read-only, like a SELECT. Nothing is run
+func ReadOnly(db *gorm.DB, query string) (bool, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return false, err
+	}
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	readOnly := false
+	err = conn.Raw(func(driverConn interface{}) error {
+		sqliteConn, ok := driverConn.(*sqlite3.SQLiteConn)
+		if !ok {
+			return errors.New("database is not sqlite")
+		}
+
+		stmt, err := sqliteConn.Prepare(query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		readOnly = stmt.(*sqlite3.SQLiteStmt).Readonly()
+
+		return nil
+	})
+
+	return readOnly, err
+}
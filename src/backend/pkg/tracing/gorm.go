@@ -0,0 +1,76 @@
+package pkg_tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GormPlugin adds a span for every statement run with the context of a
+// traced operation, such as db.WithContext(c.Request().Context()). The
+// statement is recorded with its placeholders, the values aren't
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string {
+	return "tracing"
+}
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("tracing:before_create", beforeStatement("create")),
+		callback.Create().After("gorm:create").Register("tracing:after_create", afterStatement),
+		callback.Query().Before("gorm:query").Register("tracing:before_query", beforeStatement("query")),
+		callback.Query().After("gorm:query").Register("tracing:after_query", afterStatement),
+		callback.Update().Before("gorm:update").Register("tracing:before_update", beforeStatement("update")),
+		callback.Update().After("gorm:update").Register("tracing:after_update", afterStatement),
+		callback.Delete().Before("gorm:delete").Register("tracing:before_delete", beforeStatement("delete")),
+		callback.Delete().After("gorm:delete").Register("tracing:after_delete", afterStatement),
+		callback.Row().Before("gorm:row").Register("tracing:before_row", beforeStatement("row")),
+		callback.Row().After("gorm:row").Register("tracing:after_row", afterStatement),
+		callback.Raw().Before("gorm:raw").Register("tracing:before_raw", beforeStatement("raw")),
+		callback.Raw().After("gorm:raw").Register("tracing:after_raw", afterStatement),
+	)
+}
+
+func beforeStatement(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx := tx.Statement.Context
+		// the statements of untraced work, such as the cron jobs, would each
+		// start a trace of their own
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+
+		_, span := Start(ctx, "sql."+operation, trace.WithSpanKind(trace.SpanKindClient))
+		tx.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func afterStatement(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	span.SetAttributes(
+		attribute.String("db.system", "sqlite"),
+		attribute.String("db.statement", tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	if tx.Statement.Table != "" {
+		span.SetAttributes(attribute.String("db.sql.table", tx.Statement.Table))
+	}
+
+	err := tx.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
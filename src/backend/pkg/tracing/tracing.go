@@ -0,0 +1,108 @@
+package pkg_tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "react-golang/src/backend"
+
+type TracingOption struct {
+	// Endpoint is the URL of the OTLP/HTTP collector, /v1/traces is added
+	// when it has no path
+	Endpoint string
+	// Headers are sent with the spans, formatted as name=value
+	Headers []string
+	// SamplePercent of the traces are kept, all of them when zero
+	SamplePercent int
+	ServiceName   string
+}
+
+var enabled atomic.Bool
+
+// Setup exports the spans to the collector, the returned function flushes
+// the spans left and stops the exporter
+func Setup(ctx context.Context, option TracingOption) (func(context.Context) error, error) {
+	endpoint, err := url.Parse(option.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %s", option.Endpoint)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = "/v1/traces"
+	}
+
+	headers, err := ParseHeaders(option.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(endpoint.String()),
+		otlptracehttp.WithHeaders(headers),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if option.SamplePercent > 0 && option.SamplePercent < 100 {
+		sampler = sdktrace.TraceIDRatioBased(float64(option.SamplePercent) / 100)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", option.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	enabled.Store(true)
+
+	return provider.Shutdown, nil
+}
+
+// ParseHeaders reads headers formatted as name=value
+func ParseHeaders(lines []string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, errors.New("headers must be formatted as name=value")
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return headers, nil
+}
+
+// Enabled tells whether the spans are exported
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Start starts a span, a child of the span of ctx when there is one
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, options...)
+}
+
+// End ends the span, recording err when it isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,8 @@
+package main
+
+// The compiled-in plugins, each one is enabled by importing its package for
+// the plugins.Register call of its init function, such as
+//
+//	import _ "example.com/fullbase-audit"
+//
+// See the plugins package for the interface a plugin implements.
@@ -0,0 +1,220 @@
+// Package plugins extends the server with routes, services, migrations and
+// cron jobs without changing its code.
+//
+// A plugin is a Go package implementing Plugin which registers itself from
+// its init function:
+//
+//	package audit
+//
+//	type Plugin struct{}
+//
+//	func init() {
+//		plugins.Register(Plugin{})
+//	}
+//
+//	func (Plugin) Name() string { return "audit" }
+//
+//	func (Plugin) Setup(r *plugins.Registry) error {
+//		r.Service(di.Def{Name: "audit", Build: newAuditService})
+//		r.Migration("create_audit_log", createAuditLog)
+//		r.Routes(func(router *echo.Group, ioc di.Container) {
+//			router.GET("/audit", listAudit(ioc), middleware.RequireAuth(true))
+//		})
+//		r.Job("purge_audit_log", "@daily", purgeAuditLog)
+//		return nil
+//	}
+//
+// It is compiled in by importing the package for its side effects in
+// plugins.go of the main package.
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"react-golang/src/backend/model"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sarulabs/di"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Plugin is the init interface of the plugins. Setup is called once at
+// startup, before the container is built, and registers what the plugin adds
+// to the server. An error stops the server from starting
+type Plugin interface {
+	// Name identifies the plugin, its migrations are recorded under it
+	Name() string
+	Setup(r *Registry) error
+}
+
+// Migration changes the database once, Up runs in a transaction and the
+// migration is recorded when it succeeds
+type Migration struct {
+	Name string
+	Up   func(tx *gorm.DB) error
+}
+
+// Job runs on a cron schedule, in the standard format or a descriptor such
+// as @hourly. A failed run is logged
+type Job struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context, ioc di.Container) error
+}
+
+// Registry collects what the plugins register during their setup
+type Registry struct {
+	plugin string
+
+	services   []di.Def
+	migrations map[string][]Migration
+	routes     []func(router *echo.Group, ioc di.Container)
+	jobs       []Job
+}
+
+var registered struct {
+	mu      sync.Mutex
+	plugins []Plugin
+}
+
+// Register adds a compiled-in plugin, it panics when a plugin of the same
+// name is already registered
+func Register(plugin Plugin) {
+	registered.mu.Lock()
+	defer registered.mu.Unlock()
+
+	for _, p := range registered.plugins {
+		if p.Name() == plugin.Name() {
+			panic(fmt.Sprintf("plugins: plugin %s registered twice", plugin.Name()))
+		}
+	}
+	registered.plugins = append(registered.plugins, plugin)
+}
+
+// Registered returns the names of the registered plugins
+func Registered() []string {
+	registered.mu.Lock()
+	defer registered.mu.Unlock()
+
+	names := []string{}
+	for _, plugin := range registered.plugins {
+		names = append(names, plugin.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Setup sets up the registered plugins in the order of their names
+func Setup() (*Registry, error) {
+	registered.mu.Lock()
+	plugins := append([]Plugin{}, registered.plugins...)
+	registered.mu.Unlock()
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].Name() < plugins[j].Name() })
+
+	r := &Registry{migrations: map[string][]Migration{}}
+	for _, plugin := range plugins {
+		r.plugin = plugin.Name()
+		if err := plugin.Setup(r); err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", plugin.Name(), err)
+		}
+		log.Printf("plugin %s loaded\n", plugin.Name())
+	}
+	r.plugin = ""
+
+	return r, nil
+}
+
+// Service adds a service to the container, its name must not clash with the
+// services of the server
+func (r *Registry) Service(def di.Def) {
+	r.services = append(r.services, def)
+}
+
+// Migration adds a migration of the plugin, the migrations run in the order
+// they are added
+func (r *Registry) Migration(name string, up func(tx *gorm.DB) error) {
+	r.migrations[r.plugin] = append(r.migrations[r.plugin], Migration{Name: name, Up: up})
+}
+
+// Routes adds routes under /api, which checks the API key like the routes of
+// the server. The routes pick their own authentication
+func (r *Registry) Routes(register func(router *echo.Group, ioc di.Container)) {
+	r.routes = append(r.routes, register)
+}
+
+// Job schedules a job, its name is prefixed with the name of the plugin in
+// the logs
+func (r *Registry) Job(name string, schedule string, run func(ctx context.Context, ioc di.Container) error) {
+	r.jobs = append(r.jobs, Job{Name: r.plugin + "." + name, Schedule: schedule, Run: run})
+}
+
+func (r *Registry) Services() []di.Def {
+	return r.services
+}
+
+func (r *Registry) Jobs() []Job {
+	return r.jobs
+}
+
+// Migrate runs the migrations of the plugins which haven't run yet
+func (r *Registry) Migrate(db *gorm.DB) error {
+	plugins := make([]string, 0, len(r.migrations))
+	for plugin := range r.migrations {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+
+	for _, plugin := range plugins {
+		for _, migration := range r.migrations[plugin] {
+			if err := migrate(db, plugin, migration); err != nil {
+				return fmt.Errorf("migration %s of plugin %s: %w", migration.Name, plugin, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func migrate(db *gorm.DB, plugin string, migration Migration) error {
+	if migration.Name == "" || migration.Up == nil {
+		return errors.New("migration needs a name and a function")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&model.PluginMigration{}).
+			Where("plugin = ? AND name = ?", plugin, migration.Name).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if err := migration.Up(tx); err != nil {
+			return err
+		}
+		log.Printf("plugin %s migrated %s\n", plugin, migration.Name)
+
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.PluginMigration{
+			Plugin:    plugin,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+}
+
+// Serve registers the routes of the plugins
+func (r *Registry) Serve(router *echo.Group, ioc di.Container) {
+	for _, register := range r.routes {
+		register(router, ioc)
+	}
+}
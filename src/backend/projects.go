@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"react-golang/src/backend/config"
+	pkg_apierror "react-golang/src/backend/pkg/apierror"
+	"react-golang/src/backend/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A host serves several projects from the directories of a projects
+// directory, routed by their slug as /api/<slug>/..., the rows of the tables
+// of a project being under /api/<slug>/db/... Every project runs in a
+// server of its own, started by the host from its binary, with the database,
+// settings, storage, backups and signing secret kept in its directory. So no
+// table, admin, token, setting or event of a project is ever seen by another
+const (
+	projectDatabase = "fullbase.db"
+	projectSettings = "config.json"
+	projectSecret   = "jwt_secret"
+
+	// projectStartTimeout is how long a request waits for the server of its
+	// project to start
+	projectStartTimeout = 15 * time.Second
+	// projectStopTimeout is how long the servers of the projects are given to
+	// shut down with the host
+	projectStopTimeout = 30 * time.Second
+)
+
+var projectSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var ErrProjectNotFound = errors.New("project does not exist")
+
+// projectsDir returns the projects directory of the flag, PROJECTS_DIR or
+// ./projects
+func projectsDir(dir string) string {
+	if dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("PROJECTS_DIR"); dir != "" {
+		return dir
+	}
+
+	return "projects"
+}
+
+const projectsUsage = `usage:
+  fullbase projects create [--dir dir] <slug>         add a project, with its own database and settings
+  fullbase projects list [--dir dir]                  list the projects
+  fullbase projects serve [--dir dir]                 serve the projects as /api/<slug>/db/... and /api/<slug>/...
+  fullbase projects run [--dir dir] <slug> <command>  run a command, such as admin create, on a project`
+
+func projectsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(projectsUsage)
+	}
+
+	flags := flag.NewFlagSet("projects "+args[0], flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory of the projects, PROJECTS_DIR or ./projects by default")
+
+	switch args[0] {
+	case "create":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: fullbase projects create [--dir dir] <slug>")
+		}
+		return createProject(projectsDir(*dir), flags.Arg(0))
+	case "list":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		slugs, err := listProjects(projectsDir(*dir))
+		if err != nil {
+			return err
+		}
+		for _, slug := range slugs {
+			fmt.Println(slug)
+		}
+		return nil
+	case "serve":
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		return serveProjects(projectsDir(*dir))
+	case "run":
+		// the flags after the slug belong to the command
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if flags.NArg() < 2 {
+			return errors.New("usage: fullbase projects run [--dir dir] <slug> <command>")
+		}
+		return runProjectCommand(projectsDir(*dir), flags.Arg(0), flags.Args()[1:])
+	}
+
+	return errors.New(projectsUsage)
+}
+
+// projectDir returns the directory of an existing project
+func projectDir(dir string, slug string) (string, error) {
+	if !projectSlugPattern.MatchString(slug) {
+		return "", ErrProjectNotFound
+	}
+
+	path, err := filepath.Abs(filepath.Join(dir, slug))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(path, projectSecret)); err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrProjectNotFound
+		}
+		return "", err
+	}
+
+	return path, nil
+}
+
+// createProject creates the directory of a project with its signing secret
+// and its settings, which get an API key of their own
+func createProject(dir string, slug string) error {
+	if !projectSlugPattern.MatchString(slug) {
+		return errors.New("slug must be lowercase letters, digits and dashes, starting with a letter or a digit")
+	}
+
+	path := filepath.Join(dir, slug)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, 0o700); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("project %s already exists", slug)
+		}
+		return err
+	}
+
+	secret, err := utils.GenerateRandomString(48)
+	if err != nil {
+		return err
+	}
+	apiKey, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return err
+	}
+
+	settings := config.Default()
+	settings.AppName = slug
+	settings.APIKey = apiKey
+	if err := settings.SaveTo(filepath.Join(path, projectSettings)); err != nil {
+		return err
+	}
+	// the secret is written last, a directory without it isn't a project
+	if err := os.WriteFile(filepath.Join(path, projectSecret), []byte(secret), 0o600); err != nil {
+		return err
+	}
+
+	fmt.Printf("project %s created in %s, its API key is %s\n", slug, path, apiKey)
+
+	return nil
+}
+
+func listProjects(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slugs := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := projectDir(dir, entry.Name()); err == nil {
+			slugs = append(slugs, entry.Name())
+		}
+	}
+	sort.Strings(slugs)
+
+	return slugs, nil
+}
+
+// projectEnv returns the environment of the processes of a project. The
+// API key of the host isn't passed on, the projects only accept their own
+func projectEnv(path string, extra ...string) ([]string, error) {
+	secret, err := os.ReadFile(filepath.Join(path, projectSecret))
+	if err != nil {
+		return nil, err
+	}
+
+	overridden := map[string]bool{
+		"DB_PATH":          true,
+		"CONFIG_PATH":      true,
+		"JWT_SECRET_KEY":   true,
+		"MAIN_APP_API_KEY": true,
+		"PORT":             true,
+		"HOST":             true,
+		"LISTEN_FD":        true,
+		"PROJECTS_DIR":     true,
+	}
+	env := []string{}
+	for _, variable := range os.Environ() {
+		name, _, _ := strings.Cut(variable, "=")
+		if !overridden[name] {
+			env = append(env, variable)
+		}
+	}
+
+	return append(env, append([]string{
+		"DB_PATH=" + filepath.Join(path, projectDatabase),
+		"CONFIG_PATH=" + filepath.Join(path, projectSettings),
+		"JWT_SECRET_KEY=" + strings.TrimSpace(string(secret)),
+	}, extra...)...), nil
+}
+
+// projectCommand returns the command running the binary of the host for a
+// project. It runs in the directory of the project so the .env of the host
+// isn't read again
+func projectCommand(path string, args []string, extra ...string) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	env, err := projectEnv(path, extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = path
+	cmd.Env = env
+
+	return cmd, nil
+}
+
+func runProjectCommand(dir string, slug string, args []string) error {
+	path, err := projectDir(dir, slug)
+	if err != nil {
+		return fmt.Errorf("project %s: %w", slug, err)
+	}
+	if len(args) > 0 && (args[0] == "serve" || args[0] == "projects") {
+		return errors.New("the projects are served by fullbase projects serve")
+	}
+
+	cmd, err := projectCommand(path, args)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// projectServer is the server of a project, started by the host
+type projectServer struct {
+	slug   string
+	cmd    *exec.Cmd
+	target *url.URL
+	// ready is closed once the server accepts connections, exited once it
+	// stopped
+	ready  chan struct{}
+	exited chan struct{}
+}
+
+type projectHost struct {
+	dir string
+
+	mu       sync.Mutex
+	servers  map[string]*projectServer
+	stopping bool
+}
+
+// server returns the running server of a project, starting it when it
+// isn't. The projects created while the host runs are started on their
+// first request, and the servers which stopped are started again
+func (h *projectHost) server(ctx context.Context, slug string) (*projectServer, error) {
+	h.mu.Lock()
+	server, ok := h.servers[slug]
+	if !ok {
+		if h.stopping {
+			h.mu.Unlock()
+			return nil, errors.New("the host is shutting down")
+		}
+
+		path, err := projectDir(h.dir, slug)
+		if err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		server, err = h.start(slug, path)
+		if err != nil {
+			h.mu.Unlock()
+			return nil, err
+		}
+		h.servers[slug] = server
+	}
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, projectStartTimeout)
+	defer cancel()
+	select {
+	case <-server.ready:
+		return server, nil
+	case <-server.exited:
+		return nil, fmt.Errorf("the server of project %s stopped", slug)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("the server of project %s isn't ready", slug)
+	}
+}
+
+// start starts the server of a project on a free port of the loopback, it
+// must be called holding mu. The host binds the port and the server inherits
+// the listener, so no other process can take the port in between
+func (h *projectHost) start(slug string, path string) (*projectServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	// the file is a copy of the listener, which the server gets as its first
+	// extra file
+	file, err := listener.(*net.TCPListener).File()
+	listener.Close()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cmd, err := projectCommand(path, []string{"serve"}, "LISTEN_FD=3")
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = []*os.File{file}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go logProject(slug, stdout)
+	go logProject(slug, stderr)
+
+	server := &projectServer{
+		slug:   slug,
+		cmd:    cmd,
+		target: &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", port)},
+		ready:  make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	log.Printf("project %s started on port %d\n", slug, port)
+
+	go func() {
+		err := cmd.Wait()
+		log.Printf("project %s stopped: %v\n", slug, err)
+		close(server.exited)
+
+		h.mu.Lock()
+		if h.servers[slug] == server {
+			delete(h.servers, slug)
+		}
+		h.mu.Unlock()
+	}()
+	go server.waitReady()
+
+	return server, nil
+}
+
+// waitReady closes ready once the server answers. The port is bound before
+// the server starts, so a connection is accepted before the server serves
+func (s *projectServer) waitReady() {
+	client := &http.Client{Timeout: time.Second}
+	for {
+		resp, err := client.Get(s.target.String())
+		if err == nil {
+			resp.Body.Close()
+			close(s.ready)
+			return
+		}
+
+		select {
+		case <-s.exited:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// inheritedListener returns the listener passed by the host of the projects
+// as the file descriptor LISTEN_FD, nil when the server listens on its own
+func inheritedListener() (net.Listener, error) {
+	fd := os.Getenv("LISTEN_FD")
+	if fd == "" {
+		return nil, nil
+	}
+	number, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FD %s", fd)
+	}
+
+	file := os.NewFile(uintptr(number), "listener")
+	defer file.Close()
+
+	return net.FileListener(file)
+}
+
+// logProject writes the output of the server of a project to the log of the
+// host, prefixed by its slug
+func logProject(slug string, output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		log.Printf("[%s] %s\n", slug, scanner.Text())
+	}
+}
+
+// projectPath returns the path of the server of a project for the rest of
+// a path of the host following <prefix>/<slug>. The rows are under
+// /api/<slug>/db/... which the server serves as /api/main/..., the other
+// routes keep their path. It's false for the paths the host doesn't route
+func projectPath(prefix string, rest string) (string, bool) {
+	if prefix != "/api" {
+		return prefix + rest, true
+	}
+
+	switch {
+	case rest == "/main" || strings.HasPrefix(rest, "/main/"):
+		return "", false
+	case rest == "/db" || strings.HasPrefix(rest, "/db/"):
+		return prefix + "/main" + strings.TrimPrefix(rest, "/db"), true
+	}
+
+	return prefix + rest, true
+}
+
+// proxy returns the handler forwarding <prefix>/<slug>/... to the server of
+// the project, the path given by projectPath
+func (h *projectHost) proxy(prefix string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		slug := c.Param("project")
+		rest := strings.TrimPrefix(c.Request().URL.EscapedPath(), prefix+"/"+slug)
+		target, ok := projectPath(prefix, rest)
+		if !ok {
+			return pkg_apierror.Message(c, http.StatusNotFound, "not found")
+		}
+
+		server, err := h.server(c.Request().Context(), slug)
+		if errors.Is(err, ErrProjectNotFound) {
+			return pkg_apierror.Message(c, http.StatusNotFound, "project not found")
+		}
+		if err != nil {
+			return pkg_apierror.Error(c, http.StatusServiceUnavailable, err)
+		}
+
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(server.target)
+				if path, err := url.Parse(target); err == nil {
+					r.Out.URL.Path = path.Path
+					r.Out.URL.RawPath = path.RawPath
+				}
+				r.SetXForwarded()
+			},
+			// the realtime events and the stats are streamed
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				pkg_apierror.Error(c, http.StatusBadGateway, err)
+			},
+		}
+		proxy.ServeHTTP(c.Response(), c.Request())
+
+		return nil
+	}
+}
+
+// startAll starts the server of every project, so the first requests don't
+// wait for them
+func (h *projectHost) startAll() {
+	slugs, err := listProjects(h.dir)
+	if err != nil {
+		log.Printf("failed to list the projects: %v\n", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, slug := range slugs {
+		path, err := projectDir(h.dir, slug)
+		if err != nil {
+			continue
+		}
+		server, err := h.start(slug, path)
+		if err != nil {
+			log.Printf("failed to start project %s: %v\n", slug, err)
+			continue
+		}
+		h.servers[slug] = server
+	}
+}
+
+// stop shuts the servers of the projects down, they are killed when they
+// don't stop in time
+func (h *projectHost) stop(ctx context.Context) {
+	h.mu.Lock()
+	h.stopping = true
+	servers := []*projectServer{}
+	for _, server := range h.servers {
+		servers = append(servers, server)
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *projectServer) {
+			defer wg.Done()
+			server.cmd.Process.Signal(syscall.SIGTERM)
+			select {
+			case <-server.exited:
+			case <-ctx.Done():
+				server.cmd.Process.Kill()
+				<-server.exited
+			}
+		}(server)
+	}
+	wg.Wait()
+}
+
+// serveProjects serves the projects of the directory until the host is
+// stopped
+func serveProjects(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	host := &projectHost{dir: dir, servers: map[string]*projectServer{}}
+	host.startAll()
+
+	app := echo.New()
+	app.HideBanner = true
+	// the SAML routes of a project are served outside of /api like they are
+	// without a host, the app_url of the project must route /saml to
+	// /saml/<slug> of the host
+	for _, prefix := range []string{"/api", "/saml"} {
+		app.Any(prefix+"/:project", host.proxy(prefix))
+		app.Any(prefix+"/:project/*", host.proxy(prefix))
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	go func() {
+		if err := app.Start(fmt.Sprintf("%s:%s", os.Getenv("HOST"), port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			app.Logger.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	log.Println("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), projectStopTimeout)
+	defer cancel()
+	if err := app.Shutdown(ctx); err != nil {
+		log.Printf("requests still in progress at shutdown: %v\n", err)
+	}
+	host.stop(ctx)
+
+	return nil
+}
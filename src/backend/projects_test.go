@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"react-golang/src/backend/config"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProjectsAreIsolated(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MAIN_APP_API_KEY", "host key")
+	t.Setenv("JWT_SECRET_KEY", "host secret")
+
+	for _, slug := range []string{"alpha", "beta"} {
+		if err := createProject(dir, slug); err != nil {
+			t.Fatalf("createProject %s: %v", slug, err)
+		}
+	}
+	for _, slug := range []string{"alpha", "Alpha", "../alpha", "-alpha", ""} {
+		if err := createProject(dir, slug); err == nil {
+			t.Errorf("createProject %q succeeded, want an error", slug)
+		}
+	}
+
+	slugs, err := listProjects(dir)
+	if err != nil || !slices.Equal(slugs, []string{"alpha", "beta"}) {
+		t.Fatalf("listProjects returned %v %v, want alpha and beta", slugs, err)
+	}
+
+	envs := map[string]map[string]string{}
+	for _, slug := range slugs {
+		path, err := projectDir(dir, slug)
+		if err != nil {
+			t.Fatalf("projectDir %s: %v", slug, err)
+		}
+		env, err := projectEnv(path)
+		if err != nil {
+			t.Fatalf("projectEnv %s: %v", slug, err)
+		}
+		envs[slug] = map[string]string{}
+		for _, variable := range env {
+			name, value, _ := strings.Cut(variable, "=")
+			envs[slug][name] = value
+		}
+
+		if envs[slug]["DB_PATH"] != filepath.Join(path, projectDatabase) {
+			t.Errorf("DB_PATH of %s is %s, want the database of its directory", slug, envs[slug]["DB_PATH"])
+		}
+		if _, ok := envs[slug]["MAIN_APP_API_KEY"]; ok {
+			t.Errorf("the API key of the host is passed on to %s", slug)
+		}
+		if secret := envs[slug]["JWT_SECRET_KEY"]; secret == "" || secret == "host secret" {
+			t.Errorf("JWT_SECRET_KEY of %s is %q, want a secret of its own", slug, secret)
+		}
+
+		t.Setenv("CONFIG_PATH", envs[slug]["CONFIG_PATH"])
+		var settings config.Config
+		if err := settings.Load(); err != nil {
+			t.Fatalf("failed to read the settings of %s: %v", slug, err)
+		}
+		if settings.AppName != slug || settings.APIKey == "" {
+			t.Errorf("settings of %s are named %s with the API key %q", slug, settings.AppName, settings.APIKey)
+		}
+		envs[slug]["api key"] = settings.APIKey
+	}
+
+	for _, name := range []string{"JWT_SECRET_KEY", "api key"} {
+		if envs["alpha"][name] == envs["beta"][name] {
+			t.Errorf("alpha and beta share their %s", name)
+		}
+	}
+
+	if _, err := projectDir(dir, "gamma"); err != ErrProjectNotFound {
+		t.Errorf("projectDir of a missing project returned %v, want ErrProjectNotFound", err)
+	}
+}
+
+func TestProjectPaths(t *testing.T) {
+	tests := []struct {
+		prefix string
+		rest   string
+		path   string
+		ok     bool
+	}{
+		{"/api", "/db/orders/rows", "/api/main/orders/rows", true},
+		{"/api", "/db", "/api/main", true},
+		{"/api", "/auth/users/login", "/api/auth/users/login", true},
+		{"/api", "/files/key", "/api/files/key", true},
+		{"/api", "/dbs", "/api/dbs", true},
+		{"/api", "/main/orders/rows", "", false},
+		{"/api", "/main", "", false},
+		{"/saml", "/acs", "/saml/acs", true},
+	}
+	for _, test := range tests {
+		path, ok := projectPath(test.prefix, test.rest)
+		if path != test.path || ok != test.ok {
+			t.Errorf("projectPath(%s, %s) = %s %v, want %s %v", test.prefix, test.rest, path, ok, test.path, test.ok)
+		}
+	}
+}
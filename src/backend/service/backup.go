@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/events"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	pkg_tracing "react-golang/src/backend/pkg/tracing"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const backupPrefix = "backup_"
+
+var ErrBackupNotFound = errors.New("backup does not exist")
+
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Compression is none, gzip or zstd, compressed backups are decompressed
+	// when restored
+	Compression string `json:"compression"`
+
+	// read from the manifest stored along with the backup, SchemaVersion is
+	// the schema version of SQLite at the time of the backup
+	SchemaVersion int    `json:"schema_version"`
+	TableCount    int    `json:"table_count"`
+	Checksum      string `json:"checksum"`
+
+	Local  bool `json:"local"`
+	Remote bool `json:"remote"`
+}
+
+type BackupService interface {
+	// Backup copies the database to the backup directory and uploads the copy
+	// to the remote target when one is configured
+	Backup(ctx context.Context) (Backup, error)
+	FetchBackups(ctx context.Context) ([]Backup, error)
+	// Path returns the local file of a backup, downloading it from the remote
+	// target when it only exists there
+	Path(ctx context.Context, name string) (string, error)
+	Restore(ctx context.Context, name string) error
+	Delete(ctx context.Context, name string) error
+
+	// IncrementalBackup stores the pages changed since the previous one,
+	// starting a new chain with a full snapshot when needed
+	IncrementalBackup(ctx context.Context) (RestorePoint, error)
+	FetchRestorePoints(ctx context.Context) ([]RestorePoint, error)
+	RestoreToPoint(ctx context.Context, at time.Time) (RestorePoint, error)
+
+	// Dir returns the local directory of the backups
+	Dir() string
+}
+
+type BackupServiceImpl struct {
+	db *gorm.DB
+
+	// only one backup or restore runs at a time
+	mu sync.Mutex
+}
+
+func NewBackupService(db *gorm.DB) BackupService {
+	return &BackupServiceImpl{
+		db: db,
+	}
+}
+
+func (b *BackupServiceImpl) dir() string {
+	settings := config.GetInstance()
+	if settings.BackupDir != "" {
+		return settings.BackupDir
+	}
+
+	return filepath.Join(filepath.Dir(os.Getenv("DB_PATH")), "backups")
+}
+
+func (b *BackupServiceImpl) Dir() string {
+	return b.dir()
+}
+
+// validBackupName prevents names from escaping the backup directory
+func validBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && filepath.Base(name) == name && !strings.ContainsAny(name, `/\`) && !isManifest(name)
+}
+
+func (b *BackupServiceImpl) Backup(ctx context.Context) (Backup, error) {
+	ctx, span := pkg_tracing.Start(ctx, "BackupService.Backup")
+	backup, err := b.createBackup(ctx)
+	pkg_tracing.End(span, err)
+	publishBackupFinished("full", backup, err)
+
+	return backup, err
+}
+
+// publishBackupFinished tells the event handlers about the outcome of a
+// backup, which is set even when the backup failed after being created
+func publishBackupFinished(mode string, backup interface{}, err error) {
+	data := map[string]interface{}{
+		"mode":   mode,
+		"backup": backup,
+	}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+
+	events.Publish(events.Event{Name: events.BACKUP_FINISHED, Data: data})
+}
+
+// verify checks the integrity of the database when the backups are set to
+func (b *BackupServiceImpl) verify(ctx context.Context) error {
+	if !config.GetInstance().BackupIntegrityCheck {
+		return nil
+	}
+
+	return pkg_sqlite.Verify(b.db.WithContext(ctx))
+}
+
+func (b *BackupServiceImpl) createBackup(ctx context.Context) (Backup, error) {
+	settings := config.GetInstance()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.verify(ctx); err != nil {
+		return Backup{}, err
+	}
+	if err := os.MkdirAll(b.dir(), 0o700); err != nil {
+		return Backup{}, err
+	}
+
+	schemaVersion, tableCount, err := schemaInfo(b.db)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	now := time.Now().UTC()
+	name := fmt.Sprintf("%s%s.db", backupPrefix, now.Format("20060102_150405"))
+	path := filepath.Join(b.dir(), name)
+
+	compression := settings.BackupCompression
+	if compression == "" || compression == CompressionNone {
+		if err := pkg_sqlite.Backup(b.db.WithContext(ctx), path); err != nil {
+			return Backup{}, err
+		}
+	} else {
+		// the copy is compressed into the final file, the uncompressed one is
+		// hidden from the listing meanwhile
+		tmpPath := filepath.Join(b.dir(), "."+name+".tmp")
+		if err := pkg_sqlite.Backup(b.db.WithContext(ctx), tmpPath); err != nil {
+			os.Remove(tmpPath)
+			return Backup{}, err
+		}
+
+		name += compressionExtensions[compression]
+		path = filepath.Join(b.dir(), name)
+		err := compressFile(tmpPath, path, compression)
+		os.Remove(tmpPath)
+		if err != nil {
+			os.Remove(path)
+			return Backup{}, err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	backup := Backup{
+		Name:        name,
+		Size:        info.Size(),
+		CreatedAt:   now,
+		Compression: backupCompression(name),
+		Local:       true,
+	}
+
+	manifestPath, err := b.writeManifest(&backup, schemaVersion, tableCount)
+	if err != nil {
+		return backup, err
+	}
+
+	remote, err := newRemoteTarget(settings)
+	if err != nil {
+		return backup, fmt.Errorf("backup %s was created but not uploaded: %w", name, err)
+	}
+	if remote != nil {
+		if err := remote.Upload(ctx, name, path); err != nil {
+			return backup, fmt.Errorf("backup %s was created but not uploaded: %w", name, err)
+		}
+		if err := remote.Upload(ctx, manifestName(name), manifestPath); err != nil {
+			return backup, fmt.Errorf("backup %s was created but not uploaded: %w", name, err)
+		}
+		backup.Remote = true
+	}
+
+	return backup, nil
+}
+
+func (b *BackupServiceImpl) FetchBackups(ctx context.Context) ([]Backup, error) {
+	backups := map[string]*Backup{}
+
+	entries, err := os.ReadDir(b.dir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !validBackupName(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups[entry.Name()] = &Backup{
+			Name:        entry.Name(),
+			Size:        info.Size(),
+			CreatedAt:   info.ModTime().UTC(),
+			Compression: backupCompression(entry.Name()),
+			Local:       true,
+		}
+	}
+
+	remote, err := newRemoteTarget(config.GetInstance())
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		remoteBackups, err := remote.List(ctx, backupPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list remote backups: %w", err)
+		}
+
+		for _, remoteBackup := range remoteBackups {
+			if !validBackupName(remoteBackup.Name) {
+				continue
+			}
+
+			if backup, ok := backups[remoteBackup.Name]; ok {
+				backup.Remote = true
+				continue
+			}
+			backup := remoteBackup
+			backup.Compression = backupCompression(backup.Name)
+			backup.Remote = true
+			backups[backup.Name] = &backup
+		}
+	}
+
+	result := []Backup{}
+	for _, backup := range backups {
+		b.readManifest(ctx, backup)
+		result = append(result, *backup)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name > result[j].Name
+	})
+
+	return result, nil
+}
+
+func (b *BackupServiceImpl) Path(ctx context.Context, name string) (string, error) {
+	if !validBackupName(name) {
+		return "", ErrBackupNotFound
+	}
+
+	return b.localFile(ctx, name)
+}
+
+// localFile returns the path of a file of the backup directory, downloading
+// it from the remote target when it isn't there
+func (b *BackupServiceImpl) localFile(ctx context.Context, name string) (string, error) {
+	path := filepath.Join(b.dir(), name)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	remote, err := newRemoteTarget(config.GetInstance())
+	if err != nil {
+		return "", err
+	}
+	if remote == nil {
+		return "", ErrBackupNotFound
+	}
+
+	if err := os.MkdirAll(b.dir(), 0o700); err != nil {
+		return "", err
+	}
+	if err := remote.Download(ctx, name, path); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (b *BackupServiceImpl) Restore(ctx context.Context, name string) error {
+	ctx, span := pkg_tracing.Start(ctx, "BackupService.Restore")
+	err := b.restore(ctx, name)
+	pkg_tracing.End(span, err)
+
+	return err
+}
+
+func (b *BackupServiceImpl) restore(ctx context.Context, name string) error {
+	path, err := b.Path(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := b.verifyChecksum(ctx, name, path); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	compression := backupCompression(name)
+	if compression != CompressionNone {
+		tmpPath := filepath.Join(b.dir(), ".restore_"+name+".db")
+		defer os.Remove(tmpPath)
+		if err := decompressFile(path, tmpPath, compression); err != nil {
+			return err
+		}
+		path = tmpPath
+	}
+
+	if err := pkg_sqlite.Restore(b.db, path); err != nil {
+		return err
+	}
+	if err := b.verify(ctx); err != nil {
+		return fmt.Errorf("backup %s was restored: %w", name, err)
+	}
+
+	return nil
+}
+
+func (b *BackupServiceImpl) Delete(ctx context.Context, name string) error {
+	if !validBackupName(name) {
+		return ErrBackupNotFound
+	}
+
+	found := false
+	os.Remove(filepath.Join(b.dir(), manifestName(name)))
+	err := os.Remove(filepath.Join(b.dir(), name))
+	if err == nil {
+		found = true
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	remote, err := newRemoteTarget(config.GetInstance())
+	if err != nil {
+		return err
+	}
+	if remote != nil {
+		deleted, err := remote.Delete(ctx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := remote.Delete(ctx, manifestName(name)); err != nil {
+			return err
+		}
+		found = found || deleted
+	}
+
+	if !found {
+		return ErrBackupNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var compressionExtensions = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// backupCompression tells how a backup was compressed from its extension
+func backupCompression(name string) string {
+	for compression, extension := range compressionExtensions {
+		if strings.HasSuffix(name, extension) {
+			return compression
+		}
+	}
+
+	return CompressionNone
+}
+
+func compressFile(src string, dst string, compression string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	var writer io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		writer = gzip.NewWriter(out)
+	case CompressionZstd:
+		writer, err = zstd.NewWriter(out)
+	default:
+		err = fmt.Errorf("unknown compression %s", compression)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	if _, err := io.Copy(writer, in); err != nil {
+		writer.Close()
+		out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func decompressFile(src string, dst string, compression string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var reader io.Reader
+	switch compression {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	default:
+		return fmt.Errorf("unknown compression %s", compression)
+	}
+
+	return writeFile(dst, reader)
+}
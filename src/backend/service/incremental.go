@@ -0,0 +1,464 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/config"
+	pkg_sqlite "react-golang/src/backend/pkg/sqlite"
+	pkg_tracing "react-golang/src/backend/pkg/tracing"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Incremental backups are made of chains, each starting with a full snapshot
+// of the database followed by deltas holding the pages changed since the
+// previous backup. A copy of the last snapshot is kept to diff against
+const (
+	incrementalPrefix    = "incremental_"
+	incrementalLayout    = "20060102T150405.000000"
+	deltaMagic           = "FBDELTA1"
+	defaultFullEvery     = 24
+	baseSuffix           = "_base.db.zst"
+	deltaSuffix          = ".delta.zst"
+	incrementalStateFile = ".incremental_state.json"
+	incrementalShadow    = ".incremental_shadow.db"
+)
+
+var errPageSizeChanged = errors.New("page size changed")
+
+// RestorePoint is a time the database can be restored to
+type RestorePoint struct {
+	Chain string    `json:"chain"`
+	Time  time.Time `json:"time"`
+	Full  bool      `json:"full"`
+	Size  int64     `json:"size"`
+
+	name string
+}
+
+type incrementalState struct {
+	Chain  string `json:"chain"`
+	Deltas int    `json:"deltas"`
+}
+
+func parseIncrementalName(name string) (RestorePoint, bool) {
+	rest := strings.TrimPrefix(name, incrementalPrefix)
+	if rest == name {
+		return RestorePoint{}, false
+	}
+
+	parts := strings.SplitN(rest, "_", 2)
+	if len(parts) != 2 {
+		return RestorePoint{}, false
+	}
+
+	point := RestorePoint{Chain: parts[0], name: name}
+	at := parts[0]
+	switch {
+	case "_"+parts[1] == baseSuffix:
+		point.Full = true
+	case strings.HasSuffix(parts[1], deltaSuffix):
+		at = strings.TrimSuffix(parts[1], deltaSuffix)
+	default:
+		return RestorePoint{}, false
+	}
+
+	t, err := time.Parse(incrementalLayout, at)
+	if err != nil {
+		return RestorePoint{}, false
+	}
+	point.Time = t
+
+	return point, true
+}
+
+func (b *BackupServiceImpl) readIncrementalState() incrementalState {
+	var state incrementalState
+
+	data, err := os.ReadFile(filepath.Join(b.dir(), incrementalStateFile))
+	if err == nil {
+		json.Unmarshal(data, &state)
+	}
+
+	return state
+}
+
+func (b *BackupServiceImpl) writeIncrementalState(state incrementalState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(b.dir(), incrementalStateFile), data, 0o600)
+}
+
+func (b *BackupServiceImpl) IncrementalBackup(ctx context.Context) (RestorePoint, error) {
+	ctx, span := pkg_tracing.Start(ctx, "BackupService.IncrementalBackup")
+	point, err := b.createIncrementalBackup(ctx)
+	pkg_tracing.End(span, err)
+	publishBackupFinished("incremental", point, err)
+
+	return point, err
+}
+
+func (b *BackupServiceImpl) createIncrementalBackup(ctx context.Context) (RestorePoint, error) {
+	settings := config.GetInstance()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.verify(ctx); err != nil {
+		return RestorePoint{}, err
+	}
+	if err := os.MkdirAll(b.dir(), 0o700); err != nil {
+		return RestorePoint{}, err
+	}
+
+	snapshot := filepath.Join(b.dir(), ".incremental_snapshot.db")
+	os.Remove(snapshot)
+	defer os.Remove(snapshot)
+	if err := pkg_sqlite.Snapshot(b.db, snapshot); err != nil {
+		return RestorePoint{}, err
+	}
+
+	now := time.Now().UTC()
+	at := now.Format(incrementalLayout)
+	shadow := filepath.Join(b.dir(), incrementalShadow)
+
+	fullEvery := settings.BackupFullEvery
+	if fullEvery <= 0 {
+		fullEvery = defaultFullEvery
+	}
+
+	state := b.readIncrementalState()
+	_, shadowErr := os.Stat(shadow)
+
+	var name string
+	if state.Chain != "" && shadowErr == nil && state.Deltas < fullEvery {
+		name = incrementalPrefix + state.Chain + "_" + at + deltaSuffix
+		err := writeDelta(shadow, snapshot, filepath.Join(b.dir(), name))
+		if err != nil && !errors.Is(err, errPageSizeChanged) {
+			os.Remove(filepath.Join(b.dir(), name))
+			return RestorePoint{}, err
+		}
+		if err == nil {
+			state.Deltas++
+		} else {
+			// the pages can't be compared anymore, a new chain is started
+			os.Remove(filepath.Join(b.dir(), name))
+			name = ""
+		}
+	}
+	if name == "" {
+		state = incrementalState{Chain: at}
+		name = incrementalPrefix + at + baseSuffix
+		if err := compressFile(snapshot, filepath.Join(b.dir(), name), CompressionZstd); err != nil {
+			os.Remove(filepath.Join(b.dir(), name))
+			return RestorePoint{}, err
+		}
+	}
+
+	if err := os.Rename(snapshot, shadow); err != nil {
+		return RestorePoint{}, err
+	}
+	if err := b.writeIncrementalState(state); err != nil {
+		return RestorePoint{}, err
+	}
+
+	point, _ := parseIncrementalName(name)
+	if info, err := os.Stat(filepath.Join(b.dir(), name)); err == nil {
+		point.Size = info.Size()
+	}
+
+	remote, err := newRemoteTarget(settings)
+	if err != nil {
+		return point, err
+	}
+	if remote != nil {
+		if err := remote.Upload(ctx, name, filepath.Join(b.dir(), name)); err != nil {
+			return point, errors.New("restore point was created but not uploaded: " + err.Error())
+		}
+	}
+
+	return point, nil
+}
+
+func (b *BackupServiceImpl) FetchRestorePoints(ctx context.Context) ([]RestorePoint, error) {
+	points := map[string]RestorePoint{}
+
+	entries, err := os.ReadDir(b.dir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		point, ok := parseIncrementalName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			point.Size = info.Size()
+		}
+		points[point.name] = point
+	}
+
+	remote, err := newRemoteTarget(config.GetInstance())
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		files, err := remote.List(ctx, incrementalPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			point, ok := parseIncrementalName(file.Name)
+			if !ok {
+				continue
+			}
+			if _, exists := points[point.name]; !exists {
+				point.Size = file.Size
+				points[point.name] = point
+			}
+		}
+	}
+
+	result := []RestorePoint{}
+	for _, point := range points {
+		result = append(result, point)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Time.After(result[j].Time)
+	})
+
+	return result, nil
+}
+
+// RestoreToPoint restores the database as it was at the latest restore point
+// taken at or before at, the latest one when at is zero
+func (b *BackupServiceImpl) RestoreToPoint(ctx context.Context, at time.Time) (RestorePoint, error) {
+	ctx, span := pkg_tracing.Start(ctx, "BackupService.RestoreToPoint")
+	point, err := b.restoreToPoint(ctx, at)
+	pkg_tracing.End(span, err)
+
+	return point, err
+}
+
+func (b *BackupServiceImpl) restoreToPoint(ctx context.Context, at time.Time) (RestorePoint, error) {
+	points, err := b.FetchRestorePoints(ctx)
+	if err != nil {
+		return RestorePoint{}, err
+	}
+
+	var target *RestorePoint
+	for i, point := range points {
+		if at.IsZero() || !point.Time.After(at) {
+			target = &points[i]
+			break
+		}
+	}
+	if target == nil {
+		return RestorePoint{}, ErrBackupNotFound
+	}
+
+	// the base and the deltas of the chain up to the target, oldest first
+	chain := []RestorePoint{}
+	for _, point := range points {
+		if point.Chain == target.Chain && !point.Time.After(target.Time) {
+			chain = append([]RestorePoint{point}, chain...)
+		}
+	}
+	if len(chain) == 0 || !chain[0].Full {
+		return RestorePoint{}, errors.New("the base snapshot of the restore point is missing")
+	}
+
+	tmpPath := filepath.Join(b.dir(), ".restore_incremental.db")
+	defer os.Remove(tmpPath)
+
+	for i, point := range chain {
+		path, err := b.localFile(ctx, point.name)
+		if err != nil {
+			return RestorePoint{}, err
+		}
+
+		if i == 0 {
+			err = decompressFile(path, tmpPath, CompressionZstd)
+		} else {
+			err = applyDelta(tmpPath, path)
+		}
+		if err != nil {
+			return RestorePoint{}, err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := pkg_sqlite.Restore(b.db, tmpPath); err != nil {
+		return RestorePoint{}, err
+	}
+	if err := b.verify(ctx); err != nil {
+		return *target, fmt.Errorf("the restore point was restored: %w", err)
+	}
+
+	return *target, nil
+}
+
+// pageSize reads the page size from the header of a database file
+func pageSize(file *os.File) (int, error) {
+	header := make([]byte, 18)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return 0, err
+	}
+
+	size := int(binary.BigEndian.Uint16(header[16:18]))
+	if size == 1 {
+		size = 65536
+	}
+
+	return size, nil
+}
+
+// writeDelta writes the pages of newPath which differ from oldPath to dst
+func writeDelta(oldPath string, newPath string, dst string) error {
+	oldFile, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+
+	newFile, err := os.Open(newPath)
+	if err != nil {
+		return err
+	}
+	defer newFile.Close()
+
+	size, err := pageSize(newFile)
+	if err != nil {
+		return err
+	}
+	if oldSize, err := pageSize(oldFile); err != nil || oldSize != size {
+		return errPageSizeChanged
+	}
+
+	info, err := newFile.Stat()
+	if err != nil {
+		return err
+	}
+	pageCount := uint32(info.Size() / int64(size))
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	writer, err := zstd.NewWriter(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[0:4], uint32(size))
+	binary.BigEndian.PutUint32(header[4:8], pageCount)
+	writer.Write([]byte(deltaMagic))
+	writer.Write(header)
+
+	newPage := make([]byte, size)
+	oldPage := make([]byte, size)
+	pageNumber := make([]byte, 4)
+	for pgno := uint32(1); pgno <= pageCount; pgno++ {
+		offset := int64(pgno-1) * int64(size)
+		if _, err := newFile.ReadAt(newPage, offset); err != nil {
+			writer.Close()
+			out.Close()
+			return err
+		}
+
+		n, _ := oldFile.ReadAt(oldPage, offset)
+		if n == size && bytes.Equal(newPage, oldPage) {
+			continue
+		}
+
+		binary.BigEndian.PutUint32(pageNumber, pgno)
+		writer.Write(pageNumber)
+		if _, err := writer.Write(newPage); err != nil {
+			writer.Close()
+			out.Close()
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// applyDelta writes the pages of a delta over the database file at dbPath
+func applyDelta(dbPath string, deltaPath string) error {
+	in, err := os.Open(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader, err := zstd.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	header := make([]byte, len(deltaMagic)+8)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return err
+	}
+	if string(header[:len(deltaMagic)]) != deltaMagic {
+		return errors.New("invalid delta file")
+	}
+	size := int64(binary.BigEndian.Uint32(header[len(deltaMagic):]))
+	pageCount := int64(binary.BigEndian.Uint32(header[len(deltaMagic)+4:]))
+
+	db, err := os.OpenFile(dbPath, os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+
+	page := make([]byte, size)
+	pageNumber := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, pageNumber); err != nil {
+			if err == io.EOF {
+				break
+			}
+			db.Close()
+			return err
+		}
+		if _, err := io.ReadFull(reader, page); err != nil {
+			db.Close()
+			return err
+		}
+
+		pgno := int64(binary.BigEndian.Uint32(pageNumber))
+		if _, err := db.WriteAt(page, (pgno-1)*size); err != nil {
+			db.Close()
+			return err
+		}
+	}
+
+	if err := db.Truncate(pageCount * size); err != nil {
+		db.Close()
+		return err
+	}
+
+	return db.Close()
+}
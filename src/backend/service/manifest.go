@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/model"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// every backup is stored along with a manifest describing it
+const manifestSuffix = ".manifest.json"
+
+var ErrChecksumMismatch = errors.New("backup checksum does not match its manifest")
+
+type backupManifest struct {
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	CreatedAt     time.Time `json:"created_at"`
+	Compression   string    `json:"compression"`
+	SchemaVersion int       `json:"schema_version"`
+	TableCount    int       `json:"table_count"`
+	Checksum      string    `json:"checksum"`
+}
+
+func manifestName(name string) string {
+	return name + manifestSuffix
+}
+
+func isManifest(name string) bool {
+	return strings.HasSuffix(name, manifestSuffix)
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// schemaInfo returns the schema version of the database, which SQLite bumps
+// on every schema change, and its number of user tables
+func schemaInfo(db *gorm.DB) (int, int, error) {
+	var schemaVersion int
+	if err := db.Raw("PRAGMA schema_version").Scan(&schemaVersion).Error; err != nil {
+		return 0, 0, err
+	}
+
+	var tableCount int64
+	err := db.Model(&model.Tables{}).Where("is_system = ?", false).Count(&tableCount).Error
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return schemaVersion, int(tableCount), nil
+}
+
+func (b *BackupServiceImpl) writeManifest(backup *Backup, schemaVersion int, tableCount int) (string, error) {
+	path := filepath.Join(b.dir(), backup.Name)
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+
+	backup.SchemaVersion = schemaVersion
+	backup.TableCount = tableCount
+	backup.Checksum = checksum
+
+	data, err := json.MarshalIndent(backupManifest{
+		Name:          backup.Name,
+		Size:          backup.Size,
+		CreatedAt:     backup.CreatedAt,
+		Compression:   backup.Compression,
+		SchemaVersion: schemaVersion,
+		TableCount:    tableCount,
+		Checksum:      checksum,
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	manifestPath := filepath.Join(b.dir(), manifestName(backup.Name))
+	return manifestPath, os.WriteFile(manifestPath, data, 0o600)
+}
+
+// readManifest fills backup with its manifest, fetching it from the remote
+// target when needed. Backups made before manifests existed are left as is
+func (b *BackupServiceImpl) readManifest(ctx context.Context, backup *Backup) {
+	path, err := b.localFile(ctx, manifestName(backup.Name))
+	if err != nil {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var manifest backupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return
+	}
+
+	if !manifest.CreatedAt.IsZero() {
+		backup.CreatedAt = manifest.CreatedAt
+	}
+	backup.SchemaVersion = manifest.SchemaVersion
+	backup.TableCount = manifest.TableCount
+	backup.Checksum = manifest.Checksum
+}
+
+// verifyChecksum compares a backup file with the checksum of its manifest
+func (b *BackupServiceImpl) verifyChecksum(ctx context.Context, name string, path string) error {
+	backup := Backup{Name: name}
+	b.readManifest(ctx, &backup)
+	if backup.Checksum == "" {
+		return nil
+	}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if checksum != backup.Checksum {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
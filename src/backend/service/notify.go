@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"react-golang/src/backend/config"
+	pkg_mailer "react-golang/src/backend/pkg/mailer"
+	"time"
+)
+
+const (
+	BACKUP_EVENT_SUCCEEDED = "backup.succeeded"
+	BACKUP_EVENT_FAILED    = "backup.failed"
+)
+
+// BackupEvent is sent to the webhook as is and summarized in the emails
+type BackupEvent struct {
+	Event  string    `json:"event"`
+	Mode   string    `json:"mode"`
+	Backup string    `json:"backup,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// BackupNotifier tells the configured emails and webhook about the outcome
+// of the scheduled backups. Successes are only reported when enabled
+type BackupNotifier struct {
+	mailer  pkg_mailer.Mailer
+	webhook WebhookService
+}
+
+func NewBackupNotifier(mailer pkg_mailer.Mailer, webhook WebhookService) *BackupNotifier {
+	return &BackupNotifier{
+		mailer:  mailer,
+		webhook: webhook,
+	}
+}
+
+func (n *BackupNotifier) Notify(event BackupEvent) {
+	settings := config.GetInstance()
+	if event.Event == BACKUP_EVENT_SUCCEEDED && !settings.BackupNotifyOnSuccess {
+		return
+	}
+
+	if settings.BackupNotifyWebhook != "" {
+		if err := n.webhook.Send(context.Background(), WebhookEndpoint{
+			URL:    settings.BackupNotifyWebhook,
+			Secret: settings.BackupNotifyWebhookSecret,
+		}, event.Event, event); err != nil {
+			log.Printf("failed to send backup notification webhook: %v\n", err)
+		}
+	}
+
+	subject, body := n.email(event)
+	for _, to := range settings.BackupNotifyEmails {
+		if err := n.mailer.Send(to, subject, body); err != nil {
+			log.Printf("failed to send backup notification to %s: %v\n", to, err)
+		}
+	}
+}
+
+func (n *BackupNotifier) email(event BackupEvent) (string, string) {
+	settings := config.GetInstance()
+	at := event.Time.Format(time.RFC1123)
+
+	if event.Event == BACKUP_EVENT_FAILED {
+		return fmt.Sprintf("[%s] Scheduled backup failed", settings.AppName),
+			fmt.Sprintf("The scheduled %s backup of %s failed at %s.\n\nError: %s\n", event.Mode, settings.AppName, at, event.Error)
+	}
+
+	return fmt.Sprintf("[%s] Scheduled backup succeeded", settings.AppName),
+		fmt.Sprintf("The scheduled %s backup of %s succeeded at %s.\n\nBackup: %s\n", event.Mode, settings.AppName, at, event.Backup)
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"react-golang/src/backend/config"
+	pkg_s3 "react-golang/src/backend/pkg/s3"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteTarget stores backups off-host
+type remoteTarget interface {
+	Upload(ctx context.Context, name string, path string) error
+	// List returns the stored files whose name starts with prefix
+	List(ctx context.Context, prefix string) ([]Backup, error)
+	Download(ctx context.Context, name string, path string) error
+	// Delete removes a backup and tells whether it existed
+	Delete(ctx context.Context, name string) (bool, error)
+}
+
+// newRemoteTarget returns the target configured in the settings, or nil when
+// backups are only kept locally
+func newRemoteTarget(config *config.Config) (remoteTarget, error) {
+	switch config.BackupRemote {
+	case "":
+		return nil, nil
+	case "s3":
+		return &s3Target{
+			client: &pkg_s3.Client{
+				Endpoint:   config.BackupS3Endpoint,
+				Region:     config.BackupS3Region,
+				Bucket:     config.BackupS3Bucket,
+				AccessKey:  config.BackupS3AccessKey,
+				SecretKey:  config.BackupS3SecretKey,
+				PathStyle:  config.BackupS3PathStyle,
+				HTTPClient: &http.Client{Timeout: time.Hour},
+			},
+			prefix: config.BackupS3Prefix,
+		}, nil
+	case "sftp":
+		return &sftpTarget{config: config}, nil
+	default:
+		return nil, fmt.Errorf("unknown backup remote %s", config.BackupRemote)
+	}
+}
+
+type s3Target struct {
+	client *pkg_s3.Client
+	prefix string
+}
+
+func (t *s3Target) key(name string) string {
+	return t.prefix + name
+}
+
+func (t *s3Target) Upload(ctx context.Context, name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return t.client.Put(ctx, t.key(name), file, info.Size())
+}
+
+func (t *s3Target) List(ctx context.Context, prefix string) ([]Backup, error) {
+	objects, err := t.client.List(ctx, t.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, t.prefix)
+		if strings.Contains(name, "/") {
+			continue
+		}
+
+		backups = append(backups, Backup{
+			Name:      name,
+			Size:      object.Size,
+			CreatedAt: object.LastModified.UTC(),
+		})
+	}
+
+	return backups, nil
+}
+
+func (t *s3Target) Download(ctx context.Context, name string, path string) error {
+	body, err := t.client.Get(ctx, t.key(name))
+	if err != nil {
+		if strings.Contains(err.Error(), "NoSuchKey") {
+			return ErrBackupNotFound
+		}
+		return err
+	}
+	defer body.Close()
+
+	return writeFile(path, body)
+}
+
+func (t *s3Target) Delete(ctx context.Context, name string) (bool, error) {
+	// S3 doesn't tell whether the deleted object existed
+	objects, err := t.client.List(ctx, t.key(name))
+	if err != nil {
+		return false, err
+	}
+	if len(objects) == 0 {
+		return false, nil
+	}
+
+	return true, t.client.Delete(ctx, t.key(name))
+}
+
+type sftpTarget struct {
+	config *config.Config
+}
+
+// connect opens a new connection for every operation, backups are rare
+// enough that keeping one alive isn't worth it
+func (t *sftpTarget) connect(ctx context.Context) (*sftp.Client, func(), error) {
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(t.config.BackupSFTPHostKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sftp host key: %w", err)
+	}
+
+	port := t.config.BackupSFTPPort
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(t.config.BackupSFTPHost, fmt.Sprint(port))
+
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            t.config.BackupSFTPUsername,
+		Auth:            []ssh.AuthMethod{ssh.Password(t.config.BackupSFTPPassword)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		sshClient.Close()
+		return nil, nil, err
+	}
+
+	return client, func() {
+		client.Close()
+		sshClient.Close()
+	}, nil
+}
+
+func (t *sftpTarget) path(name string) string {
+	return path.Join(t.config.BackupSFTPPath, name)
+}
+
+func (t *sftpTarget) Upload(ctx context.Context, name string, localPath string) error {
+	client, closeConn, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	if t.config.BackupSFTPPath != "" {
+		if err := client.MkdirAll(t.config.BackupSFTPPath); err != nil {
+			return err
+		}
+	}
+
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	// written under a temporary name so a partial upload is never listed
+	tmpPath := t.path("." + name + ".tmp")
+	remote, err := client.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := remote.ReadFrom(local); err != nil {
+		remote.Close()
+		client.Remove(tmpPath)
+		return err
+	}
+	if err := remote.Close(); err != nil {
+		return err
+	}
+
+	client.Remove(t.path(name))
+	return client.Rename(tmpPath, t.path(name))
+}
+
+func (t *sftpTarget) List(ctx context.Context, prefix string) ([]Backup, error) {
+	client, closeConn, err := t.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeConn()
+
+	dir := t.config.BackupSFTPPath
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := client.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Backup{}, nil
+		}
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+
+		backups = append(backups, Backup{
+			Name:      entry.Name(),
+			Size:      entry.Size(),
+			CreatedAt: entry.ModTime().UTC(),
+		})
+	}
+
+	return backups, nil
+}
+
+func (t *sftpTarget) Download(ctx context.Context, name string, localPath string) error {
+	client, closeConn, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	remote, err := client.Open(t.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrBackupNotFound
+		}
+		return err
+	}
+	defer remote.Close()
+
+	return writeFile(localPath, remote)
+}
+
+func (t *sftpTarget) Delete(ctx context.Context, name string) (bool, error) {
+	client, closeConn, err := t.connect(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer closeConn()
+
+	if err := client.Remove(t.path(name)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+func writeFile(path string, content io.Reader) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/model"
+	"time"
+
+	pkg_scanner "react-golang/src/backend/pkg/scanner"
+)
+
+// infected files are moved apart from the stored files so they are never
+// served, and kept for an admin to look at
+const QuarantineDir = ".quarantine"
+
+// a scan still pending after this long was interrupted, by a restart for
+// instance, and is run again
+const scanRetryAfter = 15 * time.Minute
+
+var ErrFileQuarantined = errors.New("file failed the malware scan and was quarantined")
+
+// scanner returns the scanner of the settings, nil when scanning is disabled
+func (s *StorageServiceImpl) scanner() (pkg_scanner.Scanner, error) {
+	settings := config.GetInstance()
+	return pkg_scanner.NewScanner(pkg_scanner.ScannerOption{
+		Backend: settings.ScannerBackend,
+		Address: settings.ScannerAddress,
+		URL:     settings.ScannerURL,
+	})
+}
+
+func quarantineKey(key string) string {
+	return QuarantineDir + "/" + key
+}
+
+// scan checks a stored file in the background. Infected files are moved to
+// quarantine and flagged in the registry, where the row holding them can be
+// found through their table and row id
+func (s *StorageServiceImpl) scan(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	status, result := s.runScan(ctx, key)
+	if status == model.FILE_SCAN_INFECTED {
+		if err := s.quarantine(ctx, key); err != nil {
+			log.Printf("failed to quarantine file %s: %v\n", key, err)
+			status, result = model.FILE_SCAN_FAILED, err.Error()
+		} else {
+			log.Printf("quarantined file %s: %s\n", key, result)
+		}
+	}
+
+	err := s.db.WithContext(ctx).Model(&model.File{}).
+		Where("key = ?", key).
+		Updates(map[string]interface{}{
+			"scan_status": status,
+			"scan_result": result,
+		}).Error
+	if err != nil {
+		log.Printf("failed to record the scan of file %s: %v\n", key, err)
+	}
+}
+
+func (s *StorageServiceImpl) runScan(ctx context.Context, key string) (status string, result string) {
+	scanner, err := s.scanner()
+	if err != nil {
+		return model.FILE_SCAN_FAILED, err.Error()
+	}
+	if scanner == nil {
+		return "", ""
+	}
+
+	store, err := s.store()
+	if err != nil {
+		return model.FILE_SCAN_FAILED, err.Error()
+	}
+	content, err := store.Open(ctx, key)
+	if err != nil {
+		return model.FILE_SCAN_FAILED, err.Error()
+	}
+	defer content.Close()
+
+	verdict, err := scanner.Scan(ctx, content)
+	if err != nil {
+		return model.FILE_SCAN_FAILED, err.Error()
+	}
+	if !verdict.Clean {
+		return model.FILE_SCAN_INFECTED, verdict.Threat
+	}
+
+	return model.FILE_SCAN_CLEAN, ""
+}
+
+// quarantine copies the file apart before removing it, the storages can't
+// move their objects
+func (s *StorageServiceImpl) quarantine(ctx context.Context, key string) error {
+	store, err := s.store()
+	if err != nil {
+		return err
+	}
+
+	object, err := store.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+	content, err := store.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	err = store.Save(ctx, quarantineKey(key), content, object.Size)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := deleteThumbnails(ctx, store, key); err != nil {
+		return err
+	}
+
+	return store.Delete(ctx, key)
+}
+
+func (s *StorageServiceImpl) ScanPending(ctx context.Context) (int, error) {
+	scanner, err := s.scanner()
+	if err != nil || scanner == nil {
+		return 0, err
+	}
+
+	var keys []string
+	err = s.db.WithContext(ctx).Model(&model.File{}).
+		Where("(scan_status = ? AND created_at < ?) OR scan_status = ?",
+			model.FILE_SCAN_PENDING, time.Now().Add(-scanRetryAfter), model.FILE_SCAN_FAILED).
+		Pluck("key", &keys).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		s.scan(key)
+	}
+
+	return len(keys), nil
+}
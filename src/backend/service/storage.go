@@ -0,0 +1,801 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/events"
+	"react-golang/src/backend/model"
+	pkg_tracing "react-golang/src/backend/pkg/tracing"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// the uploads in progress are kept apart from the stored files
+const UploadsDir = ".uploads"
+
+const defaultMaxFileSizeMB = 5 * 1024
+
+var (
+	ErrFileNotFound     = errors.New("file does not exist")
+	ErrUploadNotFound   = errors.New("upload does not exist")
+	ErrUploadOffset     = errors.New("upload offset does not match")
+	ErrUploadIncomplete = errors.New("upload is incomplete")
+	ErrUploadTooLarge   = errors.New("upload is larger than its declared size")
+	ErrFileTooLarge     = errors.New("file is too large")
+	ErrQuotaExceeded    = errors.New("storage quota exceeded")
+	ErrSameStorage      = errors.New("files are already in this storage")
+	ErrFileAttached     = errors.New("file is held by another row")
+)
+
+// Upload is a chunked upload in progress. The chunks are appended in order
+// at Offset until Size bytes were received, then the upload is completed
+// into a stored file. Uploads that aren't completed in time are removed
+type Upload struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FileFilter narrows the listed files, empty fields match every file
+type FileFilter struct {
+	Search     string
+	Table      string
+	RowID      string
+	Column     string
+	UploadedBy string
+	MimeType   string
+	ScanStatus string
+
+	Page     int
+	PageSize int
+}
+
+// TableUsage is the storage used by the files of a table, the files which
+// aren't held by any row are counted under an empty table
+type TableUsage struct {
+	Table string `json:"table"`
+	Files int64  `json:"files"`
+	Size  int64  `json:"size"`
+	Quota int64  `json:"quota"`
+}
+
+type StorageUsage struct {
+	Files  int64        `json:"files"`
+	Size   int64        `json:"size"`
+	Quota  int64        `json:"quota"`
+	Tables []TableUsage `json:"tables"`
+}
+
+// StorageMigration is the outcome of copying the objects of a storage to
+// another, the objects the target already held are skipped
+type StorageMigration struct {
+	Copied  int      `json:"copied"`
+	Skipped int      `json:"skipped"`
+	Failed  []string `json:"failed"`
+}
+
+// StorageService keeps the files in the Storage of the settings under their
+// key, which is what the file fields of the rows hold, and their metadata in
+// the _file table
+type StorageService interface {
+	// Save stores body as a new file in one go
+	Save(ctx context.Context, name string, uploadedBy string, body io.Reader) (model.File, error)
+	// Open returns the content of a stored file, which must be closed
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, model.File, error)
+	// OpenThumbnail returns a resized copy of a stored image as described by
+	// thumb, encoded in format. Thumbnails are made once and kept in storage
+	OpenThumbnail(ctx context.Context, key string, thumb string, format string) (io.ReadSeekCloser, model.File, error)
+	Fetch(ctx context.Context, key string) (model.File, error)
+	FetchFiles(ctx context.Context, filter FileFilter) ([]model.File, error)
+	// Attach records the file field of the row holding the file
+	Attach(ctx context.Context, key string, table string, rowID string, column string) error
+	Delete(ctx context.Context, key string) error
+	// Usage sums the size of the stored files, per table and overall
+	Usage(ctx context.Context) (StorageUsage, error)
+	TableSize(ctx context.Context, table string) (int64, error)
+	// Dir returns the local directory of the stored files, the uploads in
+	// progress are kept in its UploadsDir whatever the storage
+	Dir() string
+	// Objects lists what the storage holds under prefix, the stored files
+	// along with their thumbnails in ThumbsDir and the quarantined files in
+	// QuarantineDir
+	Objects(ctx context.Context, prefix string) ([]StorageObject, error)
+	// Migrate copies every object of the storage of the from backend to the
+	// storage of the settings, leaving the from storage as it was
+	Migrate(ctx context.Context, from string) (StorageMigration, error)
+
+	CreateUpload(ctx context.Context, upload Upload) (Upload, error)
+	FetchUpload(ctx context.Context, id string) (Upload, error)
+	// AppendUpload writes a chunk at offset, which must be where the
+	// previous chunk ended so a client can resume after a failure
+	AppendUpload(ctx context.Context, id string, offset int64, body io.Reader) (Upload, error)
+	CompleteUpload(ctx context.Context, id string) (model.File, error)
+	AbortUpload(ctx context.Context, id string) error
+	// CleanupUploads removes the expired uploads and returns how many
+	CleanupUploads(ctx context.Context) (int, error)
+	// ScanPending scans again the files whose scan failed or was
+	// interrupted, and returns how many
+	ScanPending(ctx context.Context) (int, error)
+}
+
+type StorageServiceImpl struct {
+	db *gorm.DB
+
+	// chunks of the same upload are appended one at a time
+	locks sync.Map
+}
+
+func NewStorageService(db *gorm.DB) StorageService {
+	return &StorageServiceImpl{
+		db: db,
+	}
+}
+
+func (s *StorageServiceImpl) dir() string {
+	return localStorageDir(config.GetInstance())
+}
+
+// store returns the storage of the settings, which may change at any time
+func (s *StorageServiceImpl) store() (Storage, error) {
+	settings := config.GetInstance()
+	return NewStorage(settings, settings.StorageBackend)
+}
+
+func (s *StorageServiceImpl) Dir() string {
+	return s.dir()
+}
+
+func (s *StorageServiceImpl) maxFileSize() int64 {
+	size := config.GetInstance().MaxFileSizeMB
+	if size <= 0 {
+		size = defaultMaxFileSizeMB
+	}
+
+	return int64(size) << 20
+}
+
+// validKey prevents keys from escaping the storage directory, keys are
+// always uuids
+func validKey(key string) bool {
+	_, err := uuid.Parse(key)
+	return err == nil
+}
+
+func (s *StorageServiceImpl) uploadPath(id string) string {
+	return filepath.Join(s.dir(), UploadsDir, id+".part")
+}
+
+func (s *StorageServiceImpl) uploadInfoPath(id string) string {
+	return filepath.Join(s.dir(), UploadsDir, id+".json")
+}
+
+func (s *StorageServiceImpl) lock(id string) func() {
+	mu, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+
+	return mu.(*sync.Mutex).Unlock
+}
+
+// detectMimeType uses the extension of the name, or sniffs the content when
+// it is unknown
+func detectMimeType(name string, path string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+
+	return http.DetectContentType(head[:n])
+}
+
+func (s *StorageServiceImpl) Save(ctx context.Context, name string, uploadedBy string, body io.Reader) (model.File, error) {
+	ctx, span := pkg_tracing.Start(ctx, "StorageService.Save")
+	file, err := s.save(ctx, name, uploadedBy, body)
+	pkg_tracing.End(span, err)
+
+	return file, err
+}
+
+func (s *StorageServiceImpl) save(ctx context.Context, name string, uploadedBy string, body io.Reader) (model.File, error) {
+	if err := os.MkdirAll(filepath.Join(s.dir(), UploadsDir), 0o700); err != nil {
+		return model.File{}, err
+	}
+
+	key := uuid.NewString()
+	tmpPath := filepath.Join(s.dir(), UploadsDir, key+".tmp")
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return model.File{}, err
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(body, s.maxFileSize()+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > s.maxFileSize() {
+		err = ErrFileTooLarge
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return model.File{}, err
+	}
+
+	mimeType := detectMimeType(name, tmpPath)
+	if err := s.storeFile(ctx, key, tmpPath, size); err != nil {
+		return model.File{}, err
+	}
+
+	return s.register(ctx, model.File{
+		Key:        key,
+		Name:       filepath.Base(name),
+		Size:       size,
+		MimeType:   mimeType,
+		Hash:       hex.EncodeToString(hash.Sum(nil)),
+		UploadedBy: uploadedBy,
+	})
+}
+
+// storeFile moves a file received in the uploads directory to the storage
+func (s *StorageServiceImpl) storeFile(ctx context.Context, key string, path string, size int64) error {
+	defer os.Remove(path)
+
+	store, err := s.store()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return store.Save(ctx, key, file, size)
+}
+
+// removeFile removes the content of a file along with its thumbnails and
+// its quarantined copy
+func (s *StorageServiceImpl) removeFile(ctx context.Context, key string) error {
+	store, err := s.store()
+	if err != nil {
+		return err
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, quarantineKey(key)); err != nil {
+		return err
+	}
+
+	return deleteThumbnails(ctx, store, key)
+}
+
+// register records a file written to disk, the file is removed when it
+// can't be recorded so no file goes untracked
+func (s *StorageServiceImpl) register(ctx context.Context, file model.File) (model.File, error) {
+	if err := s.checkQuota(ctx, file.Size); err != nil {
+		s.removeFile(ctx, file.Key)
+		return model.File{}, err
+	}
+
+	// the upload doesn't wait for the scan, which may take a while
+	scanner, err := s.scanner()
+	if err != nil {
+		log.Printf("failed to set up the malware scanner: %v\n", err)
+	}
+	if scanner != nil || err != nil {
+		file.ScanStatus = model.FILE_SCAN_PENDING
+	}
+
+	if err := s.db.WithContext(ctx).Create(&file).Error; err != nil {
+		s.removeFile(ctx, file.Key)
+		return model.File{}, err
+	}
+	if file.ScanStatus == model.FILE_SCAN_PENDING {
+		go s.scan(file.Key)
+	}
+	events.Publish(events.Event{Name: events.FILE_UPLOADED, Data: map[string]interface{}{"file": file}})
+
+	return file, nil
+}
+
+func (s *StorageServiceImpl) quota() int64 {
+	return int64(config.GetInstance().StorageQuotaMB) << 20
+}
+
+// checkQuota tells whether size more bytes fit in the storage quota
+func (s *StorageServiceImpl) checkQuota(ctx context.Context, size int64) error {
+	if s.quota() <= 0 {
+		return nil
+	}
+
+	var used int64
+	err := s.db.WithContext(ctx).Model(&model.File{}).
+		Select("COALESCE(SUM(size), 0)").
+		Scan(&used).Error
+	if err != nil {
+		return err
+	}
+	if used+size > s.quota() {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func (s *StorageServiceImpl) Usage(ctx context.Context) (StorageUsage, error) {
+	usage := StorageUsage{Quota: s.quota(), Tables: []TableUsage{}}
+	err := s.db.WithContext(ctx).Model(&model.File{}).
+		Select("`table`, COUNT(*) AS files, COALESCE(SUM(size), 0) AS size").
+		Group("`table`").
+		Order("size DESC").
+		Scan(&usage.Tables).Error
+	if err != nil {
+		return usage, err
+	}
+
+	for _, table := range usage.Tables {
+		usage.Files += table.Files
+		usage.Size += table.Size
+	}
+
+	return usage, nil
+}
+
+func (s *StorageServiceImpl) TableSize(ctx context.Context, table string) (int64, error) {
+	var size int64
+	err := s.db.WithContext(ctx).Model(&model.File{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("`table` = ?", table).
+		Scan(&size).Error
+
+	return size, err
+}
+
+func (s *StorageServiceImpl) Fetch(ctx context.Context, key string) (model.File, error) {
+	var file model.File
+	err := s.db.WithContext(ctx).Where("key = ?", key).Take(&file).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return file, ErrFileNotFound
+	}
+
+	return file, err
+}
+
+func (s *StorageServiceImpl) FetchFiles(ctx context.Context, filter FileFilter) ([]model.File, error) {
+	query := s.db.WithContext(ctx).Model(&model.File{})
+	if filter.Search != "" {
+		query = query.Where("name LIKE ?", "%"+filter.Search+"%")
+	}
+	if filter.Table != "" {
+		query = query.Where("`table` = ?", filter.Table)
+	}
+	if filter.RowID != "" {
+		query = query.Where("row_id = ?", filter.RowID)
+	}
+	if filter.Column != "" {
+		query = query.Where("`column` = ?", filter.Column)
+	}
+	if filter.UploadedBy != "" {
+		query = query.Where("uploaded_by = ?", filter.UploadedBy)
+	}
+	if filter.ScanStatus != "" {
+		query = query.Where("scan_status = ?", filter.ScanStatus)
+	}
+	if filter.MimeType != "" {
+		// a type without subtype such as image matches all of its subtypes
+		if strings.Contains(filter.MimeType, "/") {
+			query = query.Where("mime_type = ?", filter.MimeType)
+		} else {
+			query = query.Where("mime_type LIKE ?", filter.MimeType+"/%")
+		}
+	}
+
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 50
+	}
+
+	files := []model.File{}
+	err := query.
+		Order("created_at DESC").
+		Offset((filter.Page - 1) * filter.PageSize).
+		Limit(filter.PageSize).
+		Find(&files).Error
+
+	return files, err
+}
+
+func (s *StorageServiceImpl) Open(ctx context.Context, key string) (io.ReadSeekCloser, model.File, error) {
+	file, err := s.Fetch(ctx, key)
+	if err != nil {
+		return nil, file, err
+	}
+	if file.ScanStatus == model.FILE_SCAN_INFECTED {
+		return nil, file, ErrFileQuarantined
+	}
+
+	store, err := s.store()
+	if err != nil {
+		return nil, file, err
+	}
+	content, err := store.Open(ctx, key)
+	if err != nil {
+		return nil, file, err
+	}
+
+	return content, file, nil
+}
+
+// Attach only attaches a file held by no row yet, or already held by the
+// same field, a file is never taken from another row
+func (s *StorageServiceImpl) Attach(ctx context.Context, key string, table string, rowID string, column string) error {
+	result := s.db.WithContext(ctx).Model(&model.File{}).
+		Where("key = ?", key).
+		Where("(`table` = '' OR `table` IS NULL) OR (`table` = ? AND row_id = ? AND `column` = ?)", table, rowID, column).
+		Updates(map[string]interface{}{
+			"table":  table,
+			"row_id": rowID,
+			"column": column,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFileAttached
+	}
+
+	return nil
+}
+
+func (s *StorageServiceImpl) Delete(ctx context.Context, key string) error {
+	ctx, span := pkg_tracing.Start(ctx, "StorageService.Delete")
+	err := s.delete(ctx, key)
+	pkg_tracing.End(span, err)
+
+	return err
+}
+
+func (s *StorageServiceImpl) delete(ctx context.Context, key string) error {
+	file, err := s.Fetch(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if err := s.removeFile(ctx, key); err != nil {
+		return err
+	}
+
+	if err := s.db.WithContext(ctx).Where("key = ?", key).Delete(&model.File{}).Error; err != nil {
+		return err
+	}
+	events.Publish(events.Event{Name: events.FILE_DELETED, Data: map[string]interface{}{"file": file}})
+
+	return nil
+}
+
+func (s *StorageServiceImpl) CreateUpload(ctx context.Context, upload Upload) (Upload, error) {
+	if upload.Size <= 0 {
+		return Upload{}, errors.New("upload size must be positive")
+	}
+	if upload.Size > s.maxFileSize() {
+		return Upload{}, ErrFileTooLarge
+	}
+	// checked again once completed, the quota is only reserved then
+	if err := s.checkQuota(ctx, upload.Size); err != nil {
+		return Upload{}, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.dir(), UploadsDir), 0o700); err != nil {
+		return Upload{}, err
+	}
+
+	now := time.Now()
+	upload.ID = uuid.NewString()
+	upload.Name = filepath.Base(upload.Name)
+	upload.Offset = 0
+	upload.CreatedAt = now
+	upload.ExpiresAt = now.Add(24 * time.Hour)
+
+	file, err := os.OpenFile(s.uploadPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return Upload{}, err
+	}
+	file.Close()
+
+	if err := s.saveUploadInfo(upload); err != nil {
+		os.Remove(s.uploadPath(upload.ID))
+		return Upload{}, err
+	}
+
+	return upload, nil
+}
+
+func (s *StorageServiceImpl) saveUploadInfo(upload Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.uploadInfoPath(upload.ID), data, 0o600)
+}
+
+// FetchUpload reads the upload, its offset is the size of what was received
+func (s *StorageServiceImpl) FetchUpload(ctx context.Context, id string) (Upload, error) {
+	if !validKey(id) {
+		return Upload{}, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(s.uploadInfoPath(id))
+	if os.IsNotExist(err) {
+		return Upload{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+
+	var upload Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return Upload{}, err
+	}
+
+	info, err := os.Stat(s.uploadPath(id))
+	if os.IsNotExist(err) {
+		return Upload{}, ErrUploadNotFound
+	}
+	if err != nil {
+		return Upload{}, err
+	}
+	upload.Offset = info.Size()
+
+	return upload, nil
+}
+
+func (s *StorageServiceImpl) AppendUpload(ctx context.Context, id string, offset int64, body io.Reader) (Upload, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.FetchUpload(ctx, id)
+	if err != nil {
+		return upload, err
+	}
+	if offset != upload.Offset {
+		return upload, ErrUploadOffset
+	}
+
+	file, err := os.OpenFile(s.uploadPath(id), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return upload, err
+	}
+
+	remaining := upload.Size - upload.Offset
+	written, err := io.Copy(file, io.LimitReader(body, remaining+1))
+	if written > remaining {
+		err = ErrUploadTooLarge
+	}
+	if err != nil {
+		// a partial chunk is dropped so the client resends it whole, the
+		// offset stays where the previous chunk ended
+		file.Truncate(upload.Offset)
+		file.Close()
+		return upload, err
+	}
+	if err := file.Close(); err != nil {
+		return upload, err
+	}
+
+	upload.Offset += written
+
+	return upload, nil
+}
+
+func (s *StorageServiceImpl) CompleteUpload(ctx context.Context, id string) (model.File, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.FetchUpload(ctx, id)
+	if err != nil {
+		return model.File{}, err
+	}
+	if upload.Offset != upload.Size {
+		return model.File{}, ErrUploadIncomplete
+	}
+
+	// the chunks arrive in separate requests, the whole file is hashed once
+	// they are all there
+	hash, err := hashFile(s.uploadPath(id))
+	if err != nil {
+		return model.File{}, err
+	}
+
+	mimeType := upload.MimeType
+	if mimeType == "" {
+		mimeType = detectMimeType(upload.Name, s.uploadPath(id))
+	}
+
+	key := uuid.NewString()
+	if err := s.storeFile(ctx, key, s.uploadPath(id), upload.Size); err != nil {
+		return model.File{}, err
+	}
+	os.Remove(s.uploadInfoPath(id))
+	s.locks.Delete(id)
+
+	return s.register(ctx, model.File{
+		Key:        key,
+		Name:       upload.Name,
+		Size:       upload.Size,
+		MimeType:   mimeType,
+		Hash:       hash,
+		UploadedBy: upload.UserID,
+	})
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (s *StorageServiceImpl) AbortUpload(ctx context.Context, id string) error {
+	unlock := s.lock(id)
+	defer unlock()
+
+	if _, err := s.FetchUpload(ctx, id); err != nil {
+		return err
+	}
+
+	os.Remove(s.uploadPath(id))
+	s.locks.Delete(id)
+
+	return os.Remove(s.uploadInfoPath(id))
+}
+
+func (s *StorageServiceImpl) CleanupUploads(ctx context.Context) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir(), UploadsDir))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		upload, err := s.FetchUpload(ctx, name[:len(name)-len(".json")])
+		if err != nil || time.Now().Before(upload.ExpiresAt) {
+			continue
+		}
+		if err := s.AbortUpload(ctx, upload.ID); err != nil {
+			return removed, fmt.Errorf("failed to remove upload %s: %w", upload.ID, err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (s *StorageServiceImpl) Objects(ctx context.Context, prefix string) ([]StorageObject, error) {
+	store, err := s.store()
+	if err != nil {
+		return nil, err
+	}
+
+	listed, err := store.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// the uploads in progress share the directory of the local storage
+	objects := make([]StorageObject, 0, len(listed))
+	for _, object := range listed {
+		if !strings.HasPrefix(object.Key, UploadsDir+"/") {
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+// storageBackend returns the name of a backend, the local one by default
+func storageBackend(backend string) string {
+	if backend == "" {
+		return "local"
+	}
+
+	return backend
+}
+
+func (s *StorageServiceImpl) Migrate(ctx context.Context, from string) (StorageMigration, error) {
+	settings := config.GetInstance()
+	migration := StorageMigration{Failed: []string{}}
+	if storageBackend(from) == storageBackend(settings.StorageBackend) {
+		return migration, ErrSameStorage
+	}
+
+	source, err := NewStorage(settings, from)
+	if err != nil {
+		return migration, err
+	}
+	target, err := s.store()
+	if err != nil {
+		return migration, err
+	}
+
+	objects, err := source.List(ctx, "")
+	if err != nil {
+		return migration, err
+	}
+	for _, object := range objects {
+		if strings.HasPrefix(object.Key, UploadsDir+"/") {
+			continue
+		}
+		if existing, err := target.Stat(ctx, object.Key); err == nil && existing.Size == object.Size {
+			migration.Skipped++
+			continue
+		}
+
+		if err := copyObject(ctx, source, target, object); err != nil {
+			log.Printf("failed to migrate %s: %v\n", object.Key, err)
+			migration.Failed = append(migration.Failed, object.Key)
+			continue
+		}
+		migration.Copied++
+	}
+
+	return migration, nil
+}
+
+func copyObject(ctx context.Context, source Storage, target Storage, object StorageObject) error {
+	content, err := source.Open(ctx, object.Key)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return target.Save(ctx, object.Key, content, object.Size)
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"react-golang/src/backend/config"
+	pkg_s3 "react-golang/src/backend/pkg/s3"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StorageObject is an object held by a Storage
+type StorageObject struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Storage holds the content of the stored files, their thumbnails and the
+// quarantined files under slash separated keys, while their metadata stays
+// in the _file table
+type Storage interface {
+	// Save writes size bytes of body to key, replacing what key held. A
+	// failed save leaves nothing behind
+	Save(ctx context.Context, key string, body io.Reader, size int64) error
+	// Open returns the content of key, which must be closed. It can seek so
+	// the files can be served in ranges
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes key, a missing key isn't an error
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (StorageObject, error)
+	// List returns the objects whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]StorageObject, error)
+}
+
+// NewStorage returns the storage of the given backend, local or s3, as
+// configured in the settings
+func NewStorage(config *config.Config, backend string) (Storage, error) {
+	switch backend {
+	case "", "local":
+		return NewLocalStorage(localStorageDir(config)), nil
+	case "s3":
+		return NewS3Storage(&pkg_s3.Client{
+			Endpoint:   config.StorageS3Endpoint,
+			Region:     config.StorageS3Region,
+			Bucket:     config.StorageS3Bucket,
+			AccessKey:  config.StorageS3AccessKey,
+			SecretKey:  config.StorageS3SecretKey,
+			PathStyle:  config.StorageS3PathStyle,
+			HTTPClient: &http.Client{Timeout: time.Hour},
+		}, config.StorageS3Prefix), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %s", backend)
+	}
+}
+
+func localStorageDir(config *config.Config) string {
+	if config.StorageDir != "" {
+		return config.StorageDir
+	}
+
+	return filepath.Join(filepath.Dir(os.Getenv("DB_PATH")), "storage")
+}
+
+// LocalStorage keeps the objects as files under a directory
+type LocalStorage struct {
+	dir string
+}
+
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+// path keeps the keys from escaping the directory
+func (s *LocalStorage) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid storage key %s", key)
+	}
+
+	return path, nil
+}
+
+// Save writes next to the file first so a file is never read half written
+func (s *LocalStorage) Save(ctx context.Context, key string, body io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmpPath := path + "." + uuid.NewString() + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written != size {
+		err = fmt.Errorf("wrote %d bytes of %d", written, size)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func (s *LocalStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	}
+
+	return file, err
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// the directories of the thumbnails go along with their last file
+	for dir := filepath.Dir(path); dir != filepath.Clean(s.dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) Stat(ctx context.Context, key string) (StorageObject, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return StorageObject{}, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return StorageObject{}, ErrFileNotFound
+	}
+	if err != nil {
+		return StorageObject{}, err
+	}
+
+	return StorageObject{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List leaves out the files of the saves in progress
+func (s *LocalStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	objects := []StorageObject{}
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, StorageObject{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+
+		return nil
+	})
+
+	return objects, err
+}
+
+// S3Storage keeps the objects in a S3 compatible bucket under a prefix
+type S3Storage struct {
+	client *pkg_s3.Client
+	prefix string
+}
+
+func NewS3Storage(client *pkg_s3.Client, prefix string) *S3Storage {
+	return &S3Storage{client: client, prefix: prefix}
+}
+
+func (s *S3Storage) key(key string) string {
+	return s.prefix + key
+}
+
+func (s *S3Storage) Save(ctx context.Context, key string, body io.Reader, size int64) error {
+	return s.client.Put(ctx, s.key(key), body, size)
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	object, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Reader{ctx: ctx, client: s.client, key: s.key(key), size: object.Size}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	err := s.client.Delete(ctx, s.key(key))
+	if pkg_s3.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *S3Storage) Stat(ctx context.Context, key string) (StorageObject, error) {
+	object, err := s.client.Head(ctx, s.key(key))
+	if pkg_s3.IsNotFound(err) {
+		return StorageObject{}, ErrFileNotFound
+	}
+	if err != nil {
+		return StorageObject{}, err
+	}
+
+	return StorageObject{Key: key, Size: object.Size, ModTime: object.LastModified}, nil
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	listed, err := s.client.List(ctx, s.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]StorageObject, 0, len(listed))
+	for _, object := range listed {
+		objects = append(objects, StorageObject{
+			Key:     strings.TrimPrefix(object.Key, s.prefix),
+			Size:    object.Size,
+			ModTime: object.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+// s3Reader reads an object from where it was last seeked to, a seek only
+// costs a new request once the object is read again
+type s3Reader struct {
+	ctx    context.Context
+	client *pkg_s3.Client
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.body == nil {
+		if r.offset >= r.size {
+			return 0, io.EOF
+		}
+		body, err := r.client.GetRange(r.ctx, r.key, r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+
+	return r.body.Close()
+}
+
+// MemoryStorage keeps the objects in memory, for tests
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	content []byte
+	modTime time.Time
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string]memoryObject{}}
+}
+
+func (s *MemoryStorage) Save(ctx context.Context, key string, body io.Reader, size int64) error {
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) != size {
+		return fmt.Errorf("wrote %d bytes of %d", len(content), size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = memoryObject{content: content, modTime: time.Now()}
+
+	return nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+func (s *MemoryStorage) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	return memoryReader{bytes.NewReader(object.content)}, nil
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, key)
+
+	return nil
+}
+
+func (s *MemoryStorage) Stat(ctx context.Context, key string) (StorageObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	object, ok := s.objects[key]
+	if !ok {
+		return StorageObject{}, ErrFileNotFound
+	}
+
+	return StorageObject{Key: key, Size: int64(len(object.content)), ModTime: object.modTime}, nil
+}
+
+func (s *MemoryStorage) List(ctx context.Context, prefix string) ([]StorageObject, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	objects := []StorageObject{}
+	for key, object := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, StorageObject{Key: key, Size: int64(len(object.content)), ModTime: object.modTime})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
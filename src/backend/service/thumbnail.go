@@ -0,0 +1,235 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"react-golang/src/backend/model"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// the thumbnails are cached apart from the stored files, under the key of
+// the file they were made from
+const ThumbsDir = ".thumbs"
+
+const (
+	maxThumbSize = 2048
+	// larger images aren't decoded, they would take too much memory
+	maxThumbSourcePixels = 50_000_000
+)
+
+var (
+	ErrInvalidThumb  = errors.New("thumb must be WIDTHxHEIGHT with an optional f suffix, at most 2048x2048")
+	ErrInvalidFormat = errors.New("format must be jpeg, png or gif")
+	ErrNotAnImage    = errors.New("file is not a supported image")
+	ErrImageTooLarge = errors.New("image is too large to make a thumbnail of")
+)
+
+var thumbSpecPattern = regexp.MustCompile(`^(\d+)x(\d+)(f?)$`)
+
+// the formats thumbnails can be encoded in
+var (
+	thumbFormatMimes   = map[string]string{"jpeg": "image/jpeg", "png": "image/png", "gif": "image/gif"}
+	thumbFormatAliases = map[string]string{"jpg": "jpeg"}
+)
+
+// thumbSpec is a parsed thumb parameter. A width or height of 0 follows the
+// aspect ratio of the image, otherwise the image is cropped around its center
+// to fill both, or fit inside them when Fit is set
+type thumbSpec struct {
+	Width  int
+	Height int
+	Fit    bool
+}
+
+func parseThumbSpec(thumb string) (thumbSpec, error) {
+	match := thumbSpecPattern.FindStringSubmatch(thumb)
+	if match == nil {
+		return thumbSpec{}, ErrInvalidThumb
+	}
+
+	width, _ := strconv.Atoi(match[1])
+	height, _ := strconv.Atoi(match[2])
+	if (width == 0 && height == 0) || width > maxThumbSize || height > maxThumbSize {
+		return thumbSpec{}, ErrInvalidThumb
+	}
+
+	return thumbSpec{Width: width, Height: height, Fit: match[3] == "f"}, nil
+}
+
+// thumbFormat returns the format a thumbnail is encoded in, by default the
+// format of the image when it can be encoded and png otherwise
+func thumbFormat(format string, mimeType string) (string, error) {
+	format = strings.ToLower(format)
+	if alias, ok := thumbFormatAliases[format]; ok {
+		format = alias
+	}
+	if format == "" {
+		for name, formatMime := range thumbFormatMimes {
+			if strings.HasPrefix(mimeType, formatMime) {
+				return name, nil
+			}
+		}
+		return "png", nil
+	}
+	if _, ok := thumbFormatMimes[format]; !ok {
+		return "", ErrInvalidFormat
+	}
+
+	return format, nil
+}
+
+func thumbKey(key string, spec thumbSpec, format string) string {
+	name := fmt.Sprintf("%dx%d", spec.Width, spec.Height)
+	if spec.Fit {
+		name += "f"
+	}
+
+	return ThumbsDir + "/" + key + "/" + name + "." + format
+}
+
+// deleteThumbnails removes every thumbnail made of a file
+func deleteThumbnails(ctx context.Context, store Storage, key string) error {
+	thumbs, err := store.List(ctx, ThumbsDir+"/"+key+"/")
+	if err != nil {
+		return err
+	}
+	for _, thumb := range thumbs {
+		if err := store.Delete(ctx, thumb.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *StorageServiceImpl) OpenThumbnail(ctx context.Context, key string, thumb string, format string) (io.ReadSeekCloser, model.File, error) {
+	spec, err := parseThumbSpec(thumb)
+	if err != nil {
+		return nil, model.File{}, err
+	}
+
+	file, err := s.Fetch(ctx, key)
+	if err != nil {
+		return nil, file, err
+	}
+	if file.ScanStatus == model.FILE_SCAN_INFECTED {
+		return nil, file, ErrFileQuarantined
+	}
+	if format, err = thumbFormat(format, file.MimeType); err != nil {
+		return nil, file, err
+	}
+
+	store, err := s.store()
+	if err != nil {
+		return nil, file, err
+	}
+	name := thumbKey(key, spec, format)
+	content, err := store.Open(ctx, name)
+	if errors.Is(err, ErrFileNotFound) {
+		if err = createThumbnail(ctx, store, key, name, spec, format); err != nil {
+			return nil, file, err
+		}
+		content, err = store.Open(ctx, name)
+	}
+	if err != nil {
+		return nil, file, err
+	}
+
+	file.Name = strings.TrimSuffix(file.Name, filepath.Ext(file.Name)) + "." + format
+	file.MimeType = thumbFormatMimes[format]
+
+	return content, file, nil
+}
+
+// createThumbnail saves the thumbnail of the image at src to dst. The
+// storages never serve a half saved object, a thumbnail requested twice at
+// once is just made twice
+func createThumbnail(ctx context.Context, store Storage, src string, dst string, spec thumbSpec, format string) error {
+	source, err := store.Open(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	imageConfig, _, err := image.DecodeConfig(source)
+	if err != nil {
+		return ErrNotAnImage
+	}
+	if imageConfig.Width*imageConfig.Height > maxThumbSourcePixels {
+		return ErrImageTooLarge
+	}
+	if _, err := source.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(source)
+	if err != nil {
+		return ErrNotAnImage
+	}
+
+	thumbnail := resizeImage(img, spec)
+
+	var out bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: 85})
+	case "gif":
+		err = gif.Encode(&out, thumbnail, nil)
+	default:
+		err = png.Encode(&out, thumbnail)
+	}
+	if err != nil {
+		return err
+	}
+
+	return store.Save(ctx, dst, &out, int64(out.Len()))
+}
+
+func resizeImage(img image.Image, spec thumbSpec) image.Image {
+	bounds := img.Bounds()
+	width, height := spec.Width, spec.Height
+	srcRect := bounds
+
+	switch {
+	case width == 0:
+		width = max(1, bounds.Dx()*height/bounds.Dy())
+	case height == 0:
+		height = max(1, bounds.Dy()*width/bounds.Dx())
+	case spec.Fit:
+		// the largest size of the aspect ratio of the image inside the box
+		if bounds.Dx()*height > bounds.Dy()*width {
+			height = max(1, bounds.Dy()*width/bounds.Dx())
+		} else {
+			width = max(1, bounds.Dx()*height/bounds.Dy())
+		}
+	default:
+		// the largest part of the image around its center with the aspect
+		// ratio of the box
+		cropWidth, cropHeight := bounds.Dx(), bounds.Dy()
+		if cropWidth*height > cropHeight*width {
+			cropWidth = cropHeight * width / height
+		} else {
+			cropHeight = cropWidth * height / width
+		}
+		x := bounds.Min.X + (bounds.Dx()-cropWidth)/2
+		y := bounds.Min.Y + (bounds.Dy()-cropHeight)/2
+		srcRect = image.Rect(x, y, x+cropWidth, y+cropHeight)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, srcRect, draw.Src, nil)
+
+	return dst
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"react-golang/src/backend/config"
+	"react-golang/src/backend/model"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookRetryDelay  = 30 * time.Second
+	maxWebhookRetryDelay      = time.Hour
+
+	// a delivery being posted isn't picked up again before this long, in
+	// case the attempt never records its outcome
+	webhookAttemptLease = 5 * time.Minute
+)
+
+var ErrWebhookDeliveryNotFound = errors.New("webhook delivery does not exist")
+
+// WebhookEndpoint is where a webhook is posted, the payloads are signed with
+// Secret when it is set
+type WebhookEndpoint struct {
+	URL    string
+	Secret string
+}
+
+type WebhookService interface {
+	// Send posts payload as JSON to the endpoint. It is posted right away
+	// without waiting, then retried with an exponential backoff while it fails
+	Send(ctx context.Context, endpoint WebhookEndpoint, event string, payload interface{}) error
+	// Retry posts the deliveries whose retry is due
+	Retry(ctx context.Context)
+	FetchDeliveries(ctx context.Context, status string) ([]model.WebhookDelivery, error)
+	// Redeliver posts a delivery again right away with its attempts reset,
+	// a failure is retried as if it was new
+	Redeliver(ctx context.Context, id uint) (model.WebhookDelivery, error)
+	DeleteDelivery(ctx context.Context, id uint) error
+}
+
+type WebhookServiceImpl struct {
+	db     *gorm.DB
+	client *http.Client
+}
+
+func NewWebhookService(db *gorm.DB) WebhookService {
+	return &WebhookServiceImpl{
+		db:     db,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookServiceImpl) maxAttempts() int {
+	settings := config.GetInstance()
+	if settings.WebhookMaxAttempts > 0 {
+		return settings.WebhookMaxAttempts
+	}
+
+	return defaultWebhookMaxAttempts
+}
+
+// retryDelay doubles with every failed attempt
+func (w *WebhookServiceImpl) retryDelay(attempts int) time.Duration {
+	settings := config.GetInstance()
+	delay := defaultWebhookRetryDelay
+	if settings.WebhookRetryDelaySeconds > 0 {
+		delay = time.Duration(settings.WebhookRetryDelaySeconds) * time.Second
+	}
+
+	for i := 1; i < attempts && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, maxWebhookRetryDelay)
+}
+
+func (w *WebhookServiceImpl) Send(ctx context.Context, endpoint WebhookEndpoint, event string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delivery := model.WebhookDelivery{
+		URL:           endpoint.URL,
+		Secret:        endpoint.Secret,
+		Event:         event,
+		Payload:       string(body),
+		Status:        model.WEBHOOK_DELIVERY_PENDING,
+		NextAttemptAt: time.Now(),
+	}
+	if err := w.db.WithContext(ctx).Create(&delivery).Error; err != nil {
+		return err
+	}
+
+	go w.attempt(context.Background(), delivery.ID)
+
+	return nil
+}
+
+func (w *WebhookServiceImpl) Retry(ctx context.Context) {
+	var ids []uint
+	err := w.db.WithContext(ctx).
+		Model(&model.WebhookDelivery{}).
+		Where("status = ? AND next_attempt_at <= ?", model.WEBHOOK_DELIVERY_PENDING, time.Now()).
+		Order("next_attempt_at").
+		Pluck("id", &ids).Error
+	if err != nil {
+		log.Printf("failed to fetch the webhook deliveries to retry: %v\n", err)
+		return
+	}
+
+	for _, id := range ids {
+		w.attempt(ctx, id)
+	}
+}
+
+// attempt posts a pending delivery once it is claimed, so it isn't posted
+// twice by the first attempt and a retry at the same time. A successful
+// delivery is deleted, a failed one is scheduled again until it runs out
+// of attempts
+func (w *WebhookServiceImpl) attempt(ctx context.Context, id uint) (model.WebhookDelivery, error) {
+	now := time.Now()
+	claim := w.db.WithContext(ctx).
+		Model(&model.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at <= ?", id, model.WEBHOOK_DELIVERY_PENDING, now).
+		Update("next_attempt_at", now.Add(webhookAttemptLease))
+	if claim.Error != nil {
+		return model.WebhookDelivery{}, claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return model.WebhookDelivery{}, nil
+	}
+
+	var delivery model.WebhookDelivery
+	if err := w.db.WithContext(ctx).First(&delivery, id).Error; err != nil {
+		return delivery, err
+	}
+
+	status, err := w.post(ctx, delivery)
+	delivery.Attempts++
+	delivery.LastStatus = status
+	if err == nil {
+		delivery.LastError = ""
+		return delivery, w.db.WithContext(ctx).Delete(&model.WebhookDelivery{}, id).Error
+	}
+
+	delivery.LastError = err.Error()
+	delivery.NextAttemptAt = time.Now().Add(w.retryDelay(delivery.Attempts))
+	if delivery.Attempts >= w.maxAttempts() {
+		delivery.Status = model.WEBHOOK_DELIVERY_FAILED
+		log.Printf("webhook delivery %d of %s to %s failed after %d attempts: %v\n", delivery.ID, delivery.Event, delivery.URL, delivery.Attempts, err)
+	}
+
+	if err := w.db.WithContext(ctx).Save(&delivery).Error; err != nil {
+		return delivery, err
+	}
+
+	return delivery, err
+}
+
+func (w *WebhookServiceImpl) post(ctx context.Context, delivery model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Fullbase-Event", delivery.Event)
+	// receivers can drop the retries of what they already received
+	req.Header.Set("X-Fullbase-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	if delivery.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook([]byte(delivery.Secret), time.Now(), []byte(delivery.Payload)))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("webhook responded %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+func (w *WebhookServiceImpl) FetchDeliveries(ctx context.Context, status string) ([]model.WebhookDelivery, error) {
+	query := w.db.WithContext(ctx).Order("id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	deliveries := []model.WebhookDelivery{}
+	err := query.Find(&deliveries).Error
+
+	return deliveries, err
+}
+
+func (w *WebhookServiceImpl) Redeliver(ctx context.Context, id uint) (model.WebhookDelivery, error) {
+	update := w.db.WithContext(ctx).
+		Model(&model.WebhookDelivery{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          model.WEBHOOK_DELIVERY_PENDING,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if update.Error != nil {
+		return model.WebhookDelivery{}, update.Error
+	}
+	if update.RowsAffected == 0 {
+		return model.WebhookDelivery{}, ErrWebhookDeliveryNotFound
+	}
+
+	delivery, err := w.attempt(ctx, id)
+	if err != nil {
+		return delivery, err
+	}
+	delivery.Status = model.WEBHOOK_DELIVERY_DELIVERED
+
+	return delivery, nil
+}
+
+func (w *WebhookServiceImpl) DeleteDelivery(ctx context.Context, id uint) error {
+	result := w.db.WithContext(ctx).Delete(&model.WebhookDelivery{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrWebhookDeliveryNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookSignatureHeader carries the signature of the webhooks posted to an
+// endpoint having a secret, in the form
+//
+//	X-Fullbase-Signature: t=1700000000,v1=5257a869...
+//
+// t is the unix time the webhook was posted at and v1 the hex encoded
+// HMAC-SHA256 of "<t>.<body>" keyed with the secret of the endpoint. To
+// verify a webhook, the receiver computes the HMAC over the timestamp, a
+// dot and the raw body as received, compares it to v1 in constant time and
+// rejects the webhooks whose timestamp is too old, so a captured webhook
+// can't be replayed. A retry is signed again with its own timestamp.
+// VerifyWebhook does this for receivers written in Go
+const WebhookSignatureHeader = "X-Fullbase-Signature"
+
+var ErrInvalidWebhookSignature = errors.New("webhook signature is invalid or expired")
+
+func webhookMAC(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignWebhook returns the value of the signature header of body posted at
+func SignWebhook(secret []byte, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	return "t=" + timestamp + ",v1=" + webhookMAC(secret, timestamp, body)
+}
+
+// VerifyWebhook checks the signature header of a received webhook, which
+// must have been posted less than tolerance ago
+func VerifyWebhook(secret []byte, header string, body []byte, tolerance time.Duration) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	at, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidWebhookSignature
+	}
+	if age := time.Since(time.Unix(at, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidWebhookSignature
+	}
+
+	expected := []byte(webhookMAC(secret, timestamp, body))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidWebhookSignature
+}
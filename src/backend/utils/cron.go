@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	// the timezone database is embedded since containers often lack it
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+)
+
+// CronSpec returns the schedule given to the scheduler, a schedule with a
+// timezone runs in that timezone rather than the server's
+func CronSpec(schedule string, timezone string) string {
+	if timezone == "" {
+		return schedule
+	}
+
+	return "CRON_TZ=" + timezone + " " + schedule
+}
+
+// ParseCronSchedule parses a standard cron expression in the timezone, the
+// server's when empty
+func ParseCronSchedule(schedule string, timezone string) (cron.Schedule, error) {
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %s", timezone)
+		}
+	}
+
+	return cron.ParseStandard(CronSpec(schedule, timezone))
+}
+
+// NextCronRuns returns the next count times the schedule runs after from
+func NextCronRuns(schedule cron.Schedule, from time.Time, count int) []time.Time {
+	runs := []time.Time{}
+	for i := 0; i < count; i++ {
+		from = schedule.Next(from)
+		if from.IsZero() {
+			break
+		}
+		runs = append(runs, from)
+	}
+
+	return runs
+}
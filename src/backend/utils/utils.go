@@ -4,6 +4,9 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"math/big"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func JSONify(data interface{}) (string, error) {
@@ -30,3 +33,58 @@ func GenerateRandomString(length int) (string, error) {
 	}
 	return string(result), nil
 }
+
+// GenerateUUIDV7 returns a UUID ordered by its creation time
+func GenerateUUIDV7() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// GenerateULID returns a ULID, 26 characters of Crockford's base32 holding
+// the creation time in milliseconds followed by 80 random bits
+func GenerateULID() (string, error) {
+	var id [16]byte
+	ms := uint64(time.Now().UnixMilli())
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	// the 128 bits are written 5 at a time from the end, the first
+	// character holds the 3 bits left
+	number := new(big.Int).SetBytes(id[:])
+	mask := big.NewInt(31)
+	result := make([]byte, 26)
+	for i := len(result) - 1; i >= 0; i-- {
+		result[i] = crockfordBase32[new(big.Int).And(number, mask).Int64()]
+		number.Rsh(number, 5)
+	}
+
+	return string(result), nil
+}
+
+const nanoIDAlphabet = "_-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// GenerateNanoID returns a random id of the URL safe alphabet of nanoid
+func GenerateNanoID(length int) (string, error) {
+	random := make([]byte, length)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	// the alphabet has 64 characters, 6 bits of every byte pick one
+	result := make([]byte, length)
+	for i, b := range random {
+		result[i] = nanoIDAlphabet[b&63]
+	}
+
+	return string(result), nil
+}
@@ -1,4 +1,4 @@
-import { FaRegCalendar, FaRegFile } from "react-icons/fa";
+import { FaMapMarkerAlt, FaRegCalendar, FaRegFile } from "react-icons/fa";
 import { HiOutlineHashtag } from "react-icons/hi";
 import { RiText } from "react-icons/ri";
 import { RxComponentBoolean } from "react-icons/rx";
@@ -23,6 +23,18 @@ export const datatypes = [
     dtype: "REAL",
     icon: <HiOutlineHashtag />,
   },
+  {
+    label: "Integer",
+    value: "integer",
+    dtype: "INTEGER",
+    icon: <HiOutlineHashtag />,
+  },
+  {
+    label: "Decimal",
+    value: "decimal(10,2)",
+    dtype: "DECIMAL",
+    icon: <HiOutlineHashtag />,
+  },
   {
     label: "Boolean",
     value: "boolean",
@@ -41,4 +53,10 @@ export const datatypes = [
     dtype: "FILE",
     icon: <FaRegFile />,
   },
+  {
+    label: "Geo point",
+    value: "geopoint",
+    dtype: "LATLNG",
+    icon: <FaMapMarkerAlt />,
+  },
 ];
@@ -303,8 +303,8 @@ const FunctionInsert = ({
     {
       label: "User ID",
       description: "Automatically fetch user ID through JWT token",
-      value: "$user.id",
-      key: "$user.id",
+      value: "@request.auth.id",
+      key: "@request.auth.id",
     },
   ]);
 
@@ -320,8 +320,8 @@ const FunctionInsert = ({
       {
         label: "User ID",
         description: "Automatically fetch user ID through JWT token",
-        value: "$user.id",
-        key: "$user.id",
+        value: "@request.auth.id",
+        key: "@request.auth.id",
       },
       ...functionParts
         .filter(
@@ -454,8 +454,8 @@ const FunctionUpdate = ({
     {
       label: "User ID",
       description: "Automatically fetch user ID through JWT token",
-      value: "$user.id",
-      key: "$user.id",
+      value: "@request.auth.id",
+      key: "@request.auth.id",
     },
   ]);
 
@@ -471,8 +471,8 @@ const FunctionUpdate = ({
       {
         label: "User ID",
         description: "Automatically fetch user ID through JWT token",
-        value: "$user.id",
-        key: "$user.id",
+        value: "@request.auth.id",
+        key: "@request.auth.id",
       },
       ...functionParts
         .filter(
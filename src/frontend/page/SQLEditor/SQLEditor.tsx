@@ -48,8 +48,8 @@ const SQLEditor = () => {
           setRows(res.data);
         })
         .catch((err) => {
-          console.log(err.response.data.error);
-          toast.error(err.response.data.error, {
+          console.log(err.response.data.message);
+          toast.error(err.response.data.message, {
             draggable: true,
           });
           throw err;
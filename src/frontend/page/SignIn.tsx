@@ -1,7 +1,7 @@
 import { Button, Card, CardBody, CardHeader, Input } from "@nextui-org/react";
 import { useMutation } from "@tanstack/react-query";
 import { Formik } from "formik";
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import useSignIn from "react-auth-kit/hooks/useSignIn";
 import { FaRegEye, FaRegEyeSlash } from "react-icons/fa6";
 import { useNavigate } from "react-router-dom";
@@ -39,6 +39,21 @@ const SignIn = () => {
     },
   });
 
+  // SSO logins come back with the token in the url fragment
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const token = params.get("token");
+    if (token) {
+      signIn({
+        auth: {
+          token: token,
+          type: "Bearer",
+        },
+      });
+      navigate("/");
+    }
+  }, []);
+
   const [isVisible, setIsVisible] = useState(false);
   const toggleVisibility = () => setIsVisible(!isVisible);
 
@@ -119,6 +134,15 @@ const SignIn = () => {
                 >
                   Sign In
                 </Button>
+                <Button
+                  as="a"
+                  href="/saml/login"
+                  fullWidth
+                  variant="bordered"
+                  className="rounded-md w-full font-semibold"
+                >
+                  Sign in with SSO
+                </Button>
               </form>
             )}
           </Formik>
@@ -289,6 +289,46 @@ const CreateTableModal = ({ isOpen, onClose }: CreateTableModalProps) => {
                       ID will have to be manually inputted
                     </p>
                   </SelectItem>
+                  <SelectItem
+                    textValue="UUIDv7"
+                    className="rounded-sm"
+                    key="uuidv7"
+                  >
+                    <b>UUIDv7</b>
+                    <p className="text-sm text-default-500">
+                      Time ordered UUID, such as 01890a5d-ac96-774b-bcce-b302099a8057
+                    </p>
+                  </SelectItem>
+                  <SelectItem
+                    textValue="ULID"
+                    className="rounded-sm"
+                    key="ulid"
+                  >
+                    <b>ULID</b>
+                    <p className="text-sm text-default-500">
+                      Time ordered 26 characters ID, such as 01ARZ3NDEKTSV4RRFFQ69G5FAV
+                    </p>
+                  </SelectItem>
+                  <SelectItem
+                    textValue="Nano ID"
+                    className="rounded-sm"
+                    key="nanoid"
+                  >
+                    <b>Nano ID</b>
+                    <p className="text-sm text-default-500">
+                      21 characters URL friendly random ID
+                    </p>
+                  </SelectItem>
+                  <SelectItem
+                    textValue="Auto-increment"
+                    className="rounded-sm"
+                    key="autoincrement"
+                  >
+                    <b>Auto-increment</b>
+                    <p className="text-sm text-default-500">
+                      Numbers the records 1, 2, 3 and so on
+                    </p>
+                  </SelectItem>
                 </Select>
               </div>
             </div>
@@ -405,6 +445,46 @@ const CreateTableModal = ({ isOpen, onClose }: CreateTableModalProps) => {
                       ID will have to be manually inputted
                     </p>
                   </SelectItem>
+                  <SelectItem
+                    textValue="UUIDv7"
+                    className="rounded-sm"
+                    key="uuidv7"
+                  >
+                    <b>UUIDv7</b>
+                    <p className="text-sm text-default-500">
+                      Time ordered UUID, such as 01890a5d-ac96-774b-bcce-b302099a8057
+                    </p>
+                  </SelectItem>
+                  <SelectItem
+                    textValue="ULID"
+                    className="rounded-sm"
+                    key="ulid"
+                  >
+                    <b>ULID</b>
+                    <p className="text-sm text-default-500">
+                      Time ordered 26 characters ID, such as 01ARZ3NDEKTSV4RRFFQ69G5FAV
+                    </p>
+                  </SelectItem>
+                  <SelectItem
+                    textValue="Nano ID"
+                    className="rounded-sm"
+                    key="nanoid"
+                  >
+                    <b>Nano ID</b>
+                    <p className="text-sm text-default-500">
+                      21 characters URL friendly random ID
+                    </p>
+                  </SelectItem>
+                  <SelectItem
+                    textValue="Auto-increment"
+                    className="rounded-sm"
+                    key="autoincrement"
+                  >
+                    <b>Auto-increment</b>
+                    <p className="text-sm text-default-500">
+                      Numbers the records 1, 2, 3 and so on
+                    </p>
+                  </SelectItem>
                 </Select>
               </div>
             </div>